@@ -0,0 +1,113 @@
+package behaviour
+
+import (
+	"context"
+	"fmt"
+	"go-bots/border"
+	"go-bots/ev3"
+	"go-bots/ui"
+)
+
+// CornerBot is what the border calibration drives
+type CornerBot interface {
+	Bot
+	// Corners returns the corner sensors of the last reading
+	Corners() (left int, right int)
+	// Hold stops the wheels and shows the given leds
+	Hold(leftGreen int, rightGreen int, leftRed int, rightRed int)
+}
+
+// CalibrateBorder samples the corner sensors on the dohyo surface for
+// sampleMillis and then, after ENTER (with the corner sensors on the white
+// border), on the border. It picks a threshold per sensor (marginPC percent
+// of the way to the border), passes the calibration to use and shows the
+// result on the LEDs (green for a calibrated side, red for a failed one) until
+// ENTER. ok is false when ctx is done before
+func (r *Runner) CalibrateBorder(ctx context.Context, b CornerBot, start int, dir ev3.Direction, sampleMillis int, marginPC int, use func(c border.Calibration) error) (now int, ok bool) {
+	r.SetInMenu(true)
+	defer r.SetInMenu(false)
+
+	var surfaceLeft, surfaceRight, borderLeft, borderRight border.Sampler
+
+	r.EnterState(start, "calibrateBorder", "surface", dir)
+	Log(start, dir, "calibrate border: sampling the surface")
+	now, ok = sampleCorners(ctx, b, start, sampleMillis, &surfaceLeft, &surfaceRight)
+	if !ok {
+		return now, false
+	}
+
+	r.EnterState(now, "calibrateBorder", "waitBorder", dir)
+	Log(now, dir, "calibrate border: put the corner sensors on the border and press ENTER")
+	now, ok = r.waitCalibrationEnter(ctx, b, now, 255, 255, 255, 255)
+	if !ok {
+		return now, false
+	}
+
+	r.EnterState(now, "calibrateBorder", "border", dir)
+	Log(now, dir, "calibrate border: sampling the border")
+	now, ok = sampleCorners(ctx, b, now, sampleMillis, &borderLeft, &borderRight)
+	if !ok {
+		return now, false
+	}
+
+	left, errLeft := border.Compute(surfaceLeft, borderLeft, marginPC)
+	right, errRight := border.Compute(surfaceRight, borderRight, marginPC)
+	leftGreen, rightGreen, leftRed, rightRed := 255, 255, 0, 0
+	if errLeft != nil {
+		Log(now, ev3.Left, "calibrate border: "+errLeft.Error())
+		leftGreen, leftRed = 0, 255
+	}
+	if errRight != nil {
+		Log(now, ev3.Right, "calibrate border: "+errRight.Error())
+		rightGreen, rightRed = 0, 255
+	}
+	if errLeft == nil && errRight == nil {
+		Log(now, dir, fmt.Sprintf("calibrate border: thresholds %d %d", left.Threshold, right.Threshold))
+		err := use(border.Calibration{Left: left, Right: right})
+		if err != nil {
+			Log(now, dir, "calibrate border: error saving: "+err.Error())
+		}
+	}
+
+	r.EnterState(now, "calibrateBorder", "result", dir)
+	return r.waitCalibrationEnter(ctx, b, now, leftGreen, rightGreen, leftRed, rightRed)
+}
+
+// sampleCorners records the corner sensors for sampleMillis
+func sampleCorners(ctx context.Context, b CornerBot, start int, sampleMillis int, left *border.Sampler, right *border.Sampler) (int, bool) {
+	for {
+		now, ok := b.Read(ctx)
+		if !ok {
+			return start, false
+		}
+		if now-start >= sampleMillis {
+			return now, true
+		}
+		l, r := b.Corners()
+		left.Add(l)
+		right.Add(r)
+		b.Hold(0, 0, 255, 255)
+	}
+}
+
+// waitCalibrationEnter blinks the given LEDs until ENTER is pressed
+func (r *Runner) waitCalibrationEnter(ctx context.Context, b CornerBot, start int, leftGreen int, rightGreen int, leftRed int, rightRed int) (int, bool) {
+	for {
+		now, ok := b.Read(ctx)
+		if !ok {
+			return start, false
+		}
+		select {
+		case k := <-r.MenuKeys():
+			if k.Key == ui.Enter {
+				return k.Millis, true
+			}
+		default:
+		}
+		if ((now-start)/250)%2 == 0 {
+			b.Hold(leftGreen, rightGreen, leftRed, rightRed)
+		} else {
+			b.Hold(0, 0, 0, 0)
+		}
+	}
+}
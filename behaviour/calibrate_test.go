@@ -0,0 +1,98 @@
+package behaviour
+
+import (
+	"context"
+	"go-bots/border"
+	"go-bots/ev3"
+	"go-bots/ui"
+	"testing"
+)
+
+// cornerBot reads every 10ms, on the surface before 60 and on the border
+// after, and presses ENTER at the given times
+type cornerBot struct {
+	r       *Runner
+	now     int
+	surface [2]int
+	border  [2]int
+	enter   map[int]bool
+	leds    [][4]int
+}
+
+func (b *cornerBot) Read(ctx context.Context) (int, bool) {
+	if b.now >= 1000 {
+		return 0, false
+	}
+	b.now += 10
+	if b.enter[b.now] {
+		b.r.menuKeys <- ui.KeyEvent{Key: ui.Enter, Millis: b.now}
+	}
+	return b.now, true
+}
+
+func (b *cornerBot) Drive(left int, right int, front bool) {
+}
+
+func (b *cornerBot) Corners() (int, int) {
+	if b.now < 60 {
+		return b.surface[0], b.surface[1]
+	}
+	return b.border[0], b.border[1]
+}
+
+func (b *cornerBot) Hold(leftGreen int, rightGreen int, leftRed int, rightRed int) {
+	b.leds = append(b.leds, [4]int{leftGreen, rightGreen, leftRed, rightRed})
+}
+
+func TestCalibrateBorder(t *testing.T) {
+	tests := []struct {
+		name       string
+		border     [2]int
+		calibrated bool
+		result     [4]int
+	}{
+		{"calibrated", [2]int{80, 90}, true, [4]int{255, 255, 0, 0}},
+		{"right side failed", [2]int{80, 35}, false, [4]int{255, 0, 0, 255}},
+	}
+	for _, test := range tests {
+		r := NewRunner()
+		b := &cornerBot{r: r, surface: [2]int{30, 40}, border: test.border, enter: map[int]bool{60: true, 130: true}}
+		var used *border.Calibration
+		now, ok := r.CalibrateBorder(context.Background(), b, 0, ev3.Left, 50, 50, func(c border.Calibration) error {
+			used = &c
+			return nil
+		})
+
+		if !ok || now != 130 {
+			t.Errorf("%s: ended at %d (ok %v), expected at the second ENTER", test.name, now, ok)
+		}
+		if test.calibrated {
+			if used == nil || used.Left.Threshold != 55 || used.Right.Threshold != 65 {
+				t.Errorf("%s: calibration %v, expected thresholds 55 and 65", test.name, used)
+			}
+		} else if used != nil {
+			t.Errorf("%s: calibration %v used after a failure", test.name, used)
+		}
+		if len(b.leds) == 0 || b.leds[len(b.leds)-1] != test.result {
+			t.Errorf("%s: leds %v, expected to end with %v", test.name, b.leds, test.result)
+		}
+		if r.isInMenu() {
+			t.Errorf("%s: still in the menu", test.name)
+		}
+		if r.State().Phase != "result" {
+			t.Errorf("%s: state %v, expected result", test.name, r.State())
+		}
+	}
+}
+
+func TestCalibrateBorderWithoutEnter(t *testing.T) {
+	r := NewRunner()
+	b := &cornerBot{r: r, enter: map[int]bool{}}
+	_, ok := r.CalibrateBorder(context.Background(), b, 0, ev3.Left, 50, 50, func(c border.Calibration) error {
+		t.Error("calibration used without the border")
+		return nil
+	})
+	if ok {
+		t.Error("ok without ENTER")
+	}
+}
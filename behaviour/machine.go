@@ -0,0 +1,174 @@
+package behaviour
+
+import (
+	"context"
+	"go-bots/cmdline"
+	"go-bots/ev3"
+	"sync"
+)
+
+// Bot is what a machine drives
+type Bot interface {
+	// Read waits for the next reading and returns its time, ok is false when ctx is done
+	Read(ctx context.Context) (now int, ok bool)
+	// Drive commands the wheel speeds computed for the last reading
+	Drive(left int, right int, front bool)
+}
+
+// Check checks the last reading and hands off to another behaviour when it applies
+type Check func(ctx context.Context, r *Run) bool
+
+// Run is the state of a running machine
+type Run struct {
+	Dir     ev3.Direction
+	Now     int
+	Elapsed int
+}
+
+// Phase drives the bot with fixed speeds (or a steer function) until its time is up
+type Phase struct {
+	Name string
+	// Millis is the duration of the phase, 0 means until the phase ends otherwise
+	Millis int
+	// OnData checks the duration when a reading arrives, dropping the reading that ends the phase
+	OnData bool
+	// Outer and Inner are the wheel speeds of a turn towards Dir
+	Outer int
+	Inner int
+	// Steer computes left and right speeds from the last reading (instead of Outer and Inner)
+	Steer func(r *Run) (left int, right int)
+	// Until ends the phase early
+	Until func(r *Run) bool
+	// Interrupts are checked in order on every reading
+	Interrupts []Check
+	Front      bool
+	// FlipDir changes direction when the phase ends
+	FlipDir bool
+}
+
+// Machine is a behaviour described as a list of phases
+type Machine struct {
+	Name   string
+	Phases []Phase
+	// Loop restarts from the first phase after the last one
+	Loop bool
+	// Next is handed off to when the last phase ends (nil means stop)
+	Next     Func
+	FlipNext bool
+}
+
+// Sides turns outer and inner speeds into left and right ones
+func Sides(dir ev3.Direction, outer int, inner int) (left int, right int) {
+	if dir == ev3.Right {
+		return outer, inner
+	}
+	return inner, outer
+}
+
+// RunMachine runs the phases of m from first on, driving b
+func (r *Runner) RunMachine(ctx context.Context, b Bot, m *Machine, start int, dir ev3.Direction, first int) {
+	run := &Run{Dir: dir, Now: start}
+	Log(start, dir, m.Name)
+
+	for i := first; i < len(m.Phases); {
+		p := &m.Phases[i]
+		r.EnterState(run.Now, m.Name, p.Name, run.Dir)
+		Log(run.Now, run.Dir, p.Name)
+		if !runPhase(ctx, b, run, p, start) {
+			return
+		}
+		if p.FlipDir {
+			run.Dir = ev3.ChangeDirection(run.Dir)
+		}
+		i++
+		if i == len(m.Phases) && m.Loop {
+			i = 0
+		}
+	}
+
+	Log(run.Now, run.Dir, m.Name+" done")
+	if m.Next == nil {
+		return
+	}
+	if m.FlipNext {
+		run.Dir = ev3.ChangeDirection(run.Dir)
+	}
+	r.HandOff(ctx, m.Next, run.Now, run.Dir)
+}
+
+// runPhase returns false when the machine has been interrupted or cancelled
+func runPhase(ctx context.Context, b Bot, r *Run, p *Phase, start int) bool {
+	phaseStart := r.Elapsed
+	for {
+		if p.Millis > 0 && !p.OnData && r.Elapsed-phaseStart >= p.Millis {
+			return true
+		}
+		now, ok := b.Read(ctx)
+		if !ok {
+			return false
+		}
+		r.Now, r.Elapsed = now, now-start
+		if p.Millis > 0 && p.OnData && r.Elapsed-phaseStart >= p.Millis {
+			return true
+		}
+		if p.Until != nil && p.Until(r) {
+			return true
+		}
+		for _, check := range p.Interrupts {
+			if check(ctx, r) {
+				return false
+			}
+		}
+
+		left, right := Sides(r.Dir, p.Outer, p.Inner)
+		if p.Steer != nil {
+			left, right = p.Steer(r)
+		}
+		cmdline.Trace(r.Now, p.Name, left, right)
+		b.Drive(left, right, p.Front)
+	}
+}
+
+// Transition records the logic entering a state
+type Transition struct {
+	Millis  int
+	Machine string
+	Phase   string
+	Dir     ev3.Direction
+}
+
+const historySize = 100
+
+// states records the transitions of the logic, they are read from other goroutines
+type states struct {
+	stateMutex sync.Mutex
+	state      Transition
+	history    []Transition
+}
+
+// EnterState records a transition
+func (s *states) EnterState(now int, machine string, phase string, dir ev3.Direction) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.state = Transition{now, machine, phase, dir}
+	if len(s.history) == historySize {
+		s.history = s.history[1:]
+	}
+	s.history = append(s.history, s.state)
+}
+
+// State returns the current state of the logic
+func (s *states) State() Transition {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.state
+}
+
+// History returns the most recent transitions, oldest first
+func (s *states) History() []Transition {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	result := make([]Transition, len(s.history))
+	copy(result, s.history)
+	return result
+}
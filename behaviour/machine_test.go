@@ -0,0 +1,223 @@
+package behaviour
+
+import (
+	"context"
+	"go-bots/ev3"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeBot reads the given reading times, then waits for the end of the behaviour
+type fakeBot struct {
+	readings []int
+	now      int
+	drives   []drive
+}
+
+type drive struct {
+	now   int
+	left  int
+	right int
+	front bool
+}
+
+func (b *fakeBot) Read(ctx context.Context) (int, bool) {
+	if len(b.readings) == 0 {
+		<-ctx.Done()
+		return 0, false
+	}
+	b.now, b.readings = b.readings[0], b.readings[1:]
+	return b.now, true
+}
+
+func (b *fakeBot) Drive(left int, right int, front bool) {
+	b.drives = append(b.drives, drive{b.now, left, right, front})
+}
+
+// handedOff records a handoff
+type handedOff struct {
+	start int
+	dir   ev3.Direction
+}
+
+// runMachine runs m on a runner and returns the handoff done when it ends
+func runMachine(t *testing.T, b Bot, m *Machine, dir ev3.Direction, first int) (*Runner, handedOff) {
+	r := NewRunner()
+	go r.Run()
+	done := make(chan handedOff, 1)
+	if m.Next == nil {
+		m.Next = func(ctx context.Context, start int, dir ev3.Direction) {
+			done <- handedOff{start, dir}
+		}
+	}
+	r.Interrupt(func(ctx context.Context, start int, dir ev3.Direction) {
+		r.RunMachine(ctx, b, m, start, dir, first)
+	}, 0, dir)
+	select {
+	case h := <-done:
+		return r, h
+	case <-time.After(time.Second):
+		t.Fatal("the machine did not hand off")
+	}
+	return nil, handedOff{}
+}
+
+func TestPhaseDurations(t *testing.T) {
+	b := &fakeBot{readings: []int{40, 80, 120, 160, 200, 240}}
+	m := &Machine{
+		Name: "M",
+		Phases: []Phase{
+			{Name: "timed", Millis: 100, Outer: 10, Inner: 5},
+			{Name: "onData", Millis: 50, OnData: true, Outer: 20, Inner: -20, Front: true, FlipDir: true},
+		},
+	}
+	r, h := runMachine(t, b, m, ev3.Right, 0)
+
+	// The timed phase drives with the reading that ends it, the onData one drops it
+	expected := []drive{{40, 10, 5, false}, {80, 10, 5, false}, {120, 10, 5, false}, {160, 20, -20, true}}
+	if !reflect.DeepEqual(b.drives, expected) {
+		t.Errorf("drives %v, expected %v", b.drives, expected)
+	}
+	if h != (handedOff{200, ev3.Left}) {
+		t.Errorf("handed off %v, expected at 200 towards the flipped direction", h)
+	}
+	history := []Transition{{0, "M", "timed", ev3.Right}, {120, "M", "onData", ev3.Right}}
+	if !reflect.DeepEqual(r.History(), history) {
+		t.Errorf("history %v, expected %v", r.History(), history)
+	}
+}
+
+func TestPhaseUntilAndSteer(t *testing.T) {
+	b := &fakeBot{readings: []int{10, 20, 30, 40}}
+	m := &Machine{
+		Name: "M",
+		Phases: []Phase{
+			{
+				Name: "steer",
+				Steer: func(r *Run) (int, int) {
+					return r.Elapsed, -r.Elapsed
+				},
+				Until: func(r *Run) bool {
+					return r.Now >= 30
+				},
+			},
+		},
+		FlipNext: true,
+	}
+	_, h := runMachine(t, b, m, ev3.Left, 0)
+
+	expected := []drive{{10, 10, -10, false}, {20, 20, -20, false}}
+	if !reflect.DeepEqual(b.drives, expected) {
+		t.Errorf("drives %v, expected %v", b.drives, expected)
+	}
+	if h != (handedOff{30, ev3.Right}) {
+		t.Errorf("handed off %v, expected at 30 towards the flipped direction", h)
+	}
+}
+
+func TestPhaseInterrupt(t *testing.T) {
+	b := &fakeBot{readings: []int{10, 20, 30}}
+	r := NewRunner()
+	go r.Run()
+	interrupted := make(chan handedOff, 1)
+	next := make(chan bool, 1)
+	m := &Machine{
+		Name: "M",
+		Phases: []Phase{
+			{
+				Name:  "interrupted",
+				Outer: 1,
+				Inner: 1,
+				Interrupts: []Check{
+					func(ctx context.Context, run *Run) bool {
+						return false
+					},
+					func(ctx context.Context, run *Run) bool {
+						if run.Now < 20 {
+							return false
+						}
+						r.HandOff(ctx, func(ctx context.Context, start int, dir ev3.Direction) {
+							interrupted <- handedOff{start, dir}
+						}, run.Now, ev3.NoDirection)
+						return true
+					},
+				},
+			},
+		},
+		Next: func(ctx context.Context, start int, dir ev3.Direction) {
+			next <- true
+		},
+	}
+	r.Interrupt(func(ctx context.Context, start int, dir ev3.Direction) {
+		r.RunMachine(ctx, b, m, start, dir, 0)
+	}, 0, ev3.Left)
+
+	select {
+	case h := <-interrupted:
+		if h != (handedOff{20, ev3.NoDirection}) {
+			t.Errorf("interrupted with %v, expected at 20", h)
+		}
+	case <-next:
+		t.Fatal("the machine ended instead of being interrupted")
+	case <-time.After(time.Second):
+		t.Fatal("the machine was not interrupted")
+	}
+	if len(b.drives) != 1 {
+		t.Errorf("drives %v, expected only the reading before the interrupt", b.drives)
+	}
+}
+
+func TestLoopFromPhase(t *testing.T) {
+	b := &fakeBot{readings: []int{10, 20, 30, 40, 50, 60, 70}}
+	m := &Machine{
+		Name: "M",
+		Phases: []Phase{
+			{Name: "a", Millis: 20, OnData: true},
+			{Name: "b", Millis: 20, OnData: true, FlipDir: true},
+		},
+		Loop: true,
+	}
+	r := NewRunner()
+	go r.Run()
+	r.Interrupt(func(ctx context.Context, start int, dir ev3.Direction) {
+		r.RunMachine(ctx, b, m, start, dir, 1)
+	}, 0, ev3.Right)
+
+	// b ends on 20, a on 40, b on 60, then the machine waits for readings
+	expected := []Transition{{0, "M", "b", ev3.Right}, {20, "M", "a", ev3.Left}, {40, "M", "b", ev3.Left}, {60, "M", "a", ev3.Right}}
+	deadline := time.Now().Add(time.Second)
+	for len(r.History()) < len(expected) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !reflect.DeepEqual(r.History(), expected) {
+		t.Errorf("history %v, expected %v", r.History(), expected)
+	}
+	if r.State() != expected[len(expected)-1] {
+		t.Errorf("state %v, expected the last transition", r.State())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	r := NewRunner()
+	if len(r.History()) != 0 || r.State() != (Transition{}) {
+		t.Fatal("a new runner has a history")
+	}
+	for i := 0; i < historySize+50; i++ {
+		r.EnterState(i, "M", "p", ev3.Left)
+	}
+	history := r.History()
+	if len(history) != historySize {
+		t.Fatalf("history of %d transitions, expected %d", len(history), historySize)
+	}
+	if history[0].Millis != 50 || history[historySize-1].Millis != historySize+49 {
+		t.Errorf("history from %d to %d, expected the most recent oldest first", history[0].Millis, history[historySize-1].Millis)
+	}
+	if r.State() != history[historySize-1] {
+		t.Errorf("state %v, expected the last transition", r.State())
+	}
+	history[0].Machine = "changed"
+	if r.History()[0].Machine != "M" {
+		t.Error("History returns the recorded transitions instead of a copy")
+	}
+}
@@ -0,0 +1,158 @@
+package behaviour
+
+import (
+	"context"
+	"fmt"
+	"go-bots/cmdline"
+	"go-bots/ev3"
+	"go-bots/ui"
+	"os"
+	"sync"
+)
+
+// Func drives the bot until its context is cancelled or it hands off to the next one
+type Func func(ctx context.Context, start int, dir ev3.Direction)
+
+type handoff struct {
+	next  Func
+	start int
+	dir   ev3.Direction
+}
+
+// menuKeysBuffer is how many keys wait for the menu to read them, more are
+// dropped (so a stuck menu cannot block Quit and Back)
+const menuKeysBuffer = 16
+
+// Runner runs the behaviours of a bot one at a time and records their states
+type Runner struct {
+	states
+
+	// menuKeys receives the keys that are not handled by the runner while the menu is active
+	menuKeys chan ui.KeyEvent
+
+	mutex        sync.Mutex
+	wake         *sync.Cond
+	pending      *handoff
+	active       context.Context
+	cancelActive context.CancelFunc
+	inMenu       bool
+}
+
+// NewRunner creates a runner with no behaviour scheduled
+func NewRunner() *Runner {
+	r := &Runner{menuKeys: make(chan ui.KeyEvent, menuKeysBuffer)}
+	r.wake = sync.NewCond(&r.mutex)
+	return r
+}
+
+// Run runs one behaviour at a time, so only the active one can write commands
+func (r *Runner) Run() {
+	defer ev3.Recover()
+
+	r.mutex.Lock()
+	for {
+		for r.pending == nil {
+			r.wake.Wait()
+		}
+		h := *r.pending
+		r.pending = nil
+		ctx, cancel := context.WithCancel(context.Background())
+		r.active, r.cancelActive = ctx, cancel
+		r.mutex.Unlock()
+
+		h.next(ctx, h.start, h.dir)
+
+		r.mutex.Lock()
+		cancel()
+	}
+}
+
+// HandOff cancels the behaviour owning ctx and schedules next, it is ignored
+// when ctx is no longer active (the behaviour has already been replaced)
+func (r *Runner) HandOff(ctx context.Context, next Func, start int, dir ev3.Direction) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if ctx != r.active || ctx.Err() != nil {
+		return
+	}
+	r.cancelActive()
+	r.pending = &handoff{next, start, dir}
+	r.wake.Signal()
+}
+
+// Interrupt cancels whatever is running and schedules next, overriding any pending handoff
+func (r *Runner) Interrupt(next Func, start int, dir ev3.Direction) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cancelActive != nil {
+		r.cancelActive()
+	}
+	r.pending = &handoff{next, start, dir}
+	r.wake.Signal()
+}
+
+// SetInMenu tells the runner whether the active behaviour is a menu reading MenuKeys
+func (r *Runner) SetInMenu(v bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.inMenu = v
+	// The keys pressed for an earlier menu are not for this one
+	for v {
+		select {
+		case <-r.menuKeys:
+		default:
+			return
+		}
+	}
+}
+
+func (r *Runner) isInMenu() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.inMenu
+}
+
+// MenuKeys returns the keys pressed while in the menu
+func (r *Runner) MenuKeys() <-chan ui.KeyEvent {
+	return r.menuKeys
+}
+
+// DispatchKeys turns Quit and Back into cancellation and forwards the other keys to the menu:
+// outside of the menu they interrupt the running behaviour with menu, in the menu they signal quit
+func (r *Runner) DispatchKeys(keys <-chan ui.KeyEvent, quit chan<- bool, menu Func) {
+	defer ev3.Recover()
+
+	for k := range keys {
+		if k.Key == ui.Quit || k.Key == ui.Back {
+			if r.isInMenu() {
+				quit <- true
+				continue
+			}
+			Log(k.Millis, ev3.NoDirection, " *** DONE ***")
+			r.Interrupt(menu, k.Millis, ev3.NoDirection)
+			continue
+		}
+		if r.isInMenu() {
+			select {
+			case r.menuKeys <- k:
+			default:
+			}
+		}
+	}
+}
+
+// Log prints what the bot is doing, at the info level
+func Log(now int, dir ev3.Direction, msg string) {
+	dirString := ""
+	if dir == ev3.Left {
+		dirString = "LEFT"
+	} else if dir == ev3.Right {
+		dirString = "RIGHT"
+	} else {
+		dirString = "NONE"
+	}
+
+	if cmdline.Enabled(cmdline.Info) {
+		fmt.Fprintln(os.Stderr, now, dirString, msg)
+	}
+}
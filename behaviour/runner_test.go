@@ -0,0 +1,45 @@
+package behaviour
+
+import (
+	"context"
+	"go-bots/ev3"
+	"go-bots/ui"
+	"testing"
+	"time"
+)
+
+func TestDispatchKeysKeepsMenuKeys(t *testing.T) {
+	r := NewRunner()
+	r.SetInMenu(true)
+	keys := make(chan ui.KeyEvent)
+	quit := make(chan bool, 1)
+	go r.DispatchKeys(keys, quit, func(ctx context.Context, start int, dir ev3.Direction) {})
+
+	// Pressed faster than the menu reads them
+	pressed := []ui.Key{ui.Right, ui.Up, ui.Down, ui.Enter}
+	for i, k := range pressed {
+		keys <- ui.KeyEvent{Key: k, Millis: i}
+	}
+	for _, want := range pressed {
+		select {
+		case k := <-r.MenuKeys():
+			if k.Key != want {
+				t.Fatalf("menu read %v, want %v", k.Key, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("key %v lost", want)
+		}
+	}
+
+	// A new menu does not see the keys left over by the previous one
+	keys <- ui.KeyEvent{Key: ui.Left}
+	keys <- ui.KeyEvent{Key: ui.Back}
+	<-quit
+	r.SetInMenu(true)
+	select {
+	case k := <-r.MenuKeys():
+		t.Errorf("new menu read %v", k.Key)
+	default:
+	}
+	close(keys)
+}
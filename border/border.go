@@ -0,0 +1,108 @@
+package border
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Sensor is the calibration of a corner color sensor
+type Sensor struct {
+	// Surface is the brightest reflectance seen on the dohyo surface
+	Surface int
+	// Border is the darkest reflectance seen on the white border
+	Border int
+	// Threshold is the reflectance above which the sensor is out
+	Threshold int
+}
+
+// Calibration holds the calibration of the corner sensors
+type Calibration struct {
+	Left  Sensor
+	Right Sensor
+}
+
+// Sampler collects the readings of a sensor
+type Sampler struct {
+	Min     int
+	Max     int
+	Samples int
+}
+
+// Add records a reading
+func (s *Sampler) Add(v int) {
+	if s.Samples == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Samples == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Samples++
+}
+
+// Compute picks the threshold of a sensor from its samples on the surface
+// and on the border: it is placed marginPC percent of the way from the
+// brightest surface reading to the darkest border one
+func Compute(surface Sampler, border Sampler, marginPC int) (Sensor, error) {
+	if surface.Samples == 0 || border.Samples == 0 {
+		return Sensor{}, fmt.Errorf("no readings")
+	}
+	result := Sensor{Surface: surface.Max, Border: border.Min}
+	if result.Border <= result.Surface {
+		return result, fmt.Errorf("border (%d) is not brighter than the surface (%d)", result.Border, result.Surface)
+	}
+	result.Threshold = result.Surface + (result.Border-result.Surface)*marginPC/100
+	return result, nil
+}
+
+// Save writes a calibration to a TOML file
+func Save(c Calibration, fileName string) error {
+	var buf bytes.Buffer
+	err := toml.NewEncoder(&buf).Encode(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, buf.Bytes(), 0644)
+}
+
+// Load reads a calibration from a TOML file
+func Load(fileName string) (Calibration, error) {
+	result := Calibration{}
+	_, err := toml.DecodeFile(fileName, &result)
+	if err != nil {
+		return result, err
+	}
+	if result.Left.Threshold <= 0 || result.Right.Threshold <= 0 {
+		return result, fmt.Errorf("missing thresholds in %s", fileName)
+	}
+	return result, nil
+}
+
+// Current holds the calibration in use by a bot, its logic calibrates the
+// sensors while its io reads them
+type Current struct {
+	value atomic.Value
+}
+
+// Set makes a calibration the one in use
+func (c *Current) Set(cal Calibration) {
+	c.value.Store(&cal)
+}
+
+// Get returns the calibration in use, nil when there is none
+func (c *Current) Get() *Calibration {
+	cal, _ := c.value.Load().(*Calibration)
+	return cal
+}
+
+// Thresholds returns the thresholds in use, fallback when there is no calibration
+func (c *Current) Thresholds(fallback int) (left int, right int) {
+	cal := c.Get()
+	if cal == nil {
+		return fallback, fallback
+	}
+	return cal.Left.Threshold, cal.Right.Threshold
+}
@@ -0,0 +1,208 @@
+package botconf
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Validate checks the fields of a configuration struct against their check tags.
+//
+// A check tag is a comma separated list of rules:
+//
+//	required    the value must not be zero (or empty)
+//	min=N       the value must be at least N
+//	max=N       the value must be at most N
+//	lt=Field    the value must be less than Field of the same struct
+//	le=Field    the value must be less than or equal to Field
+//	gt=Field    the value must be greater than Field
+//	ge=Field    the value must be greater than or equal to Field
+//	oneof=a|b   the value must be one of the listed strings
+//
+// Structs, slices and maps of structs are checked recursively, all the
+// problems found are reported in the returned error
+func Validate(v interface{}) error {
+	problems := []string{}
+	validate(reflect.Indirect(reflect.ValueOf(v)), "", &problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func validate(v reflect.Value, path string, problems *[]string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			fieldPath := join(path, f.Name)
+			if tag, ok := f.Tag.Lookup("check"); ok {
+				for _, rule := range strings.Split(tag, ",") {
+					if err := checkRule(v, v.Field(i), strings.TrimSpace(rule)); err != nil {
+						*problems = append(*problems, fieldPath+": "+err.Error())
+						break
+					}
+				}
+			}
+			validate(v.Field(i), fieldPath, problems)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validate(v.Index(i), fmt.Sprintf("%s[%d]", path, i+1), problems)
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			validate(v.MapIndex(k), join(path, fmt.Sprint(k)), problems)
+		}
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			validate(v.Elem(), path, problems)
+		}
+	}
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func checkRule(parent reflect.Value, v reflect.Value, rule string) error {
+	name, arg := rule, ""
+	if i := strings.Index(rule, "="); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	switch name {
+	case "":
+		return nil
+	case "required":
+		if isZero(v) {
+			return fmt.Errorf("is required")
+		}
+		return nil
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		for _, allowed := range strings.Split(arg, "|") {
+			if s == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("is %q, must be one of %s", s, strings.Replace(arg, "|", ", ", -1))
+	}
+
+	value, ok := number(v)
+	if !ok {
+		return fmt.Errorf("rule %q needs a number", rule)
+	}
+
+	switch name {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("invalid rule %q", rule)
+		}
+		if name == "min" && value < limit {
+			return fmt.Errorf("is %v, must be at least %s", v.Interface(), arg)
+		}
+		if name == "max" && value > limit {
+			return fmt.Errorf("is %v, must be at most %s", v.Interface(), arg)
+		}
+		return nil
+	case "lt", "le", "gt", "ge":
+		other := parent.FieldByName(arg)
+		if !other.IsValid() {
+			return fmt.Errorf("invalid rule %q: no field %s", rule, arg)
+		}
+		limit, ok := number(other)
+		if !ok {
+			return fmt.Errorf("invalid rule %q: %s is not a number", rule, arg)
+		}
+		valid := map[string]bool{
+			"lt": value < limit,
+			"le": value <= limit,
+			"gt": value > limit,
+			"ge": value >= limit,
+		}[name]
+		if !valid {
+			relation := map[string]string{
+				"lt": "less than",
+				"le": "at most",
+				"gt": "greater than",
+				"ge": "at least",
+			}[name]
+			return fmt.Errorf("is %v, must be %s %s (%v)", v.Interface(), relation, arg, other.Interface())
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown rule %q", rule)
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// String formats a configuration struct with one field per line
+func String(v interface{}) string {
+	var buf bytes.Buffer
+	format(&buf, reflect.Indirect(reflect.ValueOf(v)), "")
+	return buf.String()
+}
+
+func format(buf *bytes.Buffer, v reflect.Value, path string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			format(buf, v.Field(i), join(path, f.Name))
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 || v.Index(0).Kind() != reflect.Struct {
+			fmt.Fprintf(buf, "%s = %v\n", path, v.Interface())
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			format(buf, v.Index(i), fmt.Sprintf("%s[%d]", path, i+1))
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			format(buf, v.MapIndex(k), join(path, fmt.Sprint(k)))
+		}
+	case reflect.String:
+		fmt.Fprintf(buf, "%s = %q\n", path, v.String())
+	default:
+		fmt.Fprintf(buf, "%s = %v\n", path, v.Interface())
+	}
+}
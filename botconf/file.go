@@ -0,0 +1,87 @@
+package botconf
+
+import (
+	"go-bots/clock"
+	"sync"
+	"sync/atomic"
+)
+
+// Decoder reads the configuration of a bot from a file with the named profile
+// and then sets (as Key=Value), it returns a pointer to the configuration and
+// the name of the applied profile
+type Decoder func(fileName string, profile string, sets []string) (interface{}, string, error)
+
+// File is the configuration file of a bot, with the profile and sets in use.
+// The parts of the bot share it, reloading it changes the configuration they get
+type File struct {
+	current atomic.Value
+	decode  Decoder
+
+	mutex   sync.Mutex
+	name    string
+	profile string
+	sets    []string
+	watcher *Watcher
+}
+
+// LoadFile reads a configuration file with decode, applying the named profile
+// and sets (they are applied again on every reload), changes to the file are
+// looked for on the clock c. On error the file uses defaults, a pointer of
+// the type decode returns
+func LoadFile(fileName string, profileName string, sets []string, defaults interface{}, decode Decoder, c clock.Clock) (*File, error) {
+	f := &File{decode: decode, name: fileName, sets: sets, watcher: NewWatcher(fileName, WatchInterval, c)}
+	f.current.Store(defaults)
+	return f, f.load(profileName)
+}
+
+// Current returns the configuration in use, callers must not modify it
+func (f *File) Current() interface{} {
+	return f.current.Load()
+}
+
+// Name returns the name of the file
+func (f *File) Name() string {
+	return f.name
+}
+
+// load reads the file with the named profile, on error the configuration in use is kept
+func (f *File) load(profileName string) error {
+	f.watcher.Reset()
+	conf, profile, err := f.decode(f.name, profileName, f.sets)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	f.profile = profile
+	f.mutex.Unlock()
+	f.current.Store(conf)
+	return nil
+}
+
+// Reload reads the file again, with the profile and sets in use
+func (f *File) Reload() error {
+	f.mutex.Lock()
+	profileName := f.profile
+	f.mutex.Unlock()
+
+	return f.load(profileName)
+}
+
+// ReloadIfChanged reads the file again when it has changed on disk, it tells
+// if the file has been read (on error the configuration in use is kept)
+func (f *File) ReloadIfChanged() (bool, error) {
+	if !f.watcher.Changed() {
+		return false, nil
+	}
+	return true, f.Reload()
+}
+
+// SelectProfile reads the file again, switching to another profile
+func (f *File) SelectProfile(profileName string) error {
+	return f.load(profileName)
+}
+
+// ProfileNames returns the profiles defined in the file
+func (f *File) ProfileNames() ([]string, error) {
+	return ProfileNamesInFile(f.name)
+}
@@ -0,0 +1,97 @@
+package botconf
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ApplyOverrides sets the values of sets (as Key=Value) in v, a pointer to a
+// configuration struct, keys are paths like KP or Strategies.left.Steps[1].Time
+func ApplyOverrides(v interface{}, sets []string) error {
+	for _, set := range sets {
+		i := strings.Index(set, "=")
+		if i < 0 {
+			return fmt.Errorf("override %q: expected Key=Value", set)
+		}
+		key, value := strings.TrimSpace(set[:i]), strings.TrimSpace(set[i+1:])
+		err := setPath(reflect.ValueOf(v).Elem(), strings.Split(key, "."), value)
+		if err != nil {
+			return fmt.Errorf("override %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func setPath(v reflect.Value, path []string, value string) error {
+	if len(path) == 0 {
+		return setValue(v, value)
+	}
+
+	name, index := path[0], 0
+	if i := strings.Index(name, "["); i >= 0 && strings.HasSuffix(name, "]") {
+		n, err := strconv.Atoi(name[i+1 : len(name)-1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid index in %s", name)
+		}
+		name, index = name[:i], n
+	}
+
+	var field reflect.Value
+	switch v.Kind() {
+	case reflect.Struct:
+		field = v.FieldByName(name)
+		if !field.IsValid() {
+			return fmt.Errorf("unknown key %s", name)
+		}
+	case reflect.Map:
+		key := reflect.ValueOf(name).Convert(v.Type().Key())
+		elem := v.MapIndex(key)
+		if !elem.IsValid() {
+			return fmt.Errorf("unknown key %s", name)
+		}
+		// Map entries are not addressable, change a copy and put it back
+		field = reflect.New(elem.Type()).Elem()
+		field.Set(elem)
+		defer v.SetMapIndex(key, field)
+	default:
+		return fmt.Errorf("%s is not a table", name)
+	}
+
+	if index > 0 {
+		if field.Kind() != reflect.Slice || index > field.Len() {
+			return fmt.Errorf("no element %d in %s", index, name)
+		}
+		field = field.Index(index - 1)
+	}
+	return setPath(field, path[1:], value)
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(strings.Trim(value, `"`))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("cannot set a %s", v.Kind())
+	}
+	return nil
+}
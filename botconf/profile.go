@@ -0,0 +1,164 @@
+package botconf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go-bots/cmdline"
+)
+
+// ProfileKey names the profile used when none is selected explicitly
+const ProfileKey = "Profile"
+
+// ProfilesKey is the table holding the named profiles, for example:
+//
+//	MaxSpeed = 30
+//	Profile = "home"
+//
+//	[Profiles.home]
+//	MaxSpeed = 25
+//
+//	[Profiles.finals]
+//	MaxSpeed = 40
+//
+// Each profile inherits the values of the base section and overrides the ones
+// it sets, an entry of a table map (like a strategy) set in a profile replaces
+// the whole entry
+const ProfilesKey = "Profiles"
+
+type profiles struct {
+	Profile  string
+	Profiles map[string]toml.Primitive
+}
+
+// Deprecated is implemented by configurations that still accept keys of older
+// versions: DeprecatedKeys maps each of them to what replaced it, they are
+// ignored with a warning instead of being reported as unknown
+type Deprecated interface {
+	DeprecatedKeys() map[string]string
+}
+
+// DecodeProfileSets reads the base section of TOML data into v (a pointer to a
+// configuration struct) and then applies the named profile on top of it.
+// An empty name selects the profile given by the Profile key of the data,
+// or the base section alone when there is none.
+// The values of sets (as Key=Value) are applied last.
+// The name of the applied profile is returned, keys that do not match any
+// field (in the base section or in any profile) are reported as an error
+func DecodeProfileSets(data string, name string, sets []string, v interface{}) (string, error) {
+	md, err := toml.Decode(data, v)
+	if err != nil {
+		return "", err
+	}
+	var p profiles
+	pmd, err := toml.Decode(data, &p)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = p.Profile
+	}
+	if _, ok := p.Profiles[name]; name != "" && !ok {
+		return "", fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(sortedNames(p.Profiles), ", "))
+	}
+
+	deprecated := map[string]string{}
+	if d, ok := v.(Deprecated); ok {
+		deprecated = d.DeprecatedKeys()
+	}
+	unknown := []string{}
+	for _, k := range md.Undecoded() {
+		if len(k) > 0 && k[0] != ProfileKey && k[0] != ProfilesKey {
+			unknown = checkDeprecated(unknown, deprecated, k, k.String())
+		}
+	}
+
+	// Every profile is decoded (the ones not in use into a scratch value) so
+	// that mistakes are reported even in profiles that are not selected
+	t := reflect.TypeOf(v).Elem()
+	for _, profileName := range sortedNames(p.Profiles) {
+		target := v
+		if profileName != name {
+			target = reflect.New(t).Interface()
+		}
+		err = pmd.PrimitiveDecode(p.Profiles[profileName], target)
+		if err != nil {
+			return "", fmt.Errorf("profile %s: %v", profileName, err)
+		}
+	}
+	for _, k := range pmd.Undecoded() {
+		if len(k) > 2 && k[0] == ProfilesKey {
+			unknown = checkDeprecated(unknown, deprecated, k, k[2:].String())
+		}
+	}
+
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+	return name, ApplyOverrides(v, sets)
+}
+
+// checkDeprecated warns about k when key (its path in a section) is deprecated,
+// otherwise it adds k to unknown
+func checkDeprecated(unknown []string, deprecated map[string]string, k toml.Key, key string) []string {
+	why, ok := deprecated[key]
+	if !ok {
+		return append(unknown, k.String())
+	}
+	cmdline.Log(cmdline.Info, "Deprecated key", k.String(), "is ignored:", why)
+	return unknown
+}
+
+// ProfileNames returns the names of the profiles defined in TOML data, sorted
+func ProfileNames(data string) ([]string, error) {
+	var p profiles
+	_, err := toml.Decode(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	return sortedNames(p.Profiles), nil
+}
+
+// ProfileNamesInFile returns the names of the profiles defined in a TOML file, sorted
+func ProfileNamesInFile(fileName string) ([]string, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ProfileNames(string(b))
+}
+
+// NextProfile returns the profile after current in names, going back to
+// the first one after the last one
+func NextProfile(names []string, current string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	for i, name := range names {
+		if name == current && i+1 < len(names) {
+			return names[i+1]
+		}
+	}
+	return names[0]
+}
+
+// ProfileLabel returns a printable name for a profile
+func ProfileLabel(name string) string {
+	if name == "" {
+		return "(base)"
+	}
+	return name
+}
+
+func sortedNames(m map[string]toml.Primitive) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
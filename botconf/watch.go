@@ -0,0 +1,88 @@
+package botconf
+
+import (
+	"go-bots/clock"
+	"os"
+	"sync"
+	"time"
+)
+
+// WatchInterval is how often a Watcher looks at its file
+const WatchInterval = 500 * time.Millisecond
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s fileState) same(o fileState) bool {
+	return s.modTime.Equal(o.modTime) && s.size == o.size
+}
+
+func statFile(fileName string) (fileState, bool) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return fileState{}, false
+	}
+	return fileState{info.ModTime(), info.Size()}, true
+}
+
+// Watcher notices when a file changes on disk.
+// It has no goroutine: the bot polls it from its loop, at a point where
+// swapping the configuration is safe, and then loads and validates the file
+type Watcher struct {
+	mutex     sync.Mutex
+	fileName  string
+	clock     clock.Clock
+	interval  time.Duration
+	lastCheck time.Time
+	seen      fileState
+	candidate fileState
+	pending   bool
+}
+
+// NewWatcher watches fileName, its current contents are considered already
+// loaded, the interval is measured on c
+func NewWatcher(fileName string, interval time.Duration, c clock.Clock) *Watcher {
+	w := &Watcher{fileName: fileName, clock: c, interval: interval}
+	w.seen, _ = statFile(fileName)
+	return w
+}
+
+// Changed returns true (once) when the file has changed since it was last
+// seen, and has then been stable for an interval, so that a file that is
+// still being copied is not read half written.
+// A missing file is not a change, the configuration in use is kept
+func (w *Watcher) Changed() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.clock.Now()
+	if now.Sub(w.lastCheck) < w.interval {
+		return false
+	}
+	w.lastCheck = now
+
+	current, ok := statFile(w.fileName)
+	if !ok || current.same(w.seen) {
+		w.pending = false
+		return false
+	}
+	if !w.pending || !current.same(w.candidate) {
+		w.candidate = current
+		w.pending = true
+		return false
+	}
+	w.seen = current
+	w.pending = false
+	return true
+}
+
+// Reset marks the current contents of the file as seen, call it after
+// loading the file for other reasons
+func (w *Watcher) Reset() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.seen, _ = statFile(w.fileName)
+	w.pending = false
+}
@@ -0,0 +1,226 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the io and ui loops, the real one on the robot
+// and a manual one in simulations, where time only moves when the
+// simulation steps
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d has passed
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine once d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+	// NewTicker sends the time on its channel every d
+	NewTicker(d time.Duration) Ticker
+	// Sleep returns once d has passed
+	Sleep(d time.Duration)
+}
+
+// Timer is a call waiting in AfterFunc
+type Timer interface {
+	// Stop cancels the call, it returns false if it has already been made
+	Stop() bool
+}
+
+// Ticker sends the time at regular intervals
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the clock of the system
+type Real struct{}
+
+// Now returns the current time
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for d on the system clock
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// AfterFunc calls f after d on the system clock
+func (Real) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// NewTicker ticks every d on the system clock
+func (Real) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// Sleep sleeps for d on the system clock
+func (Real) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
+
+// Manual is a clock that only moves when it is set or advanced, it can be
+// shared between goroutines. Its timers fire when it is moved past them.
+type Manual struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending After, AfterFunc or ticker of a manual clock
+type waiter struct {
+	at time.Time
+	// period is the interval of a ticker (0 for a timer)
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+// NewManual creates a manual clock showing start
+func NewManual(start time.Time) *Manual {
+	return &Manual{now: start}
+}
+
+// Now returns the time the clock was last set to
+func (m *Manual) Now() time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.now
+}
+
+// Set moves the clock to t, firing the timers it passes in order
+func (m *Manual) Set(t time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.set(t)
+}
+
+// Advance moves the clock forward by d, firing the timers it passes in order
+func (m *Manual) Advance(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.set(m.now.Add(d))
+}
+
+// Waiting tells how many timers and tickers are waiting for the clock to move
+func (m *Manual) Waiting() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.waiters)
+}
+
+// After sends the time on the returned channel once the clock has moved by d
+func (m *Manual) After(d time.Duration) <-chan time.Time {
+	w := &waiter{c: make(chan time.Time, 1)}
+	m.add(w, d)
+	return w.c
+}
+
+// AfterFunc calls f in its own goroutine once the clock has moved by d
+func (m *Manual) AfterFunc(d time.Duration, f func()) Timer {
+	w := &waiter{f: f}
+	m.add(w, d)
+	return manualTimer{m, w}
+}
+
+// NewTicker sends the time every time the clock moves past a multiple of d,
+// like a time.Ticker it drops the ticks that are not read
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	w := &waiter{period: d, c: make(chan time.Time, 1)}
+	m.add(w, d)
+	return manualTicker{m, w}
+}
+
+// Sleep returns once the clock has moved by d
+func (m *Manual) Sleep(d time.Duration) {
+	<-m.After(d)
+}
+
+func (m *Manual) add(w *waiter, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	w.at = m.now.Add(d)
+	m.waiters = append(m.waiters, w)
+	m.set(m.now)
+}
+
+// remove takes w out of the waiters, it returns false if it was not there
+func (m *Manual) remove(w *waiter) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, other := range m.waiters {
+		if other == w {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// set moves the clock to t and fires the waiters due by then, the earliest first
+func (m *Manual) set(t time.Time) {
+	m.now = t
+	for {
+		next := -1
+		for i, w := range m.waiters {
+			if !w.at.After(t) && (next < 0 || w.at.Before(m.waiters[next].at)) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return
+		}
+		w := m.waiters[next]
+		if w.f != nil {
+			go w.f()
+		} else {
+			select {
+			case w.c <- w.at:
+			default:
+			}
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			m.waiters = append(m.waiters[:next], m.waiters[next+1:]...)
+		}
+	}
+}
+
+type manualTimer struct {
+	m *Manual
+	w *waiter
+}
+
+func (t manualTimer) Stop() bool {
+	return t.m.remove(t.w)
+}
+
+type manualTicker struct {
+	m *Manual
+	w *waiter
+}
+
+func (t manualTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t manualTicker) Stop() {
+	t.m.remove(t.w)
+}
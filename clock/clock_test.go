@@ -0,0 +1,87 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualTimersFireInOrder(t *testing.T) {
+	start := time.Time{}
+	m := NewManual(start)
+	fired := make(chan string, 10)
+	after := m.After(300 * time.Millisecond)
+	m.AfterFunc(100*time.Millisecond, func() { fired <- "func" })
+	stopped := m.AfterFunc(200*time.Millisecond, func() { fired <- "stopped" })
+	if m.Waiting() != 3 {
+		t.Fatal("waiting", m.Waiting(), "want 3")
+	}
+
+	m.Advance(99 * time.Millisecond)
+	select {
+	case <-after:
+		t.Fatal("After fired early")
+	case f := <-fired:
+		t.Fatal(f, "fired early")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	m.Advance(time.Millisecond)
+	if f := <-fired; f != "func" {
+		t.Error("fired", f)
+	}
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Stop does not tell if the call was pending")
+	}
+	m.Advance(time.Second)
+	if at := <-after; !at.Equal(start.Add(300 * time.Millisecond)) {
+		t.Error("After sent", at)
+	}
+	select {
+	case f := <-fired:
+		t.Error(f, "fired after Stop")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if m.Waiting() != 0 {
+		t.Error("waiting", m.Waiting(), "want 0")
+	}
+}
+
+func TestManualTicker(t *testing.T) {
+	m := NewManual(time.Time{})
+	ticker := m.NewTicker(10 * time.Millisecond)
+	for i := 1; i <= 3; i++ {
+		m.Advance(10 * time.Millisecond)
+		if at := <-ticker.C(); at.Sub(time.Time{}) != time.Duration(i)*10*time.Millisecond {
+			t.Error("tick", i, "at", at)
+		}
+	}
+	// Like a time.Ticker it drops the ticks that are not read
+	m.Advance(100 * time.Millisecond)
+	<-ticker.C()
+	select {
+	case at := <-ticker.C():
+		t.Error("second tick at", at)
+	default:
+	}
+	ticker.Stop()
+	m.Advance(100 * time.Millisecond)
+	select {
+	case at := <-ticker.C():
+		t.Error("tick at", at, "after Stop")
+	default:
+	}
+}
+
+func TestManualSleep(t *testing.T) {
+	m := NewManual(time.Time{})
+	done := make(chan bool)
+	go func() {
+		m.Sleep(time.Second)
+		done <- true
+	}()
+	for m.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Advance(time.Second)
+	<-done
+}
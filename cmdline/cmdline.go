@@ -0,0 +1,180 @@
+package cmdline
+
+import (
+	"flag"
+	"fmt"
+	"go-bots/ev3"
+	"os"
+	fp "path/filepath"
+	"strings"
+	"sync"
+)
+
+// Level is the verbosity of the log output
+type Level int
+
+const (
+	// Error only reports errors
+	Error Level = iota
+	// Info reports what the bot is doing (the default)
+	Info
+	// Debug also reports the readings of every loop
+	Debug
+)
+
+var levelNames = map[string]Level{
+	"error": Error,
+	"info":  Info,
+	"debug": Debug,
+}
+
+// Options are the command line options shared by all bots
+type Options struct {
+	// ConfigFile is the configuration file to load
+	ConfigFile string
+	// Profile is the configuration profile to apply ("" for the one named in the file)
+	Profile string
+	// LogLevel is the verbosity of the log output
+	LogLevel Level
+	// TraceFile receives a line per loop iteration ("" for no trace)
+	TraceFile string
+	// Sim is the root of a simulated device tree ("" for the real hardware)
+	Sim string
+	// Sets are configuration values (Key=Value) overriding the ones in the file
+	Sets []string
+}
+
+// SearchPath lists the directories where configuration files are looked for,
+// the directory of the binary is searched after the current one
+var SearchPath = []string{".", "/home/robot"}
+
+// Sets is a repeatable flag of configuration values, as Key=Value
+type Sets []string
+
+func (s *Sets) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set adds a value, it is called by the flag package
+func (s *Sets) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected Key=Value, got %q", value)
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+var logLevel = Info
+
+// Parse reads the command line flags and applies them: configFile is the
+// default configuration file name, searched with FindConfig
+func Parse(configFile string) Options {
+	var o Options
+	var sets Sets
+	var level string
+
+	flag.StringVar(&o.ConfigFile, "config", "", "configuration `file` (default "+configFile+" searched in "+strings.Join(searchDirs(), ", ")+")")
+	flag.StringVar(&o.Profile, "profile", "", "configuration `profile` to apply")
+	flag.StringVar(&level, "log", "info", "log `level` (error, info or debug)")
+	flag.StringVar(&o.TraceFile, "trace", "", "write a trace line per loop iteration to `file`")
+	flag.StringVar(&o.Sim, "sim", "", "use the simulated device tree in `dir` instead of "+ev3.SysClass)
+	flag.Var(&sets, "set", "override a configuration value, as `Key=Value` (repeatable)")
+	flag.Parse()
+
+	l, ok := levelNames[level]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Unknown log level", level)
+		flag.Usage()
+		os.Exit(2)
+	}
+	o.LogLevel = l
+	o.Sets = sets
+	if o.ConfigFile == "" {
+		o.ConfigFile = FindConfig(configFile)
+	}
+
+	logLevel = o.LogLevel
+	if o.Sim != "" {
+		ev3.SysClass = o.Sim
+	}
+	if o.TraceFile != "" {
+		openTrace(o.TraceFile)
+	}
+	return o
+}
+
+func searchDirs() []string {
+	dirs := []string{SearchPath[0]}
+	exe, err := os.Executable()
+	if err == nil {
+		dirs = append(dirs, fp.Dir(exe))
+	}
+	return append(dirs, SearchPath[1:]...)
+}
+
+// FindConfig returns the first existing file called name in the search path,
+// or name itself when there is none (so that loading it reports the error)
+func FindConfig(name string) string {
+	for _, dir := range searchDirs() {
+		path := fp.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return name
+}
+
+// SetLevel changes the verbosity of the log output, for commands that do
+// not take it from the command line
+func SetLevel(l Level) {
+	logLevel = l
+}
+
+// Enabled tells if messages of the given level are logged
+func Enabled(l Level) bool {
+	return l <= logLevel
+}
+
+// Log writes a message to stderr when its level is enabled
+func Log(l Level, data ...interface{}) {
+	if Enabled(l) {
+		fmt.Fprintln(os.Stderr, data...)
+	}
+}
+
+var traceMutex sync.Mutex
+var trace *os.File
+
+func openTrace(fileName string) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		ev3.Fatalln("Error creating trace file:", err)
+	}
+	trace = f
+	ev3.OnShutdown(closeTrace)
+}
+
+func closeTrace() {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+	if trace != nil {
+		trace.Close()
+		trace = nil
+	}
+}
+
+// Tracing tells if a trace file is open (to skip building trace lines)
+func Tracing() bool {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+	return trace != nil
+}
+
+// Trace writes a line to the trace file, if any
+func Trace(data ...interface{}) {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+	if trace != nil {
+		fmt.Fprintln(trace, data...)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	fp "path/filepath"
 	"strings"
 	"time"
+
+	"go-bots/clock"
 )
 
 // Devices contains connected devices
@@ -231,7 +233,7 @@ const OutPortModeRaw = "raw"
 func readString(fileName string) string {
 	buf, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		log.Fatalln("Cannot read from file", fileName, ":", err)
+		Fatalln("Cannot read from file", fileName, ":", err)
 	}
 	text := string(buf)
 	text = strings.TrimSuffix(text, "\n")
@@ -241,7 +243,7 @@ func readString(fileName string) string {
 func writeString(fileName string, v string) {
 	err := ioutil.WriteFile(fileName, []byte(v), 0644)
 	if err != nil {
-		log.Fatalln("Cannot write to file", fileName, ":", err)
+		Fatalln("Cannot write to file", fileName, ":", err)
 	}
 }
 
@@ -269,7 +271,7 @@ func SetMode(dev string, mode string) {
 	modesText := ReadStringAttribute(dev, Modes)
 	modes := strings.Split(modesText, " ")
 	if !contains(modes, mode) {
-		log.Fatalln("Device", dev, "does not support mode", mode)
+		Fatalln("Device", dev, "does not support mode", mode)
 	}
 	WriteStringAttribute(dev, Mode, mode)
 }
@@ -279,7 +281,7 @@ func SetStopAction(dev string, action string) {
 	actionsText := ReadStringAttribute(dev, StopActions)
 	actions := strings.Split(actionsText, " ")
 	if !contains(actions, action) {
-		log.Fatalln("Device", dev, "does not support stop action", action)
+		Fatalln("Device", dev, "does not support stop action", action)
 	}
 	WriteStringAttribute(dev, StopAction, action)
 }
@@ -289,7 +291,7 @@ func CheckCommand(dev string, cmd string) {
 	commandsText := ReadStringAttribute(dev, Commands)
 	commands := strings.Split(commandsText, " ")
 	if !contains(commands, cmd) {
-		log.Fatalln("Device", dev, "does not support command", cmd)
+		Fatalln("Device", dev, "does not support command", cmd)
 	}
 }
 
@@ -301,11 +303,11 @@ func RunCommand(dev string, cmd string) {
 // CheckDriver checks that a device has the given driver
 func CheckDriver(dev string, driver string, port string) {
 	if dev == "" {
-		log.Fatalln("Port", port, "has no device instead of expected driver", driver)
+		Fatalln("Port", port, "has no device instead of expected driver", driver)
 	}
 	actualDriver := ReadStringAttribute(dev, DriverName)
 	if actualDriver != driver {
-		log.Fatalln("Device", dev, "in port", port, "has driver", actualDriver, "instead of", driver)
+		Fatalln("Device", dev, "in port", port, "has driver", actualDriver, "instead of", driver)
 	}
 }
 
@@ -349,17 +351,18 @@ func (a *Attribute) Writable() bool {
 
 // Close closes the attribute
 func (a *Attribute) Close() {
+	unregisterAttribute(a)
 	err := a.file.Close()
+	a.file = nil
 	if err != nil {
-		log.Fatalln("Cannot close dev", a.path, ":", err)
+		Fatalln("Cannot close dev", a.path, ":", err)
 	}
-	a.file = nil
 }
 
 // Sync reads or writes the attribute value (according to the "writable" status)
 func (a *Attribute) Sync() {
 	if a.file == nil {
-		log.Fatalln("Cannot write to closed attribute", a.path)
+		Fatalln("Cannot write to closed attribute", a.path)
 	}
 	if a.writable {
 		if (a.Value != a.currentValue) ||
@@ -434,24 +437,24 @@ func (a *Attribute) Sync() {
 			}
 			n, err := a.file.WriteAt(toWrite, 0)
 			if err != nil {
-				log.Fatalln("Cannot write to attribute file", a.path, ":", err)
+				Fatalln("Cannot write to attribute file", a.path, ":", err)
 			}
 			if n != len(toWrite) {
-				log.Fatalln("Cannot write bytes to attribute file", a.path, ":", err)
+				Fatalln("Cannot write bytes to attribute file", a.path, ":", err)
 			}
 		}
 	} else {
 		if a.text {
 			_, err := a.file.Seek(0, 0)
 			if err != nil {
-				log.Fatalln("Cannot rewind text attribute file", a.path, ":", err)
+				Fatalln("Cannot rewind text attribute file", a.path, ":", err)
 			}
 			toRead, err := ioutil.ReadAll(a.file)
 			if err != nil {
-				log.Fatalln("Cannot read from text attribute file", a.path, ":", err)
+				Fatalln("Cannot read from text attribute file", a.path, ":", err)
 			}
 			if len(toRead) == 0 {
-				log.Fatalln("Cannot read one byte from text attribute file", a.path, ":", err)
+				Fatalln("Cannot read one byte from text attribute file", a.path, ":", err)
 			}
 			if toRead[len(toRead)-1] == '\n' {
 				toRead = toRead[0 : len(toRead)-1]
@@ -477,10 +480,10 @@ func (a *Attribute) Sync() {
 		} else {
 			n, err := a.file.ReadAt(a.buf[0:a.bufferSize], 0)
 			if err != nil {
-				log.Fatalln("Cannot read from attribute file", a.path, ":", err)
+				Fatalln("Cannot read from attribute file", a.path, ":", err)
 			}
 			if n != a.bufferSize {
-				log.Fatalln("Cannot read whole attribute file", a.path, ":", n)
+				Fatalln("Cannot read whole attribute file", a.path, ":", n)
 			}
 
 			if a.valueSize == 1 {
@@ -520,13 +523,13 @@ func OpenAttribute(dev string, attr string, writable bool, text bool) *Attribute
 	}
 	f, err := os.OpenFile(path, flag, 0666)
 	if err != nil {
-		log.Fatalln("Cannot open device", dev, ":", err)
+		Fatalln("Cannot open device", dev, ":", err)
 	}
 	bufferSize := 0
 	if !text {
 		bufferSize = 1
 	}
-	return &Attribute{
+	result := &Attribute{
 		path:          path,
 		file:          f,
 		currentValue:  math.MaxInt32,
@@ -544,6 +547,8 @@ func OpenAttribute(dev string, attr string, writable bool, text bool) *Attribute
 		text:          text,
 		buf:           [attributeBufSize]byte{},
 	}
+	registerAttribute(result)
+	return result
 }
 
 // OpenByteR opens a byte attribute for reading
@@ -554,10 +559,10 @@ func OpenByteR(dev string, attr string) *Attribute {
 // OpenBinaryR opens a binary (potentially multi value) attribute for reading
 func OpenBinaryR(dev string, attr string, valueCount int, valueSize int) *Attribute {
 	if valueCount < 1 || valueCount > 4 {
-		log.Fatalln("Invalid value count", valueCount)
+		Fatalln("Invalid value count", valueCount)
 	}
 	if valueSize < 1 || valueSize > 2 {
-		log.Fatalln("Invalid value size", valueSize)
+		Fatalln("Invalid value size", valueSize)
 	}
 	result := OpenAttribute(dev, attr, false, false)
 	result.valueCount = valueCount
@@ -581,10 +586,13 @@ func OpenTextW(dev string, attr string) *Attribute {
 	return OpenAttribute(dev, attr, true, true)
 }
 
+// SysClass is the root of the device tree used by Scan, it can point to a fake tree for testing
+var SysClass = "/sys/class"
+
 // Scan scans the EV3 for devices and returns the structure describing them
 func Scan(outModes *OutPortModes) *Devices {
 	devs := Devices{}
-	classes := "/sys/class"
+	classes := SysClass
 
 	ports := fp.Join(classes, "lego-port")
 	devs.Port0 = fp.Join(ports, "port0")
@@ -654,7 +662,7 @@ func Scan(outModes *OutPortModes) *Devices {
 		case In4:
 			devs.In4 = s
 		default:
-			log.Fatalln("Unknown port", port, "for sensor", s)
+			Fatalln("Unknown port", port, "for sensor", s)
 		}
 	}
 
@@ -671,7 +679,7 @@ func Scan(outModes *OutPortModes) *Devices {
 		case OutD:
 			devs.OutD = m
 		default:
-			log.Fatalln("Unknown port", port, "for tacho motor", m)
+			Fatalln("Unknown port", port, "for tacho motor", m)
 		}
 	}
 
@@ -688,9 +696,10 @@ func Scan(outModes *OutPortModes) *Devices {
 		case OutD:
 			devs.OutD = m
 		default:
-			log.Fatalln("Unknown port", port, "for dc motor", m)
+			Fatalln("Unknown port", port, "for dc motor", m)
 		}
 	}
+	registerDevices(&devs)
 	return &devs
 }
 
@@ -751,20 +760,24 @@ type Buttons struct {
 
 const keyUpTime = time.Second / 10
 
-// OpenButtons starts listening for button changes
-func OpenButtons(readStdin bool) *Buttons {
+// OpenButtons starts listening for button changes, the keys read from stdin are released after a while on c
+func OpenButtons(readStdin bool, c clock.Clock) *Buttons {
 	result := Buttons{}
-	result.stop = make(chan bool)
+	result.stop = make(chan bool, 1)
 
 	go func() {
+		defer Recover()
+
 		buttonDev := "/dev/input/by-path/platform-gpio-keys.0-event"
 		f, err := os.OpenFile(buttonDev, os.O_RDONLY, 0666)
 		if err != nil {
-			log.Fatalln("Cannot open file", buttonDev, ":", err)
+			Fatalln("Cannot open file", buttonDev, ":", err)
 		}
 
 		data := make(chan [16]byte)
 		go func() {
+			defer Recover()
+
 			var event [16]byte
 			for {
 				if f == nil {
@@ -775,10 +788,10 @@ func OpenButtons(readStdin bool) *Buttons {
 					if err == io.EOF || err == io.ErrClosedPipe {
 						return
 					}
-					log.Fatalln("Error reading button events:", err)
+					Fatalln("Error reading button events:", err)
 				}
 				if n != 16 {
-					log.Fatalln("Event length is not 16 bytes:", n)
+					Fatalln("Event length is not 16 bytes:", n)
 				}
 				data <- event
 			}
@@ -786,6 +799,8 @@ func OpenButtons(readStdin bool) *Buttons {
 
 		if readStdin {
 			go func() {
+				defer Recover()
+
 				consoleReader := bufio.NewReaderSize(os.Stdin, 1)
 				for {
 					key, _ := consoleReader.ReadByte()
@@ -795,37 +810,37 @@ func OpenButtons(readStdin bool) *Buttons {
 					if key == 9 {
 						// Tab -> Back
 						result.Back = true
-						time.AfterFunc(keyUpTime, func() {
+						c.AfterFunc(keyUpTime, func() {
 							result.Back = false
 						})
 					} else if key == 32 {
 						// Space -> Enter
 						result.Enter = true
-						time.AfterFunc(keyUpTime, func() {
+						c.AfterFunc(keyUpTime, func() {
 							result.Enter = false
 						})
 					} else if key == 'a' || key == 'A' {
 						// A -> Left
 						result.Left = true
-						time.AfterFunc(keyUpTime, func() {
+						c.AfterFunc(keyUpTime, func() {
 							result.Left = false
 						})
 					} else if key == 'd' || key == 'D' {
 						// D -> Right
 						result.Right = true
-						time.AfterFunc(keyUpTime, func() {
+						c.AfterFunc(keyUpTime, func() {
 							result.Right = false
 						})
 					} else if key == 'w' || key == 'W' {
 						// W -> Up
 						result.Up = true
-						time.AfterFunc(keyUpTime, func() {
+						c.AfterFunc(keyUpTime, func() {
 							result.Up = false
 						})
 					} else if key == 's' || key == 'S' {
 						// S -> Down
 						result.Down = true
-						time.AfterFunc(keyUpTime, func() {
+						c.AfterFunc(keyUpTime, func() {
 							result.Down = false
 						})
 					}
@@ -893,10 +908,28 @@ func OpenButtons(readStdin bool) *Buttons {
 
 	}()
 
+	OnShutdown(result.Close)
 	return &result
 }
 
 // Close stops listening for button changes
 func (b *Buttons) Close() {
-	b.stop <- true
+	select {
+	case b.stop <- true:
+	default:
+	}
+}
+
+// LCDConsole is the console shown on the EV3 screen
+var LCDConsole = "/dev/tty0"
+
+// WriteLCD clears the EV3 screen and writes lines on it
+func WriteLCD(lines ...string) error {
+	f, err := os.OpenFile(LCDConsole, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString("\033[2J\033[H" + strings.Join(lines, "\n") + "\n")
+	return err
 }
@@ -0,0 +1,185 @@
+package ev3
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	fp "path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// ExitOK is the exit status of a normal termination
+	ExitOK = 0
+	// ExitError is the exit status of a termination caused by a fatal error
+	ExitError = 1
+	// ExitPanic is the exit status of a termination caused by a panic
+	ExitPanic = 2
+	// ExitSignal is added to the signal number to get the exit status of a termination caused by a signal
+	ExitSignal = 128
+)
+
+var shuttingDown int32
+
+var shutdownMutex sync.Mutex
+var shutdownFuncs []func()
+var scannedDevices *Devices
+var openAttributes = map[*Attribute]bool{}
+
+func registerAttribute(a *Attribute) {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+	openAttributes[a] = true
+}
+
+func unregisterAttribute(a *Attribute) {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+	delete(openAttributes, a)
+}
+
+func registerDevices(devs *Devices) {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+	scannedDevices = devs
+}
+
+// OnShutdown registers a function that is called when the bot shuts down
+// (functions are called in reverse order of registration, after the motors have been stopped)
+func OnShutdown(f func()) {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+	shutdownFuncs = append(shutdownFuncs, f)
+}
+
+// Exit shuts the bot down and terminates the process with the given status,
+// if another goroutine is already shutting down it waits for it to terminate the process
+func Exit(status int, v ...interface{}) {
+	if !atomic.CompareAndSwapInt32(&shuttingDown, 0, 1) {
+		select {}
+	}
+	if len(v) > 0 {
+		log.Println(v...)
+	}
+	shutdown()
+	log.Println("Exit status", status)
+	os.Exit(status)
+}
+
+// Fatalln logs a fatal error and shuts the bot down, errors that happen while
+// shutting down are only logged and the goroutine that hit them waits for the
+// process to terminate (each cleanup step runs in its own goroutine, so a
+// failed step cannot block the rest of the shutdown)
+func Fatalln(v ...interface{}) {
+	if atomic.LoadInt32(&shuttingDown) != 0 {
+		log.Println(v...)
+		select {}
+	}
+	Exit(ExitError, v...)
+}
+
+// Recover shuts the bot down when the calling goroutine panics, it must be deferred
+func Recover() {
+	if r := recover(); r != nil {
+		Exit(ExitPanic, "Panic:", r, "\n"+string(debug.Stack()))
+	}
+}
+
+// HandleSignals shuts the bot down on SIGINT, SIGTERM and SIGHUP
+func HandleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-sigs
+		status := ExitSignal
+		if s, ok := sig.(syscall.Signal); ok {
+			status += int(s)
+		}
+		Exit(status, "Terminated by signal", sig)
+	}()
+}
+
+// shutdownStepTimeout is how long the shutdown waits for each cleanup step
+const shutdownStepTimeout = time.Second
+
+// safely runs a cleanup step in its own goroutine, giving up on it when it
+// panics or does not complete in time (it hit a fatal error)
+func safely(step string, f func()) {
+	done := make(chan bool, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Println("Shutdown:", step, "failed:", r)
+			}
+			done <- true
+		}()
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownStepTimeout):
+		log.Println("Shutdown:", step, "did not complete")
+	}
+}
+
+func writeQuietly(dev string, attr string, v string) {
+	err := ioutil.WriteFile(fp.Join(dev, attr), []byte(v), 0644)
+	if err != nil {
+		log.Println("Shutdown: cannot write", attr, "of", dev, ":", err)
+	}
+}
+
+func stopMotor(dev string) {
+	if dev == "" {
+		return
+	}
+	writeQuietly(dev, DutyCycleSp, "0")
+	writeQuietly(dev, Command, CmdStop)
+	buf, err := ioutil.ReadFile(fp.Join(dev, Commands))
+	if err == nil && contains(strings.Fields(string(buf)), CmdReset) {
+		writeQuietly(dev, Command, CmdReset)
+	}
+}
+
+func shutdown() {
+	shutdownMutex.Lock()
+	devs := scannedDevices
+	funcs := make([]func(), len(shutdownFuncs))
+	copy(funcs, shutdownFuncs)
+	shutdownMutex.Unlock()
+
+	if devs != nil {
+		safely("stopping motors", func() {
+			stopMotor(devs.OutA)
+			stopMotor(devs.OutB)
+			stopMotor(devs.OutC)
+			stopMotor(devs.OutD)
+		})
+		safely("turning off leds", func() {
+			writeQuietly(devs.LedLeftGreen, Brightness, "0")
+			writeQuietly(devs.LedLeftRed, Brightness, "0")
+			writeQuietly(devs.LedRightGreen, Brightness, "0")
+			writeQuietly(devs.LedRightRed, Brightness, "0")
+		})
+	}
+
+	for i := len(funcs) - 1; i >= 0; i-- {
+		safely("cleanup", funcs[i])
+	}
+
+	shutdownMutex.Lock()
+	attributes := make([]*Attribute, 0, len(openAttributes))
+	for a := range openAttributes {
+		attributes = append(attributes, a)
+	}
+	shutdownMutex.Unlock()
+	for _, a := range attributes {
+		safely("closing "+a.path, a.Close)
+	}
+}
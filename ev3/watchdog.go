@@ -0,0 +1,156 @@
+package ev3
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-bots/clock"
+)
+
+const watchdogRampSteps = 10
+const watchdogRampInterval = 20 * time.Millisecond
+const watchdogFlashInterval = 250 * time.Millisecond
+const watchdogMinCheckInterval = 10 * time.Millisecond
+
+// Watchdog stops all motors and flashes the leds red when it is not fed within its deadline
+type Watchdog struct {
+	devs     *Devices
+	clock    clock.Clock
+	timeout  time.Duration
+	mutex    sync.Mutex
+	lastFeed time.Time
+	tripped  bool
+	stop     chan bool
+}
+
+// NewWatchdog creates a watchdog on the motors and leds found in devs, its deadline is kept on c
+func NewWatchdog(devs *Devices, timeout time.Duration, c clock.Clock) *Watchdog {
+	return &Watchdog{
+		devs:    devs,
+		clock:   c,
+		timeout: timeout,
+		stop:    make(chan bool, 1),
+	}
+}
+
+// Start starts watching, the deadline begins now
+func (w *Watchdog) Start() {
+	w.mutex.Lock()
+	w.lastFeed = w.clock.Now()
+	w.mutex.Unlock()
+	OnShutdown(w.Stop)
+
+	interval := w.timeout / 4
+	if interval < watchdogMinCheckInterval {
+		interval = watchdogMinCheckInterval
+	}
+
+	ticker := w.clock.NewTicker(interval)
+	go func() {
+		defer Recover()
+
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				if w.expired() {
+					w.trip()
+					return
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Feed moves the deadline forward and reports whether the motors can still be driven
+// (once the watchdog has tripped it keeps the motors stopped)
+func (w *Watchdog) Feed() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.lastFeed = w.clock.Now()
+	return !w.tripped
+}
+
+// Tripped checks if the deadline has been missed
+func (w *Watchdog) Tripped() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.tripped
+}
+
+// Stop stops watching (and flashing the leds if the watchdog has tripped)
+func (w *Watchdog) Stop() {
+	select {
+	case w.stop <- true:
+	default:
+	}
+}
+
+func (w *Watchdog) expired() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.clock.Now().Sub(w.lastFeed) < w.timeout {
+		return false
+	}
+	w.tripped = true
+	return true
+}
+
+func (w *Watchdog) motors() []string {
+	result := []string{}
+	for _, m := range []string{w.devs.OutA, w.devs.OutB, w.devs.OutC, w.devs.OutD} {
+		if m != "" {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+func (w *Watchdog) trip() {
+	log.Println("Watchdog: no motor command for", w.timeout, "stopping motors")
+
+	motors := w.motors()
+	dutyCycles := make([]int, len(motors))
+	for i, m := range motors {
+		v, err := strconv.Atoi(ReadStringAttribute(m, DutyCycleSp))
+		if err != nil {
+			log.Println("Watchdog: cannot read duty cycle of", m, ":", err)
+		}
+		dutyCycles[i] = v
+	}
+
+	for step := watchdogRampSteps - 1; step >= 0; step-- {
+		for i, m := range motors {
+			WriteStringAttribute(m, DutyCycleSp, strconv.Itoa(dutyCycles[i]*step/watchdogRampSteps))
+		}
+		w.clock.Sleep(watchdogRampInterval)
+	}
+	for _, m := range motors {
+		RunCommand(m, CmdStop)
+	}
+
+	ticker := w.clock.NewTicker(watchdogFlashInterval)
+	defer ticker.Stop()
+	on := true
+	for {
+		red := "0"
+		if on {
+			red = "255"
+		}
+		WriteStringAttribute(w.devs.LedLeftGreen, Brightness, "0")
+		WriteStringAttribute(w.devs.LedRightGreen, Brightness, "0")
+		WriteStringAttribute(w.devs.LedLeftRed, Brightness, red)
+		WriteStringAttribute(w.devs.LedRightRed, Brightness, red)
+		on = !on
+
+		select {
+		case <-ticker.C():
+		case <-w.stop:
+			return
+		}
+	}
+}
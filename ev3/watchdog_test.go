@@ -0,0 +1,192 @@
+package ev3
+
+import (
+	"io/ioutil"
+	"os"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-bots/clock"
+)
+
+// fakeSysClass builds a device tree with two tacho motors (on outA and outB)
+// running at the given duty cycles and the four leds
+func fakeSysClass(t *testing.T, dutyA int, dutyB int) string {
+	root, err := ioutil.TempDir("", "ev3-sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(path string, v string) {
+		path = fp.Join(root, path)
+		if err := os.MkdirAll(fp.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for port := 4; port < 8; port++ {
+		write(fp.Join("lego-port", "port"+strconv.Itoa(port), Mode), OutPortModeAuto)
+	}
+	for _, led := range []string{"ev3:left:green:ev3dev", "ev3:left:red:ev3dev", "ev3:right:green:ev3dev", "ev3:right:red:ev3dev"} {
+		write(fp.Join("leds", led, Brightness), "0")
+	}
+	for i, m := range []struct {
+		port string
+		duty int
+	}{{OutA, dutyA}, {OutB, dutyB}} {
+		motor := fp.Join("tacho-motor", "motor"+strconv.Itoa(i))
+		write(fp.Join(motor, Address), m.port)
+		write(fp.Join(motor, DutyCycleSp), strconv.Itoa(m.duty))
+		write(fp.Join(motor, Command), CmdRunDirect)
+		write(fp.Join(motor, Commands), strings.Join([]string{CmdRunDirect, CmdStop}, " "))
+	}
+	return root
+}
+
+// readInt reads an attribute written by the watchdog, ok is false while the
+// file is being rewritten
+func readInt(dev string, attr string) (int, bool) {
+	buf, err := ioutil.ReadFile(fp.Join(dev, attr))
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+	return v, err == nil
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestWatchdogTrip(t *testing.T) {
+	defaultSysClass := SysClass
+	SysClass = fakeSysClass(t, 80, -60)
+	defer func() {
+		os.RemoveAll(SysClass)
+		SysClass = defaultSysClass
+	}()
+
+	devs := Scan(nil)
+	if devs.OutA == "" || devs.OutB == "" {
+		t.Fatal("motors not found in", SysClass)
+	}
+	timeout := 100 * time.Millisecond
+	w := NewWatchdog(devs, timeout, clock.Real{})
+	w.Start()
+	defer w.Stop()
+
+	// Fed well within the timeout it never trips
+	for fed := time.Duration(0); fed < 3*timeout; fed += timeout / 10 {
+		time.Sleep(timeout / 10)
+		if !w.Feed() {
+			t.Fatal("tripped while fed")
+		}
+	}
+
+	// Starved it ramps the duty cycles down and stops the motors
+	started := time.Now()
+	duties := map[string][]int{devs.OutA: {80}, devs.OutB: {-60}}
+	for {
+		if time.Since(started) > 5*time.Second {
+			t.Fatal("motors not stopped after", time.Since(started))
+		}
+		for m, seen := range duties {
+			if v, ok := readInt(m, DutyCycleSp); ok && v != seen[len(seen)-1] {
+				duties[m] = append(seen, v)
+			}
+		}
+		if ReadStringAttribute(devs.OutA, Command) == CmdStop && ReadStringAttribute(devs.OutB, Command) == CmdStop {
+			break
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if elapsed := time.Since(started); elapsed < timeout {
+		t.Error("tripped after", elapsed, "before the timeout", timeout)
+	}
+	for m, seen := range duties {
+		if v, _ := readInt(m, DutyCycleSp); v != 0 {
+			t.Error(m, "stopped with duty cycle", v)
+		}
+		if len(seen) < 3 {
+			t.Error(m, "duty cycle did not ramp down:", seen)
+		}
+		for i := 1; i < len(seen); i++ {
+			if abs(seen[i]) > abs(seen[i-1]) || seen[i]*seen[0] < 0 {
+				t.Error(m, "duty cycle did not ramp down:", seen)
+				break
+			}
+		}
+	}
+
+	if !w.Tripped() {
+		t.Error("Tripped is false after the motors were stopped")
+	}
+	if w.Feed() {
+		t.Error("Feed returns true after a trip")
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	started := time.Now()
+	for !cond() {
+		if time.Since(started) > 5*time.Second {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatchdogOnManualClock(t *testing.T) {
+	defaultSysClass := SysClass
+	SysClass = fakeSysClass(t, 50, 50)
+	defer func() {
+		os.RemoveAll(SysClass)
+		SysClass = defaultSysClass
+	}()
+
+	devs := Scan(nil)
+	c := clock.NewManual(time.Time{})
+	timeout := time.Second
+	w := NewWatchdog(devs, timeout, c)
+	w.Start()
+	defer w.Stop()
+
+	// However long the test takes, it does not trip until the clock moves
+	for i := 0; i < 10; i++ {
+		c.Advance(timeout / 2)
+		if !w.Feed() {
+			t.Fatal("tripped while fed")
+		}
+	}
+	c.Advance(timeout - time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if w.Tripped() {
+		t.Fatal("tripped before the timeout")
+	}
+
+	// The ramp down waits on the clock too
+	c.Advance(time.Millisecond + timeout/4)
+	waitFor(t, "the trip", w.Tripped)
+	for step := watchdogRampSteps - 1; step >= 0; step-- {
+		want := 50 * step / watchdogRampSteps
+		waitFor(t, "duty cycle "+strconv.Itoa(want), func() bool {
+			v, ok := readInt(devs.OutA, DutyCycleSp)
+			return ok && v == want && c.Waiting() > 1
+		})
+		if ReadStringAttribute(devs.OutA, Command) == CmdStop {
+			t.Fatal("motors stopped at duty cycle", want)
+		}
+		c.Advance(watchdogRampInterval)
+	}
+	waitFor(t, "the motors to stop", func() bool {
+		return ReadStringAttribute(devs.OutA, Command) == CmdStop && ReadStringAttribute(devs.OutB, Command) == CmdStop
+	})
+}
@@ -1,78 +1,247 @@
 package config
 
 import (
+	"fmt"
+	"go-bots/botconf"
+	"go-bots/greyhound/marker"
+	"go-bots/greyhound/route"
 	"io/ioutil"
-
-	"github.com/BurntSushi/toml"
 )
 
 // Config data
 type Config struct {
-	MaxSpeed      int
-	MaxSteeringPC int
-	SensorRadius  int
-	SensorSpan    int
-	SensorMin     int
-	MinDTicks     int
-	MaxDTicks     int
-	MinOutMillis  int
-	KP            int
-	KP2           int
-	KD            int
-	KD2           int
-	MaxSteering   int
-	MaxPos        int
-	MaxPos2       int
-	MaxPosD       int
-	MaxPosD2      int
+	// Profile is the name of the profile in use (empty for the base section)
+	Profile string `toml:"-"`
+
+	MaxSpeed      int `check:"required,min=1,max=100"`
+	MaxSteeringPC int `check:"min=0,max=200"`
+	SensorRadius  int `check:"required,min=1"`
+	SensorSpan    int `check:"required,min=1,max=1000"`
+	SensorMin     int `check:"min=0,max=1000"`
+	MinDTicks     int `check:"required,min=1,lt=MaxDTicks"`
+	MaxDTicks     int `check:"required"`
+	// KP is the steering (in percent of MaxSpeed) when the line is MaxPos away
+	KP float64 `check:"min=0"`
+	// KP2 is the steering added (in percent of the speed) when the line is MaxPos away, it grows with the square of the position
+	KP2 float64 `check:"min=0"`
+	// KI is the steering added every second the line stays MaxPos away
+	KI float64 `check:"min=0"`
+	// KD is the steering when the line moves MaxPos in a second
+	KD float64 `check:"min=0"`
+	// DFilterMillis is the time constant of the low-pass filter on the derivative
+	DFilterMillis int `check:"min=0"`
+	// GainSchedule, when not empty, replaces KP, KI and KD with gains that depend on
+	// the speed, interpolated between its points (in increasing Speed order)
+	GainSchedule []GainPoint
+	// IntegralLimitPC limits the steering of the integral term (in percent of MaxSpeed)
+	IntegralLimitPC int `check:"min=0,max=200"`
+	// CalibrationSpeed and CalibrationMillis set the turns that sweep the sensors across the line
+	CalibrationSpeed  int `check:"required,min=1,max=100"`
+	CalibrationMillis int `check:"required,min=1"`
+	// CalibrationMinContrast is the minimum intensity difference between line and background
+	CalibrationMinContrast int `check:"min=0"`
+	// LapDetection ends laps on a marker with the lap action, after CrossingsPerLap crossings, or never
+	LapDetection    string `check:"oneof=none|marker|crossings"`
+	CrossingsPerLap int    `check:"min=0"`
+	// LapMinMillis is the shortest possible lap, detections before it are ignored
+	LapMinMillis int `check:"min=0"`
+	// LapLengthMM gives the average speed of a lap (0 if unknown)
+	LapLengthMM int `check:"min=0"`
+	// TrackLearning records the first lap and plans the speed of the next ones (it needs LapDetection)
+	TrackLearning bool
+	// StraightSpeed and CurveSpeed are the planned speeds, CurveSteeringPC is the steering (in percent of the speed) of a curve
+	StraightSpeed   int `check:"required,min=1,max=100"`
+	CurveSpeed      int `check:"required,min=1,le=StraightSpeed"`
+	CurveSteeringPC int `check:"required,min=1"`
+	// TrackSegmentMillis is the length of the segments of the track map (the distance covered at MaxSpeed)
+	TrackSegmentMillis int `check:"required,min=1"`
+	// TrackLookahead is how many segments before a curve the robot starts braking
+	TrackLookahead int `check:"min=0"`
+	// Route lists what to do at each crossing (straight, left, right or stop), the
+	// robot stops at the first crossing after the last step (empty means no route)
+	Route []string
+	// RouteForwardMillis is how long the robot drives over a crossing before turning
+	RouteForwardMillis int `check:"min=0"`
+	// RouteTurnSpeed is the wheel speed while turning in place at a crossing
+	RouteTurnSpeed int `check:"required,min=1,max=100"`
+	// RouteTurnMaxMillis gives up a turn that does not find the new line
+	RouteTurnMaxMillis int `check:"required,min=1"`
+	// RouteCrossMillis ignores crossings for a while after one (so it is not counted twice)
+	RouteCrossMillis int `check:"min=0"`
+	// Markers maps a marker color (red, green or blue) to the action taken when a sensor sees it
+	Markers map[string]Marker
+	// MarkerRatioPC and MarkerMin classify a reading as a marker: its channel must be
+	// at least MarkerMin and MarkerRatioPC percent of each of the others
+	MarkerRatioPC int `check:"min=100"`
+	MarkerMin     int `check:"min=0"`
+	// MarkerMillis ignores a marker color for a while after seeing it
+	MarkerMillis int `check:"min=0"`
+	// Laps stops the run after this many laps (0 means never)
+	Laps           int `check:"min=0"`
+	WatchdogMillis int `check:"min=0"`
+	MaxPos         int `toml:"-"`
+	MaxPos2        int `toml:"-"`
+}
+
+// Marker is the action taken when a sensor sees a colored marker
+type Marker struct {
+	// Action is "none", "stop", "slow", "turn" or "lap"
+	Action string `check:"oneof=none|stop|slow|turn|lap"`
+	// Speed is the speed limit in a slow zone
+	Speed int `check:"min=0,max=100"`
+	// Millis is how long a slow zone lasts (0 means until the next slow marker)
+	Millis int `check:"min=0"`
+	// Turn is the turn ("left" or "right") taken at the next crossing after a turn marker
+	Turn string
+}
+
+// GainPoint gives the steering gains to use at Speed
+type GainPoint struct {
+	Speed int     `check:"min=0,max=100"`
+	KP    float64 `check:"min=0"`
+	KI    float64 `check:"min=0"`
+	KD    float64 `check:"min=0"`
+}
+
+// DeprecatedKeys lists the keys of older greyhound.toml files, they are ignored
+func (Config) DeprecatedKeys() map[string]string {
+	return map[string]string{
+		"MinOutMillis": "it was not used",
+		"KD2":          "use KD, the derivative is computed by the PID controller",
+		"MaxSteering":  "use MaxSteeringPC",
+		"MaxPos":       "computed from SensorRadius",
+		"MaxPos2":      "computed from SensorRadius",
+		"MaxPosD":      "use KD, the derivative is computed by the PID controller",
+		"MaxPosD2":     "use KD, the derivative is computed by the PID controller",
+	}
 }
 
+// DefaultWatchdogMillis is used when the configuration does not set WatchdogMillis
+const DefaultWatchdogMillis = 1000
+
 // CompleteConfig fills in computed configutation fields
 func CompleteConfig(c *Config) {
-	c.MaxSteering = (c.MaxSpeed * c.MaxSteeringPC) / 100
+	if c.WatchdogMillis == 0 {
+		c.WatchdogMillis = DefaultWatchdogMillis
+	}
 	c.MaxPos = c.SensorRadius * 3
 	c.MaxPos2 = c.MaxPos * c.MaxPos
-	c.MaxPosD = c.MaxPos / c.MinOutMillis
-	c.MaxPosD2 = c.MaxPos * c.MaxPos
 }
 
 // Default Config data
 func Default() Config {
 	result := Config{
 		// MaxSpeed:  100,
-		MaxSpeed:      30,
-		MaxSteeringPC: 120,
-		SensorRadius:  100,
-		SensorSpan:    700,
-		SensorMin:     80,
-		MinDTicks:     10,
-		MaxDTicks:     30000,
-		MinOutMillis:  10,
-		KP:            70,
-		KP2:           10,
-		KD:            0,
-		KD2:           0,
+		MaxSpeed:               30,
+		MaxSteeringPC:          120,
+		SensorRadius:           100,
+		SensorSpan:             700,
+		SensorMin:              80,
+		MinDTicks:              10,
+		MaxDTicks:              30000,
+		KP:                     70,
+		KP2:                    10,
+		KI:                     0,
+		KD:                     0,
+		DFilterMillis:          20,
+		IntegralLimitPC:        30,
+		CalibrationSpeed:       20,
+		CalibrationMillis:      600,
+		CalibrationMinContrast: 100,
+		LapDetection:           "none",
+		CrossingsPerLap:        0,
+		LapMinMillis:           3000,
+		LapLengthMM:            0,
+		TrackLearning:          false,
+		StraightSpeed:          45,
+		CurveSpeed:             25,
+		CurveSteeringPC:        60,
+		TrackSegmentMillis:     100,
+		TrackLookahead:         3,
+		Route:                  []string{},
+		RouteForwardMillis:     150,
+		RouteTurnSpeed:         20,
+		RouteTurnMaxMillis:     3000,
+		RouteCrossMillis:       300,
+		Markers: map[string]Marker{
+			marker.Red:   {Action: marker.None},
+			marker.Green: {Action: marker.None},
+			marker.Blue:  {Action: marker.None},
+		},
+		MarkerRatioPC:  150,
+		MarkerMin:      60,
+		MarkerMillis:   500,
+		Laps:           0,
+		WatchdogMillis: DefaultWatchdogMillis,
 	}
 	CompleteConfig(&result)
 	return result
 }
 
-// FromString reads Config data from a TOML string
-func FromString(data string) (Config, error) {
-	result := Config{}
-	_, err := toml.Decode(data, &result)
+// FromString reads Config data from a TOML string applying the named profile
+// and then sets (as Key=Value), values that are not in it keep their defaults
+func FromString(data string, profile string, sets []string) (Config, error) {
+	result := Default()
+	name, err := botconf.DecodeProfileSets(data, profile, sets, &result)
+	if err != nil {
+		return result, err
+	}
+	err = botconf.Validate(&result)
 	if err != nil {
 		return result, err
 	}
+	_, err = route.Parse(result.Route)
+	if err != nil {
+		return result, fmt.Errorf("Route: %v", err)
+	}
+	err = checkMarkers(result.Markers)
+	if err != nil {
+		return result, err
+	}
+	err = checkGainSchedule(result.GainSchedule)
+	if err != nil {
+		return result, err
+	}
+	result.Profile = name
 	CompleteConfig(&result)
 	return result, nil
 }
 
-// FromFile reads Config data from a TOML file
-func FromFile(fileName string) (Config, error) {
+// checkMarkers checks the marker colors and the fields each action needs
+func checkMarkers(markers map[string]Marker) error {
+	for color, m := range markers {
+		if !marker.IsColor(color) {
+			return fmt.Errorf("Markers: unknown color %q (must be red, green or blue)", color)
+		}
+		switch m.Action {
+		case marker.Slow:
+			if m.Speed == 0 {
+				return fmt.Errorf("Markers.%s: slow needs a Speed", color)
+			}
+		case marker.Turn:
+			if m.Turn != string(route.Left) && m.Turn != string(route.Right) {
+				return fmt.Errorf("Markers.%s: Turn is %q, must be left or right", color, m.Turn)
+			}
+		}
+	}
+	return nil
+}
+
+// checkGainSchedule checks that the points are in increasing speed order
+func checkGainSchedule(schedule []GainPoint) error {
+	for i := 1; i < len(schedule); i++ {
+		if schedule[i].Speed <= schedule[i-1].Speed {
+			return fmt.Errorf("GainSchedule[%d]: Speed is %d, must be more than %d", i+1, schedule[i].Speed, schedule[i-1].Speed)
+		}
+	}
+	return nil
+}
+
+// FromFile reads Config data from a TOML file applying the named profile and then sets
+func FromFile(fileName string, profile string, sets []string) (Config, error) {
 	b, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return Config{}, err
 	}
-	return FromString(string(b))
+	return FromString(string(b), profile, sets)
 }
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"go-bots/greyhound/marker"
+)
+
+func TestDefaultIsTheShippedFile(t *testing.T) {
+	c, err := FromFile("../greyhound.toml", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := Default(); !reflect.DeepEqual(c, d) {
+		t.Errorf("greyhound.toml gives\n%+v\nDefault gives\n%+v", c, d)
+	}
+}
+
+func TestMarkersAreOffByDefault(t *testing.T) {
+	d := Default()
+	for _, color := range marker.Colors {
+		if m := d.Markers[color]; m.Action != marker.None {
+			t.Errorf("Markers.%s: Action is %q, want %q", color, m.Action, marker.None)
+		}
+	}
+}
+
+func TestOlderFilesStillLoad(t *testing.T) {
+	// greyhound.toml before the PID controller
+	c, err := FromString(`
+MaxSpeed=         30
+MaxSteeringPC=    120
+SensorRadius=     100
+SensorSpan=       700
+SensorMin=        80
+MinDTicks=        10
+MaxDTicks=        30000
+MinOutMillis=     10
+KP=               70
+KP2=              10
+KD=               0
+KD2=              0
+`, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.KP != 70 || c.KP2 != 10 {
+		t.Errorf("KP and KP2 are %v and %v, want 70 and 10", c.KP, c.KP2)
+	}
+	_, err = FromString("Unknown=1\n", "", nil)
+	if err == nil {
+		t.Error("unknown key accepted")
+	}
+}
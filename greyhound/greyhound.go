@@ -1,62 +1,75 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"go-bots/botconf"
+	"go-bots/clock"
+	"go-bots/cmdline"
 	"go-bots/ev3"
 	"go-bots/greyhound/config"
-	"log"
+	"go-bots/greyhound/lap"
+	"go-bots/greyhound/line"
+	"go-bots/greyhound/marker"
+	"go-bots/greyhound/route"
+	"go-bots/greyhound/track"
+	"go-bots/pid"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
 	"time"
 )
 
-func handleSignals() {
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigs
-		quit("Terminated by signal", sig)
-	}()
-}
-
-var devs *ev3.Devices
-var initializationTime time.Time
-var motorL1, motorL2, motorR1, motorR2 *ev3.Attribute
-var cF, cL, cR, cB *ev3.Attribute
-var buttons *ev3.Buttons
-
-var conf config.Config
-
-func closeSensors() {
-	cF.Close()
-	cL.Close()
-	cR.Close()
-	cB.Close()
+// bot is the state of a greyhound robot: its devices (real or simulated),
+// its configuration and what it keeps between loops
+type bot struct {
+	devs                               *ev3.Devices
+	clock                              clock.Clock
+	initializationTime                 time.Time
+	motorL1, motorL2, motorR1, motorR2 *ev3.Attribute
+	cF, cL, cR, cB                     *ev3.Attribute
+	buttons                            *ev3.Buttons
+	watchdog                           *ev3.Watchdog
+
+	conf       config.Config
+	configFile string
+	watcher    *botconf.Watcher
+	profile    string
+	// sets are the values given with -set, applied on every load
+	sets []string
+
+	lastMoveTicks  int
+	lastSpeedLeft  int
+	lastSpeedRight int
+
+	estimator   *line.Estimator
+	calibration *line.Calibration
+
+	// simulation is set when running on a simulated track
+	simulation *lineSim
 }
 
-func setSensorsMode() {
-	ev3.SetMode(devs.In1, ev3.ColorModeRgbRaw)
-	ev3.SetMode(devs.In2, ev3.ColorModeRgbRaw)
-	ev3.SetMode(devs.In3, ev3.ColorModeRgbRaw)
-	ev3.SetMode(devs.In4, ev3.ColorModeRgbRaw)
+func (b *bot) setSensorsMode() {
+	ev3.SetMode(b.devs.In1, ev3.ColorModeRgbRaw)
+	ev3.SetMode(b.devs.In2, ev3.ColorModeRgbRaw)
+	ev3.SetMode(b.devs.In3, ev3.ColorModeRgbRaw)
+	ev3.SetMode(b.devs.In4, ev3.ColorModeRgbRaw)
 
-	cF = ev3.OpenBinaryR(devs.In1, ev3.BinData, 3, 2)
-	cL = ev3.OpenBinaryR(devs.In2, ev3.BinData, 3, 2)
-	cR = ev3.OpenBinaryR(devs.In3, ev3.BinData, 3, 2)
-	cB = ev3.OpenBinaryR(devs.In4, ev3.BinData, 3, 2)
+	b.cF = ev3.OpenBinaryR(b.devs.In1, ev3.BinData, 3, 2)
+	b.cL = ev3.OpenBinaryR(b.devs.In2, ev3.BinData, 3, 2)
+	b.cR = ev3.OpenBinaryR(b.devs.In3, ev3.BinData, 3, 2)
+	b.cB = ev3.OpenBinaryR(b.devs.In4, ev3.BinData, 3, 2)
 }
 
-func initializeTime() {
-	initializationTime = time.Now()
+func (b *bot) initializeTime() {
+	b.initializationTime = b.clock.Now()
 }
 
-func initialize() {
-	initializeTime()
+func (b *bot) initialize() {
+	b.initializeTime()
 
-	buttons = ev3.OpenButtons(true)
+	b.buttons = ev3.OpenButtons(true, b.clock)
 
-	devs = ev3.Scan(&ev3.OutPortModes{
+	b.devs = ev3.Scan(&ev3.OutPortModes{
 		OutA: ev3.OutPortModeDcMotor,
 		OutB: ev3.OutPortModeDcMotor,
 		OutC: ev3.OutPortModeDcMotor,
@@ -64,85 +77,64 @@ func initialize() {
 	})
 
 	// Check motors
-	ev3.CheckDriver(devs.OutA, ev3.DriverRcxMotor, ev3.OutA)
-	ev3.CheckDriver(devs.OutB, ev3.DriverRcxMotor, ev3.OutB)
-	ev3.CheckDriver(devs.OutC, ev3.DriverRcxMotor, ev3.OutC)
-	ev3.CheckDriver(devs.OutD, ev3.DriverRcxMotor, ev3.OutD)
+	ev3.CheckDriver(b.devs.OutA, ev3.DriverRcxMotor, ev3.OutA)
+	ev3.CheckDriver(b.devs.OutB, ev3.DriverRcxMotor, ev3.OutB)
+	ev3.CheckDriver(b.devs.OutC, ev3.DriverRcxMotor, ev3.OutC)
+	ev3.CheckDriver(b.devs.OutD, ev3.DriverRcxMotor, ev3.OutD)
 
 	// Check sensors
-	ev3.CheckDriver(devs.In1, ev3.DriverColor, ev3.In1)
-	ev3.CheckDriver(devs.In2, ev3.DriverColor, ev3.In2)
-	ev3.CheckDriver(devs.In3, ev3.DriverColor, ev3.In3)
-	ev3.CheckDriver(devs.In4, ev3.DriverColor, ev3.In4)
+	ev3.CheckDriver(b.devs.In1, ev3.DriverColor, ev3.In1)
+	ev3.CheckDriver(b.devs.In2, ev3.DriverColor, ev3.In2)
+	ev3.CheckDriver(b.devs.In3, ev3.DriverColor, ev3.In3)
+	ev3.CheckDriver(b.devs.In4, ev3.DriverColor, ev3.In4)
 
 	// Set sensors mode
-	setSensorsMode()
+	b.setSensorsMode()
 
 	// Stop motors
-	ev3.RunCommand(devs.OutA, ev3.CmdStop)
-	ev3.RunCommand(devs.OutB, ev3.CmdStop)
-	ev3.RunCommand(devs.OutC, ev3.CmdStop)
-	ev3.RunCommand(devs.OutD, ev3.CmdStop)
+	ev3.RunCommand(b.devs.OutA, ev3.CmdStop)
+	ev3.RunCommand(b.devs.OutB, ev3.CmdStop)
+	ev3.RunCommand(b.devs.OutC, ev3.CmdStop)
+	ev3.RunCommand(b.devs.OutD, ev3.CmdStop)
 
 	// Open motors
-	motorL1 = ev3.OpenTextW(devs.OutA, ev3.DutyCycleSp)
-	motorL2 = ev3.OpenTextW(devs.OutB, ev3.DutyCycleSp)
-	motorR1 = ev3.OpenTextW(devs.OutC, ev3.DutyCycleSp)
-	motorR2 = ev3.OpenTextW(devs.OutD, ev3.DutyCycleSp)
+	b.motorL1 = ev3.OpenTextW(b.devs.OutA, ev3.DutyCycleSp)
+	b.motorL2 = ev3.OpenTextW(b.devs.OutB, ev3.DutyCycleSp)
+	b.motorR1 = ev3.OpenTextW(b.devs.OutC, ev3.DutyCycleSp)
+	b.motorR2 = ev3.OpenTextW(b.devs.OutD, ev3.DutyCycleSp)
 
 	// Reset motor speed
-	motorL1.Value = 0
-	motorL2.Value = 0
-	motorR1.Value = 0
-	motorR2.Value = 0
+	b.motorL1.Value = 0
+	b.motorL2.Value = 0
+	b.motorR1.Value = 0
+	b.motorR2.Value = 0
 
-	motorL1.Sync()
-	motorL2.Sync()
-	motorR1.Sync()
-	motorR2.Sync()
+	b.motorL1.Sync()
+	b.motorL2.Sync()
+	b.motorR1.Sync()
+	b.motorR2.Sync()
 
 	// Put motors in direct mode
-	ev3.RunCommand(devs.OutA, ev3.CmdRunDirect)
-	ev3.RunCommand(devs.OutB, ev3.CmdRunDirect)
-	ev3.RunCommand(devs.OutC, ev3.CmdRunDirect)
-	ev3.RunCommand(devs.OutD, ev3.CmdRunDirect)
-}
-
-func close() {
-	// Close buttons
-	buttons.Close()
-
-	// Stop motors
-	ev3.RunCommand(devs.OutA, ev3.CmdStop)
-	ev3.RunCommand(devs.OutB, ev3.CmdStop)
-	ev3.RunCommand(devs.OutC, ev3.CmdStop)
-	ev3.RunCommand(devs.OutD, ev3.CmdStop)
-
-	// Close motors
-	motorL1.Close()
-	motorL2.Close()
-	motorR1.Close()
-	motorR2.Close()
-
-	// Close sensor values
-	closeSensors()
+	ev3.RunCommand(b.devs.OutA, ev3.CmdRunDirect)
+	ev3.RunCommand(b.devs.OutB, ev3.CmdRunDirect)
+	ev3.RunCommand(b.devs.OutC, ev3.CmdRunDirect)
+	ev3.RunCommand(b.devs.OutD, ev3.CmdRunDirect)
 }
 
-var lastMoveTicks int
-var lastSpeedLeft int
-var lastSpeedRight int
-
 const accelPerTicks int = 5
 const accelSpeedFactor int = 10000
 
-func move(left int, right int, now int) {
-	ticks := now - lastMoveTicks
-	lastMoveTicks = now
+func (b *bot) move(left int, right int, now int) {
+	if !b.watchdog.Feed() {
+		return
+	}
+	ticks := now - b.lastMoveTicks
+	b.lastMoveTicks = now
 	right *= accelSpeedFactor
 	left *= accelSpeedFactor
 
-	nextSpeedLeft := lastSpeedLeft
-	nextSpeedRight := lastSpeedRight
+	nextSpeedLeft := b.lastSpeedLeft
+	nextSpeedRight := b.lastSpeedRight
 	delta := ticks * accelPerTicks
 	// delta := ticks * ticks * accelPerTicks
 
@@ -168,25 +160,33 @@ func move(left int, right int, now int) {
 			nextSpeedRight = right
 		}
 	}
-	lastSpeedLeft = nextSpeedLeft
-	lastSpeedRight = nextSpeedRight
+	b.lastSpeedLeft = nextSpeedLeft
+	b.lastSpeedRight = nextSpeedRight
 
-	motorL1.Value = nextSpeedLeft / accelSpeedFactor
-	motorL2.Value = nextSpeedLeft / accelSpeedFactor
-	motorR1.Value = -nextSpeedRight / accelSpeedFactor
-	motorR2.Value = -nextSpeedRight / accelSpeedFactor
+	b.motorL1.Value = nextSpeedLeft / accelSpeedFactor
+	b.motorL2.Value = nextSpeedLeft / accelSpeedFactor
+	b.motorR1.Value = -nextSpeedRight / accelSpeedFactor
+	b.motorR2.Value = -nextSpeedRight / accelSpeedFactor
 
-	motorL1.Sync()
-	motorL2.Sync()
-	motorR1.Sync()
-	motorR2.Sync()
+	if b.simulation != nil {
+		b.simulation.step()
+		return
+	}
+	b.motorL1.Sync()
+	b.motorL2.Sync()
+	b.motorR1.Sync()
+	b.motorR2.Sync()
 }
 
-func read() {
-	cF.Sync()
-	cL.Sync()
-	cR.Sync()
-	cB.Sync()
+func (b *bot) read() {
+	if b.simulation != nil {
+		b.simulation.read()
+		return
+	}
+	b.cF.Sync()
+	b.cL.Sync()
+	b.cR.Sync()
+	b.cB.Sync()
 }
 
 func durationToTicks(d time.Duration) int {
@@ -195,59 +195,104 @@ func durationToTicks(d time.Duration) int {
 func timespanAsTicks(start time.Time, end time.Time) int {
 	return durationToTicks(end.Sub(start))
 }
-func currentTicks() int {
-	return timespanAsTicks(initializationTime, time.Now())
+func (b *bot) currentTicks() int {
+	return timespanAsTicks(b.initializationTime, b.clock.Now())
 }
 func ticksToMillis(ticks int) int {
 	return ticks / 1000
 }
 
 func print(data ...interface{}) {
-	fmt.Fprintln(os.Stderr, data...)
+	if cmdline.Enabled(cmdline.Info) {
+		fmt.Fprintln(os.Stderr, data...)
+	}
+}
+
+func debug(data ...interface{}) {
+	if cmdline.Enabled(cmdline.Debug) {
+		fmt.Fprintln(os.Stderr, data...)
+	}
 }
 
-func quit(data ...interface{}) {
-	close()
-	log.Fatalln(data...)
+func printError(data ...interface{}) {
+	fmt.Fprintln(os.Stderr, data...)
 }
 
-func waitEnter() {
+func (b *bot) waitEnter() {
 	// Let the button be released if needed
-	if buttons.Enter == true {
+	if b.buttons.Enter == true {
 		print("wait enter release")
-		for buttons.Enter == true {
-			now := currentTicks()
-			move(0, 0, now)
+		for b.buttons.Enter == true {
+			now := b.currentTicks()
+			b.move(0, 0, now)
 		}
 	}
 
 	// Wait for it to be pressed
 	print("wait enter")
-	for buttons.Enter == false {
-		now := currentTicks()
-		move(0, 0, now)
-		if buttons.Back {
-			newConf, err := config.FromFile("greyhound.toml")
-			if err != nil {
-				print("Error reading conf:", err)
-			} else {
-				conf = newConf
-				print("Configuration reloaded:", conf)
+	for b.buttons.Enter == false {
+		now := b.currentTicks()
+		b.move(0, 0, now)
+		if b.buttons.Back {
+			for b.buttons.Back {
+				now := b.currentTicks()
+				b.move(0, 0, now)
 			}
+			b.loadConfig()
+		} else if b.watcher.Changed() {
+			print("Configuration file changed")
+			b.loadConfig()
 		}
+		if b.buttons.Up {
+			for b.buttons.Up {
+				now := b.currentTicks()
+				b.move(0, 0, now)
+			}
+			b.calibrate()
+		}
+		if b.buttons.Right {
+			for b.buttons.Right {
+				now := b.currentTicks()
+				b.move(0, 0, now)
+			}
+			b.selectNextProfile()
+		}
+	}
+}
+
+func (b *bot) loadConfig() {
+	b.watcher.Reset()
+	newConf, err := config.FromFile(b.configFile, b.profile, b.sets)
+	if err != nil {
+		printError("Error reading conf, keeping the previous one:", err)
+	} else {
+		b.conf = newConf
+		print("Configuration loaded:\n" + botconf.String(b.conf))
 	}
 }
 
-func waitOneSecond() int {
-	initializeTime()
+// selectNextProfile switches to the next profile of the configuration file
+func (b *bot) selectNextProfile() {
+	names, err := botconf.ProfileNamesInFile(b.configFile)
+	if err != nil {
+		printError("Error reading profiles:", err)
+		return
+	}
+	b.profile = botconf.NextProfile(names, b.conf.Profile)
+	print("profile", botconf.ProfileLabel(b.profile))
+	b.loadConfig()
+}
+
+func (b *bot) waitOneSecond() int {
+	b.initializeTime()
 	print("wait one second")
-	start := currentTicks()
+	start := b.currentTicks()
 	for {
-		now := currentTicks()
+		now := b.currentTicks()
 		elapsed := now - start
-		move(0, 0, now)
-		if buttons.Enter && buttons.Back {
-			quit("Done")
+		b.move(0, 0, now)
+		if b.buttons.Enter && b.buttons.Back {
+			ev3.Exit(ev3.ExitOK, "Done")
 		}
 		if elapsed >= 1000000 {
 			return now
@@ -255,27 +300,6 @@ func waitOneSecond() int {
 	}
 }
 
-func trimSensor(attr *ev3.Attribute) int {
-	value := attr.Value + attr.Value1 + attr.Value2
-	if value < conf.SensorMin {
-		value = conf.SensorMin
-	}
-	value -= conf.SensorMin
-	if value > conf.SensorSpan {
-		value = conf.SensorSpan
-	}
-	return value
-}
-func isOnTrack(value int) bool {
-	return value < conf.SensorSpan
-}
-func distanceFromSensor(value int) int {
-	return value * conf.SensorRadius / conf.SensorSpan
-}
-func positionBetweenSensors(value1 int, value2 int) int {
-	return (value1 - value2) * conf.SensorRadius / conf.SensorSpan
-}
-
 func sign(value int) int {
 	if value > 0 {
 		return 1
@@ -286,214 +310,378 @@ func sign(value int) int {
 	}
 }
 
-type sensorReadType int
+func (b *bot) initializeEstimator() {
+	b.estimator = line.New(line.Diamond(b.conf.SensorRadius, b.conf.SensorMin, b.conf.SensorSpan), b.conf.SensorRadius)
+	if b.calibration != nil {
+		b.calibration.Apply(b.estimator.Sensors)
+	}
+}
 
-const (
-	bitB sensorReadType = 1 << iota
-	bitR
-	bitL
-	bitF
-)
+// calibrationFile is next to the configuration file
+func (b *bot) calibrationFile() string {
+	return filepath.Join(filepath.Dir(b.configFile), "greyhound-calibration.toml")
+}
 
-const (
-	sensorReadZero sensorReadType = iota
-	sensorReadB
-	sensorReadR
-	sensorReadRB
-	sensorReadL
-	sensorReadLB
-	sensorReadLR
-	sensorReadLRB
-	sensorReadF
-	sensorReadFB
-	sensorReadFR
-	sensorReadFRB
-	sensorReadFL
-	sensorReadFLB
-	sensorReadFLR
-	sensorReadFLRB
-)
+func (b *bot) loadCalibration() {
+	c, err := line.LoadCalibration(b.calibrationFile())
+	if err != nil {
+		print("No calibration, using SensorMin and SensorSpan:", err)
+		return
+	}
+	b.calibration = &c
+	print("Calibration loaded from", b.calibrationFile())
+}
+
+// calibrate records the sensors while the robot stands still next to the line
+// and then turns to sweep them across it, and saves the result
+func (b *bot) calibrate() {
+	print("calibrating")
+	b.initializeEstimator()
+	recorder := line.NewRecorder(b.estimator.Sensors)
+
+	start := b.currentTicks()
+	for b.currentTicks()-start < 500000 {
+		b.move(0, 0, b.currentTicks())
+		b.read()
+		recorder.AddStill(b.readings())
+	}
+
+	speed := b.conf.CalibrationSpeed
+	turns := []struct {
+		left   int
+		right  int
+		millis int
+	}{
+		{-speed, speed, b.conf.CalibrationMillis},
+		{speed, -speed, b.conf.CalibrationMillis * 2},
+		{-speed, speed, b.conf.CalibrationMillis},
+	}
+	for _, turn := range turns {
+		start := b.currentTicks()
+		for b.currentTicks()-start < turn.millis*1000 {
+			b.move(turn.left, turn.right, b.currentTicks())
+			b.read()
+			recorder.Add(b.readings())
+		}
+	}
+	// After a watchdog trip move does nothing, the motors are already stopped
+	for (b.lastSpeedLeft != 0 || b.lastSpeedRight != 0) && !b.watchdog.Tripped() {
+		b.move(0, 0, b.currentTicks())
+	}
+	if b.watchdog.Tripped() {
+		printError("Calibration failed: the watchdog stopped the motors")
+		return
+	}
+
+	c, err := recorder.Calibration(b.conf.CalibrationMinContrast)
+	if err != nil {
+		printError("Calibration failed:", err)
+		return
+	}
+	for _, s := range c.Sensors {
+		print("calibrated", s.Name, "line", s.Line, "background", s.Background, "noise", s.Noise, "threshold", s.Threshold)
+	}
+	err = c.Save(b.calibrationFile())
+	if err != nil {
+		printError("Error saving calibration:", err)
+	}
+	b.calibration = &c
+}
+
+func rgb(attr *ev3.Attribute) line.RGB {
+	return line.RGB{R: attr.Value, G: attr.Value1, B: attr.Value2}
+}
+
+// readings returns the sensor readings in the order of the estimator sensors
+func (b *bot) readings() []line.RGB {
+	return []line.RGB{rgb(b.cF), rgb(b.cL), rgb(b.cR), rgb(b.cB)}
+}
+
+func (b *bot) newMarkerDetector() *marker.Detector {
+	return &marker.Detector{
+		RatioPC:   b.conf.MarkerRatioPC,
+		Min:       b.conf.MarkerMin,
+		MinMillis: b.conf.MarkerMillis,
+	}
+}
+
+func (b *bot) newLapCounter() *lap.Counter {
+	return &lap.Counter{
+		Mode:            b.conf.LapDetection,
+		CrossingsPerLap: b.conf.CrossingsPerLap,
+		MinMillis:       b.conf.LapMinMillis,
+		LengthMM:        b.conf.LapLengthMM,
+	}
+}
+
+// planSpeed turns the track recorded in the first lap into a speed profile
+func (b *bot) planSpeed(recorder *track.Recorder) track.Profile {
+	result := track.Plan(recorder.Map(), recorder.SegmentLength, track.Params{
+		StraightSpeed: b.conf.StraightSpeed,
+		CurveSpeed:    b.conf.CurveSpeed,
+		CurveSteering: float64(b.conf.CurveSteeringPC) / 100,
+		Accel:         float64(accelPerTicks*1000) / float64(accelSpeedFactor),
+		Lookahead:     b.conf.TrackLookahead,
+	})
+	print("speed profile", result.Speeds)
+	return result
+}
+
+// showLaps prints the lap summary and shows it on the LCD
+func (b *bot) showLaps(laps []lap.Lap) {
+	summary := lap.Summary(laps)
+	for _, l := range summary {
+		print(l)
+	}
+	if b.simulation != nil {
+		return
+	}
+	err := ev3.WriteLCD(summary...)
+	if err != nil {
+		printError("Error writing to the LCD:", err)
+	}
+}
+
+// lineStatus shows which sensors see the line, like "FL--"
+func (b *bot) lineStatus(est line.Estimate) string {
+	if est.Lost {
+		return "----"
+	}
+	status := ""
+	for i, s := range b.estimator.Sensors {
+		if b.estimator.Sees(est, i) {
+			status += s.Name
+		} else {
+			status += "-"
+		}
+	}
+	if est.Cross {
+		status += "+"
+	}
+	return status
+}
 
-var sensorReadNames = [16]string{
-	"---",
-	"-v-",
-	"-->",
-	"-v>",
-	"<--",
-	"<v-",
-	"<->",
-	"<v>",
-	"-^-",
-	"-X-",
-	"-^>",
-	"-X>",
-	"<^-",
-	"<X-",
-	"<^>",
-	"<X>",
-}
-
-// const lineStatusStraight = "-|-"
-// const lineStatusStraightLeft = "<|-"
-// const lineStatusLeft = "<--"
-// const lineStatusStraightRight = "-|>"
-// const lineStatusRight = "-->"
-// const lineStatusFrontLeft = "<^-"
-// const lineStatusFrontRight = "-^>"
-// const lineStatusBackLeft = "<v-"
-// const lineStatusBackRight = "-v>"
-// const lineStatusOut = "---"
-// const lineStatusCross = "-+-"
-
-func processSensorData() (sensorRead sensorReadType, pos int, hint int, cross bool, out bool) {
-	read()
-	f, l, r, b := trimSensor(cB), trimSensor(cL), trimSensor(cR), trimSensor(cB)
-
-	sensorRead = sensorReadZero
-	if isOnTrack(b) {
-		sensorRead |= bitB
-	}
-	if isOnTrack(r) {
-		sensorRead |= bitR
-	}
-	if isOnTrack(l) {
-		sensorRead |= bitL
-	}
-	if isOnTrack(f) {
-		sensorRead |= bitF
-	}
-
-	switch sensorRead {
-	case sensorReadZero:
-	case sensorReadB:
-	case sensorReadF:
-		// Out
-		out = true
-		pos, hint, cross = 0, 0, false
-	case sensorReadR:
-		pos = conf.SensorRadius*2 + distanceFromSensor(r)
-		hint = 0
-		cross, out = false, false
-	case sensorReadRB:
-		pos = conf.SensorRadius + positionBetweenSensors(b, r)
-		hint = 1
-		cross, out = false, false
-	case sensorReadL:
-		pos = -conf.SensorRadius*2 - distanceFromSensor(l)
-		hint = 0
-		cross, out = false, false
-	case sensorReadLB:
-		pos = -conf.SensorRadius + positionBetweenSensors(l, b)
-		hint = 1
-		cross, out = false, false
-	case sensorReadLR:
-	case sensorReadLRB:
-	case sensorReadFLRB:
-	case sensorReadFLR:
-		// Cross
-		cross = true
-		pos, hint, out = 0, 0, false
-	case sensorReadFB:
-		pos = 0
-		hint = 0
-		cross, out = false, false
-	case sensorReadFR:
-		pos = conf.SensorRadius + positionBetweenSensors(f, r)
-		hint = -1
-		cross, out = false, false
-	case sensorReadFRB:
-		pos = conf.SensorRadius + positionBetweenSensors((f+b)/2, r)
-		hint = 0
-		cross, out = false, false
-	case sensorReadFL:
-		pos = -conf.SensorRadius + positionBetweenSensors(l, f)
-		hint = 0
-		cross, out = false, false
-	case sensorReadFLB:
-		pos = -conf.SensorRadius + positionBetweenSensors(l, (f+b)/2)
-		hint = 0
-		cross, out = false, false
-	default:
-		print("Error: reading", sensorRead)
-	}
-
-	return
-}
-
-func moveOneSecond() {
+func (b *bot) moveOneSecond() {
 	print("move one second")
-	start := currentTicks()
+	start := b.currentTicks()
 	for {
-		now := currentTicks()
+		now := b.currentTicks()
 		elapsed := now - start
-		move(conf.MaxSpeed, conf.MaxSpeed, now)
+		b.move(b.conf.MaxSpeed, b.conf.MaxSpeed, now)
 		if elapsed >= 1000000 {
 			break
 		}
 	}
 }
 
-func followLine(lastGivenTicks int) {
-	print("following line")
+// turnAtCrossing drives over a crossing and turns in place until the front
+// sensor leaves the line and then finds the new one
+func (b *bot) turnAtCrossing(action route.Action) {
+	start := b.currentTicks()
+	for b.currentTicks()-start < b.conf.RouteForwardMillis*1000 {
+		b.move(b.conf.MaxSpeed, b.conf.MaxSpeed, b.currentTicks())
+	}
+
+	left, right := -b.conf.RouteTurnSpeed, b.conf.RouteTurnSpeed
+	if action == route.Right {
+		left, right = right, left
+	}
+	start = b.currentTicks()
+	leftLine := false
+	for {
+		now := b.currentTicks()
+		if now-start >= b.conf.RouteTurnMaxMillis*1000 {
+			printError("Error: turn", action, "did not find the line")
+			return
+		}
+		b.read()
+		est := b.estimator.Estimate(b.readings())
+		sees := b.estimator.Sees(est, line.Front)
+		if !leftLine && !sees {
+			leftLine = true
+		} else if leftLine && sees {
+			return
+		}
+		b.move(left, right, now)
+	}
+}
+
+func (b *bot) followLine(lastGivenTicks int) {
+	print("following line, profile", botconf.ProfileLabel(b.conf.Profile))
+	b.initializeEstimator()
+
+	maxSteering := float64(b.conf.MaxSteeringPC) / 100
+	steer := pid.New(b.conf.KP/100, b.conf.KI/100, b.conf.KD/100, -maxSteering, maxSteering)
+	steer.IntegralLimit = float64(b.conf.IntegralLimitPC) / 100
+	steer.DFilter = float64(b.conf.DFilterMillis) / 1000
+	for _, g := range b.conf.GainSchedule {
+		steer.Schedule = append(steer.Schedule, pid.Point{At: float64(g.Speed), Gains: pid.Gains{KP: g.KP / 100, KI: g.KI / 100, KD: g.KD / 100}})
+	}
+
+	laps := b.newLapCounter()
+	laps.Start(ticksToMillis(lastGivenTicks))
+	defer func() {
+		b.showLaps(laps.Laps())
+	}()
+
+	var recorder *track.Recorder
+	var speedProfile track.Profile
+	if b.conf.TrackLearning {
+		if b.conf.LapDetection == lap.DetectNone {
+			printError("TrackLearning needs LapDetection, ignoring it")
+		} else {
+			recorder = track.NewRecorder(b.conf.TrackSegmentMillis * b.conf.MaxSpeed)
+		}
+	}
+	distance := 0
+
+	markers := b.newMarkerDetector()
+	pendingTurn := route.Action("")
+	slowSpeed, slowUntil := 0, 0
+
+	r, _ := route.Parse(b.conf.Route)
+	wasCross := false
+	lastCrossTicks := lastGivenTicks - b.conf.RouteCrossMillis*1000
 
 	lastTicks := lastGivenTicks
 	lastPos := 0
-	lastPosD := 0
 
 	for {
-		now := currentTicks()
-		sr, pos, hint, cross, out := processSensorData()
-		posD := 0
-
-		if out {
-			pos = conf.SensorRadius * 3 * sign(lastPos)
-			hint = sign(pos)
-			posD = lastPosD
-		} else if cross {
-			pos = lastPos
-			posD = 0
-		} else {
-			dTicks := now - lastTicks
-			if dTicks < 5 {
-				dTicks = 5
+		now := b.currentTicks()
+		b.read()
+		rs := b.readings()
+		est := b.estimator.Estimate(rs)
+
+		stop, lapMarker := false, false
+		for _, color := range markers.Update(ticksToMillis(now), rs) {
+			m, ok := b.conf.Markers[color]
+			if !ok || m.Action == marker.None {
+				continue
+			}
+			print("marker", color, m.Action)
+			switch m.Action {
+			case marker.Stop:
+				stop = true
+			case marker.Slow:
+				if slowSpeed > 0 && m.Millis == 0 {
+					slowSpeed = 0
+				} else {
+					slowSpeed, slowUntil = m.Speed, 0
+					if m.Millis > 0 {
+						slowUntil = now + m.Millis*1000
+					}
+				}
+			case marker.Turn:
+				pendingTurn = route.Action(m.Turn)
+			case marker.Lap:
+				lapMarker = true
+			}
+		}
+		if stop {
+			print("stop marker")
+			break
+		}
+		if slowUntil > 0 && now >= slowUntil {
+			slowSpeed, slowUntil = 0, 0
+		}
+
+		if (r.Active() || pendingTurn != "") && est.Cross && !wasCross && now-lastCrossTicks >= b.conf.RouteCrossMillis*1000 {
+			lastCrossTicks = now
+			action := pendingTurn
+			if action != "" {
+				pendingTurn = ""
+				print("junction", action)
+			} else {
+				action = r.Next()
+				print("crossing", r.Crossing(), action)
+			}
+			if action == route.Stop {
+				print("route done")
+				break
 			}
-			if dTicks > 100000 {
-				dTicks = 100000
+			if action == route.Left || action == route.Right {
+				b.turnAtCrossing(action)
+				steer.Reset()
+				lastTicks, lastPos = b.currentTicks(), 0
+				wasCross = false
+				continue
 			}
-			posD = (pos - lastPos) / dTicks
-			posD += hint
 		}
+		wasCross = est.Cross
 
-		lastTicks, lastPos, lastPosD = now, pos, posD
+		pos := est.Pos
+
+		if est.Lost {
+			pos = b.conf.MaxPos * sign(lastPos)
+		} else if est.Cross {
+			pos = lastPos
+		}
+
+		dTicks := now - lastTicks
+		if dTicks < b.conf.MinDTicks {
+			dTicks = b.conf.MinDTicks
+		}
+		if dTicks > b.conf.MaxDTicks {
+			dTicks = b.conf.MaxDTicks
+		}
+		lastTicks, lastPos = now, pos
+
+		baseSpeed := speedProfile.SpeedAt(distance, b.conf.MaxSpeed)
+		if slowSpeed > 0 && slowSpeed < baseSpeed {
+			baseSpeed = slowSpeed
+		}
+		steeringLimit := baseSpeed * b.conf.MaxSteeringPC / 100
+
+		// The controller works on the position as a fraction of MaxPos, in seconds,
+		// and gives the steering as a fraction of MaxSpeed
+		x := float64(pos) / float64(b.conf.MaxPos)
+		steer.ScheduleAt(float64(baseSpeed))
+		output := -steer.Update(0, x, float64(dTicks)/1000000)
 
 		pos2 := sign(pos) * pos * pos
-		posD2 := sign(posD) * posD * posD
+		factorP2 := int(float64(pos2*baseSpeed) * b.conf.KP2 / float64(b.conf.MaxPos2*100))
 
-		factorP := (pos * conf.KP * conf.MaxSpeed) / (conf.MaxPos * 100)
-		factorP2 := (pos2 * conf.KP2 * conf.MaxSpeed) / (conf.MaxPos2 * 100)
-		factorD := (posD * conf.KD * conf.MaxSpeed) / (conf.MaxPosD * 100)
-		factorD2 := (posD2 * conf.KD2 * conf.MaxSpeed) / (conf.MaxPosD2 * 100)
+		steering := int(output*float64(baseSpeed)) + factorP2
 
-		steering := factorP + factorP2 - factorD - factorD2
+		terms := steer.Terms()
+		debug(b.lineStatus(est), "pos", pos, "c", est.Confidence, "pid", -terms.P, -terms.I, -terms.D, "p2", factorP2, "t", dTicks/1000, "v", baseSpeed, "s", steering)
+		cmdline.Trace(now, b.lineStatus(est), pos, est.Confidence, baseSpeed, steering)
 
-		print(sensorReadNames[sr], "pos", pos, "f", factorP, factorP2, factorD, factorD2, "t", (now-lastTicks)/1000, "s", steering)
+		if recorder != nil {
+			recorder.Add(distance, float64(steering)/float64(baseSpeed))
+		}
+		distance += baseSpeed * dTicks / 1000
 
 		if steering > 0 {
-			if steering > conf.MaxSteering {
-				steering = conf.MaxSteering
+			if steering > steeringLimit {
+				steering = steeringLimit
 			}
-			move(conf.MaxSpeed, conf.MaxSpeed-steering, now)
+			b.move(baseSpeed, baseSpeed-steering, now)
 		} else if steering < 0 {
 			steering = -steering
-			if steering > conf.MaxSteering {
-				steering = conf.MaxSteering
+			if steering > steeringLimit {
+				steering = steeringLimit
 			}
-			move(conf.MaxSpeed-steering, conf.MaxSpeed, now)
+			b.move(baseSpeed-steering, baseSpeed, now)
 		} else {
-			move(conf.MaxSpeed, conf.MaxSpeed, now)
+			b.move(baseSpeed, baseSpeed, now)
 		}
 
-		if buttons.Enter {
+		speed := (b.lastSpeedLeft + b.lastSpeedRight) / (2 * accelSpeedFactor)
+		if laps.Update(ticksToMillis(now), est.Cross, est.Lost, lapMarker, speed) {
+			print(laps.Last())
+			distance = 0
+			if recorder != nil {
+				speedProfile = b.planSpeed(recorder)
+				recorder = nil
+			}
+			if b.conf.Laps > 0 && len(laps.Laps()) >= b.conf.Laps {
+				print("laps done")
+				break
+			}
+		}
+
+		if b.buttons.Enter {
 			print("stopping")
 			break
 		}
@@ -501,21 +689,40 @@ func followLine(lastGivenTicks int) {
 }
 
 func main() {
-	handleSignals()
-	initialize()
-	defer close()
+	ev3.HandleSignals()
+	defer ev3.Recover()
 
-	newConf, err := config.FromFile("greyhound.toml")
-	if err != nil {
-		print("Error reading conf:", err)
-		conf = config.Default()
-		print("Using default conf", conf)
+	trackFile := flag.String("track", "", "run on the simulated line track described in `file` (starts right away)")
+	recordFile := flag.String("record", "", "with -track, record the run to `file` (.json to play it back with replay, .svg or .gif)")
+	opts := cmdline.Parse("greyhound.toml")
+	b := &bot{clock: clock.Real{}, conf: config.Default(), configFile: opts.ConfigFile, profile: opts.Profile, sets: opts.Sets}
+
+	if *recordFile != "" && *trackFile == "" {
+		ev3.Fatalln("Error: -record needs -track")
+	}
+	if *trackFile != "" {
+		b.initializeSim(*trackFile, *recordFile)
 	} else {
-		conf = newConf
-		print("Configuration loaded:", conf)
+		b.initialize()
 	}
 
-	waitEnter()
-	lastGivenTicks := waitOneSecond()
-	followLine(lastGivenTicks)
+	// The watcher runs on the clock of the track when simulating
+	b.watcher = botconf.NewWatcher(b.configFile, botconf.WatchInterval, b.clock)
+	b.loadConfig()
+	if b.simulation == nil {
+		// The calibration is the one of the real sensors
+		b.loadCalibration()
+	}
+
+	b.watchdog = ev3.NewWatchdog(b.devs, time.Duration(b.conf.WatchdogMillis)*time.Millisecond, b.clock)
+	if b.simulation == nil {
+		b.watchdog.Start()
+		b.waitEnter()
+	}
+	lastGivenTicks := b.waitOneSecond()
+	b.followLine(lastGivenTicks)
+	if b.simulation != nil {
+		b.simulation.summary()
+	}
+	ev3.Exit(ev3.ExitOK, "Done")
 }
@@ -0,0 +1,148 @@
+package lap
+
+import "fmt"
+
+// Lap detection modes
+const (
+	// DetectNone never ends a lap
+	DetectNone = "none"
+	// DetectMarker ends a lap when the start/finish marker is seen
+	DetectMarker = "marker"
+	// DetectCrossings ends a lap after a number of crossings
+	DetectCrossings = "crossings"
+)
+
+// Lap is the record of a completed lap
+type Lap struct {
+	Number int
+	Millis int
+	// Crossings is the number of crossing lines driven over
+	Crossings int
+	// LineLost is the number of times the line has been lost
+	LineLost int
+	// AverageSpeed is the average commanded speed (in motor duty cycle percent)
+	AverageSpeed int
+	// SpeedMMS is the average speed in millimeters per second (0 when the lap length is not known)
+	SpeedMMS int
+}
+
+// Counter detects laps and records them
+type Counter struct {
+	Mode            string
+	CrossingsPerLap int
+	// MinMillis is the shortest possible lap, detections before it are ignored
+	MinMillis int
+	// LengthMM is the length of a lap (0 if unknown)
+	LengthMM int
+
+	laps       []Lap
+	lapStart   int
+	crossings  int
+	lost       int
+	speedSum   int
+	samples    int
+	wasCross   bool
+	wasLost    bool
+	wasMarker  bool
+	inProgress bool
+}
+
+// Start begins timing the first lap
+func (c *Counter) Start(now int) {
+	c.laps = nil
+	c.startLap(now)
+}
+
+func (c *Counter) startLap(now int) {
+	c.lapStart = now
+	c.crossings, c.lost = 0, 0
+	c.speedSum, c.samples = 0, 0
+	c.inProgress = true
+}
+
+// Update processes a reading taken at now (in milliseconds), it returns true when it completes a lap
+func (c *Counter) Update(now int, cross bool, lost bool, marker bool, speed int) bool {
+	if !c.inProgress {
+		c.Start(now)
+	}
+	crossEdge := cross && !c.wasCross
+	markerEdge := marker && !c.wasMarker
+	if lost && !c.wasLost {
+		c.lost++
+	}
+	if crossEdge {
+		c.crossings++
+	}
+	c.wasCross, c.wasLost, c.wasMarker = cross, lost, marker
+	c.speedSum += speed
+	c.samples++
+
+	if now-c.lapStart < c.MinMillis {
+		return false
+	}
+	done := false
+	switch c.Mode {
+	case DetectMarker:
+		done = markerEdge
+	case DetectCrossings:
+		done = c.CrossingsPerLap > 0 && crossEdge && c.crossings >= c.CrossingsPerLap
+	}
+	if !done {
+		return false
+	}
+
+	lap := Lap{
+		Number:       len(c.laps) + 1,
+		Millis:       now - c.lapStart,
+		Crossings:    c.crossings,
+		LineLost:     c.lost,
+		AverageSpeed: c.speedSum / c.samples,
+	}
+	if c.LengthMM > 0 && lap.Millis > 0 {
+		lap.SpeedMMS = c.LengthMM * 1000 / lap.Millis
+	}
+	c.laps = append(c.laps, lap)
+	c.startLap(now)
+	return true
+}
+
+// Laps returns the completed laps
+func (c *Counter) Laps() []Lap {
+	return c.laps
+}
+
+// Last returns the last completed lap
+func (c *Counter) Last() Lap {
+	if len(c.laps) == 0 {
+		return Lap{}
+	}
+	return c.laps[len(c.laps)-1]
+}
+
+// String formats a lap on one line
+func (l Lap) String() string {
+	result := fmt.Sprintf("lap %d %d.%03ds speed %d", l.Number, l.Millis/1000, l.Millis%1000, l.AverageSpeed)
+	if l.SpeedMMS > 0 {
+		result += fmt.Sprintf(" (%dmm/s)", l.SpeedMMS)
+	}
+	return result + fmt.Sprintf(" lost %d", l.LineLost)
+}
+
+// Summary formats the laps, one per line, followed by the best and total times
+func Summary(laps []Lap) []string {
+	if len(laps) == 0 {
+		return []string{"no laps"}
+	}
+	lines := []string{}
+	best, total := laps[0], 0
+	for _, l := range laps {
+		lines = append(lines, l.String())
+		if l.Millis < best.Millis {
+			best = l
+		}
+		total += l.Millis
+	}
+	lines = append(lines, fmt.Sprintf("best lap %d %d.%03ds", best.Number, best.Millis/1000, best.Millis%1000))
+	lines = append(lines, fmt.Sprintf("total %d.%03ds", total/1000, total%1000))
+	return lines
+}
@@ -0,0 +1,131 @@
+package lap
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sample is one reading given to Counter.Update
+type sample struct {
+	now    int
+	cross  bool
+	lost   bool
+	marker bool
+	speed  int
+}
+
+// run feeds the samples to c and returns the times at which laps were completed
+func run(c *Counter, samples []sample) []int {
+	done := []int{}
+	for _, s := range samples {
+		if c.Update(s.now, s.cross, s.lost, s.marker, s.speed) {
+			done = append(done, s.now)
+		}
+	}
+	return done
+}
+
+func TestMarkerEndsLaps(t *testing.T) {
+	c := &Counter{Mode: DetectMarker, LengthMM: 2000}
+	c.Start(0)
+	done := run(c, []sample{
+		{now: 500, speed: 30},
+		{now: 1000, marker: true, speed: 30},
+		// still on the same marker
+		{now: 1100, marker: true, speed: 30},
+		{now: 1500, lost: true, speed: 10},
+		{now: 2000, marker: true, speed: 50},
+	})
+	if !reflect.DeepEqual(done, []int{1000, 2000}) {
+		t.Fatalf("laps done at %v, want [1000 2000]", done)
+	}
+	want := []Lap{
+		{Number: 1, Millis: 1000, AverageSpeed: 30, SpeedMMS: 2000},
+		{Number: 2, Millis: 1000, LineLost: 1, AverageSpeed: 30, SpeedMMS: 2000},
+	}
+	if !reflect.DeepEqual(c.Laps(), want) {
+		t.Errorf("laps are %+v, want %+v", c.Laps(), want)
+	}
+	if c.Last() != want[1] {
+		t.Errorf("last lap is %+v, want %+v", c.Last(), want[1])
+	}
+}
+
+func TestCrossingsEndLapsOnACrossing(t *testing.T) {
+	c := &Counter{Mode: DetectCrossings, CrossingsPerLap: 2, MinMillis: 1000}
+	c.Start(0)
+	done := run(c, []sample{
+		{now: 100, cross: true},
+		{now: 200},
+		// the second crossing comes before MinMillis
+		{now: 300, cross: true},
+		{now: 400},
+		// past MinMillis but not on a crossing, the lap goes on
+		{now: 1100},
+		{now: 1500, cross: true},
+		{now: 1600, cross: true},
+		{now: 1700},
+		{now: 2000, cross: true},
+		{now: 2100},
+		{now: 2600, cross: true},
+	})
+	if !reflect.DeepEqual(done, []int{1500, 2600}) {
+		t.Fatalf("laps done at %v, want [1500 2600]", done)
+	}
+	laps := c.Laps()
+	if laps[0].Crossings != 3 || laps[1].Crossings != 2 {
+		t.Errorf("crossings are %d and %d, want 3 and 2", laps[0].Crossings, laps[1].Crossings)
+	}
+}
+
+func TestMinMillisIgnoresEarlyMarkers(t *testing.T) {
+	c := &Counter{Mode: DetectMarker, MinMillis: 1000}
+	c.Start(0)
+	done := run(c, []sample{
+		{now: 200, marker: true},
+		{now: 300},
+		{now: 900, marker: true},
+		{now: 1000},
+		{now: 1200, marker: true},
+	})
+	if !reflect.DeepEqual(done, []int{1200}) {
+		t.Errorf("laps done at %v, want [1200]", done)
+	}
+}
+
+func TestNoneNeverEndsLaps(t *testing.T) {
+	c := &Counter{Mode: DetectNone}
+	c.Start(0)
+	done := run(c, []sample{
+		{now: 1000, cross: true, marker: true},
+		{now: 2000},
+		{now: 3000, cross: true, marker: true},
+	})
+	if len(done) != 0 || len(c.Laps()) != 0 {
+		t.Errorf("laps done at %v, want none", done)
+	}
+	if c.Last() != (Lap{}) {
+		t.Errorf("last lap is %+v, want none", c.Last())
+	}
+}
+
+func TestSummary(t *testing.T) {
+	laps := []Lap{
+		{Number: 1, Millis: 12345, AverageSpeed: 30, LineLost: 1},
+		{Number: 2, Millis: 11002, AverageSpeed: 35, SpeedMMS: 450},
+		{Number: 3, Millis: 11500, AverageSpeed: 34},
+	}
+	want := []string{
+		"lap 1 12.345s speed 30 lost 1",
+		"lap 2 11.002s speed 35 (450mm/s) lost 0",
+		"lap 3 11.500s speed 34 lost 0",
+		"best lap 2 11.002s",
+		"total 34.847s",
+	}
+	if got := Summary(laps); !reflect.DeepEqual(got, want) {
+		t.Errorf("summary is\n%q\nwant\n%q", got, want)
+	}
+	if got := Summary(nil); !reflect.DeepEqual(got, []string{"no laps"}) {
+		t.Errorf("empty summary is %q", got)
+	}
+}
@@ -0,0 +1,172 @@
+package line
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/BurntSushi/toml"
+)
+
+// SensorCalibration is the calibration of one sensor
+type SensorCalibration struct {
+	Name string
+	// Line has the darkest reading of each channel
+	Line RGB
+	// Background has the brightest reading of each channel
+	Background RGB
+	// Noise has the spread of each channel while looking at the background
+	Noise RGB
+	// Threshold is the weight above which the sensor sees the line
+	Threshold float64
+}
+
+// Calibration holds the calibration of all the sensors, in the order of the estimator
+type Calibration struct {
+	Sensors []SensorCalibration
+}
+
+// MinThreshold and MaxThreshold limit the computed thresholds
+const MinThreshold = 0.05
+const MaxThreshold = 0.5
+
+// NoiseMargin multiplies the background noise to get the threshold
+const NoiseMargin = 3
+
+// Recorder collects readings while the robot looks at the background and
+// then sweeps its sensors across the line
+type Recorder struct {
+	names   []string
+	min     []RGB
+	max     []RGB
+	noiseLo []RGB
+	noiseHi []RGB
+	samples int
+	still   int
+}
+
+// NewRecorder returns a recorder for the given sensors
+func NewRecorder(sensors []Sensor) *Recorder {
+	r := &Recorder{}
+	for _, s := range sensors {
+		r.names = append(r.names, s.Name)
+	}
+	n := len(sensors)
+	r.min, r.max = make([]RGB, n), make([]RGB, n)
+	r.noiseLo, r.noiseHi = make([]RGB, n), make([]RGB, n)
+	return r
+}
+
+func lower(a RGB, b RGB) RGB {
+	return RGB{minInt(a.R, b.R), minInt(a.G, b.G), minInt(a.B, b.B)}
+}
+
+func higher(a RGB, b RGB) RGB {
+	return RGB{maxInt(a.R, b.R), maxInt(a.G, b.G), maxInt(a.B, b.B)}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// AddStill records readings taken on the background with the robot still (to measure noise)
+func (r *Recorder) AddStill(readings []RGB) {
+	for i, c := range readings {
+		if r.still == 0 {
+			r.noiseLo[i], r.noiseHi[i] = c, c
+		} else {
+			r.noiseLo[i], r.noiseHi[i] = lower(r.noiseLo[i], c), higher(r.noiseHi[i], c)
+		}
+	}
+	r.still++
+	r.Add(readings)
+}
+
+// Add records readings taken while sweeping
+func (r *Recorder) Add(readings []RGB) {
+	for i, c := range readings {
+		if r.samples == 0 {
+			r.min[i], r.max[i] = c, c
+		} else {
+			r.min[i], r.max[i] = lower(r.min[i], c), higher(r.max[i], c)
+		}
+	}
+	r.samples++
+}
+
+// Calibration computes the calibration from the recorded readings, it fails
+// when a sensor has not seen enough contrast (it did not cross the line)
+func (r *Recorder) Calibration(minContrast int) (Calibration, error) {
+	result := Calibration{}
+	if r.samples == 0 {
+		return result, fmt.Errorf("no readings recorded")
+	}
+	for i, name := range r.names {
+		s := SensorCalibration{
+			Name:       name,
+			Line:       r.min[i],
+			Background: r.max[i],
+		}
+		if r.still > 0 {
+			s.Noise = RGB{
+				r.noiseHi[i].R - r.noiseLo[i].R,
+				r.noiseHi[i].G - r.noiseLo[i].G,
+				r.noiseHi[i].B - r.noiseLo[i].B,
+			}
+		}
+		span := s.Background.Intensity() - s.Line.Intensity()
+		if span < minContrast {
+			return result, fmt.Errorf("sensor %s: contrast %d is below %d", name, span, minContrast)
+		}
+		threshold := float64(s.Noise.Intensity()*NoiseMargin) / float64(span)
+		if threshold < MinThreshold {
+			threshold = MinThreshold
+		}
+		if threshold > MaxThreshold {
+			threshold = MaxThreshold
+		}
+		s.Threshold = threshold
+		result.Sensors = append(result.Sensors, s)
+	}
+	return result, nil
+}
+
+// Apply copies the calibration into the sensors with the same names
+func (c *Calibration) Apply(sensors []Sensor) {
+	for _, sc := range c.Sensors {
+		for i := range sensors {
+			if sensors[i].Name == sc.Name {
+				sensors[i].Line = sc.Line
+				sensors[i].Background = sc.Background
+				sensors[i].Threshold = sc.Threshold
+			}
+		}
+	}
+}
+
+// Save writes the calibration to a TOML file
+func (c *Calibration) Save(fileName string) error {
+	var buf bytes.Buffer
+	err := toml.NewEncoder(&buf).Encode(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, buf.Bytes(), 0644)
+}
+
+// LoadCalibration reads a calibration from a TOML file
+func LoadCalibration(fileName string) (Calibration, error) {
+	result := Calibration{}
+	_, err := toml.DecodeFile(fileName, &result)
+	return result, err
+}
@@ -0,0 +1,218 @@
+package line
+
+// RGB is a raw reading of a color sensor
+type RGB struct {
+	R int
+	G int
+	B int
+}
+
+// Intensity is the sum of the channels
+func (c RGB) Intensity() int {
+	return c.R + c.G + c.B
+}
+
+// Color returns "red", "green" or "blue" when that channel is at least min
+// and ratioPC percent of each of the others, "" otherwise
+func (c RGB) Color(ratioPC int, min int) string {
+	dominates := func(v int, o1 int, o2 int) bool {
+		return v >= min && v*100 >= o1*ratioPC && v*100 >= o2*ratioPC
+	}
+	if dominates(c.R, c.G, c.B) {
+		return "red"
+	}
+	if dominates(c.G, c.R, c.B) {
+		return "green"
+	}
+	if dominates(c.B, c.R, c.G) {
+		return "blue"
+	}
+	return ""
+}
+
+// Sensor describes a color sensor: where it is and how its readings are scaled.
+// Positions are relative to the center of the sensors, X grows to the right
+// and Y forward
+type Sensor struct {
+	Name string
+	X    int
+	Y    int
+	// Min is the intensity on the line (darker readings count as on the line)
+	Min int
+	// Span is the intensity range from the line to the background
+	Span int
+	// Line and Background are the calibrated readings, when set they replace Min and Span
+	Line       RGB
+	Background RGB
+	// Threshold is the weight above which the sensor sees the line (0 to use the estimator one)
+	Threshold float64
+}
+
+// Calibrated tells if the sensor has per channel calibration
+func (s *Sensor) Calibrated() bool {
+	return s.Background != RGB{}
+}
+
+func channelWeight(value int, line int, background int) float64 {
+	if background <= line {
+		return 0
+	}
+	w := float64(background-value) / float64(background-line)
+	if w < 0 {
+		return 0
+	}
+	if w > 1 {
+		return 1
+	}
+	return w
+}
+
+// Weight returns how much the sensor sees the line, from 0 (background) to 1 (line)
+func (s *Sensor) Weight(c RGB) float64 {
+	if s.Calibrated() {
+		return (channelWeight(c.R, s.Line.R, s.Background.R) +
+			channelWeight(c.G, s.Line.G, s.Background.G) +
+			channelWeight(c.B, s.Line.B, s.Background.B)) / 3
+	}
+
+	value := c.Intensity() - s.Min
+	if value < 0 {
+		value = 0
+	}
+	if value > s.Span {
+		value = s.Span
+	}
+	return 1 - float64(value)/float64(s.Span)
+}
+
+// Sensor indexes in the diamond layout
+const (
+	Front = iota
+	Left
+	Right
+	Back
+)
+
+// Diamond returns greyhound's layout: front and back on the center line, left
+// and right radius*2 to the sides, with the same scaling for all the sensors
+func Diamond(radius int, min int, span int) []Sensor {
+	return []Sensor{
+		{Name: "F", X: 0, Y: radius, Min: min, Span: span},
+		{Name: "L", X: -radius * 2, Y: 0, Min: min, Span: span},
+		{Name: "R", X: radius * 2, Y: 0, Min: min, Span: span},
+		{Name: "B", X: 0, Y: -radius, Min: min, Span: span},
+	}
+}
+
+// Estimate is the result of an estimation
+type Estimate struct {
+	// Pos is the position of the line (same unit and direction as the sensor X)
+	Pos int
+	// Confidence goes from 0 (no sensor sees the line) to 1
+	Confidence float64
+	// Cross is true when sensors on both sides see the line (a crossing line)
+	Cross bool
+	// Lost is true when no sensor sees the line, Pos is then meaningless
+	Lost bool
+	// Weights are the weights of each sensor
+	Weights []float64
+}
+
+// Estimator combines the readings of all the sensors into a line position
+type Estimator struct {
+	Sensors []Sensor
+	// Threshold is the weight above which a sensor sees the line
+	Threshold float64
+	// Radius is how far beyond an outer sensor the line can be estimated
+	Radius int
+}
+
+// New returns an estimator for the given sensors
+func New(sensors []Sensor, radius int) *Estimator {
+	return &Estimator{
+		Sensors:   sensors,
+		Threshold: 0.1,
+		Radius:    radius,
+	}
+}
+
+// Estimate computes the line position from one reading per sensor (in the order of Sensors)
+func (e *Estimator) Estimate(readings []RGB) Estimate {
+	result := Estimate{Weights: make([]float64, len(e.Sensors))}
+
+	total, weighted := 0.0, 0.0
+	seeing := 0
+	minX, maxX := 0, 0
+	leftSees, rightSees := false, false
+	for i := range e.Sensors {
+		s := &e.Sensors[i]
+		w := s.Weight(readings[i])
+		result.Weights[i] = w
+		if w > result.Confidence {
+			result.Confidence = w
+		}
+		if w < e.threshold(s) {
+			continue
+		}
+		seeing++
+		total += w
+		weighted += w * float64(s.X)
+		if s.X < minX {
+			minX = s.X
+		}
+		if s.X > maxX {
+			maxX = s.X
+		}
+		if s.X < 0 {
+			leftSees = true
+		} else if s.X > 0 {
+			rightSees = true
+		}
+	}
+
+	if seeing == 0 {
+		result.Lost = true
+		return result
+	}
+	result.Pos = int(weighted / total)
+
+	if leftSees && rightSees {
+		result.Cross = true
+		result.Pos = 0
+		return result
+	}
+
+	// When only the outermost sensor on a side sees the line, the line is
+	// beyond it: the fainter the reading the farther away
+	if seeing == 1 && (minX < 0 || maxX > 0) {
+		if minX < 0 {
+			result.Pos = minX - int((1-total)*float64(e.Radius))
+		} else {
+			result.Pos = maxX + int((1-total)*float64(e.Radius))
+		}
+	}
+	return result
+}
+
+func (e *Estimator) threshold(s *Sensor) float64 {
+	if s.Threshold > 0 {
+		return s.Threshold
+	}
+	return e.Threshold
+}
+
+// Sees tells if sensor i sees the line in an estimate
+func (e *Estimator) Sees(est Estimate, i int) bool {
+	return est.Weights[i] >= e.threshold(&e.Sensors[i])
+}
+
+// Code is a bit mask of the sensors that see the line, bit i for sensor i
+func (e *Estimator) Code(est Estimate) int {
+	code := 0
+	for i := range est.Weights {
+		if e.Sees(est, i) {
+			code |= 1 << uint(i)
+		}
+	}
+	return code
+}
@@ -0,0 +1,302 @@
+package line
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math"
+	"os"
+	fp "path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Readings of a sensor on the line, on the background and across the line edge
+var (
+	lineRGB       = RGB{20, 24, 18}
+	backgroundRGB = RGB{180, 192, 170}
+	// halfRGB is a sensor half over the line edge
+	halfRGB = RGB{100, 108, 94}
+	// quarterRGB is a sensor at the edge of the line
+	quarterRGB = RGB{140, 150, 132}
+	// dimRGB is a sensor near the line, seeing it below the threshold
+	dimRGB = RGB{172, 184, 162}
+)
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
+
+// calibrated returns the diamond calibrated on lineRGB and backgroundRGB
+func calibrated() []Sensor {
+	sensors := Diamond(100, 0, 1)
+	for i := range sensors {
+		sensors[i].Line = lineRGB
+		sensors[i].Background = backgroundRGB
+	}
+	return sensors
+}
+
+func TestSensorWeight(t *testing.T) {
+	raw := Sensor{Min: 100, Span: 500}
+	cal := Sensor{Line: lineRGB, Background: backgroundRGB}
+	flat := Sensor{Line: RGB{20, 100, 18}, Background: RGB{180, 100, 170}}
+
+	tests := []struct {
+		name     string
+		s        Sensor
+		c        RGB
+		expected float64
+	}{
+		{"raw line", raw, RGB{40, 30, 30}, 1},
+		{"raw darker than the line", raw, RGB{10, 10, 10}, 1},
+		{"raw background", raw, RGB{200, 200, 200}, 0},
+		{"raw brighter than the background", raw, RGB{300, 300, 300}, 0},
+		{"raw half", raw, RGB{100, 150, 100}, 0.5},
+		{"calibrated line", cal, lineRGB, 1},
+		{"calibrated background", cal, backgroundRGB, 0},
+		{"calibrated half", cal, halfRGB, 0.5},
+		{"calibrated quarter", cal, quarterRGB, 0.25},
+		{"calibrated darker than the line", cal, RGB{0, 0, 0}, 1},
+		{"calibrated brighter than the background", cal, RGB{255, 255, 255}, 0},
+		{"channel without contrast", flat, RGB{20, 100, 18}, 2.0 / 3},
+	}
+	for _, test := range tests {
+		if w := test.s.Weight(test.c); !near(w, test.expected) {
+			t.Errorf("%s: weight %v, expected %v", test.name, w, test.expected)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	bg := backgroundRGB
+	tests := []struct {
+		name string
+		// readings are front, left, right and back
+		readings   []RGB
+		pos        int
+		confidence float64
+		cross      bool
+		lost       bool
+		code       int
+	}{
+		{"centred", []RGB{lineRGB, bg, bg, lineRGB}, 0, 1, false, false, 1<<Front | 1<<Back},
+		{"left", []RGB{halfRGB, halfRGB, bg, bg}, -100, 0.5, false, false, 1<<Front | 1<<Left},
+		{"right", []RGB{halfRGB, bg, halfRGB, bg}, 100, 0.5, false, false, 1<<Front | 1<<Right},
+		{"under the left sensor", []RGB{bg, lineRGB, bg, bg}, -200, 1, false, false, 1 << Left},
+		{"beyond the left sensor", []RGB{bg, quarterRGB, bg, bg}, -275, 0.25, false, false, 1 << Left},
+		{"beyond the right sensor", []RGB{bg, bg, halfRGB, bg}, 250, 0.5, false, false, 1 << Right},
+		{"cross", []RGB{lineRGB, lineRGB, lineRGB, lineRGB}, 0, 1, true, false, 15},
+		{"cross off centre", []RGB{bg, halfRGB, lineRGB, bg}, 0, 1, true, false, 1<<Left | 1<<Right},
+		{"lost", []RGB{bg, bg, bg, bg}, 0, 0, false, true, 0},
+		{"lost near the line", []RGB{dimRGB, bg, bg, bg}, 0, 0.05, false, true, 0},
+	}
+	for _, test := range tests {
+		e := New(calibrated(), 100)
+		est := e.Estimate(test.readings)
+		if est.Pos != test.pos || !near(est.Confidence, test.confidence) || est.Cross != test.cross || est.Lost != test.lost {
+			t.Errorf("%s: pos %d confidence %.3f cross %v lost %v, expected pos %d confidence %.3f cross %v lost %v",
+				test.name, est.Pos, est.Confidence, est.Cross, est.Lost, test.pos, test.confidence, test.cross, test.lost)
+		}
+		if code := e.Code(est); code != test.code {
+			t.Errorf("%s: code %04b, expected %04b", test.name, code, test.code)
+		}
+	}
+}
+
+func TestEstimateSensorThreshold(t *testing.T) {
+	// A calibrated threshold above the reading loses the line, the
+	// confidence still tells how close it is
+	sensors := calibrated()
+	sensors[Left].Threshold = 0.3
+	e := New(sensors, 100)
+	est := e.Estimate([]RGB{backgroundRGB, quarterRGB, backgroundRGB, backgroundRGB})
+	if !est.Lost || !near(est.Confidence, 0.25) {
+		t.Errorf("lost %v confidence %.3f, expected lost with confidence 0.25", est.Lost, est.Confidence)
+	}
+
+	sensors[Left].Threshold = 0.2
+	est = e.Estimate([]RGB{backgroundRGB, quarterRGB, backgroundRGB, backgroundRGB})
+	if est.Lost || est.Pos != -275 {
+		t.Errorf("lost %v pos %d, expected the line beyond the left sensor", est.Lost, est.Pos)
+	}
+}
+
+// sample is a recorded reading of all the sensors
+type sample struct {
+	// still is true for the readings taken on the background before the sweep
+	still bool
+	// offset is where the line is from the middle of the sensors, in mm
+	offset   int
+	readings []RGB
+}
+
+// Geometry of the recording, in mm
+const (
+	sideSensorMM = 24
+	lineHalfMM   = 10
+	spotMM       = 4
+)
+
+// loadRecording reads the sensor readings of testdata/sweep.txt
+func loadRecording(t *testing.T) []sample {
+	f, err := os.Open(fp.Join("testdata", "sweep.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	result := []sample{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		values := []int{}
+		for _, field := range fields[1:] {
+			v, err := strconv.Atoi(field)
+			if err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+			values = append(values, v)
+		}
+		if len(values) != 13 {
+			t.Fatalf("%q: expected an offset and 4 RGB readings", line)
+		}
+		s := sample{still: fields[0] == "still", offset: values[0]}
+		for i := 1; i < len(values); i += 3 {
+			s.readings = append(s.readings, RGB{values[i], values[i+1], values[i+2]})
+		}
+		result = append(result, s)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// recordedCalibration calibrates greyhound's sensors on the recording
+func recordedCalibration(samples []sample) (Calibration, error) {
+	r := NewRecorder(Diamond(100, 80, 700))
+	for _, s := range samples {
+		if s.still {
+			r.AddStill(s.readings)
+		} else {
+			r.Add(s.readings)
+		}
+	}
+	return r.Calibration(100)
+}
+
+func TestCalibrationOfRecording(t *testing.T) {
+	samples := loadRecording(t)
+	c, err := recordedCalibration(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SensorCalibration{
+		{"F", RGB{20, 21, 20}, RGB{270, 288, 248}, RGB{15, 5, 12}, 32 * NoiseMargin / 745.0},
+		{"L", RGB{21, 27, 22}, RGB{269, 288, 248}, RGB{18, 12, 10}, 40 * NoiseMargin / 735.0},
+		{"R", RGB{21, 22, 19}, RGB{271, 286, 250}, RGB{19, 10, 13}, 42 * NoiseMargin / 745.0},
+		{"B", RGB{21, 24, 17}, RGB{268, 293, 250}, RGB{14, 9, 12}, 35 * NoiseMargin / 749.0},
+	}
+	if len(c.Sensors) != len(expected) {
+		t.Fatalf("%d sensors calibrated, expected %d", len(c.Sensors), len(expected))
+	}
+	for i, s := range c.Sensors {
+		e := expected[i]
+		if s.Name != e.Name || s.Line != e.Line || s.Background != e.Background || s.Noise != e.Noise || !near(s.Threshold, e.Threshold) {
+			t.Errorf("sensor %d: %+v, expected %+v", i, s, e)
+		}
+	}
+
+	// The readings on the background alone do not show the line
+	r := NewRecorder(Diamond(100, 80, 700))
+	for _, s := range samples {
+		if s.still {
+			r.AddStill(s.readings)
+		}
+	}
+	if _, err := r.Calibration(100); err == nil {
+		t.Error("calibrated without crossing the line")
+	}
+
+	dir, err := ioutil.TempDir("", "greyhound-line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := fp.Join(dir, "calibration.toml")
+	err = c.Save(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCalibration(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range loaded.Sensors {
+		if s != c.Sensors[i] {
+			t.Errorf("sensor %d loaded as %+v, saved %+v", i, s, c.Sensors[i])
+		}
+	}
+}
+
+func TestEstimateRecording(t *testing.T) {
+	samples := loadRecording(t)
+	c, err := recordedCalibration(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calibratedSensors := Diamond(100, 80, 700)
+	c.Apply(calibratedSensors)
+	estimators := map[string]*Estimator{
+		"raw":        New(Diamond(100, 80, 700), 100),
+		"calibrated": New(calibratedSensors, 100),
+	}
+	// Where each sensor is across the line, in mm
+	sensorMM := []int{0, -sideSensorMM, sideSensorMM, 0}
+
+	for name, e := range estimators {
+		for _, s := range samples {
+			est := e.Estimate(s.readings)
+			if est.Cross {
+				t.Errorf("%s %d mm: a single line seen as a crossing", name, s.offset)
+			}
+			for i, x := range sensorMM {
+				d := s.offset - x
+				if d < 0 {
+					d = -d
+				}
+				// The spot of the sensor is all on the line, or all off it
+				if d <= lineHalfMM-spotMM && est.Weights[i] < 0.9 {
+					t.Errorf("%s %d mm: sensor %d weight %.2f on the line", name, s.offset, i, est.Weights[i])
+				}
+				if d >= lineHalfMM+spotMM && e.Sees(est, i) {
+					t.Errorf("%s %d mm: sensor %d sees the line %d mm away", name, s.offset, i, d)
+				}
+			}
+
+			beyond := s.offset
+			if beyond < 0 {
+				beyond = -beyond
+			}
+			beyond -= sideSensorMM + lineHalfMM + spotMM
+			if est.Lost != (beyond >= 0) {
+				t.Errorf("%s %d mm: lost %v", name, s.offset, est.Lost)
+			}
+			if est.Lost {
+				continue
+			}
+			// Positions are in SensorRadius units, the side sensors are 2 from the middle
+			posMM := est.Pos * sideSensorMM / 200
+			if math.Abs(float64(posMM-s.offset)) > lineHalfMM+spotMM {
+				t.Errorf("%s %d mm: line estimated at %d mm", name, s.offset, posMM)
+			}
+			if posMM*s.offset < 0 {
+				t.Errorf("%s %d mm: line estimated on the other side, at %d mm", name, s.offset, posMM)
+			}
+		}
+	}
+}
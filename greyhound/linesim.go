@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"go-bots/clock"
+	"go-bots/cmdline"
+	"go-bots/ev3"
+	"go-bots/sim"
+	"go-bots/sim/linetrack"
+	"go-bots/sim/replay"
+	"math"
+	"time"
+)
+
+// lineSim replaces the sensors, motors, buttons and clock with a robot
+// running on a simulated track
+type lineSim struct {
+	bot   *bot
+	clock *clock.Manual
+	world *linetrack.World
+	scene linetrack.Scene
+	// sensors are the attributes read by each sensor of the body
+	sensors []*ev3.Attribute
+
+	record     *replay.Run
+	recordFile string
+
+	stopped   bool
+	offTrack  bool
+	distance  float64
+	offsetSum float64
+	maxOffset float64
+	steps     int
+}
+
+// initializeSim opens the track file and sets up the simulated devices
+// instead of the real ones, the run is recorded to recordFile (when set)
+func (b *bot) initializeSim(trackFile string, recordFile string) {
+	scene, err := linetrack.FromFile(trackFile)
+	if err != nil {
+		ev3.Fatalln("Error reading track", trackFile+":", err)
+	}
+	world, err := scene.NewWorld()
+	if err != nil {
+		ev3.Fatalln("Error building track", trackFile+":", err)
+	}
+	s := &lineSim{bot: b, clock: clock.NewManual(time.Time{}), world: world, scene: scene}
+	if recordFile != "" {
+		err := replay.CheckFormat(recordFile)
+		if err != nil {
+			ev3.Fatalln("Error:", err)
+		}
+		s.record = &replay.Run{Title: "greyhound " + trackFile, Floor: world.Map.Floor()}
+		s.recordFile = recordFile
+	}
+
+	b.buttons = &ev3.Buttons{}
+	b.cF, b.cL, b.cR, b.cB = &ev3.Attribute{}, &ev3.Attribute{}, &ev3.Attribute{}, &ev3.Attribute{}
+	b.motorL1, b.motorL2, b.motorR1, b.motorR2 = &ev3.Attribute{}, &ev3.Attribute{}, &ev3.Attribute{}, &ev3.Attribute{}
+	attributes := map[string]*ev3.Attribute{"F": b.cF, "L": b.cL, "R": b.cR, "B": b.cB}
+	for _, sensor := range scene.Body.Sensors {
+		a, ok := attributes[sensor.Name]
+		if !ok {
+			ev3.Fatalln("Error: unknown sensor", sensor.Name, "in", trackFile, "(must be F, L, R or B)")
+		}
+		s.sensors = append(s.sensors, a)
+	}
+	b.clock = s.clock
+	b.simulation = s
+	print("simulating track", trackFile)
+}
+
+// read sets the bin_data triples of the sensors, like their Sync would
+func (s *lineSim) read() {
+	for i, a := range s.sensors {
+		c := s.world.Read(i)
+		a.Value, a.Value1, a.Value2 = c.R, c.G, c.B
+	}
+}
+
+// step applies the motor duty cycles (the right motors are mounted the other
+// way round) and advances the simulation and its clock by one loop, when the
+// run is over ENTER is pressed to stop the robot
+func (s *lineSim) step() {
+	w := s.world
+	before := w.Pose.Pos
+	w.SetDuty(s.bot.motorL1.Value, s.bot.motorL2.Value, -s.bot.motorR1.Value, -s.bot.motorR2.Value)
+	w.Step(s.scene.StepMillis)
+	s.clock.Advance(time.Duration(s.scene.StepMillis) * time.Millisecond)
+	s.distance += w.Pose.Pos.Sub(before).Len()
+
+	offset := w.Offset(s.scene.LostMM)
+	s.offsetSum += offset
+	s.maxOffset = math.Max(s.maxOffset, offset)
+	s.steps++
+	if s.record != nil {
+		s.record.Add(replay.Frame{Millis: w.Millis, Robots: []replay.Robot{s.robot()}})
+	}
+	if cmdline.Tracing() {
+		cmdline.Trace("track", w.Millis, int(w.Pose.Pos.X), int(w.Pose.Pos.Y), int(sim.Degrees(w.Pose.Heading)), int(offset))
+	}
+
+	if s.stopped {
+		return
+	}
+	if offset >= s.scene.LostMM {
+		print("simulation: off the track at", w.Millis, "ms")
+		s.offTrack = true
+		s.stop()
+	} else if w.Millis >= s.scene.MaxMillis {
+		print("simulation: time is up")
+		s.stop()
+	}
+}
+
+// robot returns the robot for the recording, with the sensors that see the line
+func (s *lineSim) robot() replay.Robot {
+	rb := s.world.Robot("greyhound")
+	rb.State = "waiting"
+	if s.bot.estimator == nil || len(rb.Rays) != len(s.bot.estimator.Sensors) {
+		return rb
+	}
+	est := s.bot.estimator.Estimate(s.bot.readings())
+	for i := range rb.Rays {
+		rb.Rays[i].Hit = s.bot.estimator.Sees(est, i)
+	}
+	rb.State = s.bot.lineStatus(est)
+	return rb
+}
+
+func (s *lineSim) stop() {
+	s.stopped = true
+	s.bot.buttons.Enter = true
+}
+
+// summary prints how the run went on stdout, the offset is the distance of the middle
+// of the sensors from the line
+func (s *lineSim) summary() {
+	mean := 0.0
+	if s.steps > 0 {
+		mean = s.offsetSum / float64(s.steps)
+	}
+	fmt.Println("millis", s.world.Millis, "distance", int(s.distance), "mean-offset", int(mean), "max-offset", int(s.maxOffset), "off-track", s.offTrack)
+	if s.record != nil {
+		err := replay.Save(s.recordFile, s.record)
+		if err != nil {
+			printError("Error saving the recording:", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package marker
+
+import "go-bots/greyhound/line"
+
+// Marker colors
+const (
+	Red   = "red"
+	Green = "green"
+	Blue  = "blue"
+)
+
+// Colors lists the marker colors
+var Colors = []string{Red, Green, Blue}
+
+// IsColor tells if a name is a marker color
+func IsColor(name string) bool {
+	for _, c := range Colors {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Actions taken when a marker is seen
+const (
+	// None ignores the marker
+	None = "none"
+	// Stop ends the run
+	Stop = "stop"
+	// Slow limits the speed for a while (a slow zone)
+	Slow = "slow"
+	// Turn turns at the next crossing (a junction)
+	Turn = "turn"
+	// Lap ends a lap (when laps are detected with markers)
+	Lap = "lap"
+)
+
+// Classify returns the marker color under each sensor ("" where there is none)
+func Classify(readings []line.RGB, ratioPC int, min int) []string {
+	result := make([]string, len(readings))
+	for i, c := range readings {
+		result[i] = c.Color(ratioPC, min)
+	}
+	return result
+}
+
+// Detector reports markers when a sensor starts seeing them
+type Detector struct {
+	// RatioPC and Min classify the readings (see line.RGB.Color)
+	RatioPC int
+	Min     int
+	// MinMillis ignores a color for a while after it has been reported,
+	// so that a marker passing under several sensors counts once
+	MinMillis int
+
+	seen     map[string]bool
+	reported map[string]int
+}
+
+// Update processes readings taken at now (in milliseconds), it returns the
+// colors that have just appeared under the sensors
+func (d *Detector) Update(now int, readings []line.RGB) []string {
+	if d.seen == nil {
+		d.seen = map[string]bool{}
+		d.reported = map[string]int{}
+	}
+	current := map[string]bool{}
+	for _, c := range Classify(readings, d.RatioPC, d.Min) {
+		if c != "" {
+			current[c] = true
+		}
+	}
+	result := []string{}
+	for _, c := range Colors {
+		last, wasReported := d.reported[c]
+		if current[c] && !d.seen[c] && (!wasReported || now-last >= d.MinMillis) {
+			d.reported[c] = now
+			result = append(result, c)
+		}
+	}
+	d.seen = current
+	return result
+}
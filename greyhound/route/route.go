@@ -0,0 +1,56 @@
+package route
+
+import "fmt"
+
+// Action is what to do at a crossing
+type Action string
+
+// Actions at crossings
+const (
+	Straight Action = "straight"
+	Left     Action = "left"
+	Right    Action = "right"
+	// Stop ends the run at the crossing
+	Stop Action = "stop"
+)
+
+// Route is a list of actions, one for each crossing met
+type Route struct {
+	actions []Action
+	next    int
+}
+
+// Parse checks the steps of a route
+func Parse(steps []string) (Route, error) {
+	result := Route{}
+	for i, step := range steps {
+		a := Action(step)
+		switch a {
+		case Straight, Left, Right, Stop:
+			result.actions = append(result.actions, a)
+		default:
+			return Route{}, fmt.Errorf("step %d: unknown action %q (must be straight, left, right or stop)", i+1, step)
+		}
+	}
+	return result, nil
+}
+
+// Active tells if there is a route to follow
+func (r *Route) Active() bool {
+	return len(r.actions) > 0
+}
+
+// Next returns the action for the next crossing, Stop after the last step
+func (r *Route) Next() Action {
+	if r.next >= len(r.actions) {
+		return Stop
+	}
+	a := r.actions[r.next]
+	r.next++
+	return a
+}
+
+// Crossing returns the number of crossings met so far
+func (r *Route) Crossing() int {
+	return r.next
+}
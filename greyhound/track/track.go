@@ -0,0 +1,120 @@
+package track
+
+import "math"
+
+// Distances along the track are measured in speed * milliseconds (the
+// commanded speed integrated over time), the robot has no odometry
+
+// Recorder builds a curvature map while driving a lap
+type Recorder struct {
+	// SegmentLength is the length of a segment of the map
+	SegmentLength int
+
+	sums   []float64
+	counts []int
+}
+
+// NewRecorder returns a recorder with the given segment length
+func NewRecorder(segmentLength int) *Recorder {
+	return &Recorder{SegmentLength: segmentLength}
+}
+
+// Add records the steering (as a fraction of the speed) at a distance from the start of the lap
+func (r *Recorder) Add(distance int, steering float64) {
+	i := distance / r.SegmentLength
+	for len(r.sums) <= i {
+		r.sums = append(r.sums, 0)
+		r.counts = append(r.counts, 0)
+	}
+	r.sums[i] += math.Abs(steering)
+	r.counts[i]++
+}
+
+// Map is the average steering of each segment of a lap
+type Map []float64
+
+// Map returns the recorded curvature map, segments without samples take the previous value
+func (r *Recorder) Map() Map {
+	result := make(Map, len(r.sums))
+	last := 0.0
+	for i := range r.sums {
+		if r.counts[i] > 0 {
+			last = r.sums[i] / float64(r.counts[i])
+		}
+		result[i] = last
+	}
+	return result
+}
+
+// Params shape the speed profile
+type Params struct {
+	// StraightSpeed is used where the robot does not steer
+	StraightSpeed int
+	// CurveSpeed is used where the steering reaches CurveSteering
+	CurveSpeed    int
+	CurveSteering float64
+	// Accel is the speed change per millisecond the motors can do
+	Accel float64
+	// Lookahead is how many segments before a curve the robot starts braking for it
+	Lookahead int
+}
+
+// Profile is the planned speed of each segment of a lap
+type Profile struct {
+	SegmentLength int
+	Speeds        []int
+}
+
+// Plan computes a speed profile from a curvature map: each segment gets a
+// speed from its curvature, then the speeds are lowered so that the robot
+// can brake in time before curves and accelerate after them
+func Plan(m Map, segmentLength int, p Params) Profile {
+	n := len(m)
+	speeds := make([]float64, n)
+	for i, k := range m {
+		t := 1.0
+		if p.CurveSteering > 0 {
+			t = 1 - math.Min(k/p.CurveSteering, 1)
+		}
+		speeds[i] = float64(p.CurveSpeed) + float64(p.StraightSpeed-p.CurveSpeed)*t
+	}
+
+	// Start braking early for the curves ahead
+	target := make([]float64, n)
+	for i := range speeds {
+		target[i] = speeds[i]
+		for j := 1; j <= p.Lookahead && i+j < n; j++ {
+			target[i] = math.Min(target[i], speeds[i+j])
+		}
+	}
+
+	// v^2 = v0^2 + 2 * a * distance, going backwards for braking and forwards for acceleration
+	reach := 2 * p.Accel * float64(segmentLength)
+	for pass := 0; pass < 2; pass++ {
+		for i := n - 2; i >= 0; i-- {
+			target[i] = math.Min(target[i], math.Sqrt(target[i+1]*target[i+1]+reach))
+		}
+		for i := 1; i < n; i++ {
+			target[i] = math.Min(target[i], math.Sqrt(target[i-1]*target[i-1]+reach))
+		}
+	}
+
+	result := Profile{SegmentLength: segmentLength, Speeds: make([]int, n)}
+	for i, v := range target {
+		result.Speeds[i] = int(v)
+	}
+	return result
+}
+
+// SpeedAt returns the planned speed at a distance from the start of the lap,
+// fallback when the profile is empty
+func (p Profile) SpeedAt(distance int, fallback int) int {
+	if len(p.Speeds) == 0 {
+		return fallback
+	}
+	i := distance / p.SegmentLength
+	if i >= len(p.Speeds) {
+		i = len(p.Speeds) - 1
+	}
+	return p.Speeds[i]
+}
@@ -0,0 +1,162 @@
+package pid
+
+import (
+	"math"
+	"sort"
+)
+
+// Gains are the coefficients of a controller
+type Gains struct {
+	KP float64
+	KI float64
+	KD float64
+}
+
+// Point gives the gains to use when the scheduling variable is At
+type Point struct {
+	At    float64
+	Gains Gains
+}
+
+// Schedule picks gains from a scheduling variable (like speed), interpolating
+// linearly between its points and keeping the first and last gains outside them
+type Schedule []Point
+
+// Gains returns the gains for the scheduling variable at
+func (s Schedule) Gains(at float64) Gains {
+	if len(s) == 0 {
+		return Gains{}
+	}
+	i := sort.Search(len(s), func(i int) bool { return s[i].At >= at })
+	if i == 0 {
+		return s[0].Gains
+	}
+	if i == len(s) {
+		return s[len(s)-1].Gains
+	}
+	a, b := s[i-1], s[i]
+	t := (at - a.At) / (b.At - a.At)
+	return Gains{
+		KP: a.Gains.KP + (b.Gains.KP-a.Gains.KP)*t,
+		KI: a.Gains.KI + (b.Gains.KI-a.Gains.KI)*t,
+		KD: a.Gains.KD + (b.Gains.KD-a.Gains.KD)*t,
+	}
+}
+
+// Terms are the contributions of each term to the last output
+type Terms struct {
+	P float64
+	I float64
+	D float64
+}
+
+// Controller is a PID controller.
+// The derivative is computed on the measurement (so that setpoint changes
+// do not kick the output) and low-pass filtered, the integral term is
+// clamped and stops growing while the output is saturated (anti-windup)
+type Controller struct {
+	Gains
+	// Schedule, when not empty, replaces Gains on every call to ScheduleAt
+	Schedule Schedule
+	// OutMin and OutMax limit the output (both 0 means no limit)
+	OutMin float64
+	OutMax float64
+	// IntegralLimit limits the contribution of the integral term (0 means no limit)
+	IntegralLimit float64
+	// DFilter is the time constant of the low-pass filter on the derivative
+	// (in the same unit as dt), 0 means no filter
+	DFilter float64
+
+	integral        float64
+	derivative      float64
+	lastMeasurement float64
+	started         bool
+	terms           Terms
+}
+
+// New returns a controller with the given gains and output limits
+func New(kp float64, ki float64, kd float64, outMin float64, outMax float64) *Controller {
+	return &Controller{
+		Gains:  Gains{kp, ki, kd},
+		OutMin: outMin,
+		OutMax: outMax,
+	}
+}
+
+// ScheduleAt sets the gains from the schedule, if there is one
+func (c *Controller) ScheduleAt(at float64) {
+	if len(c.Schedule) > 0 {
+		c.Gains = c.Schedule.Gains(at)
+	}
+}
+
+// Reset clears the controller state (integral and derivative)
+func (c *Controller) Reset() {
+	c.integral = 0
+	c.derivative = 0
+	c.started = false
+	c.terms = Terms{}
+}
+
+// Update computes the output for a new measurement, taken dt after the previous one
+func (c *Controller) Update(setpoint float64, measurement float64, dt float64) float64 {
+	e := setpoint - measurement
+
+	if c.started && dt > 0 {
+		raw := -(measurement - c.lastMeasurement) / dt
+		if c.DFilter > 0 {
+			alpha := dt / (c.DFilter + dt)
+			c.derivative += alpha * (raw - c.derivative)
+		} else {
+			c.derivative = raw
+		}
+	}
+	c.lastMeasurement = measurement
+	c.started = true
+
+	p := c.KP * e
+	d := c.KD * c.derivative
+
+	if dt > 0 && c.KI != 0 {
+		integral := c.integral + c.KI*e*dt
+		if c.IntegralLimit > 0 {
+			integral = clamp(integral, -c.IntegralLimit, c.IntegralLimit)
+		}
+		// Anti-windup: do not integrate further into saturation
+		unclamped := p + integral + d
+		if !(c.saturatedHigh(unclamped) && integral > c.integral) && !(c.saturatedLow(unclamped) && integral < c.integral) {
+			c.integral = integral
+		}
+	}
+
+	c.terms = Terms{p, c.integral, d}
+	return c.limit(p + c.integral + d)
+}
+
+// Terms returns the contributions of the terms to the last output
+func (c *Controller) Terms() Terms {
+	return c.terms
+}
+
+func (c *Controller) hasLimits() bool {
+	return c.OutMin != 0 || c.OutMax != 0
+}
+
+func (c *Controller) saturatedHigh(out float64) bool {
+	return c.hasLimits() && out > c.OutMax
+}
+
+func (c *Controller) saturatedLow(out float64) bool {
+	return c.hasLimits() && out < c.OutMin
+}
+
+func (c *Controller) limit(out float64) float64 {
+	if !c.hasLimits() {
+		return out
+	}
+	return clamp(out, c.OutMin, c.OutMax)
+}
+
+func clamp(v float64, min float64, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
@@ -0,0 +1,130 @@
+package pid
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestOutputLimits(t *testing.T) {
+	c := New(2, 0, 0, -1, 0.5)
+	if out := c.Update(1, 0, 0.1); out != 0.5 {
+		t.Error("output", out, "above OutMax")
+	}
+	if out := c.Update(-1, 0, 0.1); out != -1 {
+		t.Error("output", out, "below OutMin")
+	}
+	if out := c.Update(0.2, 0, 0.1); !near(out, 0.4) {
+		t.Error("output", out, "within the limits, want 0.4")
+	}
+	// No limits when both are 0
+	c = New(2, 0, 0, 0, 0)
+	if out := c.Update(10, 0, 0.1); out != 20 {
+		t.Error("output", out, "without limits, want 20")
+	}
+}
+
+func TestAntiWindup(t *testing.T) {
+	c := New(0, 1, 0, -1, 1)
+	// A long error saturates the output, the integral stops at the limit
+	for i := 0; i < 100; i++ {
+		c.Update(1, 0, 0.1)
+	}
+	if i := c.Terms().I; i > 1+1e-9 {
+		t.Error("integral wound up to", i)
+	}
+	// so the output leaves saturation as soon as the error changes sign
+	if out := c.Update(-1, 0, 0.1); out >= 1 {
+		t.Error("output", out, "still saturated after the error changed sign")
+	}
+
+	c = New(0, 1, 0, 0, 0)
+	c.IntegralLimit = 0.3
+	for i := 0; i < 100; i++ {
+		c.Update(1, 0, 0.1)
+	}
+	if out := c.Update(1, 0, 0.1); !near(out, 0.3) {
+		t.Error("output", out, "with IntegralLimit 0.3")
+	}
+}
+
+func TestDerivativeOnMeasurement(t *testing.T) {
+	c := New(0, 0, 1, 0, 0)
+	c.Update(0, 0, 0.1)
+	// A setpoint change does not kick the output
+	if out := c.Update(5, 0, 0.1); out != 0 {
+		t.Error("output", out, "after a setpoint change")
+	}
+	// A measurement change does, against the change
+	if out := c.Update(5, 1, 0.1); !near(out, -10) {
+		t.Error("output", out, "after the measurement moved 1 in 0.1, want -10")
+	}
+	c.Reset()
+	if out := c.Update(5, 3, 0.1); out != 0 {
+		t.Error("output", out, "on the first update after Reset")
+	}
+}
+
+func TestDerivativeFilter(t *testing.T) {
+	c := New(0, 0, 1, 0, 0)
+	c.DFilter = 0.3
+	c.Update(0, 0, 0.1)
+	// The filter lets through dt/(DFilter+dt) of a step, then converges on it
+	out := c.Update(0, 1, 0.1)
+	if !near(out, -10*0.25) {
+		t.Error("filtered step", out, "want", -10*0.25)
+	}
+	last := out
+	for i := 0; i < 50; i++ {
+		out = c.Update(0, 1+float64(i+1), 0.1)
+		if out > last+1e-9 {
+			t.Error("filtered derivative", out, "moved away from -10 after", last)
+		}
+		last = out
+	}
+	if math.Abs(out+10) > 1e-3 {
+		t.Error("filtered derivative", out, "did not converge to -10")
+	}
+}
+
+func TestGainSchedule(t *testing.T) {
+	s := Schedule{
+		{At: 20, Gains: Gains{KP: 1, KI: 0.5, KD: 0}},
+		{At: 40, Gains: Gains{KP: 3, KI: 0, KD: 2}},
+	}
+	for _, c := range []struct {
+		at   float64
+		want Gains
+	}{
+		{10, Gains{1, 0.5, 0}},
+		{20, Gains{1, 0.5, 0}},
+		{25, Gains{1.5, 0.375, 0.5}},
+		{40, Gains{3, 0, 2}},
+		{60, Gains{3, 0, 2}},
+	} {
+		g := s.Gains(c.at)
+		if !near(g.KP, c.want.KP) || !near(g.KI, c.want.KI) || !near(g.KD, c.want.KD) {
+			t.Errorf("gains at %v are %+v, want %+v", c.at, g, c.want)
+		}
+	}
+	if g := (Schedule{}).Gains(30); g != (Gains{}) {
+		t.Errorf("empty schedule gives %+v", g)
+	}
+
+	c := New(7, 0, 0, 0, 0)
+	c.ScheduleAt(30)
+	if c.KP != 7 {
+		t.Error("ScheduleAt without a schedule changed KP to", c.KP)
+	}
+	c.Schedule = s
+	c.ScheduleAt(30)
+	if !near(c.KP, 2) {
+		t.Error("ScheduleAt(30) set KP to", c.KP, "want 2")
+	}
+	if out := c.Update(1, 0, 0.1); !near(out, 2+0.25*0.1) {
+		t.Error("output", out, "with the scheduled gains")
+	}
+}
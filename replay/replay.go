@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-bots/sim"
+	"go-bots/sim/replay"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	t "github.com/gizak/termui"
+)
+
+func printError(data ...interface{}) {
+	fmt.Fprintln(os.Stderr, data...)
+}
+
+// tickMillis is the refresh period of the terminal playback
+const tickMillis = 50
+
+const frameEvent = "custom/frameEvent"
+
+// player plays a run back on a braille canvas
+type player struct {
+	run    *replay.Run
+	mutex  sync.Mutex
+	index  int
+	millis float64
+	speed  float64
+	paused bool
+
+	// world to canvas dots
+	min    sim.Vec
+	scale  float64
+	height int
+}
+
+// canvas draws in dots (two per terminal column, four per row)
+type canvas struct {
+	t.Canvas
+	width  int
+	height int
+}
+
+func (c *canvas) set(x int, y int) {
+	if x >= 0 && y >= 0 && x < c.width && y < c.height {
+		c.Set(x, y)
+	}
+}
+
+func (p *player) dot(v sim.Vec) (int, int) {
+	return int(math.Round((v.X - p.min.X) * p.scale)), p.height - 1 - int(math.Round((v.Y-p.min.Y)*p.scale))
+}
+
+func (p *player) line(c *canvas, a sim.Vec, b sim.Vec) {
+	x1, y1 := p.dot(a)
+	x2, y2 := p.dot(b)
+	steps := int(math.Max(math.Abs(float64(x2-x1)), math.Abs(float64(y2-y1))))
+	for i := 0; i <= steps; i++ {
+		k := 0.0
+		if steps > 0 {
+			k = float64(i) / float64(steps)
+		}
+		c.set(x1+int(math.Round(float64(x2-x1)*k)), y1+int(math.Round(float64(y2-y1)*k)))
+	}
+}
+
+func (p *player) circle(c *canvas, center sim.Vec, radius float64) {
+	n := int(math.Max(12, radius*p.scale*2*math.Pi))
+	for i := 0; i < n; i++ {
+		x, y := p.dot(center.Add(sim.FromAngle(2 * math.Pi * float64(i) / float64(n)).Scale(radius)))
+		c.set(x, y)
+	}
+}
+
+// blob marks a point with a small square
+func (p *player) blob(c *canvas, v sim.Vec, size int) {
+	x, y := p.dot(v)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			c.set(x+dx-size/2, y+dy-size/2)
+		}
+	}
+}
+
+// frame returns the frame to show, moving forward in time when playing
+func (p *player) frame(elapsed float64) replay.Frame {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	frames := p.run.Frames
+	if !p.paused {
+		p.millis += elapsed * p.speed
+	}
+	for p.index+1 < len(frames) && float64(frames[p.index+1].Millis-frames[0].Millis) <= p.millis {
+		p.index++
+	}
+	for p.index > 0 && float64(frames[p.index].Millis-frames[0].Millis) > p.millis {
+		p.index--
+	}
+	return frames[p.index]
+}
+
+func (p *player) seek(millis float64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.millis = math.Max(0, p.millis+millis)
+}
+
+func (p *player) render(elapsed float64) {
+	f := p.frame(elapsed)
+	robots := len(f.Robots)
+	width, height := t.TermWidth(), t.TermHeight()-robots-2
+	if width < 10 || height < 5 {
+		return
+	}
+	c := &canvas{Canvas: t.NewCanvas(), width: width * 2, height: height * 4}
+	min, max := p.run.Bounds()
+	extent := max.Sub(min)
+	p.min, p.height = min, c.height
+	p.scale = math.Min(float64(c.width)/extent.X, float64(c.height)/extent.Y)
+
+	for _, s := range p.run.Floor {
+		switch s.Kind {
+		case replay.Disc:
+			p.circle(c, s.Points[0], s.Radius)
+		case replay.Path:
+			for i := 1; i < len(s.Points); i++ {
+				p.line(c, s.Points[i-1], s.Points[i])
+			}
+		}
+	}
+	for _, rb := range f.Robots {
+		p.circle(c, rb.Pos, rb.Radius)
+		p.line(c, rb.Pos, rb.Pos.Add(sim.FromAngle(rb.Heading).Scale(rb.Radius)))
+		for _, ray := range rb.Rays {
+			if ray.From != ray.To {
+				if ray.Hit {
+					p.line(c, ray.From, ray.To)
+				}
+			} else if ray.Hit {
+				p.blob(c, ray.From, 3)
+			} else {
+				p.blob(c, ray.From, 1)
+			}
+		}
+		if rb.Vision != nil && rb.Vision.Intensity > 0 {
+			dir := rb.Heading - sim.Radians(float64(rb.Vision.Angle))
+			p.line(c, rb.Pos, rb.Pos.Add(sim.FromAngle(dir).Scale(rb.Radius*3)))
+		}
+	}
+
+	lines := []string{fmt.Sprintf("%s  %d.%03ds  x%g%s  (space pause, arrows seek, +/- speed, q quit)",
+		p.run.Title, f.Millis/1000, f.Millis%1000, p.speed, map[bool]string{true: " paused", false: ""}[p.paused])}
+	for _, rb := range f.Robots {
+		hits := []string{}
+		for _, ray := range rb.Rays {
+			if ray.Hit {
+				hits = append(hits, ray.Name)
+			}
+		}
+		line := fmt.Sprintf("%s: %s  sees [%s]", rb.Name, rb.State, strings.Join(hits, " "))
+		if rb.Vision != nil {
+			line += fmt.Sprintf("  vision %d° %d", rb.Vision.Angle, rb.Vision.Intensity)
+		}
+		lines = append(lines, line)
+	}
+	par := t.NewPar(strings.Join(lines, "\n"))
+	par.Border = false
+	par.X, par.Y = 0, height
+	par.Width, par.Height = width, robots+2
+
+	t.Clear()
+	t.Render(c, par)
+}
+
+// play runs the terminal playback until q is pressed
+func play(r *replay.Run, speed float64) {
+	err := t.Init()
+	if err != nil {
+		printError("Error setting up the terminal:", err)
+		os.Exit(1)
+	}
+	defer t.Close()
+
+	p := &player{run: r, speed: speed}
+	t.Handle("/sys/kbd/q", func(t.Event) {
+		t.StopLoop()
+	})
+	t.Handle("/sys/kbd/C-c", func(t.Event) {
+		t.StopLoop()
+	})
+	t.Handle("/sys/kbd/<space>", func(t.Event) {
+		p.mutex.Lock()
+		p.paused = !p.paused
+		p.mutex.Unlock()
+	})
+	t.Handle("/sys/kbd/<left>", func(t.Event) {
+		p.seek(-1000)
+	})
+	t.Handle("/sys/kbd/<right>", func(t.Event) {
+		p.seek(1000)
+	})
+	t.Handle("/sys/kbd/+", func(t.Event) {
+		p.mutex.Lock()
+		p.speed *= 2
+		p.mutex.Unlock()
+	})
+	t.Handle("/sys/kbd/-", func(t.Event) {
+		p.mutex.Lock()
+		p.speed /= 2
+		p.mutex.Unlock()
+	})
+	t.Handle(frameEvent, func(t.Event) {
+		p.render(tickMillis)
+	})
+
+	go func() {
+		ticker := time.NewTicker(tickMillis * time.Millisecond)
+		defer ticker.Stop()
+		for range ticker.C {
+			t.SendCustomEvt(frameEvent, nil)
+		}
+	}()
+	t.Loop()
+}
+
+func main() {
+	output := flag.String("o", "", "convert the run to `file` (.svg or .gif) instead of playing it back")
+	speed := flag.Float64("speed", 1, "playback `speed` (2 is twice as fast)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: replay [options] run.json\n\nPlays back a run recorded by sumosim or greyhound -track (with -record run.json)")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *speed <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	r, err := replay.Load(flag.Arg(0))
+	if err != nil {
+		printError("Error:", err)
+		os.Exit(1)
+	}
+	if len(r.Frames) == 0 {
+		printError("Error:", flag.Arg(0), "has no frames")
+		os.Exit(1)
+	}
+
+	if *output != "" {
+		err = replay.Save(*output, r)
+		if err != nil {
+			printError("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	play(r, *speed)
+}
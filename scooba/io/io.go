@@ -1,125 +1,141 @@
 package io
 
 import (
+	"go-bots/clock"
 	"go-bots/ev3"
 	"go-bots/scooba/config"
 	"go-bots/scooba/logic"
 	"time"
 )
 
-var devs *ev3.Devices
-var data chan<- logic.Data
-var commands <-chan logic.Commands
+// IO connects the logic to the devices of the robot
+type IO struct {
+	devs *ev3.Devices
+	data chan<- logic.Data
 
-var ml, mr, mfl, mfr *ev3.Attribute
-var irL, irFL, irFR, irR *ev3.Attribute
+	ml, mr, mfl, mfr     *ev3.Attribute
+	irL, irFL, irFR, irR *ev3.Attribute
 
-var ledRR, ledRG, ledLR, ledLG *ev3.Attribute
+	ledRR, ledRG, ledLR, ledLG *ev3.Attribute
 
-var start time.Time
+	watchdog *ev3.Watchdog
+
+	clock clock.Clock
+	start time.Time
+
+	conf *config.File
+
+	speedL, speedR            int
+	lastMillis, currentMillis int
+}
 
 // StartTime gets the time when the bot started
-func StartTime() time.Time {
-	return start
+func (io *IO) StartTime() time.Time {
+	return io.start
 }
 
-// Init initializes the io module
-func Init(d chan<- logic.Data, s time.Time) {
-	devs = ev3.Scan(&ev3.OutPortModes{
+// New opens and initializes the devices, the readings are sent to d with
+// the times of c relative to s, the configuration is the one of the bot
+func New(d chan<- logic.Data, c clock.Clock, s time.Time, conf *config.File) *IO {
+	io := &IO{data: d, clock: c, start: s, conf: conf}
+	io.devs = ev3.Scan(&ev3.OutPortModes{
 		OutA: ev3.OutPortModeDcMotor,
 		OutB: ev3.OutPortModeAuto,
 		OutC: ev3.OutPortModeAuto,
 		OutD: ev3.OutPortModeDcMotor,
 	})
-	data = d
-	start = s
 
 	// Ir Left
-	ev3.CheckDriver(devs.In2, ev3.DriverIr, ev3.In2)
+	ev3.CheckDriver(io.devs.In2, ev3.DriverIr, ev3.In2)
 	// Ir FrontLeft
-	ev3.CheckDriver(devs.In4, ev3.DriverIr, ev3.In4)
+	ev3.CheckDriver(io.devs.In4, ev3.DriverIr, ev3.In4)
 	// Ir FrontRight
-	ev3.CheckDriver(devs.In1, ev3.DriverIr, ev3.In1)
+	ev3.CheckDriver(io.devs.In1, ev3.DriverIr, ev3.In1)
 	// Ir Right
-	ev3.CheckDriver(devs.In3, ev3.DriverIr, ev3.In3)
+	ev3.CheckDriver(io.devs.In3, ev3.DriverIr, ev3.In3)
 
 	// A front Left
-	ev3.CheckDriver(devs.OutA, ev3.DriverRcxMotor, ev3.OutA)
-	frontRight := devs.OutA
+	ev3.CheckDriver(io.devs.OutA, ev3.DriverRcxMotor, ev3.OutA)
+	frontRight := io.devs.OutA
 	// B left direct
-	ev3.CheckDriver(devs.OutB, ev3.DriverTachoMotorLarge, ev3.OutB)
+	ev3.CheckDriver(io.devs.OutB, ev3.DriverTachoMotorLarge, ev3.OutB)
 	// C right direct
-	ev3.CheckDriver(devs.OutC, ev3.DriverTachoMotorLarge, ev3.OutC)
+	ev3.CheckDriver(io.devs.OutC, ev3.DriverTachoMotorLarge, ev3.OutC)
 	// D front Right
-	ev3.CheckDriver(devs.OutD, ev3.DriverRcxMotor, ev3.OutD)
-	frontLeft := devs.OutD
-
-	ev3.SetMode(devs.In1, ev3.IrModeProx)
-	ev3.SetMode(devs.In2, ev3.IrModeProx)
-	ev3.SetMode(devs.In3, ev3.IrModeProx)
-	ev3.SetMode(devs.In4, ev3.IrModeProx)
-
-	ev3.RunCommand(devs.OutA, ev3.CmdStop)
-	ev3.RunCommand(devs.OutB, ev3.CmdReset)
-	ev3.RunCommand(devs.OutC, ev3.CmdReset)
-	ev3.RunCommand(devs.OutD, ev3.CmdStop)
-
-	irL = ev3.OpenByteR(devs.In2, ev3.BinData)
-	irFL = ev3.OpenByteR(devs.In4, ev3.BinData)
-	irFR = ev3.OpenByteR(devs.In1, ev3.BinData)
-	irR = ev3.OpenByteR(devs.In3, ev3.BinData)
+	ev3.CheckDriver(io.devs.OutD, ev3.DriverRcxMotor, ev3.OutD)
+	frontLeft := io.devs.OutD
+
+	ev3.SetMode(io.devs.In1, ev3.IrModeProx)
+	ev3.SetMode(io.devs.In2, ev3.IrModeProx)
+	ev3.SetMode(io.devs.In3, ev3.IrModeProx)
+	ev3.SetMode(io.devs.In4, ev3.IrModeProx)
+
+	ev3.RunCommand(io.devs.OutA, ev3.CmdStop)
+	ev3.RunCommand(io.devs.OutB, ev3.CmdReset)
+	ev3.RunCommand(io.devs.OutC, ev3.CmdReset)
+	ev3.RunCommand(io.devs.OutD, ev3.CmdStop)
+
+	io.irL = ev3.OpenByteR(io.devs.In2, ev3.BinData)
+	io.irFL = ev3.OpenByteR(io.devs.In4, ev3.BinData)
+	io.irFR = ev3.OpenByteR(io.devs.In1, ev3.BinData)
+	io.irR = ev3.OpenByteR(io.devs.In3, ev3.BinData)
 	// A front Left
-	mfl = ev3.OpenTextW(devs.OutA, ev3.DutyCycleSp)
+	io.mfl = ev3.OpenTextW(io.devs.OutA, ev3.DutyCycleSp)
 	// B left direct
-	ml = ev3.OpenTextW(devs.OutB, ev3.DutyCycleSp)
+	io.ml = ev3.OpenTextW(io.devs.OutB, ev3.DutyCycleSp)
 	// C right direct
-	mr = ev3.OpenTextW(devs.OutC, ev3.DutyCycleSp)
+	io.mr = ev3.OpenTextW(io.devs.OutC, ev3.DutyCycleSp)
 	// D front Right
-	mfr = ev3.OpenTextW(devs.OutD, ev3.DutyCycleSp)
-
-	ledLG = ev3.OpenTextW(devs.LedLeftGreen, ev3.Brightness)
-	ledLR = ev3.OpenTextW(devs.LedLeftRed, ev3.Brightness)
-	ledRG = ev3.OpenTextW(devs.LedRightGreen, ev3.Brightness)
-	ledRR = ev3.OpenTextW(devs.LedRightRed, ev3.Brightness)
-	ledLG.Value = 0
-	ledLR.Value = 0
-	ledRG.Value = 0
-	ledRR.Value = 0
-	ledLG.Sync()
-	ledLR.Sync()
-	ledRG.Sync()
-	ledRR.Sync()
+	io.mfr = ev3.OpenTextW(io.devs.OutD, ev3.DutyCycleSp)
+
+	io.ledLG = ev3.OpenTextW(io.devs.LedLeftGreen, ev3.Brightness)
+	io.ledLR = ev3.OpenTextW(io.devs.LedLeftRed, ev3.Brightness)
+	io.ledRG = ev3.OpenTextW(io.devs.LedRightGreen, ev3.Brightness)
+	io.ledRR = ev3.OpenTextW(io.devs.LedRightRed, ev3.Brightness)
+	io.ledLG.Value = 0
+	io.ledLR.Value = 0
+	io.ledRG.Value = 0
+	io.ledRR.Value = 0
+	io.ledLG.Sync()
+	io.ledLR.Sync()
+	io.ledRG.Sync()
+	io.ledRR.Sync()
 
 	// Wheels
-	mr.Value = 0
-	ml.Value = 0
-	mr.Sync()
-	ml.Sync()
-	ev3.RunCommand(devs.OutB, ev3.CmdReset)
-	ev3.RunCommand(devs.OutC, ev3.CmdReset)
-	ev3.RunCommand(devs.OutB, ev3.CmdRunDirect)
-	ev3.RunCommand(devs.OutC, ev3.CmdRunDirect)
+	io.mr.Value = 0
+	io.ml.Value = 0
+	io.mr.Sync()
+	io.ml.Sync()
+	ev3.RunCommand(io.devs.OutB, ev3.CmdReset)
+	ev3.RunCommand(io.devs.OutC, ev3.CmdReset)
+	ev3.RunCommand(io.devs.OutB, ev3.CmdRunDirect)
+	ev3.RunCommand(io.devs.OutC, ev3.CmdRunDirect)
 
 	// front Left
-	mfl.Value = 0
-	mfl.Sync()
+	io.mfl.Value = 0
+	io.mfl.Sync()
 	ev3.RunCommand(frontLeft, ev3.CmdStop)
 	ev3.RunCommand(frontLeft, ev3.CmdRunDirect)
 
 	// front Right
-	mfr.Value = 0
-	mfr.Sync()
+	io.mfr.Value = 0
+	io.mfr.Sync()
 	ev3.RunCommand(frontRight, ev3.CmdStop)
 	ev3.RunCommand(frontRight, ev3.CmdRunDirect)
-}
 
-var speedL, speedR int
-var lastMillis, currentMillis int
+	io.watchdog = ev3.NewWatchdog(io.devs, time.Duration(io.conf.Get().WatchdogMillis)*time.Millisecond, io.clock)
+	io.watchdog.Start()
+	return io
+}
 
 // ProcessCommand process the commands
-func ProcessCommand(c *logic.Commands) {
-	currentMillis = c.Millis
-	lastMillis = currentMillis
+func (io *IO) ProcessCommand(c *logic.Commands) {
+	if !io.watchdog.Feed() {
+		return
+	}
+
+	io.currentMillis = c.Millis
+	io.lastMillis = io.currentMillis
 
 	mlValue := -c.SpeedLeft / 100
 	mrValue := -c.SpeedRight / 100
@@ -135,75 +151,79 @@ func ProcessCommand(c *logic.Commands) {
 	if mrValue < -100 {
 		mrValue = -100
 	}
-	ml.Value = mlValue
-	mr.Value = mrValue
-	ml.Sync()
-	mr.Sync()
-
-	ledLG.Value = c.LedLeftGreen
-	ledLR.Value = c.LedLeftRed
-	ledRG.Value = c.LedRightGreen
-	ledRR.Value = c.LedRightRed
-	ledLG.Sync()
-	ledLR.Sync()
-	ledRG.Sync()
-	ledRR.Sync()
+	io.ml.Value = mlValue
+	io.mr.Value = mrValue
+	io.ml.Sync()
+	io.mr.Sync()
+
+	io.ledLG.Value = c.LedLeftGreen
+	io.ledLR.Value = c.LedLeftRed
+	io.ledRG.Value = c.LedRightGreen
+	io.ledRR.Value = c.LedRightRed
+	io.ledLG.Sync()
+	io.ledLR.Sync()
+	io.ledRG.Sync()
+	io.ledRR.Sync()
 
 	if c.FrontActive {
-		mfl.Value = -config.FrontWheelsSpeed
-		mfr.Value = config.FrontWheelsSpeed
-		mfl.Sync()
-		mfr.Sync()
+		io.mfl.Value = -io.conf.Get().FrontWheelsSpeed
+		io.mfr.Value = io.conf.Get().FrontWheelsSpeed
+		io.mfl.Sync()
+		io.mfr.Sync()
 	} else {
-		mfl.Value = 0
-		mfr.Value = 0
-		mfl.Sync()
-		mfr.Sync()
+		io.mfl.Value = 0
+		io.mfr.Value = 0
+		io.mfl.Sync()
+		io.mfr.Sync()
 	}
 }
 
 // Loop contains the io loop
-func Loop() {
+func (io *IO) Loop() {
+	defer ev3.Recover()
+
 	for {
-		now := time.Now()
-		millis := ev3.TimespanAsMillis(start, now)
+		now := io.clock.Now()
+		millis := ev3.TimespanAsMillis(io.start, now)
 
-		irL.Sync()
-		irFL.Sync()
-		irFR.Sync()
-		irR.Sync()
+		io.irL.Sync()
+		io.irFL.Sync()
+		io.irFR.Sync()
+		io.irR.Sync()
 
 		// fmt.Fprintln(os.Stderr, "DATA", irL.Value, irFL.Value, irFR.Value, irR.Value)
 
-		data <- logic.Data{
-			Start:             start,
+		io.data <- logic.Data{
+			Start:             io.start,
 			Millis:            millis,
-			IrValueLeft:       irL.Value,
-			IrValueFrontLeft:  irFL.Value,
-			IrValueFrontRight: irFR.Value,
-			IrValueRight:      irR.Value,
+			IrValueLeft:       io.irL.Value,
+			IrValueFrontLeft:  io.irFL.Value,
+			IrValueFrontRight: io.irFR.Value,
+			IrValueRight:      io.irR.Value,
 		}
 	}
 }
 
 // Close terminates and cleans up the io module
-func Close() {
-	defer ev3.RunCommand(devs.OutA, ev3.CmdStop)
-	defer ev3.RunCommand(devs.OutB, ev3.CmdReset)
-	defer ev3.RunCommand(devs.OutC, ev3.CmdReset)
-	defer ev3.RunCommand(devs.OutD, ev3.CmdStop)
-
-	defer ev3.RunCommand(devs.OutB, ev3.CmdStop)
-	defer ev3.RunCommand(devs.OutC, ev3.CmdStop)
-
-	ledLG.Value = 0
-	ledLR.Value = 0
-	ledRG.Value = 0
-	ledRR.Value = 0
-	ledLG.Sync()
-	ledLR.Sync()
-	ledRG.Sync()
-	ledRR.Sync()
+func (io *IO) Close() {
+	io.watchdog.Stop()
+
+	defer ev3.RunCommand(io.devs.OutA, ev3.CmdStop)
+	defer ev3.RunCommand(io.devs.OutB, ev3.CmdReset)
+	defer ev3.RunCommand(io.devs.OutC, ev3.CmdReset)
+	defer ev3.RunCommand(io.devs.OutD, ev3.CmdStop)
+
+	defer ev3.RunCommand(io.devs.OutB, ev3.CmdStop)
+	defer ev3.RunCommand(io.devs.OutC, ev3.CmdStop)
+
+	io.ledLG.Value = 0
+	io.ledLR.Value = 0
+	io.ledRG.Value = 0
+	io.ledRR.Value = 0
+	io.ledLG.Sync()
+	io.ledLR.Sync()
+	io.ledRG.Sync()
+	io.ledRR.Sync()
 
 	// TODO: close all files
 	// mfl, mlr, ml, mr
@@ -1,214 +1,158 @@
 package logic
 
 import (
-	"fmt"
+	"context"
+	"go-bots/behaviour"
+	"go-bots/botconf"
+	"go-bots/cmdline"
 	"go-bots/ev3"
-	"go-bots/scooba/config"
 	"go-bots/ui"
-	"os"
 )
 
-func pauseBeforeBegin(start int, strategy func(int, ev3.Direction), dir ev3.Direction) {
-	for {
-		select {
-		case d := <-data:
-			now, elapsed := handleTime(d, start)
-			if elapsed >= config.StartTime {
-				go strategy(now, dir)
-				return
-			}
-			speed(0, 0)
-			intensity := ((elapsed % 1000) * 255) / (config.StartTime / 5)
-			if elapsed > (config.StartTime * 4 / 5) {
-				leds(intensity, intensity, intensity, intensity)
-			} else {
-				leds(0, 0, intensity, intensity)
-			}
-			startCmd()
-		case k := <-keys:
-			if checkDone(k) {
+// pauseBeforeBegin waits for the start time and then hands off to strategy
+func (l *Logic) pauseBeforeBegin(strategy behaviour.Func) behaviour.Func {
+	return func(ctx context.Context, start int, dir ev3.Direction) {
+		l.runner.EnterState(start, "pauseBeforeBegin", "", dir)
+		behaviour.Log(start, dir, "profile "+botconf.ProfileLabel(l.conf.Profile))
+		for {
+			select {
+			case d := <-l.data:
+				now, elapsed := l.handleTime(d, start)
+				if elapsed >= l.conf.StartTime {
+					l.runner.HandOff(ctx, strategy, now, dir)
+					return
+				}
+				l.speed(0, 0)
+				intensity := ((elapsed % 1000) * 255) / (l.conf.StartTime / 5)
+				if elapsed > (l.conf.StartTime * 4 / 5) {
+					l.leds(intensity, intensity, intensity, intensity)
+				} else {
+					l.leds(0, 0, intensity, intensity)
+				}
+				l.startCmd()
+			case <-ctx.Done():
 				return
 			}
 		}
 	}
 }
 
-func chooseStrategy(start int) {
-	strategy := goForward
-	var dir ev3.Direction = ev3.Left
-	leds(0, 0, 0, 0)
-	speed(0, 0)
-	startCmd()
-	fmt.Fprintln(os.Stderr, "chooseStrategy START")
+func (l *Logic) chooseStrategy(ctx context.Context, start int, dir ev3.Direction) {
+	l.runner.SetInMenu(true)
+	defer l.runner.SetInMenu(false)
+	l.reloadConfig()
+
+	strategy := l.goForward
+	dir = ev3.Left
+	l.runner.EnterState(start, "chooseStrategy", "", dir)
+	l.leds(0, 0, 0, 0)
+	l.speed(0, 0)
+	l.startCmd()
+	cmdline.Log(cmdline.Info, "chooseStrategy START")
 
 	for {
 		select {
-		case d := <-data:
-			handleTime(d, start)
-			speed(0, 0)
-			startCmd()
-		case k := <-keys:
-			checkQuit(k)
+		case d := <-l.data:
+			l.handleTime(d, start)
+			l.refreshConfig()
+			l.speed(0, 0)
+			l.startCmd()
+		case k := <-l.runner.MenuKeys():
 			if k.Key == ui.Enter {
-				go pauseBeforeBegin(k.Millis, strategy, dir)
+				l.runner.HandOff(ctx, l.pauseBeforeBegin(strategy), k.Millis, dir)
 				return
+			} else if k.Key == ui.Profile {
+				l.selectNextProfile()
 			} else if k.Key == ui.Left {
 				dir = ev3.Left
-				leds(255, 0, 255, 0)
-				fmt.Fprintln(os.Stderr, "chooseStrategy circle left")
+				l.leds(255, 0, 255, 0)
+				cmdline.Log(cmdline.Info, "chooseStrategy circle left")
 			} else if k.Key == ui.Right {
 				dir = ev3.Right
-				leds(0, 255, 0, 255)
-				fmt.Fprintln(os.Stderr, "chooseStrategy circle right")
+				l.leds(0, 255, 0, 255)
+				cmdline.Log(cmdline.Info, "chooseStrategy circle right")
 			} else if k.Key == ui.Up {
-				strategy = goForward
+				strategy = l.goForward
 				if dir == ev3.Left {
-					leds(255, 0, 0, 0)
-					fmt.Fprintln(os.Stderr, "chooseStrategy forward left")
+					l.leds(255, 0, 0, 0)
+					cmdline.Log(cmdline.Info, "chooseStrategy forward left")
 				} else {
-					leds(0, 255, 0, 0)
-					fmt.Fprintln(os.Stderr, "chooseStrategy forward right")
+					l.leds(0, 255, 0, 0)
+					cmdline.Log(cmdline.Info, "chooseStrategy forward right")
 				}
 			} else if k.Key == ui.Down {
-				strategy = turnBack
+				strategy = l.turnBack
 				if dir == ev3.Left {
-					leds(0, 0, 255, 0)
-					fmt.Fprintln(os.Stderr, "chooseStrategy back left")
+					l.leds(0, 0, 255, 0)
+					cmdline.Log(cmdline.Info, "chooseStrategy back left")
 				} else {
-					leds(0, 0, 0, 255)
-					fmt.Fprintln(os.Stderr, "chooseStrategy back right")
+					l.leds(0, 0, 0, 255)
+					cmdline.Log(cmdline.Info, "chooseStrategy back right")
 				}
 			}
-			speed(0, 0)
-			startCmd()
+			l.speed(0, 0)
+			l.startCmd()
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func goForward(start int, dir ev3.Direction) {
-	now, elapsed := start, 0
-
-	fmt.Fprintln(os.Stderr, "goForward", now, dir)
-
-	for elapsed < config.GoForwardMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-
-			speed(config.GoForwardSpeed, config.GoForwardSpeed)
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	fmt.Fprintln(os.Stderr, "goForward turn", now, dir)
-
-	forwardDone := elapsed
-	for elapsed-forwardDone < config.GoForwardTurnMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				speed(config.GoForwardTurnOuterSpeed, config.GoForwardTurnInnerSpeed)
-			} else {
-				speed(config.GoForwardTurnInnerSpeed, config.GoForwardTurnOuterSpeed)
-			}
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) goForwardMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "goForward",
+		Phases: []behaviour.Phase{
+			{
+				Name:       "goForward move",
+				Millis:     l.conf.GoForwardMillis,
+				Outer:      l.conf.GoForwardSpeed,
+				Inner:      l.conf.GoForwardSpeed,
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+			{
+				Name:       "goForward turn",
+				Millis:     l.conf.GoForwardTurnMillis,
+				Outer:      l.conf.GoForwardTurnOuterSpeed,
+				Inner:      l.conf.GoForwardTurnInnerSpeed,
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+		},
 	}
 }
 
-func turnBack(start int, dir ev3.Direction) {
-	now, elapsed := start, 0
-
-	fmt.Fprintln(os.Stderr, "turnBack", now, dir)
-
-	for elapsed < config.TurnBackPreMoveMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-
-			speed(config.TurnBackPreMoveSpeed, config.TurnBackPreMoveSpeed)
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	fmt.Fprintln(os.Stderr, "turnBack turn", now, dir)
-	turnBackPreMoveDone := elapsed
-	for elapsed-turnBackPreMoveDone < config.TurnBackMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				speed(config.TurnBackOuterSpeed, config.TurnBackInnerSpeed)
-			} else {
-				speed(config.TurnBackInnerSpeed, config.TurnBackOuterSpeed)
-			}
+func (l *Logic) goForward(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.goForwardMachine(), start, dir, 0)
+}
 
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) turnBackMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "turnBack",
+		Phases: []behaviour.Phase{
+			{
+				Name:       "turnBack pre move",
+				Millis:     l.conf.TurnBackPreMoveMillis,
+				Outer:      l.conf.TurnBackPreMoveSpeed,
+				Inner:      l.conf.TurnBackPreMoveSpeed,
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+			{
+				Name:       "turnBack turn",
+				Millis:     l.conf.TurnBackMillis,
+				Outer:      l.conf.TurnBackOuterSpeed,
+				Inner:      l.conf.TurnBackInnerSpeed,
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+			{
+				Name:       "turnBack move",
+				Millis:     l.conf.TurnBackMoveMillis,
+				Outer:      l.conf.TurnBackMoveSpeed,
+				Inner:      l.conf.TurnBackMoveSpeed,
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+		},
 	}
+}
 
-	fmt.Fprintln(os.Stderr, "turnBack move", now, dir)
-
-	turnBackMoveDone := elapsed
-	for elapsed-turnBackMoveDone < config.TurnBackMoveMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-
-			speed(config.TurnBackMoveSpeed, config.TurnBackMoveSpeed)
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
+func (l *Logic) turnBack(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.turnBackMachine(), start, dir, 0)
 }
@@ -1,43 +1,38 @@
 package logic
 
 import (
-	"fmt"
-	"os"
+	"context"
+	"go-bots/behaviour"
+	"go-bots/ev3"
 )
 
-func checkVision(d Data, now int) bool {
-	if d.IrValueLeft < 100 || d.IrValueFrontLeft < 100 || d.IrValueFrontRight < 100 || d.IrValueRight < 100 {
+func (l *Logic) checkVision(ctx context.Context, r *behaviour.Run) bool {
+	if l.d.IrValueLeft < 100 || l.d.IrValueFrontLeft < 100 || l.d.IrValueFrontRight < 100 || l.d.IrValueRight < 100 {
 		// REMOVE ME!!
-		// go track(now)
+		// handOff(ctx, track, r.Now, ev3.NoDirection)
 		return true
 	}
 	return false
 }
 
-const trackPrintMillis = 250
-
-func track(start int) {
-	// now, elapsed := start, 0
-	// var dir ev3.Direction = ev3.Right
-
-	fmt.Fprintln(os.Stderr, "TRACK")
-
-	for {
-		select {
-		case d := <-data:
-			_, _ = handleTime(d, start)
-
-			if d.IrValueLeft >= 100 || d.IrValueFrontLeft >= 100 || d.IrValueFrontRight >= 100 || d.IrValueRight >= 100 {
-				return
-			}
-
-			// fmt.Fprintln(os.Stderr, "TRACK time ", now, ", speed", c.SpeedLeft, c.SpeedRight)
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) trackMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "TRACK",
+		Phases: []behaviour.Phase{
+			{
+				Name: "TRACK follow",
+				// keep the current speeds
+				Steer: func(r *behaviour.Run) (int, int) {
+					return l.c.SpeedLeft, l.c.SpeedRight
+				},
+				Until: func(r *behaviour.Run) bool {
+					return l.d.IrValueLeft >= 100 || l.d.IrValueFrontLeft >= 100 || l.d.IrValueFrontRight >= 100 || l.d.IrValueRight >= 100
+				},
+			},
+		},
 	}
 }
+
+func (l *Logic) track(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.trackMachine(), start, ev3.Right, 0)
+}
@@ -1,23 +1,47 @@
 package logic
 
 import (
-	"fmt"
-	"go-bots/ev3"
-	"go-bots/ui"
-	"os"
+	"go-bots/botconf"
+	"go-bots/cmdline"
 )
 
-func log(now int, dir ev3.Direction, msg string) {
-	dirString := ""
-	if dir == ev3.Left {
-		dirString = "LEFT"
-	} else if dir == ev3.Right {
-		dirString = "RIGHT"
+// reloadConfig rereads the configuration file, between rounds
+func (l *Logic) reloadConfig() {
+	err := l.file.Reload()
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error reloading configuration, keeping the previous one:", err)
 	} else {
-		dirString = "NONE"
+		cmdline.Log(cmdline.Info, "Configuration reloaded:\n"+botconf.String(l.file.Get()))
 	}
+	l.conf = l.file.Get()
+}
 
-	fmt.Fprintln(os.Stderr, now, dirString, msg)
+// refreshConfig picks up the configuration file when it changes on disk,
+// it is called where swapping the configuration is safe
+func (l *Logic) refreshConfig() {
+	changed, err := l.file.ReloadIfChanged()
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error reading the changed configuration, keeping the previous one:", err)
+	} else if changed {
+		cmdline.Log(cmdline.Info, "Configuration changed:\n"+botconf.String(l.file.Get()))
+	}
+	l.conf = l.file.Get()
+}
+
+// selectNextProfile switches to the next profile of the configuration file
+func (l *Logic) selectNextProfile() {
+	names, err := l.file.ProfileNames()
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error reading profiles:", err)
+		return
+	}
+	err = l.file.SelectProfile(botconf.NextProfile(names, l.conf.Profile))
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error switching profile, keeping the previous one:", err)
+	} else {
+		cmdline.Log(cmdline.Info, "Configuration loaded:\n"+botconf.String(l.file.Get()))
+	}
+	l.conf = l.file.Get()
 }
 
 func abs(v int) int {
@@ -27,29 +51,26 @@ func abs(v int) int {
 	return v
 }
 
-// FrontActive decide if move mfl and mfr
-var FrontActive bool
-
-func cmd() {
-	commandProcessor(&c)
-	FrontActive = true
+func (l *Logic) cmd() {
+	l.commandProcessor(&l.c)
+	l.frontActive = true
 }
 
-func startCmd() {
-	commandProcessor(&c)
-	FrontActive = false
+func (l *Logic) startCmd() {
+	l.commandProcessor(&l.c)
+	l.frontActive = false
 }
 
-func handleTime(d Data, start int) (now int, elapsed int) {
+func (l *Logic) handleTime(d Data, start int) (now int, elapsed int) {
 	now = d.Millis
-	c.Millis = now
+	l.c.Millis = now
 	elapsed = now - start
 	return
 }
 
-func speed(left int, right int) {
-	c.SpeedLeft = left
-	c.SpeedRight = right
+func (l *Logic) speed(left int, right int) {
+	l.c.SpeedLeft = left
+	l.c.SpeedRight = right
 }
 
 func normalizeLedValue(v int) int {
@@ -62,35 +83,18 @@ func normalizeLedValue(v int) int {
 	return v
 }
 
-func leds(leftGreen int, rightGreen int, leftRed int, rightRed int) {
+func (l *Logic) leds(leftGreen int, rightGreen int, leftRed int, rightRed int) {
 	leftGreen = normalizeLedValue(leftGreen)
 	rightGreen = normalizeLedValue(rightGreen)
 	leftRed = normalizeLedValue(leftRed)
 	rightRed = normalizeLedValue(rightRed)
-	c.LedLeftGreen = leftGreen
-	c.LedRightGreen = rightGreen
-	c.LedLeftRed = leftRed
-	c.LedRightRed = rightRed
+	l.c.LedLeftGreen = leftGreen
+	l.c.LedRightGreen = rightGreen
+	l.c.LedLeftRed = leftRed
+	l.c.LedRightRed = rightRed
 }
 
-func ledsFromData(d Data) {
-	c.LedLeftGreen = 255
-	c.LedRightGreen = 255
-}
-
-func checkDone(k ui.KeyEvent) bool {
-	if k.Key == ui.Quit || k.Key == ui.Back {
-
-		log(k.Millis, ev3.NoDirection, " *** DONE ***")
-
-		go chooseStrategy(k.Millis)
-		return true
-	}
-	return false
-}
-func checkQuit(k ui.KeyEvent) {
-	if k.Key == ui.Quit || k.Key == ui.Back {
-		quit <- true
-		return
-	}
+func (l *Logic) ledsFromData(d Data) {
+	l.c.LedLeftGreen = 255
+	l.c.LedRightGreen = 255
 }
@@ -0,0 +1,229 @@
+package simio
+
+import (
+	"fmt"
+	"go-bots/behaviour"
+	"go-bots/clock"
+	"go-bots/ev3"
+	"go-bots/scooba/config"
+	"go-bots/scooba/logic"
+	"go-bots/sim/sumo"
+	"go-bots/ui"
+	"sync"
+	"time"
+)
+
+// Sensor names of the simulated body
+const (
+	IrLeft       = "ir-left"
+	IrFrontLeft  = "ir-front-left"
+	IrFrontRight = "ir-front-right"
+	IrRight      = "ir-right"
+)
+
+// Body returns the shape and sensors of scooba
+func Body() sumo.Body {
+	return sumo.Body{
+		Radius:      110,
+		Mass:        1.2,
+		Friction:    0.9,
+		WheelBase:   150,
+		MaxSpeed:    500,
+		MotorMillis: 80,
+		Sensors: []sumo.Sensor{
+			{Name: IrLeft, Kind: sumo.IR, X: 20, Y: 90, Angle: 90},
+			{Name: IrFrontLeft, Kind: sumo.IR, X: 90, Y: 40, Angle: 15},
+			{Name: IrFrontRight, Kind: sumo.IR, X: 90, Y: -40, Angle: -15},
+			{Name: IrRight, Kind: sumo.IR, X: 20, Y: -90, Angle: -90},
+		},
+	}
+}
+
+// Strategies lists the strategies that can be chosen from the menu
+var Strategies = []string{"goForward", "turnBack"}
+
+// menuKeys returns the keys that choose a strategy in the menu
+func menuKeys(strategy string, dir ev3.Direction) ([]ui.Key, error) {
+	right := dir == ev3.Right
+	switch strategy {
+	case "goForward":
+		if right {
+			return []ui.Key{ui.Right}, nil
+		}
+		return []ui.Key{}, nil
+	case "turnBack":
+		if right {
+			return []ui.Key{ui.Right, ui.Down}, nil
+		}
+		return []ui.Key{ui.Down}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q (must be one of %v)", strategy, Strategies)
+}
+
+// Bot runs the scooba logic against a robot of the simulated arena, in
+// place of the io module
+type Bot struct {
+	body     sumo.Body
+	strategy []ui.Key
+	data     chan logic.Data
+	keys     chan ui.KeyEvent
+	quit     chan bool
+	clock    *clock.Manual
+	// stallClock times the wait for the logic to take a reading, the
+	// simulation is stopped meanwhile so it cannot be its own clock
+	stallClock clock.Clock
+	conf       *config.File
+	start      time.Time
+	keypad     *sumo.Keypad
+	logic      *logic.Logic
+
+	mutex    sync.Mutex
+	commands []logic.Commands
+	stalled  bool
+	// taken is the time of the last reading taken by the logic
+	taken int
+}
+
+// New starts the scooba logic with the given strategy and direction,
+// its time is kept on c, its configuration is conf
+func New(strategy string, dir ev3.Direction, c *clock.Manual, conf *config.File) (*Bot, error) {
+	keys, err := menuKeys(strategy, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bot{
+		body:       Body(),
+		strategy:   keys,
+		data:       make(chan logic.Data),
+		keys:       make(chan ui.KeyEvent),
+		quit:       make(chan bool, 1),
+		clock:      c,
+		stallClock: clock.Real{},
+		conf:       conf,
+	}
+	b.start = b.clock.Now()
+	b.logic = logic.New(b.data, b.processCommand, b.keys, b.quit, conf)
+	b.keypad = &sumo.Keypad{
+		Keys:   b.keys,
+		InMenu: func() bool { return b.state().Machine == "chooseStrategy" },
+		Ready:  func() bool { return b.state().Machine != "" },
+	}
+	go b.logic.Run()
+	return b, nil
+}
+
+// SetBody replaces the default body
+func (b *Bot) SetBody(body sumo.Body) {
+	b.body = body
+}
+
+// SetStallClock replaces the clock timing the wait for the logic (the system
+// clock by default)
+func (b *Bot) SetStallClock(c clock.Clock) {
+	b.stallClock = c
+}
+
+// Name identifies the controller
+func (b *Bot) Name() string {
+	return "scooba"
+}
+
+// Body returns the shape and sensors of the robot
+func (b *Bot) Body() sumo.Body {
+	return b.body
+}
+
+// Start goes back to the menu (when needed), chooses the strategy and presses ENTER
+func (b *Bot) Start(now int) {
+	b.keypad.Choose(now, b.strategy)
+}
+
+// State describes what the logic is doing
+func (b *Bot) State() string {
+	s := b.state()
+	if s.Phase == "" {
+		return s.Machine
+	}
+	return s.Phase
+}
+
+// state returns the last state entered for a reading before the last one
+// taken, like the commands
+func (b *Bot) state() behaviour.Transition {
+	result := behaviour.Transition{}
+	for _, t := range b.logic.History() {
+		if t.Millis < b.taken {
+			result = t
+		}
+	}
+	return result
+}
+
+// processCommand records the commands, they are applied at the next step
+func (b *Bot) processCommand(c *logic.Commands) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.commands = append(b.commands, *c)
+}
+
+// command returns the last command given for a reading before the last
+// one taken (see the seeker2 simio module)
+func (b *Bot) command() (logic.Commands, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	found := -1
+	for i, c := range b.commands {
+		if c.Millis < b.taken {
+			found = i
+		}
+	}
+	if found < 0 {
+		return logic.Commands{}, false
+	}
+	result := b.commands[found]
+	b.commands = b.commands[found+1:]
+	return result, true
+}
+
+// Step applies the last command to the robot (the front wheels are not
+// simulated), reads its sensors and hands them to the logic
+func (b *Bot) Step(now int, r *sumo.Robot, w *sumo.World) {
+	b.clock.Set(b.start.Add(time.Duration(now) * time.Millisecond))
+	if c, ok := b.command(); ok {
+		r.SetDuty(c.SpeedLeft/100, c.SpeedRight/100)
+	}
+
+	b.send(r, logic.Data{
+		Start:             b.start,
+		Millis:            ev3.TimespanAsMillis(b.start, b.clock.Now()),
+		IrValueLeft:       w.Sense(r, IrLeft),
+		IrValueFrontLeft:  w.Sense(r, IrFrontLeft),
+		IrValueFrontRight: w.Sense(r, IrFrontRight),
+		IrValueRight:      w.Sense(r, IrRight),
+	})
+	// The keys follow the reading, once the logic has taken it
+	b.keypad.Step(now)
+}
+
+// send hands a reading to the logic, when the logic does not take it the
+// robot stops until the logic reads again
+func (b *Bot) send(r *sumo.Robot, d logic.Data) {
+	if b.stalled {
+		select {
+		case b.data <- d:
+			b.stalled = false
+			b.taken = d.Millis
+		default:
+			r.SetDuty(0, 0)
+		}
+		return
+	}
+	select {
+	case b.data <- d:
+		b.taken = d.Millis
+	case <-b.stallClock.After(sumo.StallTimeout):
+		b.stalled = true
+		r.SetDuty(0, 0)
+	}
+}
@@ -5,22 +5,32 @@ import (
 	"go-bots/scooba/config"
 )
 
-var firstIntensityLeft int
-var firstPositionLeft int
-var currentIntensityLeft int
-var currentPositionLeft int
-var hasLeftEstimation bool
-
-var firstIntensityRight int
-var firstPositionRight int
-var currentIntensityRight int
-var currentPositionRight int
-var hasRightEstimation bool
-
-var estimatedIntensityLeft int
-var estimatedPositionLeft int
-var estimatedIntensityRight int
-var estimatedPositionRight int
+// Vision estimates where the opponent is from the IR sensors on the moving eyes
+type Vision struct {
+	firstIntensityLeft   int
+	firstPositionLeft    int
+	currentIntensityLeft int
+	currentPositionLeft  int
+	hasLeftEstimation    bool
+
+	firstIntensityRight   int
+	firstPositionRight    int
+	currentIntensityRight int
+	currentPositionRight  int
+	hasRightEstimation    bool
+
+	estimatedIntensityLeft  int
+	estimatedPositionLeft   int
+	estimatedIntensityRight int
+	estimatedPositionRight  int
+
+	conf *config.File
+}
+
+// New creates a vision with no estimation, using the configuration of conf
+func New(conf *config.File) *Vision {
+	return &Vision{conf: conf}
+}
 
 func abs(v int) int {
 	if v < 0 {
@@ -29,19 +39,19 @@ func abs(v int) int {
 	return v
 }
 
-func farValueAtPosition(pos int) (farValueLeft int, farValueRight int) {
+func farValueAtPosition(conf *config.Config, pos int) (farValueLeft int, farValueRight int) {
 	if pos > 0 {
-		farValueLeft = config.VisionFarValueSide + (config.VisionFarValueDelta * pos / config.VisionMaxPosition)
-		farValueRight = config.VisionFarValueFront - (config.VisionFarValueDelta * pos / config.VisionMaxPosition)
+		farValueLeft = conf.VisionFarValueSide + (conf.VisionFarValueDelta * pos / conf.VisionMaxPosition)
+		farValueRight = conf.VisionFarValueFront - (conf.VisionFarValueDelta * pos / conf.VisionMaxPosition)
 	} else {
-		farValueLeft = config.VisionFarValueFront + (config.VisionFarValueDelta * pos / config.VisionMaxPosition)
-		farValueRight = config.VisionFarValueSide - (config.VisionFarValueDelta * pos / config.VisionMaxPosition)
+		farValueLeft = conf.VisionFarValueFront + (conf.VisionFarValueDelta * pos / conf.VisionMaxPosition)
+		farValueRight = conf.VisionFarValueSide - (conf.VisionFarValueDelta * pos / conf.VisionMaxPosition)
 	}
 	return
 }
 
-func irValuesToIntensity(leftValue int, rightValue int, pos int) (leftIntensity int, rightIntensity int) {
-	leftLimit, rightLimit := farValueAtPosition(pos)
+func irValuesToIntensity(conf *config.Config, leftValue int, rightValue int, pos int) (leftIntensity int, rightIntensity int) {
+	leftLimit, rightLimit := farValueAtPosition(conf, pos)
 	if leftValue >= leftLimit {
 		leftValue = 100
 	}
@@ -55,66 +65,66 @@ func positionToAngle(pos int) int {
 	return pos * 9 / 25
 }
 
-func estimate(d ev3.Direction) (intensity int, angle int, dir ev3.Direction) {
-	leftAngle := positionToAngle(estimatedPositionLeft) - 45
-	rightAngle := positionToAngle(estimatedPositionRight) + 45
-	if estimatedIntensityLeft > estimatedIntensityRight {
-		return estimatedIntensityLeft, leftAngle, d
-	} else if estimatedIntensityRight > estimatedIntensityLeft {
-		return estimatedIntensityRight, rightAngle, d
+func (v *Vision) estimate(d ev3.Direction) (intensity int, angle int, dir ev3.Direction) {
+	leftAngle := positionToAngle(v.estimatedPositionLeft) - 45
+	rightAngle := positionToAngle(v.estimatedPositionRight) + 45
+	if v.estimatedIntensityLeft > v.estimatedIntensityRight {
+		return v.estimatedIntensityLeft, leftAngle, d
+	} else if v.estimatedIntensityRight > v.estimatedIntensityLeft {
+		return v.estimatedIntensityRight, rightAngle, d
 	} else {
-		return estimatedIntensityRight, (leftAngle + rightAngle) / 2, d
+		return v.estimatedIntensityRight, (leftAngle + rightAngle) / 2, d
 	}
 }
 
 // Reset resets the vision state
-func Reset() {
-	firstIntensityLeft = 0
-	firstPositionLeft = 0
-	currentIntensityLeft = 0
-	currentPositionLeft = 0
-	hasLeftEstimation = false
-	firstIntensityRight = 0
-	firstPositionRight = 0
-	currentIntensityRight = 0
-	currentPositionRight = 0
-	hasRightEstimation = false
-	estimatedIntensityLeft = 0
-	estimatedPositionLeft = 0
-	estimatedIntensityRight = 0
-	estimatedPositionRight = 0
+func (v *Vision) Reset() {
+	v.firstIntensityLeft = 0
+	v.firstPositionLeft = 0
+	v.currentIntensityLeft = 0
+	v.currentPositionLeft = 0
+	v.hasLeftEstimation = false
+	v.firstIntensityRight = 0
+	v.firstPositionRight = 0
+	v.currentIntensityRight = 0
+	v.currentPositionRight = 0
+	v.hasRightEstimation = false
+	v.estimatedIntensityLeft = 0
+	v.estimatedPositionLeft = 0
+	v.estimatedIntensityRight = 0
+	v.estimatedPositionRight = 0
 }
 
-func switchDirection(pos int, leftIntensity int, rightIntensity int, dir ev3.Direction) ev3.Direction {
-	if leftIntensity > 0 && !hasLeftEstimation {
-		estimatedIntensityLeft = leftIntensity
-		estimatedPositionLeft = pos
-	} else if currentIntensityLeft == 0 {
-		estimatedIntensityLeft = 0
+func (v *Vision) switchDirection(pos int, leftIntensity int, rightIntensity int, dir ev3.Direction) ev3.Direction {
+	if leftIntensity > 0 && !v.hasLeftEstimation {
+		v.estimatedIntensityLeft = leftIntensity
+		v.estimatedPositionLeft = pos
+	} else if v.currentIntensityLeft == 0 {
+		v.estimatedIntensityLeft = 0
 	}
-	firstIntensityLeft = 0
-	firstPositionLeft = 0
-	currentIntensityLeft = 0
-	currentPositionLeft = pos
-	hasLeftEstimation = false
-
-	if rightIntensity > 0 && !hasRightEstimation {
-		estimatedIntensityRight = rightIntensity
-		estimatedPositionRight = pos
-	} else if currentIntensityRight == 0 {
-		estimatedIntensityRight = 0
+	v.firstIntensityLeft = 0
+	v.firstPositionLeft = 0
+	v.currentIntensityLeft = 0
+	v.currentPositionLeft = pos
+	v.hasLeftEstimation = false
+
+	if rightIntensity > 0 && !v.hasRightEstimation {
+		v.estimatedIntensityRight = rightIntensity
+		v.estimatedPositionRight = pos
+	} else if v.currentIntensityRight == 0 {
+		v.estimatedIntensityRight = 0
 	}
-	firstIntensityRight = 0
-	firstPositionRight = 0
-	currentIntensityRight = 0
-	currentPositionRight = pos
-	hasRightEstimation = false
+	v.firstIntensityRight = 0
+	v.firstPositionRight = 0
+	v.currentIntensityRight = 0
+	v.currentPositionRight = pos
+	v.hasRightEstimation = false
 
 	return ev3.ChangeDirection(dir)
 }
 
-func estimationIsOld(estimationPosition int, pos int) bool {
-	return abs(pos-estimationPosition) > config.VisionSpotWidth && abs(estimationPosition) > config.VisionSpotSearchWidth
+func estimationIsOld(conf *config.Config, estimationPosition int, pos int) bool {
+	return abs(pos-estimationPosition) > conf.VisionSpotWidth && abs(estimationPosition) > conf.VisionSpotSearchWidth
 }
 
 func computeEstimatedPositionCorrection(firstPosition int, firstIntensity int, currentPosition int, currentIntensity int, pos int, intensity int) int {
@@ -139,54 +149,55 @@ func computeEstimatedPositionCorrection(firstPosition int, firstIntensity int, c
 }
 
 // Process processes IR sensor data
-func Process(millis int, d ev3.Direction, pos int, leftValue int, rightValue int) (intensity int, angle int, dir ev3.Direction) {
-	leftIntensity, rightIntensity := irValuesToIntensity(leftValue, rightValue, pos)
-
-	if d == ev3.Right && pos >= config.VisionThresholdPosition {
-		dir = switchDirection(pos, leftIntensity, rightIntensity, d)
-	} else if d == ev3.Left && pos <= -config.VisionThresholdPosition {
-		dir = switchDirection(pos, leftIntensity, rightIntensity, d)
-	} else if hasLeftEstimation && (rightIntensity == 0 || hasRightEstimation) && estimationIsOld(estimatedPositionLeft, pos) {
-		dir = switchDirection(pos, leftIntensity, rightIntensity, d)
-	} else if hasRightEstimation && (leftIntensity == 0 || hasLeftEstimation) && estimationIsOld(estimatedPositionRight, pos) {
-		dir = switchDirection(pos, leftIntensity, rightIntensity, d)
-	} else if (hasLeftEstimation || hasRightEstimation) && leftIntensity == 0 && rightIntensity == 0 {
-		dir = switchDirection(pos, leftIntensity, rightIntensity, d)
+func (v *Vision) Process(millis int, d ev3.Direction, pos int, leftValue int, rightValue int) (intensity int, angle int, dir ev3.Direction) {
+	conf := v.conf.Get()
+	leftIntensity, rightIntensity := irValuesToIntensity(conf, leftValue, rightValue, pos)
+
+	if d == ev3.Right && pos >= conf.VisionThresholdPosition {
+		dir = v.switchDirection(pos, leftIntensity, rightIntensity, d)
+	} else if d == ev3.Left && pos <= -conf.VisionThresholdPosition {
+		dir = v.switchDirection(pos, leftIntensity, rightIntensity, d)
+	} else if v.hasLeftEstimation && (rightIntensity == 0 || v.hasRightEstimation) && estimationIsOld(conf, v.estimatedPositionLeft, pos) {
+		dir = v.switchDirection(pos, leftIntensity, rightIntensity, d)
+	} else if v.hasRightEstimation && (leftIntensity == 0 || v.hasLeftEstimation) && estimationIsOld(conf, v.estimatedPositionRight, pos) {
+		dir = v.switchDirection(pos, leftIntensity, rightIntensity, d)
+	} else if (v.hasLeftEstimation || v.hasRightEstimation) && leftIntensity == 0 && rightIntensity == 0 {
+		dir = v.switchDirection(pos, leftIntensity, rightIntensity, d)
 	} else {
 		dir = d
 
-		if leftIntensity > currentIntensityLeft {
-			if firstIntensityLeft == 0 {
-				firstIntensityLeft = leftIntensity
-				firstPositionLeft = pos
+		if leftIntensity > v.currentIntensityLeft {
+			if v.firstIntensityLeft == 0 {
+				v.firstIntensityLeft = leftIntensity
+				v.firstPositionLeft = pos
 			}
-			currentIntensityLeft = leftIntensity
-			currentPositionLeft = pos
-		} else if leftIntensity < currentIntensityLeft-(currentIntensityLeft/config.VisionEstimateReductionRange) {
-			estimatedIntensityLeft = currentIntensityLeft
-			positionCorrection := computeEstimatedPositionCorrection(abs(firstPositionLeft), firstIntensityLeft, currentPositionLeft, currentIntensityLeft, abs(pos), leftIntensity)
-			estimatedPositionLeft = currentPositionLeft - (int(dir) * positionCorrection)
-			hasLeftEstimation = true
+			v.currentIntensityLeft = leftIntensity
+			v.currentPositionLeft = pos
+		} else if leftIntensity < v.currentIntensityLeft-(v.currentIntensityLeft/conf.VisionEstimateReductionRange) {
+			v.estimatedIntensityLeft = v.currentIntensityLeft
+			positionCorrection := computeEstimatedPositionCorrection(abs(v.firstPositionLeft), v.firstIntensityLeft, v.currentPositionLeft, v.currentIntensityLeft, abs(pos), leftIntensity)
+			v.estimatedPositionLeft = v.currentPositionLeft - (int(dir) * positionCorrection)
+			v.hasLeftEstimation = true
 		}
 
-		if rightIntensity > currentIntensityRight {
-			if firstIntensityRight == 0 {
-				firstIntensityRight = rightIntensity
-				firstPositionRight = pos
+		if rightIntensity > v.currentIntensityRight {
+			if v.firstIntensityRight == 0 {
+				v.firstIntensityRight = rightIntensity
+				v.firstPositionRight = pos
 			}
-			currentIntensityRight = rightIntensity
-			currentPositionRight = pos
-		} else if rightIntensity < currentIntensityRight-(currentIntensityRight/config.VisionEstimateReductionRange) {
-			estimatedIntensityRight = currentIntensityRight
-			positionCorrection := computeEstimatedPositionCorrection(abs(firstPositionRight), firstIntensityRight, currentPositionRight, currentIntensityRight, abs(pos), rightIntensity)
-			estimatedPositionRight = currentPositionRight - (int(dir) * positionCorrection)
-			hasRightEstimation = true
+			v.currentIntensityRight = rightIntensity
+			v.currentPositionRight = pos
+		} else if rightIntensity < v.currentIntensityRight-(v.currentIntensityRight/conf.VisionEstimateReductionRange) {
+			v.estimatedIntensityRight = v.currentIntensityRight
+			positionCorrection := computeEstimatedPositionCorrection(abs(v.firstPositionRight), v.firstIntensityRight, v.currentPositionRight, v.currentIntensityRight, abs(pos), rightIntensity)
+			v.estimatedPositionRight = v.currentPositionRight - (int(dir) * positionCorrection)
+			v.hasRightEstimation = true
 		}
 
-		// intens, ang, _ := estimate(dir)
-		// fmt.Fprintln(os.Stderr, "VISION", dir, pos, leftValue, rightValue, "- I", leftIntensity, rightIntensity, "- L", currentIntensityLeft, currentPositionLeft, "- R", currentIntensityRight, currentPositionRight, "- RES", intens, ang)
-		// fmt.Fprintln(os.Stderr, "VISION", dir, pos, "- I", leftIntensity, rightIntensity, "- L", currentIntensityLeft, currentPositionLeft, "- R", currentIntensityRight, currentPositionRight, "- RES", intens, ang)
+		// intens, ang, _ := v.estimate(dir)
+		// fmt.Fprintln(os.Stderr, "VISION", dir, pos, leftValue, rightValue, "- I", leftIntensity, rightIntensity, "- L", v.currentIntensityLeft, v.currentPositionLeft, "- R", v.currentIntensityRight, v.currentPositionRight, "- RES", intens, ang)
+		// fmt.Fprintln(os.Stderr, "VISION", dir, pos, "- I", leftIntensity, rightIntensity, "- L", v.currentIntensityLeft, v.currentPositionLeft, "- R", v.currentIntensityRight, v.currentPositionRight, "- RES", intens, ang)
 	}
 
-	return estimate(dir)
+	return v.estimate(dir)
 }
@@ -1,93 +1,251 @@
 package config
 
-const ColorIsOut = 30
-
-const ForwardAcceleration = 10000 / 200
-const ReverseAcceleration = 10000 / 1
-
-const MaxSpeed = 10000
-
-const FrontWheelsSpeed = 100
-
-const StartTime = 5000
-
-const SeekMoveSpeed = 6000
-const SeekMoveMillis = 850
-const SeekTurnSpeed = 4000
-const SeekTurnMillis = 1500
-
-const BackTurn1SpeedOuter = MaxSpeed
-const BackTurn1SpeedInner = MaxSpeed / 2
-const BackTurn1Millis = 400
-const BackTurn2Speed = 5000
-const BackTurn2Millis = 800
-const BackMoveSpeed = MaxSpeed
-const BackMoveMillis = 500
-const BackTurn3Speed = 5000
-const BackTurn3Millis = 1000
-
-const CircleFindBorderMillis = 200
-const CircleFindBorderOuterSpeed = MaxSpeed * 70 / 100
-const CircleFindBorderInnerSpeed = MaxSpeed * 25 / 100
-const CircleFindBorderOuterSpeedSlow = MaxSpeed * 31 / 100
-const CircleFindBorderInnerSpeedSlow = MaxSpeed * 18 / 100
-
-const CircleMillis = 2000
-const CircleOuterSpeed = MaxSpeed
-const CircleInnerSpeedLeft = 5300
-const CircleInnerSpeedRight = 5300
-
-const CircleAdjustInnerMax = 440
-const CircleSpiralMillis = 500
-const CircleSpiralOuterSpeed = MaxSpeed
-const CircleSpiralInnerSpeed = 2000
-
-const GoForwardMillis = 1000
-const GoForwardSpeed = MaxSpeed
-
-// const GoForwardTurnMillis = 600
-const GoForwardTurnMillis = 0
-const GoForwardTurnOuterSpeed = MaxSpeed
-const GoForwardTurnInnerSpeed = 1000
-
-const TurnBackPreMoveSpeed = MaxSpeed
-const TurnBackPreMoveMillis = 200
-const TurnBackMillis = 300
-const TurnBackOuterSpeed = MaxSpeed
-const TurnBackInnerSpeed = -MaxSpeed
-const TurnBackMoveMillis = 650
-const TurnBackMoveSpeed = MaxSpeed
-
-const TrackFrontAngle = 15
-const TrackSemiFrontAngle = 30
-const TrackSemiFrontInnerSpeed = MaxSpeed / 2
-const TrackMaxSpeed = MaxSpeed
-const TrackOuterSpeed = 4000
-const TrackInnerSpeed = -3000
-const TrackSpeedReductionAngle = VisionMaxAngle - TrackFrontAngle
-const TrackSpeedReductionMax = TrackOuterSpeed - TrackInnerSpeed
-const TrackVisionIntensityIgnoreBorder = 40
-
-// VisionSpeed is the speed of the eyes motor, max is 1560
-const VisionSpeed = "450"
-
-// const VisionFarValueFront = 90
-// const VisionFarValueSide = 80
-
-const VisionFarValueFront = 80
-const VisionFarValueSide = 70
-const VisionFarValueDelta = VisionFarValueFront - VisionFarValueSide
-
-const VisionMaxAngle = (VisionMaxPosition * 9 / 25) + 45
-
-const VisionMaxIntensity = 100
-
-const VisionStartPosition = 150
-const VisionStartPositionString = "150"
-const VisionMaxPosition = 158
-const VisionThresholdPosition = 155
-const VisionEstimateReductionRange = 10
-const VisionSpotWidth = 5 * 25 / 9
-const VisionSpotSearchWidth = VisionMaxPosition - VisionSpotWidth
-
-const VisionIgnoreBorderValue = 60
+import (
+	"go-bots/botconf"
+	"go-bots/clock"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+)
+
+// Config data
+type Config struct {
+	// Profile is the name of the profile in use (empty for the base section)
+	Profile string `toml:"-"`
+
+	// ColorIsOut is the border threshold used until the corner sensors are calibrated
+	ColorIsOut int `check:"min=0,max=101"`
+	// BorderMarginPC places the calibrated thresholds between surface (0) and border (100)
+	BorderMarginPC int `check:"min=1,max=99"`
+	// BorderSampleMillis is how long the surface and the border are sampled
+	BorderSampleMillis int `check:"required,min=1"`
+
+	ForwardAcceleration int `check:"required,min=1"`
+	ReverseAcceleration int `check:"required,min=1"`
+
+	MaxSpeed int `check:"required,min=1,max=10000"`
+
+	FrontWheelsSpeed int `check:"min=-100,max=100"`
+
+	StartTime int `check:"min=0"`
+
+	// WatchdogMillis is the time without motor commands after which the motors are stopped
+	WatchdogMillis int `check:"required,min=1"`
+
+	SeekMoveSpeed  int `check:"le=MaxSpeed"`
+	SeekMoveMillis int `check:"min=0"`
+	SeekTurnSpeed  int `check:"le=MaxSpeed"`
+	SeekTurnMillis int `check:"min=0"`
+
+	BackTurn1SpeedOuter int `check:"le=MaxSpeed"`
+	BackTurn1SpeedInner int `check:"le=MaxSpeed"`
+	BackTurn1Millis     int
+	BackTurn2Speed      int `check:"le=MaxSpeed"`
+	BackTurn2Millis     int
+	BackMoveSpeed       int `check:"le=MaxSpeed"`
+	BackMoveMillis      int
+	BackTurn3Speed      int `check:"le=MaxSpeed"`
+	BackTurn3Millis     int
+
+	CircleFindBorderMillis         int
+	CircleFindBorderOuterSpeed     int `check:"le=MaxSpeed"`
+	CircleFindBorderInnerSpeed     int `check:"le=MaxSpeed"`
+	CircleFindBorderOuterSpeedSlow int `check:"le=MaxSpeed"`
+	CircleFindBorderInnerSpeedSlow int `check:"le=MaxSpeed"`
+
+	CircleMillis          int
+	CircleOuterSpeed      int `check:"le=MaxSpeed"`
+	CircleInnerSpeedLeft  int `check:"le=MaxSpeed"`
+	CircleInnerSpeedRight int `check:"le=MaxSpeed"`
+
+	CircleAdjustInnerMax   int
+	CircleSpiralMillis     int
+	CircleSpiralOuterSpeed int `check:"le=MaxSpeed"`
+	CircleSpiralInnerSpeed int `check:"le=MaxSpeed"`
+
+	GoForwardMillis int `check:"min=0"`
+	GoForwardSpeed  int `check:"le=MaxSpeed"`
+
+	GoForwardTurnMillis     int
+	GoForwardTurnOuterSpeed int `check:"le=MaxSpeed"`
+	GoForwardTurnInnerSpeed int `check:"le=MaxSpeed"`
+
+	TurnBackPreMoveSpeed  int `check:"le=MaxSpeed"`
+	TurnBackPreMoveMillis int `check:"min=0"`
+	TurnBackMillis        int
+	TurnBackOuterSpeed    int `check:"le=MaxSpeed"`
+	TurnBackInnerSpeed    int `check:"le=MaxSpeed"`
+	TurnBackMoveMillis    int
+	TurnBackMoveSpeed     int `check:"le=MaxSpeed"`
+
+	TrackFrontAngle                  int `check:"min=0,lt=TrackSemiFrontAngle"`
+	TrackSemiFrontAngle              int
+	TrackSemiFrontInnerSpeed         int `check:"le=MaxSpeed"`
+	TrackMaxSpeed                    int `check:"le=MaxSpeed"`
+	TrackOuterSpeed                  int `check:"le=MaxSpeed"`
+	TrackInnerSpeed                  int `check:"le=MaxSpeed"`
+	TrackSpeedReductionAngle         int `toml:"-"`
+	TrackSpeedReductionMax           int `toml:"-"`
+	TrackVisionIntensityIgnoreBorder int
+
+	// VisionSpeed is the speed of the eyes motor, max is 1560
+	VisionSpeed int `check:"required,min=1,max=1560"`
+
+	VisionFarValueFront int
+	VisionFarValueSide  int
+	VisionFarValueDelta int `toml:"-"`
+
+	VisionMaxAngle int `toml:"-"`
+
+	VisionMaxIntensity int `check:"required,min=1"`
+
+	VisionStartPosition          int `check:"le=VisionMaxPosition"`
+	VisionMaxPosition            int `check:"required,min=1"`
+	VisionThresholdPosition      int `check:"le=VisionMaxPosition"`
+	VisionEstimateReductionRange int `check:"required,min=1"`
+	VisionSpotWidth              int `check:"lt=VisionMaxPosition"`
+	VisionSpotSearchWidth        int `toml:"-"`
+
+	VisionIgnoreBorderValue int
+
+	// VisionStartPositionString is VisionStartPosition formatted for sysfs
+	VisionStartPositionString string `toml:"-"`
+}
+
+// CompleteConfig fills in computed configuration fields
+func CompleteConfig(c *Config) {
+	c.VisionMaxAngle = (c.VisionMaxPosition * 9 / 25) + 45
+	c.TrackSpeedReductionAngle = c.VisionMaxAngle - c.TrackFrontAngle
+	c.TrackSpeedReductionMax = c.TrackOuterSpeed - c.TrackInnerSpeed
+	c.VisionFarValueDelta = c.VisionFarValueFront - c.VisionFarValueSide
+	c.VisionSpotSearchWidth = c.VisionMaxPosition - c.VisionSpotWidth
+	c.VisionStartPositionString = strconv.Itoa(c.VisionStartPosition)
+}
+
+// Default Config data (the values the bot was tuned with)
+func Default() Config {
+	const maxSpeed = 10000
+	result := Config{
+		BorderMarginPC:                   50,
+		BorderSampleMillis:               1000,
+		ColorIsOut:                       30,
+		ForwardAcceleration:              10000 / 200,
+		ReverseAcceleration:              10000 / 1,
+		MaxSpeed:                         maxSpeed,
+		FrontWheelsSpeed:                 100,
+		StartTime:                        5000,
+		WatchdogMillis:                   500,
+		SeekMoveSpeed:                    6000,
+		SeekMoveMillis:                   850,
+		SeekTurnSpeed:                    4000,
+		SeekTurnMillis:                   1500,
+		BackTurn1SpeedOuter:              maxSpeed,
+		BackTurn1SpeedInner:              maxSpeed / 2,
+		BackTurn1Millis:                  400,
+		BackTurn2Speed:                   5000,
+		BackTurn2Millis:                  800,
+		BackMoveSpeed:                    maxSpeed,
+		BackMoveMillis:                   500,
+		BackTurn3Speed:                   5000,
+		BackTurn3Millis:                  1000,
+		CircleFindBorderMillis:           200,
+		CircleFindBorderOuterSpeed:       maxSpeed * 70 / 100,
+		CircleFindBorderInnerSpeed:       maxSpeed * 25 / 100,
+		CircleFindBorderOuterSpeedSlow:   maxSpeed * 31 / 100,
+		CircleFindBorderInnerSpeedSlow:   maxSpeed * 18 / 100,
+		CircleMillis:                     2000,
+		CircleOuterSpeed:                 maxSpeed,
+		CircleInnerSpeedLeft:             5300,
+		CircleInnerSpeedRight:            5300,
+		CircleAdjustInnerMax:             440,
+		CircleSpiralMillis:               500,
+		CircleSpiralOuterSpeed:           maxSpeed,
+		CircleSpiralInnerSpeed:           2000,
+		GoForwardMillis:                  1000,
+		GoForwardSpeed:                   maxSpeed,
+		GoForwardTurnMillis:              0,
+		GoForwardTurnOuterSpeed:          maxSpeed,
+		GoForwardTurnInnerSpeed:          1000,
+		TurnBackPreMoveSpeed:             maxSpeed,
+		TurnBackPreMoveMillis:            200,
+		TurnBackMillis:                   300,
+		TurnBackOuterSpeed:               maxSpeed,
+		TurnBackInnerSpeed:               -maxSpeed,
+		TurnBackMoveMillis:               650,
+		TurnBackMoveSpeed:                maxSpeed,
+		TrackFrontAngle:                  15,
+		TrackSemiFrontAngle:              30,
+		TrackSemiFrontInnerSpeed:         maxSpeed / 2,
+		TrackMaxSpeed:                    maxSpeed,
+		TrackOuterSpeed:                  4000,
+		TrackInnerSpeed:                  -3000,
+		TrackVisionIntensityIgnoreBorder: 40,
+		VisionSpeed:                      450,
+		VisionFarValueFront:              80,
+		VisionFarValueSide:               70,
+		VisionMaxIntensity:               100,
+		VisionStartPosition:              150,
+		VisionMaxPosition:                158,
+		VisionThresholdPosition:          155,
+		VisionEstimateReductionRange:     10,
+		VisionSpotWidth:                  5 * 25 / 9,
+		VisionIgnoreBorderValue:          60,
+	}
+	CompleteConfig(&result)
+	return result
+}
+
+// FromString reads Config data from a TOML string applying the named profile
+// and then sets (as Key=Value), values that are not in it keep their defaults
+func FromString(data string, profile string, sets []string) (Config, error) {
+	result := Default()
+	name, err := botconf.DecodeProfileSets(data, profile, sets, &result)
+	if err != nil {
+		return result, err
+	}
+	err = botconf.Validate(&result)
+	if err != nil {
+		return result, err
+	}
+	result.Profile = name
+	CompleteConfig(&result)
+	return result, nil
+}
+
+// FromFile reads Config data from a TOML file applying the named profile and then sets
+func FromFile(fileName string, profile string, sets []string) (Config, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return Config{}, err
+	}
+	return FromString(string(b), profile, sets)
+}
+
+// File is the configuration file of seeker2, see botconf.File
+type File struct {
+	*botconf.File
+}
+
+// Load reads a configuration file with the named profile and sets (as
+// Key=Value, they are applied again on every reload), changes to the file are
+// looked for on the clock c. On error the file uses the defaults
+func Load(fileName string, profileName string, sets []string, c clock.Clock) (*File, error) {
+	defaults := Default()
+	f, err := botconf.LoadFile(fileName, profileName, sets, &defaults, decode, c)
+	return &File{f}, err
+}
+
+func decode(fileName string, profile string, sets []string) (interface{}, string, error) {
+	c, err := FromFile(fileName, profile, sets)
+	return &c, c.Profile, err
+}
+
+// Get returns the configuration in use, callers must not modify it
+func (f *File) Get() *Config {
+	return f.Current().(*Config)
+}
+
+// BorderFile is where the border calibration is kept, next to the file
+func (f *File) BorderFile() string {
+	return filepath.Join(filepath.Dir(f.Name()), "seeker2-border.toml")
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"go-bots/botconf"
+	"go-bots/clock"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadKeepsSets(t *testing.T) {
+	data, err := ioutil.ReadFile("../seeker2.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempFile("", "seeker2-*.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+	write := func(data string) {
+		if err := ioutil.WriteFile(tmp.Name(), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(string(data))
+
+	c := clock.NewManual(time.Time{})
+	f, err := Load(tmp.Name(), "", []string{"SeekTurnMillis=1234"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Get().SeekTurnMillis != 1234 {
+		t.Errorf("SeekTurnMillis is %d after Load, expected the set value", f.Get().SeekTurnMillis)
+	}
+
+	err = f.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Get().SeekTurnMillis != 1234 {
+		t.Errorf("SeekTurnMillis is %d after Reload, expected the set value", f.Get().SeekTurnMillis)
+	}
+
+	// A change of the file is read once it has been stable for an interval
+	write(strings.Replace(string(data), "SeekMoveMillis=                    850", "SeekMoveMillis=                    1850", 1))
+	changed := false
+	for i := 0; i < 3 && !changed; i++ {
+		c.Advance(botconf.WatchInterval)
+		changed, err = f.ReloadIfChanged()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !changed || f.Get().SeekMoveMillis != 1850 {
+		t.Fatalf("file change not reloaded, SeekMoveMillis is %d", f.Get().SeekMoveMillis)
+	}
+	if f.Get().SeekTurnMillis != 1234 {
+		t.Errorf("SeekTurnMillis is %d after the file changed, expected the set value", f.Get().SeekTurnMillis)
+	}
+}
+
+func TestFilesAreIndependent(t *testing.T) {
+	c := clock.NewManual(time.Time{})
+	a, err := Load("../seeker2.toml", "", []string{"SeekTurnMillis=500"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Load("../seeker2.toml", "", []string{"SeekTurnMillis=600"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Get().SeekTurnMillis != 500 || b.Get().SeekTurnMillis != 600 {
+		t.Errorf("SeekTurnMillis is %d and %d, expected 500 and 600", a.Get().SeekTurnMillis, b.Get().SeekTurnMillis)
+	}
+}
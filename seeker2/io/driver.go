@@ -0,0 +1,170 @@
+package io
+
+import (
+	"go-bots/border"
+	"go-bots/ev3"
+	"go-bots/seeker2/config"
+	"go-bots/seeker2/logic"
+	"go-bots/seeker2/vision"
+	"sync"
+	"time"
+)
+
+// Driver is the part of the io module that does not touch the devices: it
+// turns the commands of the logic into motor and led values and the sensor
+// readings into the data of the logic. IO runs it on the robot and the
+// simulator on a simulated one
+type Driver struct {
+	start       time.Time
+	conf        *config.File
+	calibration *border.Current
+
+	// The logic gives commands while the io loop reads the sensors
+	mutex          sync.Mutex
+	vision         *vision.Vision
+	speedL, speedR int
+	lastMillis     int
+	eyesSetPoint   int
+}
+
+// Outputs are the values a command sets on the devices
+type Outputs struct {
+	// MotorLeft and MotorRight are the duty cycles of the wheels (positive forward)
+	MotorLeft  int
+	MotorRight int
+	// Front is the duty cycle of the front wheels
+	Front int
+
+	LedLeftGreen  int
+	LedLeftRed    int
+	LedRightGreen int
+	LedRightRed   int
+}
+
+// Sensors are the values read from the devices
+type Sensors struct {
+	CornerLeft  int
+	CornerRight int
+	IrLeft      int
+	IrRight     int
+	// EyesPosition is the position of the eyes motor
+	EyesPosition int
+}
+
+// NewDriver creates a driver giving times relative to s, with the
+// configuration and the corner sensor calibration of the bot
+func NewDriver(s time.Time, conf *config.File, calibration *border.Current) *Driver {
+	d := &Driver{start: s, conf: conf, calibration: calibration, vision: vision.New(conf)}
+	d.setEyesDirection(ev3.NoDirection)
+	return d
+}
+
+func computeSpeed(conf *config.Config, currentSpeed int, targetSpeed int, millis int) int {
+	if currentSpeed < targetSpeed {
+		currentSpeed += (conf.ForwardAcceleration * millis)
+		if currentSpeed > targetSpeed {
+			currentSpeed = targetSpeed
+		}
+	}
+	if currentSpeed > targetSpeed {
+		currentSpeed -= (conf.ReverseAcceleration * millis)
+		if currentSpeed < targetSpeed {
+			currentSpeed = targetSpeed
+		}
+	}
+	return currentSpeed
+}
+
+func dutyCycle(speed int) int {
+	v := speed / 100
+	if v > 100 {
+		v = 100
+	}
+	if v < -100 {
+		v = -100
+	}
+	return v
+}
+
+// Command accelerates the wheels towards the speeds of c and points the eyes
+func (d *Driver) Command(c *logic.Commands) Outputs {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	conf := d.conf.Get()
+	millis := c.Millis - d.lastMillis
+	d.speedL = computeSpeed(conf, d.speedL, c.SpeedLeft, millis)
+	d.speedR = computeSpeed(conf, d.speedR, c.SpeedRight, millis)
+	d.lastMillis = c.Millis
+
+	result := Outputs{
+		MotorLeft:     dutyCycle(d.speedL),
+		MotorRight:    dutyCycle(d.speedR),
+		LedLeftGreen:  c.LedLeftGreen,
+		LedLeftRed:    c.LedLeftRed,
+		LedRightGreen: c.LedRightGreen,
+		LedRightRed:   c.LedRightRed,
+	}
+	if c.FrontActive {
+		result.Front = conf.FrontWheelsSpeed
+	}
+
+	if !c.EyesActive {
+		d.vision.Reset()
+		d.setEyesDirection(ev3.NoDirection)
+	} else if d.eyesDirection() == ev3.NoDirection {
+		d.vision.Reset()
+		d.setEyesDirection(ev3.Right)
+	}
+	return result
+}
+
+// Read turns sensor values read at millis into the data for the logic, the
+// eyes may be pointed elsewhere
+func (d *Driver) Read(millis int, s Sensors) logic.Data {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	visionIntensity, visionAngle, eyesDirection := 0, 0, d.eyesDirection()
+	if eyesDirection != ev3.NoDirection {
+		visionIntensity, visionAngle, eyesDirection = d.vision.Process(millis, eyesDirection, s.EyesPosition, s.IrLeft, s.IrRight)
+		d.setEyesDirection(eyesDirection)
+	}
+
+	leftThreshold, rightThreshold := d.calibration.Thresholds(d.conf.Get().ColorIsOut)
+	return logic.Data{
+		Start:            d.start,
+		Millis:           millis,
+		CornerRightIsOut: s.CornerRight > rightThreshold,
+		CornerLeftIsOut:  s.CornerLeft > leftThreshold,
+		CornerRight:      s.CornerRight,
+		CornerLeft:       s.CornerLeft,
+		IrValueRight:     s.IrRight,
+		IrValueLeft:      s.IrLeft,
+		VisionIntensity:  visionIntensity,
+		VisionAngle:      visionAngle,
+	}
+}
+
+// EyesSetPoint is the position the eyes motor has to be driven to
+func (d *Driver) EyesSetPoint() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.eyesSetPoint
+}
+
+func (d *Driver) eyesDirection() ev3.Direction {
+	if d.eyesSetPoint == d.conf.Get().VisionMaxPosition {
+		return ev3.Right
+	} else if d.eyesSetPoint == -d.conf.Get().VisionMaxPosition {
+		return ev3.Left
+	}
+	return ev3.NoDirection
+}
+
+func (d *Driver) setEyesDirection(dir ev3.Direction) {
+	d.eyesSetPoint = d.conf.Get().VisionStartPosition
+	if dir != ev3.NoDirection {
+		d.eyesSetPoint = d.conf.Get().VisionMaxPosition * int(dir)
+	}
+}
@@ -1,277 +1,222 @@
 package io
 
 import (
+	"go-bots/border"
+	"go-bots/clock"
 	"go-bots/ev3"
 	"go-bots/seeker2/config"
 	"go-bots/seeker2/logic"
-	"go-bots/seeker2/vision"
+	"strconv"
 	"time"
 )
 
-func colorIsOut(v int) bool {
-	return v > config.ColorIsOut
-}
+// IO connects the logic to the devices of the robot
+type IO struct {
+	devs *ev3.Devices
+	data chan<- logic.Data
 
-var devs *ev3.Devices
-var data chan<- logic.Data
-var commands <-chan logic.Commands
+	pme, pmesp, ml, mr, mf *ev3.Attribute
+	dme, dmf               string
+	colR, colL, irR, irL   *ev3.Attribute
 
-var pme, pmesp, ml, mr, mf *ev3.Attribute
-var dme, dmf string
-var colR, colL, irR, irL *ev3.Attribute
+	ledRR, ledRG, ledLR, ledLG *ev3.Attribute
 
-var ledRR, ledRG, ledLR, ledLG *ev3.Attribute
+	watchdog *ev3.Watchdog
+	driver   *Driver
 
-var start time.Time
+	clock clock.Clock
+	start time.Time
 
-func getEyesDirection() ev3.Direction {
-	if pmesp.Value == config.VisionMaxPosition {
-		return ev3.Right
-	} else if pmesp.Value == -config.VisionMaxPosition {
-		return ev3.Left
-	} else {
-		return ev3.NoDirection
-	}
+	conf *config.File
 }
-func setEyesDirection(dir ev3.Direction) {
-	desiredSetPosition := config.VisionStartPosition
-	if dir != ev3.NoDirection {
-		desiredSetPosition = int(config.VisionMaxPosition * dir)
-	}
-	if pmesp.Value != desiredSetPosition {
-		pmesp.Value = desiredSetPosition
-		pmesp.Sync()
-		ev3.RunCommand(dme, ev3.CmdRunToAbsPos)
+
+// syncEyes drives the eyes motor to the set point of the driver
+func (io *IO) syncEyes() {
+	setPoint := io.driver.EyesSetPoint()
+	if io.pmesp.Value != setPoint {
+		io.pmesp.Value = setPoint
+		io.pmesp.Sync()
+		ev3.RunCommand(io.dme, ev3.CmdRunToAbsPos)
 	}
 }
 
 // StartTime gets the time when the bot started
-func StartTime() time.Time {
-	return start
+func (io *IO) StartTime() time.Time {
+	return io.start
 }
 
-// Init initializes the io module
-func Init(d chan<- logic.Data, s time.Time) {
-	devs = ev3.Scan(&ev3.OutPortModes{
+// New opens and initializes the devices, the readings are sent to d with
+// the times of c relative to s, the configuration and the corner sensor
+// calibration are the ones of the bot
+func New(d chan<- logic.Data, c clock.Clock, s time.Time, conf *config.File, calibration *border.Current) *IO {
+	io := &IO{data: d, clock: c, start: s, conf: conf, driver: NewDriver(s, conf, calibration)}
+	io.devs = ev3.Scan(&ev3.OutPortModes{
 		OutA: ev3.OutPortModeAuto,
 		OutB: ev3.OutPortModeAuto,
 		OutC: ev3.OutPortModeDcMotor,
 		OutD: ev3.OutPortModeDcMotor,
 	})
-	data = d
-	start = s
 
 	// Col L
-	ev3.CheckDriver(devs.In1, ev3.DriverColor, ev3.In1)
+	ev3.CheckDriver(io.devs.In1, ev3.DriverColor, ev3.In1)
 	// Col R
-	ev3.CheckDriver(devs.In2, ev3.DriverColor, ev3.In2)
+	ev3.CheckDriver(io.devs.In2, ev3.DriverColor, ev3.In2)
 	// Ir L
-	ev3.CheckDriver(devs.In3, ev3.DriverIr, ev3.In3)
+	ev3.CheckDriver(io.devs.In3, ev3.DriverIr, ev3.In3)
 	// Ir R
-	ev3.CheckDriver(devs.In4, ev3.DriverIr, ev3.In4)
+	ev3.CheckDriver(io.devs.In4, ev3.DriverIr, ev3.In4)
 
 	// A front
-	ev3.CheckDriver(devs.OutA, ev3.DriverTachoMotorMedium, ev3.OutA)
+	ev3.CheckDriver(io.devs.OutA, ev3.DriverTachoMotorMedium, ev3.OutA)
 	// B eyes
-	ev3.CheckDriver(devs.OutB, ev3.DriverTachoMotorMedium, ev3.OutB)
+	ev3.CheckDriver(io.devs.OutB, ev3.DriverTachoMotorMedium, ev3.OutB)
 	// C left direct
-	ev3.CheckDriver(devs.OutC, ev3.DriverRcxMotor, ev3.OutC)
+	ev3.CheckDriver(io.devs.OutC, ev3.DriverRcxMotor, ev3.OutC)
 	// D right inverted
-	ev3.CheckDriver(devs.OutD, ev3.DriverRcxMotor, ev3.OutD)
-
-	ev3.SetMode(devs.In1, ev3.ColorModeReflect)
-	ev3.SetMode(devs.In2, ev3.ColorModeReflect)
-	ev3.SetMode(devs.In3, ev3.IrModeProx)
-	ev3.SetMode(devs.In4, ev3.IrModeProx)
-
-	ev3.RunCommand(devs.OutA, ev3.CmdReset)
-	ev3.RunCommand(devs.OutB, ev3.CmdReset)
-	ev3.RunCommand(devs.OutC, ev3.CmdStop)
-	ev3.RunCommand(devs.OutD, ev3.CmdStop)
-
-	colL = ev3.OpenByteR(devs.In1, ev3.BinData)
-	colR = ev3.OpenByteR(devs.In2, ev3.BinData)
-	irL = ev3.OpenByteR(devs.In3, ev3.BinData)
-	irR = ev3.OpenByteR(devs.In4, ev3.BinData)
+	ev3.CheckDriver(io.devs.OutD, ev3.DriverRcxMotor, ev3.OutD)
+
+	ev3.SetMode(io.devs.In1, ev3.ColorModeReflect)
+	ev3.SetMode(io.devs.In2, ev3.ColorModeReflect)
+	ev3.SetMode(io.devs.In3, ev3.IrModeProx)
+	ev3.SetMode(io.devs.In4, ev3.IrModeProx)
+
+	ev3.RunCommand(io.devs.OutA, ev3.CmdReset)
+	ev3.RunCommand(io.devs.OutB, ev3.CmdReset)
+	ev3.RunCommand(io.devs.OutC, ev3.CmdStop)
+	ev3.RunCommand(io.devs.OutD, ev3.CmdStop)
+
+	io.colL = ev3.OpenByteR(io.devs.In1, ev3.BinData)
+	io.colR = ev3.OpenByteR(io.devs.In2, ev3.BinData)
+	io.irL = ev3.OpenByteR(io.devs.In3, ev3.BinData)
+	io.irR = ev3.OpenByteR(io.devs.In4, ev3.BinData)
 	// C left direct
-	ml = ev3.OpenTextW(devs.OutC, ev3.DutyCycleSp)
+	io.ml = ev3.OpenTextW(io.devs.OutC, ev3.DutyCycleSp)
 	// D right inverted
-	mr = ev3.OpenTextW(devs.OutD, ev3.DutyCycleSp)
+	io.mr = ev3.OpenTextW(io.devs.OutD, ev3.DutyCycleSp)
 	// B eyes
-	dme = devs.OutB
-	pme = ev3.OpenTextR(devs.OutB, ev3.Position)
-	pmesp = ev3.OpenTextW(devs.OutB, ev3.PositionSp)
+	io.dme = io.devs.OutB
+	io.pme = ev3.OpenTextR(io.devs.OutB, ev3.Position)
+	io.pmesp = ev3.OpenTextW(io.devs.OutB, ev3.PositionSp)
 	// A front
-	dmf = devs.OutA
-	mf = ev3.OpenTextW(devs.OutA, ev3.DutyCycleSp)
-
-	ledLG = ev3.OpenTextW(devs.LedLeftGreen, ev3.Brightness)
-	ledLR = ev3.OpenTextW(devs.LedLeftRed, ev3.Brightness)
-	ledRG = ev3.OpenTextW(devs.LedRightGreen, ev3.Brightness)
-	ledRR = ev3.OpenTextW(devs.LedRightRed, ev3.Brightness)
-	ledLG.Value = 0
-	ledLR.Value = 0
-	ledRG.Value = 0
-	ledRR.Value = 0
-	ledLG.Sync()
-	ledLR.Sync()
-	ledRG.Sync()
-	ledRR.Sync()
+	io.dmf = io.devs.OutA
+	io.mf = ev3.OpenTextW(io.devs.OutA, ev3.DutyCycleSp)
+
+	io.ledLG = ev3.OpenTextW(io.devs.LedLeftGreen, ev3.Brightness)
+	io.ledLR = ev3.OpenTextW(io.devs.LedLeftRed, ev3.Brightness)
+	io.ledRG = ev3.OpenTextW(io.devs.LedRightGreen, ev3.Brightness)
+	io.ledRR = ev3.OpenTextW(io.devs.LedRightRed, ev3.Brightness)
+	io.ledLG.Value = 0
+	io.ledLR.Value = 0
+	io.ledRG.Value = 0
+	io.ledRR.Value = 0
+	io.ledLG.Sync()
+	io.ledLR.Sync()
+	io.ledRG.Sync()
+	io.ledRR.Sync()
 
 	// Wheels
-	mr.Value = 0
-	ml.Value = 0
-	mr.Sync()
-	ml.Sync()
-	ev3.RunCommand(devs.OutC, ev3.CmdStop)
-	ev3.RunCommand(devs.OutD, ev3.CmdStop)
-	ev3.RunCommand(devs.OutC, ev3.CmdRunDirect)
-	ev3.RunCommand(devs.OutD, ev3.CmdRunDirect)
+	io.mr.Value = 0
+	io.ml.Value = 0
+	io.mr.Sync()
+	io.ml.Sync()
+	ev3.RunCommand(io.devs.OutC, ev3.CmdStop)
+	ev3.RunCommand(io.devs.OutD, ev3.CmdStop)
+	ev3.RunCommand(io.devs.OutC, ev3.CmdRunDirect)
+	ev3.RunCommand(io.devs.OutD, ev3.CmdRunDirect)
 
 	// Front
-	ev3.RunCommand(dmf, ev3.CmdReset)
-	mf.Value = 0
-	mf.Sync()
-	ev3.RunCommand(dmf, ev3.CmdRunDirect)
+	ev3.RunCommand(io.dmf, ev3.CmdReset)
+	io.mf.Value = 0
+	io.mf.Sync()
+	ev3.RunCommand(io.dmf, ev3.CmdRunDirect)
 
 	// Eyes
-	ev3.RunCommand(dme, ev3.CmdReset)
-	ev3.WriteStringAttribute(dme, ev3.Position, config.VisionStartPositionString)
-	ev3.WriteStringAttribute(dme, ev3.SpeedSp, config.VisionSpeed)
-	ev3.WriteStringAttribute(dme, ev3.StopAction, "hold")
-	setEyesDirection(ev3.NoDirection)
+	ev3.RunCommand(io.dme, ev3.CmdReset)
+	ev3.WriteStringAttribute(io.dme, ev3.Position, io.conf.Get().VisionStartPositionString)
+	ev3.WriteStringAttribute(io.dme, ev3.SpeedSp, strconv.Itoa(io.conf.Get().VisionSpeed))
+	ev3.WriteStringAttribute(io.dme, ev3.StopAction, "hold")
+	io.syncEyes()
+
+	io.watchdog = ev3.NewWatchdog(io.devs, time.Duration(io.conf.Get().WatchdogMillis)*time.Millisecond, io.clock)
+	io.watchdog.Start()
+	return io
 }
 
-var speedL, speedR int
-var lastMillis, currentMillis int
-
-func computeSpeed(currentSpeed int, targetSpeed int, millis int) int {
-	if currentSpeed < targetSpeed {
-		currentSpeed += (config.ForwardAcceleration * millis)
-		if currentSpeed > targetSpeed {
-			currentSpeed = targetSpeed
-		}
-	}
-	if currentSpeed > targetSpeed {
-		currentSpeed -= (config.ReverseAcceleration * millis)
-		if currentSpeed < targetSpeed {
-			currentSpeed = targetSpeed
-		}
+// ProcessCommand applies a command of the logic to the devices
+func (io *IO) ProcessCommand(c *logic.Commands) {
+	if !io.watchdog.Feed() {
+		return
 	}
-	return currentSpeed
-}
 
-func ProcessCommand(c *logic.Commands) {
-	currentMillis = c.Millis
-	millis := currentMillis - lastMillis
-	speedL = computeSpeed(speedL, c.SpeedLeft, millis)
-	speedR = computeSpeed(speedR, c.SpeedRight, millis)
-	lastMillis = currentMillis
-
-	mlValue := speedL / 100
-	mrValue := -speedR / 100
-	if mlValue > 100 {
-		mlValue = 100
-	}
-	if mlValue < -100 {
-		mlValue = -100
-	}
-	if mrValue > 100 {
-		mrValue = 100
-	}
-	if mrValue < -100 {
-		mrValue = -100
-	}
-	ml.Value = mlValue
-	mr.Value = mrValue
-	ml.Sync()
-	mr.Sync()
-
-	ledLG.Value = c.LedLeftGreen
-	ledLR.Value = c.LedLeftRed
-	ledRG.Value = c.LedRightGreen
-	ledRR.Value = c.LedRightRed
-	ledLG.Sync()
-	ledLR.Sync()
-	ledRG.Sync()
-	ledRR.Sync()
-
-	if c.FrontActive {
-		mf.Value = config.FrontWheelsSpeed
-	} else {
-		mf.Value = 0
-	}
-	mf.Sync()
-
-	// fmt.Fprintln(os.Stderr, "DATA EYES ACTIVE", c.EyesActive)
-
-	if !c.EyesActive {
-		vision.Reset()
-		setEyesDirection(ev3.NoDirection)
-	} else {
-		if getEyesDirection() == ev3.NoDirection {
-			vision.Reset()
-			setEyesDirection(ev3.Right)
-		}
-	}
+	out := io.driver.Command(c)
+	io.ml.Value = out.MotorLeft
+	io.mr.Value = -out.MotorRight
+	io.ml.Sync()
+	io.mr.Sync()
+
+	io.ledLG.Value = out.LedLeftGreen
+	io.ledLR.Value = out.LedLeftRed
+	io.ledRG.Value = out.LedRightGreen
+	io.ledRR.Value = out.LedRightRed
+	io.ledLG.Sync()
+	io.ledLR.Sync()
+	io.ledRG.Sync()
+	io.ledRR.Sync()
+
+	io.mf.Value = out.Front
+	io.mf.Sync()
+
+	io.syncEyes()
 }
 
 // Loop contains the io loop
-func Loop() {
+func (io *IO) Loop() {
+	defer ev3.Recover()
+
 	for {
-		now := time.Now()
-		millis := ev3.TimespanAsMillis(start, now)
-
-		pme.Sync()
-		colR.Sync()
-		colL.Sync()
-		irR.Sync()
-		irL.Sync()
-
-		visionIntensity, visionAngle, eyesDirection := 0, 0, getEyesDirection()
-		if eyesDirection != ev3.NoDirection {
-			// fmt.Fprintln(os.Stderr, "EYES PROCESS", eyesDirection)
-			visionIntensity, visionAngle, eyesDirection = vision.Process(millis, eyesDirection, pme.Value, irL.Value, irR.Value)
-			setEyesDirection(eyesDirection)
-		}
-
-		// fmt.Fprintln(os.Stderr, "DATA", colL.Value, colR.Value, irL.Value, irR.Value)
-
-		data <- logic.Data{
-			Start:            start,
-			Millis:           millis,
-			CornerRightIsOut: colorIsOut(colR.Value),
-			CornerLeftIsOut:  colorIsOut(colL.Value),
-			CornerRight:      colR.Value,
-			CornerLeft:       colL.Value,
-			IrValueRight:     irR.Value,
-			IrValueLeft:      irL.Value,
-			VisionIntensity:  visionIntensity,
-			VisionAngle:      visionAngle,
-		}
+		now := io.clock.Now()
+		millis := ev3.TimespanAsMillis(io.start, now)
+
+		io.pme.Sync()
+		io.colR.Sync()
+		io.colL.Sync()
+		io.irR.Sync()
+		io.irL.Sync()
+
+		data := io.driver.Read(millis, Sensors{
+			CornerLeft:   io.colL.Value,
+			CornerRight:  io.colR.Value,
+			IrLeft:       io.irL.Value,
+			IrRight:      io.irR.Value,
+			EyesPosition: io.pme.Value,
+		})
+		io.syncEyes()
+		io.data <- data
 	}
 }
 
 // Close terminates and cleans up the io module
-func Close() {
-	defer ev3.RunCommand(devs.OutA, ev3.CmdReset)
-	defer ev3.RunCommand(devs.OutB, ev3.CmdReset)
-	defer ev3.RunCommand(devs.OutC, ev3.CmdStop)
-	defer ev3.RunCommand(devs.OutD, ev3.CmdStop)
-
-	defer ev3.RunCommand(devs.OutA, ev3.CmdStop)
-	defer ev3.RunCommand(devs.OutB, ev3.CmdStop)
-
-	ledLG.Value = 0
-	ledLR.Value = 0
-	ledRG.Value = 0
-	ledRR.Value = 0
-	ledLG.Sync()
-	ledLR.Sync()
-	ledRG.Sync()
-	ledRR.Sync()
+func (io *IO) Close() {
+	io.watchdog.Stop()
+
+	defer ev3.RunCommand(io.devs.OutA, ev3.CmdReset)
+	defer ev3.RunCommand(io.devs.OutB, ev3.CmdReset)
+	defer ev3.RunCommand(io.devs.OutC, ev3.CmdStop)
+	defer ev3.RunCommand(io.devs.OutD, ev3.CmdStop)
+
+	defer ev3.RunCommand(io.devs.OutA, ev3.CmdStop)
+	defer ev3.RunCommand(io.devs.OutB, ev3.CmdStop)
+
+	io.ledLG.Value = 0
+	io.ledLR.Value = 0
+	io.ledRG.Value = 0
+	io.ledRR.Value = 0
+	io.ledLG.Sync()
+	io.ledLR.Sync()
+	io.ledRG.Sync()
+	io.ledRR.Sync()
 
 	// TODO: close all files
-	// pf, mf, ml, mc, mr
-	// colR, colL, irR, irL
+	// pf, io.mf, io.ml, mc, io.mr
+	// io.colR, io.colL, io.irR, io.irL
 }
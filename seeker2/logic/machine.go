@@ -0,0 +1,48 @@
+package logic
+
+import (
+	"context"
+	"go-bots/behaviour"
+	"go-bots/ev3"
+)
+
+// machineBot lets the machines drive the logic, it keeps the last reading for their phases
+type machineBot struct {
+	l *Logic
+}
+
+// Read takes the next reading
+func (b machineBot) Read(ctx context.Context) (int, bool) {
+	select {
+	case d := <-b.l.data:
+		b.l.d = d
+		now, _ := b.l.handleTime(d, 0)
+		return now, true
+	case <-ctx.Done():
+		return 0, false
+	}
+}
+
+// Drive commands the speeds and shows the last reading on the leds
+func (b machineBot) Drive(left int, right int, front bool) {
+	b.l.speed(left, right)
+	b.l.ledsFromData(b.l.d)
+	b.l.cmd(true, front)
+}
+
+// Corners returns the corner sensors of the last reading
+func (b machineBot) Corners() (int, int) {
+	return b.l.d.CornerLeft, b.l.d.CornerRight
+}
+
+// Hold stops the wheels and shows the given leds
+func (b machineBot) Hold(leftGreen int, rightGreen int, leftRed int, rightRed int) {
+	b.l.speed(0, 0)
+	b.l.leds(leftGreen, rightGreen, leftRed, rightRed)
+	b.l.cmd(false, false)
+}
+
+// runMachine runs the phases of m from first on
+func (l *Logic) runMachine(ctx context.Context, m *behaviour.Machine, start int, dir ev3.Direction, first int) {
+	l.runner.RunMachine(ctx, machineBot{l}, m, start, dir, first)
+}
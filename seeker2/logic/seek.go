@@ -1,109 +1,99 @@
 package logic
 
 import (
-	"fmt"
+	"context"
+	"go-bots/behaviour"
 	"go-bots/ev3"
-	"go-bots/seeker2/config"
-	"os"
 )
 
-func seekMove(start int, dir ev3.Direction, leftSpeed int, rightSpeed int, duration int, ignoreBorder bool) (done bool, now int) {
-	for {
-		select {
-		case d := <-data:
-			now, elapsed := handleTime(d, start)
-			if elapsed >= duration {
-				return false, now
-			}
-
-			if checkVision(d, now) {
-				return true, now
-			}
-			if (!ignoreBorder) && checkBorder(d, now) {
-				return true, now
-			}
-
-			speed(leftSpeed, rightSpeed)
-			ledsFromData(d)
-			cmd(true, false)
-		case k := <-keys:
-			if checkDone(k) {
-				return true, now
-			}
-		}
+func (l *Logic) backTurnMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "BACK",
+		Phases: []behaviour.Phase{
+			{
+				Name:       "BACK MOVE",
+				Millis:     l.conf.BackTurn1Millis,
+				OnData:     true,
+				Outer:      -l.conf.BackTurn1SpeedInner,
+				Inner:      -l.conf.BackTurn1SpeedOuter,
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+			{
+				Name:       "BACK TURN",
+				Millis:     l.conf.BackTurn2Millis,
+				OnData:     true,
+				Outer:      l.conf.BackTurn2Speed,
+				Inner:      -l.conf.BackTurn2Speed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+		},
+		Next: l.seekMoving,
 	}
 }
 
-func back(start int, dir ev3.Direction) {
-
-	done, now := false, start
-	fmt.Fprintln(os.Stderr, "BACK", dir)
-
-	if dir == ev3.Right {
-		done, now = seekMove(now, dir, -config.BackTurn1SpeedInner, -config.BackTurn1SpeedOuter, config.BackTurn1Millis, true)
-		if done {
-			return
-		}
-		fmt.Fprintln(os.Stderr, "BACK TURN", dir)
-		done, now = seekMove(now, dir, config.BackTurn2Speed, -config.BackTurn2Speed, config.BackTurn2Millis, false)
-		if done {
-			return
-		}
-	} else if dir == ev3.Left {
-		done, now = seekMove(now, dir, -config.BackTurn1SpeedOuter, -config.BackTurn1SpeedInner, config.BackTurn1Millis, true)
-		if done {
-			return
-		}
-		fmt.Fprintln(os.Stderr, "BACK TURN", dir)
-		done, now = seekMove(now, dir, -config.BackTurn2Speed, config.BackTurn2Speed, config.BackTurn2Millis, false)
-		if done {
-			return
-		}
-	} else {
-		dir = ev3.Right
-		done, now = seekMove(now, dir, -config.BackMoveSpeed, -config.BackMoveSpeed, config.BackMoveMillis, true)
-		if done {
-			return
-		}
-		fmt.Fprintln(os.Stderr, "BACK TURN", dir)
-		done, now = seekMove(now, dir, config.BackTurn3Speed, -config.BackTurn3Speed, config.BackTurn3Millis, false)
-		if done {
-			return
-		}
+func (l *Logic) backStraightMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "BACK",
+		Phases: []behaviour.Phase{
+			{
+				Name:       "BACK MOVE",
+				Millis:     l.conf.BackMoveMillis,
+				OnData:     true,
+				Outer:      -l.conf.BackMoveSpeed,
+				Inner:      -l.conf.BackMoveSpeed,
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+			{
+				Name:       "BACK TURN",
+				Millis:     l.conf.BackTurn3Millis,
+				OnData:     true,
+				Outer:      l.conf.BackTurn3Speed,
+				Inner:      -l.conf.BackTurn3Speed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+		},
+		Next: l.seekMoving,
 	}
-
-	go seekMoving(now, dir)
 }
 
-func seekMoving(start int, dir ev3.Direction) {
-	seek(start, dir, false)
-}
-func seekTurning(start int, dir ev3.Direction) {
-	seek(start, dir, true)
+func (l *Logic) back(ctx context.Context, start int, dir ev3.Direction) {
+	if dir == ev3.NoDirection {
+		l.runMachine(ctx, l.backStraightMachine(), start, ev3.Right, 0)
+		return
+	}
+	l.runMachine(ctx, l.backTurnMachine(), start, dir, 0)
 }
 
-func seek(start int, dir ev3.Direction, skipFirstMove bool) {
-
-	fmt.Fprintln(os.Stderr, "SEEK", dir)
-
-	done, now := false, start
-	for {
+func (l *Logic) seekMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "SEEK",
+		Phases: []behaviour.Phase{
+			{
+				Name:       "SEEK MOVE",
+				Millis:     l.conf.SeekMoveMillis,
+				OnData:     true,
+				Outer:      l.conf.SeekMoveSpeed,
+				Inner:      l.conf.SeekMoveSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+			{
+				Name:       "SEEK TURN",
+				Millis:     l.conf.SeekTurnMillis,
+				OnData:     true,
+				Outer:      l.conf.SeekTurnSpeed,
+				Inner:      -l.conf.SeekTurnSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+				FlipDir:    true,
+			},
+		},
+		Loop: true,
+	}
+}
 
-		if !skipFirstMove {
-			fmt.Fprintln(os.Stderr, "SEEK MOVE", dir, now)
-			done, now = seekMove(now, dir, config.SeekMoveSpeed, config.SeekMoveSpeed, config.SeekMoveMillis, false)
-			if done {
-				return
-			}
-		} else {
-			skipFirstMove = false
-		}
+func (l *Logic) seekMoving(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.seekMachine(), start, dir, 0)
+}
 
-		fmt.Fprintln(os.Stderr, "SEEK TURN", dir, now)
-		done, now = seekMove(now, dir, config.SeekTurnSpeed*ev3.LeftTurnVersor(dir), config.SeekTurnSpeed*ev3.RightTurnVersor(dir), config.SeekTurnMillis, false)
-		if done {
-			return
-		}
-		dir = ev3.ChangeDirection(dir)
-	}
+func (l *Logic) seekTurning(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.seekMachine(), start, dir, 1)
 }
@@ -1,353 +1,229 @@
 package logic
 
 import (
-	"fmt"
+	"context"
+	"go-bots/behaviour"
+	"go-bots/botconf"
+	"go-bots/cmdline"
 	"go-bots/ev3"
-	"go-bots/seeker2/config"
 	"go-bots/ui"
-	"os"
 )
 
-func pauseBeforeBegin(start int, strategy func(int, ev3.Direction), dir ev3.Direction) {
-	for {
-		select {
-		case d := <-data:
-			now, elapsed := handleTime(d, start)
-			if elapsed >= config.StartTime {
-				go strategy(now, dir)
-				return
-			}
-			speed(0, 0)
-			intensity := ((elapsed % 1000) * 255) / (config.StartTime / 5)
-			if elapsed > (config.StartTime * 4 / 5) {
-				leds(intensity, intensity, intensity, intensity)
-			} else {
-				leds(0, 0, intensity, intensity)
-			}
-			c.EyesActive = false
-			cmd(false, false)
-		case k := <-keys:
-			if checkDone(k) {
+// pauseBeforeBegin waits for the start time and then hands off to strategy
+func (l *Logic) pauseBeforeBegin(strategy behaviour.Func) behaviour.Func {
+	return func(ctx context.Context, start int, dir ev3.Direction) {
+		l.runner.EnterState(start, "pauseBeforeBegin", "", dir)
+		behaviour.Log(start, dir, "profile "+botconf.ProfileLabel(l.conf.Profile))
+		for {
+			select {
+			case d := <-l.data:
+				now, elapsed := l.handleTime(d, start)
+				if elapsed >= l.conf.StartTime {
+					l.runner.HandOff(ctx, strategy, now, dir)
+					return
+				}
+				l.speed(0, 0)
+				intensity := ((elapsed % 1000) * 255) / (l.conf.StartTime / 5)
+				if elapsed > (l.conf.StartTime * 4 / 5) {
+					l.leds(intensity, intensity, intensity, intensity)
+				} else {
+					l.leds(0, 0, intensity, intensity)
+				}
+				l.c.EyesActive = false
+				l.cmd(false, false)
+			case <-ctx.Done():
 				return
 			}
 		}
 	}
 }
 
-func chooseStrategy(start int) {
-	strategy := seekMoving
+func (l *Logic) chooseStrategy(ctx context.Context, start int, dir ev3.Direction) {
+	l.runner.SetInMenu(true)
+	defer l.runner.SetInMenu(false)
+	l.reloadConfig()
+
+	strategy := l.seekMoving
 	strategyIsGoForward := false
-	var dir ev3.Direction = ev3.Left
-	leds(0, 0, 0, 0)
-	speed(0, 0)
-	cmd(false, false)
-	fmt.Fprintln(os.Stderr, "chooseStrategy START")
+	dir = ev3.Left
+	l.runner.EnterState(start, "chooseStrategy", "", dir)
+	l.leds(0, 0, 0, 0)
+	l.speed(0, 0)
+	l.cmd(false, false)
+	cmdline.Log(cmdline.Info, "chooseStrategy START")
 
 	for {
 		select {
-		case d := <-data:
-			handleTime(d, start)
-			speed(0, 0)
-			cmd(false, false)
-		case k := <-keys:
-			checkQuit(k)
+		case d := <-l.data:
+			l.handleTime(d, start)
+			l.refreshConfig()
+			l.speed(0, 0)
+			l.cmd(false, false)
+		case k := <-l.runner.MenuKeys():
 			if k.Key == ui.Enter {
-				go pauseBeforeBegin(k.Millis, strategy, dir)
+				l.runner.HandOff(ctx, l.pauseBeforeBegin(strategy), k.Millis, dir)
+				return
+			} else if k.Key == ui.Profile {
+				l.selectNextProfile()
+			} else if k.Key == ui.Calibrate {
+				l.runner.HandOff(ctx, l.calibrateBorder, k.Millis, dir)
 				return
 			} else if k.Key == ui.Left {
 				dir = ev3.Left
-				strategy = circle
+				strategy = l.circle
 				strategyIsGoForward = false
-				leds(255, 0, 255, 0)
-				fmt.Fprintln(os.Stderr, "chooseStrategy circle left")
+				l.leds(255, 0, 255, 0)
+				cmdline.Log(cmdline.Info, "chooseStrategy circle left")
 			} else if k.Key == ui.Right {
 				dir = ev3.Right
-				strategy = circle
+				strategy = l.circle
 				strategyIsGoForward = false
-				leds(0, 255, 0, 255)
-				fmt.Fprintln(os.Stderr, "chooseStrategy circle right")
+				l.leds(0, 255, 0, 255)
+				cmdline.Log(cmdline.Info, "chooseStrategy circle right")
 			} else if k.Key == ui.Up {
 				if strategyIsGoForward {
-					strategy = seekMoving
+					strategy = l.seekMoving
 					strategyIsGoForward = false
-					leds(0, 0, 0, 0)
-					fmt.Fprintln(os.Stderr, "chooseStrategy seek")
+					l.leds(0, 0, 0, 0)
+					cmdline.Log(cmdline.Info, "chooseStrategy seek")
 				} else {
-					strategy = goForward
+					strategy = l.goForward
 					strategyIsGoForward = true
 					if dir == ev3.Left {
-						leds(255, 0, 0, 0)
-						fmt.Fprintln(os.Stderr, "chooseStrategy forward left")
+						l.leds(255, 0, 0, 0)
+						cmdline.Log(cmdline.Info, "chooseStrategy forward left")
 					} else {
-						leds(0, 255, 0, 0)
-						fmt.Fprintln(os.Stderr, "chooseStrategy forward right")
+						l.leds(0, 255, 0, 0)
+						cmdline.Log(cmdline.Info, "chooseStrategy forward right")
 					}
 				}
 			} else if k.Key == ui.Down {
-				strategy = turnBack
+				strategy = l.turnBack
 				strategyIsGoForward = false
 				if dir == ev3.Left {
-					leds(0, 0, 255, 0)
-					fmt.Fprintln(os.Stderr, "chooseStrategy back left")
+					l.leds(0, 0, 255, 0)
+					cmdline.Log(cmdline.Info, "chooseStrategy back left")
 				} else {
-					leds(0, 0, 0, 255)
-					fmt.Fprintln(os.Stderr, "chooseStrategy back right")
+					l.leds(0, 0, 0, 255)
+					cmdline.Log(cmdline.Info, "chooseStrategy back right")
 				}
 			}
-			speed(0, 0)
-			cmd(false, false)
+			l.speed(0, 0)
+			l.cmd(false, false)
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func circle(start int, dir ev3.Direction) {
-	now, elapsed := start, 0
-
-	log(now, dir, "CIRCLE find border")
-findBorder:
-	for {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-			if dir == ev3.Right {
-				if d.CornerRightIsOut {
-					break findBorder
-				}
-				if elapsed < config.CircleFindBorderMillis {
-					speed(config.CircleFindBorderOuterSpeed, -config.CircleFindBorderInnerSpeed)
-				} else {
-					speed(config.CircleFindBorderOuterSpeedSlow, -config.CircleFindBorderInnerSpeedSlow)
-				}
-			} else {
-				if d.CornerLeftIsOut {
-					break findBorder
-				}
-				if elapsed < config.CircleFindBorderMillis {
-					speed(-config.CircleFindBorderInnerSpeed, config.CircleFindBorderOuterSpeed)
-				} else {
-					speed(-config.CircleFindBorderInnerSpeedSlow, config.CircleFindBorderOuterSpeedSlow)
-				}
-			}
-			ledsFromData(d)
-			cmd(true, false)
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	log(now, dir, "CIRCLE start")
-	dir = ev3.ChangeDirection(dir)
-	borderFoundTime := elapsed
-	for elapsed-borderFoundTime < config.CircleMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				cornerValue := d.CornerLeft
-				adjustInner := cornerValue * config.CircleAdjustInnerMax / 100
-				inner := config.CircleInnerSpeedRight - adjustInner
-				speed(config.CircleOuterSpeed, inner)
-			} else {
-				cornerValue := d.CornerRight
-				adjustInner := cornerValue * config.CircleAdjustInnerMax / 100
-				inner := config.CircleInnerSpeedLeft - adjustInner
-				speed(inner, config.CircleOuterSpeed)
-			}
-
-			ledsFromData(d)
-			cmd(true, false)
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	log(now, dir, "CIRCLE spiral")
-	circleDoneTime := elapsed
-	for elapsed-circleDoneTime < config.CircleSpiralMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkBorder(d, now) {
-				return
-			}
-			if checkVision(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				speed(config.CircleSpiralOuterSpeed, config.CircleSpiralInnerSpeed)
-			} else {
-				speed(config.CircleSpiralInnerSpeed, config.CircleSpiralOuterSpeed)
-			}
-
-			ledsFromData(d)
-			cmd(true, false)
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) circleMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "CIRCLE",
+		Phases: []behaviour.Phase{
+			{
+				Name: "CIRCLE find border",
+				Steer: func(r *behaviour.Run) (int, int) {
+					if r.Elapsed < l.conf.CircleFindBorderMillis {
+						return behaviour.Sides(r.Dir, l.conf.CircleFindBorderOuterSpeed, -l.conf.CircleFindBorderInnerSpeed)
+					}
+					return behaviour.Sides(r.Dir, l.conf.CircleFindBorderOuterSpeedSlow, -l.conf.CircleFindBorderInnerSpeedSlow)
+				},
+				Until: func(r *behaviour.Run) bool {
+					if r.Dir == ev3.Right {
+						return l.d.CornerRightIsOut
+					}
+					return l.d.CornerLeftIsOut
+				},
+				FlipDir: true,
+			},
+			{
+				Name:   "CIRCLE start",
+				Millis: l.conf.CircleMillis,
+				Steer: func(r *behaviour.Run) (int, int) {
+					if r.Dir == ev3.Right {
+						adjustInner := l.d.CornerLeft * l.conf.CircleAdjustInnerMax / 100
+						return l.conf.CircleOuterSpeed, l.conf.CircleInnerSpeedRight - adjustInner
+					}
+					adjustInner := l.d.CornerRight * l.conf.CircleAdjustInnerMax / 100
+					return l.conf.CircleInnerSpeedLeft - adjustInner, l.conf.CircleOuterSpeed
+				},
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+			{
+				Name:       "CIRCLE spiral",
+				Millis:     l.conf.CircleSpiralMillis,
+				Outer:      l.conf.CircleSpiralOuterSpeed,
+				Inner:      l.conf.CircleSpiralInnerSpeed,
+				Interrupts: []behaviour.Check{l.checkBorder, l.checkVision},
+			},
+		},
+		Next:     l.seekMoving,
+		FlipNext: true,
 	}
-
-	log(now, dir, "CIRCLE done")
-	go seekMoving(now, ev3.ChangeDirection(dir))
 }
 
-func goForward(start int, dir ev3.Direction) {
-	now, elapsed := start, 0
-
-	fmt.Fprintln(os.Stderr, "goForward", now, dir)
-
-	for elapsed < config.GoForwardMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			speed(config.GoForwardSpeed, config.GoForwardSpeed)
-
-			ledsFromData(d)
-			cmd(true, false)
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	fmt.Fprintln(os.Stderr, "goForward turn", now, dir)
-
-	forwardDone := elapsed
-	for elapsed-forwardDone < config.GoForwardTurnMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				speed(config.GoForwardTurnOuterSpeed, config.GoForwardTurnInnerSpeed)
-			} else {
-				speed(config.GoForwardTurnInnerSpeed, config.GoForwardTurnOuterSpeed)
-			}
-
-			ledsFromData(d)
-			cmd(true, false)
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	fmt.Fprintln(os.Stderr, "goForward done", now, dir)
-	go seekMoving(now, ev3.ChangeDirection(dir))
+func (l *Logic) circle(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.circleMachine(), start, dir, 0)
 }
 
-func turnBack(start int, dir ev3.Direction) {
-	now, elapsed := start, 0
-
-	fmt.Fprintln(os.Stderr, "turnBack", now, dir)
-
-	for elapsed < config.TurnBackPreMoveMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			speed(config.TurnBackPreMoveSpeed, config.TurnBackPreMoveSpeed)
-
-			ledsFromData(d)
-			cmd(true, false)
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) goForwardMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "goForward",
+		Phases: []behaviour.Phase{
+			{
+				Name:       "goForward move",
+				Millis:     l.conf.GoForwardMillis,
+				Outer:      l.conf.GoForwardSpeed,
+				Inner:      l.conf.GoForwardSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+			{
+				Name:       "goForward turn",
+				Millis:     l.conf.GoForwardTurnMillis,
+				Outer:      l.conf.GoForwardTurnOuterSpeed,
+				Inner:      l.conf.GoForwardTurnInnerSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+		},
+		Next:     l.seekMoving,
+		FlipNext: true,
 	}
+}
 
-	fmt.Fprintln(os.Stderr, "turnBack turn", now, dir)
-	turnBackPreMoveDone := elapsed
-	for elapsed-turnBackPreMoveDone < config.TurnBackMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				speed(config.TurnBackOuterSpeed, config.TurnBackInnerSpeed)
-			} else {
-				speed(config.TurnBackInnerSpeed, config.TurnBackOuterSpeed)
-			}
-
-			ledsFromData(d)
-			cmd(true, false)
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	fmt.Fprintln(os.Stderr, "turnBack move", now, dir)
-
-	turnBackMoveDone := elapsed
-	for elapsed-turnBackMoveDone < config.TurnBackMoveMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			speed(config.TurnBackMoveSpeed, config.TurnBackMoveSpeed)
+func (l *Logic) goForward(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.goForwardMachine(), start, dir, 0)
+}
 
-			ledsFromData(d)
-			cmd(true, false)
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) turnBackMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "turnBack",
+		Phases: []behaviour.Phase{
+			{
+				Name:       "turnBack pre move",
+				Millis:     l.conf.TurnBackPreMoveMillis,
+				Outer:      l.conf.TurnBackPreMoveSpeed,
+				Inner:      l.conf.TurnBackPreMoveSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+			{
+				Name:       "turnBack turn",
+				Millis:     l.conf.TurnBackMillis,
+				Outer:      l.conf.TurnBackOuterSpeed,
+				Inner:      l.conf.TurnBackInnerSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+			{
+				Name:       "turnBack move",
+				Millis:     l.conf.TurnBackMoveMillis,
+				Outer:      l.conf.TurnBackMoveSpeed,
+				Inner:      l.conf.TurnBackMoveSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+		},
+		Next: l.seekTurning,
 	}
+}
 
-	fmt.Fprintln(os.Stderr, "turnBack done", now, dir)
-	go seekTurning(now, dir)
+func (l *Logic) turnBack(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.turnBackMachine(), start, dir, 0)
 }
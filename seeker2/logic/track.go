@@ -1,94 +1,74 @@
 package logic
 
 import (
-	"fmt"
+	"context"
+	"go-bots/behaviour"
+	"go-bots/cmdline"
 	"go-bots/ev3"
-	"go-bots/seeker2/config"
-	"os"
 )
 
-func checkVision(d Data, now int) bool {
-	result := d.VisionIntensity > 0
+func (l *Logic) checkVision(ctx context.Context, r *behaviour.Run) bool {
+	result := l.d.VisionIntensity > 0
 	if result {
-		go track(now)
+		l.runner.HandOff(ctx, l.track, r.Now, ev3.NoDirection)
 	}
 	return result
 }
 
 const trackPrintMillis = 250
 
-func track(start int) {
-	now, _ := start, 0
-	var dir ev3.Direction = ev3.Right
-
-	fmt.Fprintln(os.Stderr, "TRACK")
+func (l *Logic) trackMachine() *behaviour.Machine {
 	printTick := 0
-
-	for {
-		select {
-		case d := <-data:
-			now, _ = handleTime(d, start)
-
-			if d.VisionIntensity == 0 {
-				go seekTurning(now, dir)
-				return
-			}
-			if d.VisionIntensity < config.VisionIgnoreBorderValue && checkBorder(d, now) {
-				return
-			}
-
-			if d.VisionAngle > config.TrackSemiFrontAngle {
-				dir = ev3.Right
-				speedCorrectionAngle := config.VisionMaxAngle - d.VisionAngle
-				speedCorrection := config.TrackSpeedReductionMax * speedCorrectionAngle / config.TrackSpeedReductionAngle
-				// speedCorrection = speedCorrection * 200 / 400
-
-				if (now / trackPrintMillis) >= printTick {
-					printTick = (now / trackPrintMillis) + 1
-					fmt.Fprintln(os.Stderr, "TRACK RIGHT", d.VisionIntensity, d.VisionAngle, speedCorrection)
-				}
-
-				speed(config.TrackOuterSpeed, config.TrackInnerSpeed+speedCorrection)
-			} else if d.VisionAngle > config.TrackFrontAngle {
-				if (now / trackPrintMillis) >= printTick {
-					printTick = (now / trackPrintMillis) + 1
-					fmt.Fprintln(os.Stderr, "TRACK FRONT RIGHT", d.VisionIntensity, d.VisionAngle)
-				}
-				speed(config.TrackOuterSpeed, config.TrackSemiFrontInnerSpeed)
-			} else if d.VisionAngle < -config.TrackSemiFrontAngle {
-				dir = ev3.Left
-				speedCorrectionAngle := config.VisionMaxAngle + d.VisionAngle
-				speedCorrection := config.TrackSpeedReductionMax * speedCorrectionAngle / config.TrackSpeedReductionAngle
-				// speedCorrection = speedCorrection * 200 / 400
-
-				if (now / trackPrintMillis) >= printTick {
-					printTick = (now / trackPrintMillis) + 1
-					fmt.Fprintln(os.Stderr, "TRACK LEFT", d.VisionIntensity, d.VisionAngle, speedCorrection)
-				}
-
-				speed(config.TrackInnerSpeed+speedCorrection, config.TrackOuterSpeed)
-			} else if d.VisionAngle < -config.TrackFrontAngle {
-				if (now / trackPrintMillis) >= printTick {
-					printTick = (now / trackPrintMillis) + 1
-					fmt.Fprintln(os.Stderr, "TRACK FRONT LEFT", d.VisionIntensity, d.VisionAngle)
-				}
-				speed(config.TrackSemiFrontInnerSpeed, config.TrackOuterSpeed)
-			} else {
-
-				if (now / trackPrintMillis) >= printTick {
-					printTick = (now / trackPrintMillis) + 1
-					fmt.Fprintln(os.Stderr, "TRACK FRONT", d.VisionIntensity, d.VisionAngle)
-				}
-
-				speed(config.TrackMaxSpeed, config.TrackMaxSpeed)
-			}
-			// fmt.Fprintln(os.Stderr, "TRACK time ", now, ", speed", c.SpeedLeft, c.SpeedRight)
-			ledsFromData(d)
-			cmd(true, true)
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
+	printTrack := func(now int, v ...interface{}) {
+		if (now / trackPrintMillis) >= printTick {
+			printTick = (now / trackPrintMillis) + 1
+			cmdline.Log(cmdline.Info, v...)
 		}
 	}
+
+	return &behaviour.Machine{
+		Name: "TRACK",
+		Phases: []behaviour.Phase{
+			{
+				Name: "TRACK follow",
+				Steer: func(r *behaviour.Run) (int, int) {
+					if l.d.VisionAngle > l.conf.TrackSemiFrontAngle {
+						r.Dir = ev3.Right
+						speedCorrectionAngle := l.conf.VisionMaxAngle - l.d.VisionAngle
+						speedCorrection := l.conf.TrackSpeedReductionMax * speedCorrectionAngle / l.conf.TrackSpeedReductionAngle
+						printTrack(r.Now, "TRACK RIGHT", l.d.VisionIntensity, l.d.VisionAngle, speedCorrection)
+						return l.conf.TrackOuterSpeed, l.conf.TrackInnerSpeed + speedCorrection
+					} else if l.d.VisionAngle > l.conf.TrackFrontAngle {
+						printTrack(r.Now, "TRACK FRONT RIGHT", l.d.VisionIntensity, l.d.VisionAngle)
+						return l.conf.TrackOuterSpeed, l.conf.TrackSemiFrontInnerSpeed
+					} else if l.d.VisionAngle < -l.conf.TrackSemiFrontAngle {
+						r.Dir = ev3.Left
+						speedCorrectionAngle := l.conf.VisionMaxAngle + l.d.VisionAngle
+						speedCorrection := l.conf.TrackSpeedReductionMax * speedCorrectionAngle / l.conf.TrackSpeedReductionAngle
+						printTrack(r.Now, "TRACK LEFT", l.d.VisionIntensity, l.d.VisionAngle, speedCorrection)
+						return l.conf.TrackInnerSpeed + speedCorrection, l.conf.TrackOuterSpeed
+					} else if l.d.VisionAngle < -l.conf.TrackFrontAngle {
+						printTrack(r.Now, "TRACK FRONT LEFT", l.d.VisionIntensity, l.d.VisionAngle)
+						return l.conf.TrackSemiFrontInnerSpeed, l.conf.TrackOuterSpeed
+					}
+					printTrack(r.Now, "TRACK FRONT", l.d.VisionIntensity, l.d.VisionAngle)
+					return l.conf.TrackMaxSpeed, l.conf.TrackMaxSpeed
+				},
+				Until: func(r *behaviour.Run) bool {
+					return l.d.VisionIntensity == 0
+				},
+				Interrupts: []behaviour.Check{
+					func(ctx context.Context, r *behaviour.Run) bool {
+						return l.d.VisionIntensity < l.conf.VisionIgnoreBorderValue && l.checkBorder(ctx, r)
+					},
+				},
+				Front: true,
+			},
+		},
+		Next: l.seekTurning,
+	}
+}
+
+func (l *Logic) track(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.trackMachine(), start, ev3.Right, 0)
 }
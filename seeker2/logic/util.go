@@ -1,24 +1,50 @@
 package logic
 
 import (
-	"fmt"
+	"context"
+	"go-bots/behaviour"
+	"go-bots/botconf"
+	"go-bots/cmdline"
 	"go-bots/ev3"
-	"go-bots/seeker2/config"
-	"go-bots/ui"
-	"os"
 )
 
-func log(now int, dir ev3.Direction, msg string) {
-	dirString := ""
-	if dir == ev3.Left {
-		dirString = "LEFT"
-	} else if dir == ev3.Right {
-		dirString = "RIGHT"
+// reloadConfig rereads the configuration file, between rounds
+func (l *Logic) reloadConfig() {
+	err := l.file.Reload()
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error reloading configuration, keeping the previous one:", err)
 	} else {
-		dirString = "NONE"
+		cmdline.Log(cmdline.Info, "Configuration reloaded:\n"+botconf.String(l.file.Get()))
 	}
+	l.conf = l.file.Get()
+}
 
-	fmt.Fprintln(os.Stderr, now, dirString, msg)
+// refreshConfig picks up the configuration file when it changes on disk,
+// it is called where swapping the configuration is safe
+func (l *Logic) refreshConfig() {
+	changed, err := l.file.ReloadIfChanged()
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error reading the changed configuration, keeping the previous one:", err)
+	} else if changed {
+		cmdline.Log(cmdline.Info, "Configuration changed:\n"+botconf.String(l.file.Get()))
+	}
+	l.conf = l.file.Get()
+}
+
+// selectNextProfile switches to the next profile of the configuration file
+func (l *Logic) selectNextProfile() {
+	names, err := l.file.ProfileNames()
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error reading profiles:", err)
+		return
+	}
+	err = l.file.SelectProfile(botconf.NextProfile(names, l.conf.Profile))
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error switching profile, keeping the previous one:", err)
+	} else {
+		cmdline.Log(cmdline.Info, "Configuration loaded:\n"+botconf.String(l.file.Get()))
+	}
+	l.conf = l.file.Get()
 }
 
 func abs(v int) int {
@@ -28,22 +54,22 @@ func abs(v int) int {
 	return v
 }
 
-func cmd(eyesActive bool, frontActive bool) {
-	c.EyesActive = eyesActive
-	c.FrontActive = frontActive
-	commandProcessor(&c)
+func (l *Logic) cmd(eyesActive bool, frontActive bool) {
+	l.c.EyesActive = eyesActive
+	l.c.FrontActive = frontActive
+	l.commandProcessor(&l.c)
 }
 
-func handleTime(d Data, start int) (now int, elapsed int) {
+func (l *Logic) handleTime(d Data, start int) (now int, elapsed int) {
 	now = d.Millis
-	c.Millis = now
+	l.c.Millis = now
 	elapsed = now - start
 	return
 }
 
-func speed(left int, right int) {
-	c.SpeedLeft = left
-	c.SpeedRight = right
+func (l *Logic) speed(left int, right int) {
+	l.c.SpeedLeft = left
+	l.c.SpeedRight = right
 }
 
 func normalizeLedValue(v int) int {
@@ -56,73 +82,56 @@ func normalizeLedValue(v int) int {
 	return v
 }
 
-func leds(leftGreen int, rightGreen int, leftRed int, rightRed int) {
+func (l *Logic) leds(leftGreen int, rightGreen int, leftRed int, rightRed int) {
 	leftGreen = normalizeLedValue(leftGreen)
 	rightGreen = normalizeLedValue(rightGreen)
 	leftRed = normalizeLedValue(leftRed)
 	rightRed = normalizeLedValue(rightRed)
-	c.LedLeftGreen = leftGreen
-	c.LedRightGreen = rightGreen
-	c.LedLeftRed = leftRed
-	c.LedRightRed = rightRed
+	l.c.LedLeftGreen = leftGreen
+	l.c.LedRightGreen = rightGreen
+	l.c.LedLeftRed = leftRed
+	l.c.LedRightRed = rightRed
 }
 
-func ledsFromData(d Data) {
-	green := 255 * d.VisionIntensity / config.VisionMaxIntensity
+func (l *Logic) ledsFromData(d Data) {
+	green := 255 * d.VisionIntensity / l.conf.VisionMaxIntensity
 	if d.VisionAngle > 0 {
-		c.LedLeftGreen = normalizeLedValue(green - (green * d.VisionAngle / config.VisionMaxAngle))
-		c.LedRightGreen = normalizeLedValue(green)
+		l.c.LedLeftGreen = normalizeLedValue(green - (green * d.VisionAngle / l.conf.VisionMaxAngle))
+		l.c.LedRightGreen = normalizeLedValue(green)
 	} else if d.VisionAngle < 0 {
-		c.LedLeftGreen = normalizeLedValue(green)
-		c.LedRightGreen = normalizeLedValue(green + (green * d.VisionAngle / config.VisionMaxAngle))
+		l.c.LedLeftGreen = normalizeLedValue(green)
+		l.c.LedRightGreen = normalizeLedValue(green + (green * d.VisionAngle / l.conf.VisionMaxAngle))
 	} else {
-		c.LedLeftGreen = normalizeLedValue(green)
-		c.LedRightGreen = normalizeLedValue(green)
+		l.c.LedLeftGreen = normalizeLedValue(green)
+		l.c.LedRightGreen = normalizeLedValue(green)
 	}
 	if d.CornerLeftIsOut {
-		c.LedLeftRed = 0
+		l.c.LedLeftRed = 0
 	} else {
-		c.LedLeftRed = 0
+		l.c.LedLeftRed = 0
 	}
 	if d.CornerRightIsOut {
-		c.LedRightRed = 0
+		l.c.LedRightRed = 0
 	} else {
-		c.LedRightRed = 0
-	}
-}
-
-func checkDone(k ui.KeyEvent) bool {
-	if k.Key == ui.Quit || k.Key == ui.Back {
-
-		log(k.Millis, ev3.NoDirection, " *** DONE ***")
-
-		go chooseStrategy(k.Millis)
-		return true
-	}
-	return false
-}
-func checkQuit(k ui.KeyEvent) {
-	if k.Key == ui.Quit || k.Key == ui.Back {
-		quit <- true
-		return
+		l.c.LedRightRed = 0
 	}
 }
 
-func checkBorder(d Data, now int) bool {
-	if d.CornerLeftIsOut {
-		if d.CornerRightIsOut {
-			go back(now, ev3.NoDirection)
+func (l *Logic) checkBorder(ctx context.Context, r *behaviour.Run) bool {
+	if l.d.CornerLeftIsOut {
+		if l.d.CornerRightIsOut {
+			l.runner.HandOff(ctx, l.back, r.Now, ev3.NoDirection)
 			return true
 		}
-		go back(now, ev3.Left)
+		l.runner.HandOff(ctx, l.back, r.Now, ev3.Left)
 		return true
 	}
-	if d.CornerRightIsOut {
-		if d.CornerLeftIsOut {
-			go back(now, ev3.NoDirection)
+	if l.d.CornerRightIsOut {
+		if l.d.CornerLeftIsOut {
+			l.runner.HandOff(ctx, l.back, r.Now, ev3.NoDirection)
 			return true
 		}
-		go back(now, ev3.Right)
+		l.runner.HandOff(ctx, l.back, r.Now, ev3.Right)
 		return true
 	}
 	return false
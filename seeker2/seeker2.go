@@ -1,28 +1,52 @@
 package main
 
 import (
+	"go-bots/border"
+	"go-bots/botconf"
+	"go-bots/clock"
+	"go-bots/cmdline"
+	"go-bots/ev3"
+	"go-bots/seeker2/config"
 	"go-bots/seeker2/io"
 	"go-bots/seeker2/logic"
 	"go-bots/ui"
-	"time"
 )
 
-var data = make(chan logic.Data)
-var keys = make(chan ui.KeyEvent)
-var quit = make(chan bool)
-
 func main() {
-	start := time.Now()
+	ev3.HandleSignals()
+	defer ev3.Recover()
+
+	opts := cmdline.Parse("seeker2.toml")
+	clk := clock.Real{}
+	conf, err := config.Load(opts.ConfigFile, opts.Profile, opts.Sets, clk)
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error reading conf, using defaults:", err)
+	}
+	cmdline.Log(cmdline.Info, "Configuration loaded:\n"+botconf.String(conf.Get()))
+	calibration := &border.Current{}
+	saved, err := border.Load(conf.BorderFile())
+	if err != nil {
+		cmdline.Log(cmdline.Info, "No border calibration, using ColorIsOut:", err)
+	} else {
+		calibration.Set(saved)
+		cmdline.Log(cmdline.Info, "Border calibration loaded:", saved)
+	}
+
+	start := clk.Now()
+	data := make(chan logic.Data)
+	keys := make(chan ui.KeyEvent)
+	quit := make(chan bool)
 
-	io.Init(data, start)
-	defer io.Close()
-	go io.Loop()
+	devices := io.New(data, clk, start, conf, calibration)
+	ev3.OnShutdown(devices.Close)
+	go devices.Loop()
 
-	ui.Init(keys, start)
-	defer ui.Close()
+	ui.Init(keys, clk, start)
+	ev3.OnShutdown(ui.Close)
 	go ui.Loop()
 
-	logic.Init(data, io.ProcessCommand, keys, quit)
-	go logic.Run()
+	l := logic.New(data, devices.ProcessCommand, keys, quit, conf, calibration)
+	go l.Run()
 	<-quit
+	ev3.Exit(ev3.ExitOK, "Quit")
 }
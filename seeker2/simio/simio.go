@@ -0,0 +1,300 @@
+package simio
+
+import (
+	"fmt"
+	"go-bots/behaviour"
+	"go-bots/border"
+	"go-bots/clock"
+	"go-bots/ev3"
+	"go-bots/seeker2/config"
+	"go-bots/seeker2/io"
+	"go-bots/seeker2/logic"
+	"go-bots/sim"
+	"go-bots/sim/replay"
+	"go-bots/sim/sumo"
+	"go-bots/ui"
+	"sync"
+	"time"
+)
+
+// Sensor names of the simulated body
+const (
+	CornerLeft  = "corner-left"
+	CornerRight = "corner-right"
+	EyeLeft     = "eye-left"
+	EyeRight    = "eye-right"
+)
+
+// Body returns the shape and sensors of seeker2
+func Body() sumo.Body {
+	return sumo.Body{
+		Radius:      100,
+		Mass:        1.1,
+		Friction:    0.9,
+		WheelBase:   140,
+		MaxSpeed:    450,
+		MotorMillis: 80,
+		Sensors: []sumo.Sensor{
+			{Name: CornerLeft, Kind: sumo.Reflect, X: 90, Y: 60},
+			{Name: CornerRight, Kind: sumo.Reflect, X: 90, Y: -60},
+			{Name: EyeLeft, Kind: sumo.IR, X: 30, Angle: 45, OnHead: true},
+			{Name: EyeRight, Kind: sumo.IR, X: 30, Angle: -45, OnHead: true},
+		},
+	}
+}
+
+// Strategies lists the strategies that can be chosen from the menu
+var Strategies = []string{"seek", "circle", "goForward", "turnBack"}
+
+// menuKeys returns the keys that choose a strategy in the menu
+func menuKeys(strategy string, dir ev3.Direction) ([]ui.Key, error) {
+	right := dir == ev3.Right
+	switch strategy {
+	case "seek":
+		if right {
+			return []ui.Key{ui.Right, ui.Up, ui.Up}, nil
+		}
+		return []ui.Key{}, nil
+	case "circle":
+		if right {
+			return []ui.Key{ui.Right}, nil
+		}
+		return []ui.Key{ui.Left}, nil
+	case "goForward":
+		if right {
+			return []ui.Key{ui.Right, ui.Up}, nil
+		}
+		return []ui.Key{ui.Up}, nil
+	case "turnBack":
+		if right {
+			return []ui.Key{ui.Right, ui.Down}, nil
+		}
+		return []ui.Key{ui.Down}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q (must be one of %v)", strategy, Strategies)
+}
+
+// Bot runs the seeker2 logic against a robot of the simulated arena, in
+// place of the io module
+type Bot struct {
+	body     sumo.Body
+	strategy []ui.Key
+	data     chan logic.Data
+	keys     chan ui.KeyEvent
+	quit     chan bool
+	clock    *clock.Manual
+	// stallClock times the wait for the logic to take a reading, the
+	// simulation is stopped meanwhile so it cannot be its own clock
+	stallClock  clock.Clock
+	conf        *config.File
+	calibration *border.Current
+	start       time.Time
+	keypad      *sumo.Keypad
+	logic       *logic.Logic
+	driver      *io.Driver
+
+	mutex    sync.Mutex
+	commands []logic.Commands
+	stalled  bool
+	// taken is the time of the last reading taken by the logic
+	taken   int
+	insight sumo.Insight
+
+	lastMillis   int
+	eyesPosition float64
+}
+
+// New starts the seeker2 logic with the given strategy and direction,
+// its time is kept on c, its configuration and corner sensor calibration are
+// conf and calibration
+func New(strategy string, dir ev3.Direction, c *clock.Manual, conf *config.File, calibration *border.Current) (*Bot, error) {
+	keys, err := menuKeys(strategy, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bot{
+		body:        Body(),
+		strategy:    keys,
+		data:        make(chan logic.Data),
+		keys:        make(chan ui.KeyEvent),
+		quit:        make(chan bool, 1),
+		clock:       c,
+		stallClock:  clock.Real{},
+		conf:        conf,
+		calibration: calibration,
+	}
+	b.start = b.clock.Now()
+	b.logic = logic.New(b.data, b.processCommand, b.keys, b.quit, conf, calibration)
+	b.keypad = &sumo.Keypad{
+		Keys:   b.keys,
+		InMenu: func() bool { return b.state().Machine == "chooseStrategy" },
+		Ready:  func() bool { return b.state().Machine != "" },
+	}
+	b.driver = io.NewDriver(b.start, conf, calibration)
+	b.eyesPosition = float64(b.driver.EyesSetPoint())
+	go b.logic.Run()
+	return b, nil
+}
+
+// SetBody replaces the default body
+func (b *Bot) SetBody(body sumo.Body) {
+	b.body = body
+}
+
+// SetStallClock replaces the clock timing the wait for the logic (the system
+// clock by default)
+func (b *Bot) SetStallClock(c clock.Clock) {
+	b.stallClock = c
+}
+
+// Name identifies the controller
+func (b *Bot) Name() string {
+	return "seeker2"
+}
+
+// Body returns the shape and sensors of the robot
+func (b *Bot) Body() sumo.Body {
+	return b.body
+}
+
+// Start goes back to the menu (when needed), chooses the strategy and presses ENTER
+func (b *Bot) Start(now int) {
+	b.keypad.Choose(now, b.strategy)
+}
+
+// State describes what the logic is doing
+func (b *Bot) State() string {
+	s := b.state()
+	if s.Phase == "" {
+		return s.Machine
+	}
+	return s.Phase
+}
+
+// state returns the last state entered for a reading before the last one
+// taken, like the commands
+func (b *Bot) state() behaviour.Transition {
+	result := behaviour.Transition{}
+	for _, t := range b.logic.History() {
+		if t.Millis < b.taken {
+			result = t
+		}
+	}
+	return result
+}
+
+// Insight tells which corners see the edge and where the eyes see the opponent
+func (b *Bot) Insight() sumo.Insight {
+	return b.insight
+}
+
+// processCommand records the commands, they are applied at the next step
+func (b *Bot) processCommand(c *logic.Commands) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.commands = append(b.commands, *c)
+}
+
+// command returns the last command given for a reading before the last
+// one taken: the logic is done with a reading once it takes the next one,
+// so these commands do not depend on how fast its goroutine runs
+func (b *Bot) command() (logic.Commands, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	found := -1
+	for i, c := range b.commands {
+		if c.Millis < b.taken {
+			found = i
+		}
+	}
+	if found < 0 {
+		return logic.Commands{}, false
+	}
+	result := b.commands[found]
+	b.commands = b.commands[found+1:]
+	return result, true
+}
+
+// moveEyes turns the eyes motor towards its set point at VisionSpeed
+func (b *Bot) moveEyes(millis int) {
+	step := float64(b.conf.Get().VisionSpeed*millis) / 1000
+	target := float64(b.driver.EyesSetPoint())
+	if b.eyesPosition < target {
+		b.eyesPosition = minFloat(b.eyesPosition+step, target)
+	} else {
+		b.eyesPosition = maxFloat(b.eyesPosition-step, target)
+	}
+}
+
+func minFloat(a float64, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a float64, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Step applies the last command to the robot (the front wheels and the leds
+// are not simulated), reads its sensors and hands them to the logic
+func (b *Bot) Step(now int, r *sumo.Robot, w *sumo.World) {
+	b.clock.Set(b.start.Add(time.Duration(now) * time.Millisecond))
+
+	millis := now - b.lastMillis
+	b.lastMillis = now
+	if c, ok := b.command(); ok {
+		out := b.driver.Command(&c)
+		r.SetDuty(out.MotorLeft, out.MotorRight)
+	}
+
+	b.moveEyes(millis)
+	pos := int(b.eyesPosition)
+	// Positive positions turn the eyes to the right
+	r.HeadAngle = -sim.Radians(float64(pos * 9 / 25))
+
+	d := b.driver.Read(ev3.TimespanAsMillis(b.start, b.clock.Now()), io.Sensors{
+		CornerLeft:   w.Sense(r, CornerLeft),
+		CornerRight:  w.Sense(r, CornerRight),
+		IrLeft:       w.Sense(r, EyeLeft),
+		IrRight:      w.Sense(r, EyeRight),
+		EyesPosition: pos,
+	})
+	b.insight = sumo.Insight{Vision: &replay.Vision{Angle: d.VisionAngle, Intensity: d.VisionIntensity}}
+	if d.CornerLeftIsOut {
+		b.insight.Edges = append(b.insight.Edges, CornerLeft)
+	}
+	if d.CornerRightIsOut {
+		b.insight.Edges = append(b.insight.Edges, CornerRight)
+	}
+	b.send(r, d)
+	// The keys follow the reading, once the logic has taken it
+	b.keypad.Step(now)
+}
+
+// send hands a reading to the logic, when the logic does not take it the
+// robot stops until the logic reads again
+func (b *Bot) send(r *sumo.Robot, d logic.Data) {
+	if b.stalled {
+		select {
+		case b.data <- d:
+			b.stalled = false
+			b.taken = d.Millis
+		default:
+			r.SetDuty(0, 0)
+		}
+		return
+	}
+	select {
+	case b.data <- d:
+		b.taken = d.Millis
+	case <-b.stallClock.After(sumo.StallTimeout):
+		b.stalled = true
+		r.SetDuty(0, 0)
+	}
+}
@@ -0,0 +1,100 @@
+package linetrack
+
+import (
+	"fmt"
+	"go-bots/botconf"
+	"go-bots/sim"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Start places the robot: the middle of its sensors at X, Y and its
+// heading (in degrees)
+type Start struct {
+	X       float64
+	Y       float64
+	Heading float64
+}
+
+// Scene is a track file: the track, the robot and how long to run
+type Scene struct {
+	// StepMillis is the duration of a simulation step (one loop of the bot)
+	StepMillis int `check:"required,min=1,max=100"`
+	// MaxMillis ends the run
+	MaxMillis int `check:"required,min=1"`
+	// LostMM ends the run when the robot gets this far from the line
+	LostMM float64 `check:"required,min=1"`
+	// Seed makes the sensor noise repeatable
+	Seed  int64
+	Track Track
+	Body  Body
+	// Start is where the robot starts (the start of the first path when not set)
+	Start *Start
+
+	// Dir is the directory of the track file
+	Dir string `toml:"-"`
+}
+
+// DefaultScene has no track, it must come from a track file
+func DefaultScene() Scene {
+	return Scene{
+		StepMillis: 10,
+		MaxMillis:  60000,
+		LostMM:     150,
+		Seed:       1,
+		Track: Track{
+			Width:   20,
+			Surface: DefaultSurface(),
+		},
+		Body: DefaultBody(),
+	}
+}
+
+// FromString reads a Scene from a TOML string, values that are not in it
+// keep their defaults (the command line overrides of the bot do not apply)
+func FromString(data string) (Scene, error) {
+	result := DefaultScene()
+	md, err := toml.Decode(data, &result)
+	if err != nil {
+		return result, err
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		unknown := []string{}
+		for _, k := range keys {
+			unknown = append(unknown, k.String())
+		}
+		return result, fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+	err = botconf.Validate(&result)
+	return result, err
+}
+
+// FromFile reads a Scene from a TOML track file
+func FromFile(fileName string) (Scene, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return Scene{}, err
+	}
+	result, err := FromString(string(b))
+	result.Dir = filepath.Dir(fileName)
+	return result, err
+}
+
+// NewWorld builds the track of the scene and places the robot on it
+func (s *Scene) NewWorld() (*World, error) {
+	m, err := NewMap(s.Track, s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	// Place the sensors (not the wheels) on the start
+	start := m.Start()
+	if s.Start != nil {
+		start = sim.Pose{Pos: sim.Vec{X: s.Start.X, Y: s.Start.Y}, Heading: sim.Radians(s.Start.Heading)}
+	}
+	center := s.Body.SensorsCenter().Rotate(start.Heading)
+	start.Pos = start.Pos.Sub(center)
+	return NewWorld(m, s.Body, start, s.Seed), nil
+}
@@ -0,0 +1,395 @@
+package linetrack
+
+import (
+	"fmt"
+	"go-bots/sim"
+	"go-bots/sim/replay"
+	"image"
+	// Image tracks can be PNG or JPEG files
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// RGB is a raw reading of a color sensor in RGB-RAW mode (0 to 1020 per channel)
+type RGB struct {
+	R int
+	G int
+	B int
+}
+
+// Surface gives what a sensor reads on each part of the track
+type Surface struct {
+	Background RGB
+	Line       RGB
+	Red        RGB
+	Green      RGB
+	Blue       RGB
+}
+
+// DefaultSurface is black tape on white paper, read from about 1cm
+func DefaultSurface() Surface {
+	return Surface{
+		Background: RGB{R: 260, G: 280, B: 240},
+		Line:       RGB{R: 25, G: 30, B: 25},
+		Red:        RGB{R: 250, G: 60, B: 40},
+		Green:      RGB{R: 60, G: 200, B: 80},
+		Blue:       RGB{R: 50, G: 90, B: 220},
+	}
+}
+
+// Kinds of track surface
+const (
+	Background = ""
+	Line       = "line"
+	Red        = "red"
+	Green      = "green"
+	Blue       = "blue"
+)
+
+// Color returns the reading on a kind of surface
+func (s *Surface) Color(kind string) RGB {
+	switch kind {
+	case Line:
+		return s.Line
+	case Red:
+		return s.Red
+	case Green:
+		return s.Green
+	case Blue:
+		return s.Blue
+	}
+	return s.Background
+}
+
+// Step is a piece of a path: a straight of the given length, or a turn of
+// Turn degrees (counterclockwise, negative turns right) along a circle of
+// the given radius
+type Step struct {
+	Straight float64 `check:"min=0"`
+	Turn     float64
+	Radius   float64 `check:"min=0"`
+}
+
+// Path is a line of the track, given by its points or by the steps that a
+// turtle starting at X, Y and heading Heading (in degrees) would take
+type Path struct {
+	X       float64
+	Y       float64
+	Heading float64
+	Points  []sim.Vec
+	Steps   []Step
+	// Closed joins the last point to the first one
+	Closed bool
+}
+
+// Marker is a colored patch centered on a path, At mm from its start
+type Marker struct {
+	Color string `check:"oneof=red|green|blue"`
+	Path  int    `check:"min=0"`
+	At    float64
+	// Radius is the size of the patch (0 for the width of the line)
+	Radius float64 `check:"min=0"`
+}
+
+// Track describes the lines the robot follows: paths and markers, or an
+// image where dark pixels are the line and red, green or blue ones are markers
+type Track struct {
+	// Width is the width of the line
+	Width   float64 `check:"required,min=1"`
+	Paths   []Path
+	Markers []Marker
+	// Image is a PNG or JPEG file (relative to the track file) used instead of Paths
+	Image string
+	// MMPerPixel is the scale of the image
+	MMPerPixel float64 `check:"min=0"`
+	Surface    Surface
+}
+
+// arcStep is the angle (in radians) between the points of a turn
+const arcStep = math.Pi / 72
+
+// points returns the polyline of a path
+func (p *Path) points() []sim.Vec {
+	if len(p.Points) > 0 {
+		return p.Points
+	}
+	pos := sim.Vec{X: p.X, Y: p.Y}
+	heading := sim.Radians(p.Heading)
+	result := []sim.Vec{pos}
+	for _, s := range p.Steps {
+		if s.Straight > 0 {
+			pos = pos.Add(sim.FromAngle(heading).Scale(s.Straight))
+			result = append(result, pos)
+		}
+		if s.Turn == 0 {
+			continue
+		}
+		turn := sim.Radians(s.Turn)
+		side := math.Pi / 2
+		if turn < 0 {
+			side = -side
+		}
+		center := pos.Add(sim.FromAngle(heading + side).Scale(s.Radius))
+		n := int(math.Ceil(math.Abs(turn) / arcStep))
+		from := pos.Sub(center)
+		for i := 1; i <= n; i++ {
+			result = append(result, center.Add(from.Rotate(turn*float64(i)/float64(n))))
+		}
+		pos = result[len(result)-1]
+		heading += turn
+	}
+	return result
+}
+
+// patch is a marker placed on the floor
+type patch struct {
+	center sim.Vec
+	radius float64
+	color  string
+}
+
+// Map is a track ready to be sensed
+type Map struct {
+	surface Surface
+	width   float64
+	lines   [][]sim.Vec
+	patches []patch
+
+	// image tracks
+	pixels     [][]string
+	mmPerPixel float64
+}
+
+// NewMap builds the map of a track, dir is where its image is looked for
+func NewMap(t Track, dir string) (*Map, error) {
+	m := &Map{surface: t.Surface, width: t.Width}
+	if t.Image != "" {
+		if t.MMPerPixel <= 0 {
+			return nil, fmt.Errorf("an image track needs MMPerPixel")
+		}
+		err := m.loadImage(filepath.Join(dir, t.Image), t.MMPerPixel)
+		return m, err
+	}
+	if len(t.Paths) == 0 {
+		return nil, fmt.Errorf("the track has no Paths and no Image")
+	}
+	for i, p := range t.Paths {
+		points := p.points()
+		if len(points) < 2 {
+			return nil, fmt.Errorf("Paths[%d]: a path needs at least two points or one step", i)
+		}
+		if p.Closed {
+			points = append(points, points[0])
+		}
+		m.lines = append(m.lines, points)
+	}
+	for i, mk := range t.Markers {
+		if mk.Path >= len(m.lines) {
+			return nil, fmt.Errorf("Markers[%d]: there is no path %d", i, mk.Path)
+		}
+		radius := mk.Radius
+		if radius == 0 {
+			radius = t.Width / 2
+		}
+		m.patches = append(m.patches, patch{
+			center: pointAt(m.lines[mk.Path], mk.At),
+			radius: radius,
+			color:  mk.Color,
+		})
+	}
+	return m, nil
+}
+
+// pointAt returns the point at distance along a polyline (its end when it is shorter)
+func pointAt(line []sim.Vec, distance float64) sim.Vec {
+	for i := 1; i < len(line); i++ {
+		d := line[i].Sub(line[i-1])
+		l := d.Len()
+		if distance <= l && l > 0 {
+			return line[i-1].Add(d.Scale(distance / l))
+		}
+		distance -= l
+	}
+	return line[len(line)-1]
+}
+
+// Start returns the start of the first path and its direction
+func (m *Map) Start() sim.Pose {
+	if len(m.lines) == 0 {
+		return sim.Pose{}
+	}
+	a, b := m.lines[0][0], m.lines[0][1]
+	return sim.Pose{Pos: a, Heading: b.Sub(a).Angle()}
+}
+
+func (m *Map) loadImage(fileName string, mmPerPixel float64) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fileName, err)
+	}
+	bounds := img.Bounds()
+	m.mmPerPixel = mmPerPixel
+	m.pixels = make([][]string, bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		row := make([]string, bounds.Dx())
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			row[x] = pixelKind(int(r>>8), int(g>>8), int(b>>8))
+		}
+		// Image rows go down, Y goes up
+		m.pixels[bounds.Dy()-1-y] = row
+	}
+	return nil
+}
+
+// pixelKind classifies a pixel: dark is the line, a channel above half and
+// one and a half times the others is a marker
+func pixelKind(r int, g int, b int) string {
+	dominates := func(v int, o1 int, o2 int) bool {
+		return v >= 128 && v*2 >= o1*3 && v*2 >= o2*3
+	}
+	switch {
+	case r+g+b < 240:
+		return Line
+	case dominates(r, g, b):
+		return Red
+	case dominates(g, r, b):
+		return Green
+	case dominates(b, r, g):
+		return Blue
+	}
+	return Background
+}
+
+func (m *Map) pixel(p sim.Vec) string {
+	x, y := int(math.Floor(p.X/m.mmPerPixel)), int(math.Floor(p.Y/m.mmPerPixel))
+	if y < 0 || y >= len(m.pixels) || x < 0 || x >= len(m.pixels[y]) {
+		return Background
+	}
+	return m.pixels[y][x]
+}
+
+// At returns the kind of surface at a point
+func (m *Map) At(p sim.Vec) string {
+	if m.pixels != nil {
+		return m.pixel(p)
+	}
+	for _, pt := range m.patches {
+		if p.Sub(pt.center).Len() <= pt.radius {
+			return pt.color
+		}
+	}
+	if m.lineDistance(p) <= m.width/2 {
+		return Line
+	}
+	return Background
+}
+
+// Color returns the reading of a point of the track
+func (m *Map) Color(p sim.Vec) RGB {
+	return m.surface.Color(m.At(p))
+}
+
+func segmentDistance(p sim.Vec, a sim.Vec, b sim.Vec) float64 {
+	ab := b.Sub(a)
+	l2 := ab.Dot(ab)
+	if l2 == 0 {
+		return p.Sub(a).Len()
+	}
+	t := math.Max(0, math.Min(1, p.Sub(a).Dot(ab)/l2))
+	return p.Sub(a.Add(ab.Scale(t))).Len()
+}
+
+func (m *Map) lineDistance(p sim.Vec) float64 {
+	result := math.Inf(1)
+	for _, line := range m.lines {
+		for i := 1; i < len(line); i++ {
+			result = math.Min(result, segmentDistance(p, line[i-1], line[i]))
+		}
+	}
+	return result
+}
+
+// Distance returns how far a point is from the center of the closest line,
+// image tracks only look up to limit away (and return limit beyond it)
+func (m *Map) Distance(p sim.Vec, limit float64) float64 {
+	if m.pixels == nil {
+		return math.Min(m.lineDistance(p), limit)
+	}
+	// The center of the line is half its width from its edge
+	if m.pixel(p) == Background {
+		edge := m.nearest(p, limit, func(kind string) bool { return kind != Background })
+		return math.Min(limit, edge+m.width/2)
+	}
+	edge := m.nearest(p, m.width/2, func(kind string) bool { return kind == Background })
+	return math.Max(0, m.width/2-edge)
+}
+
+// nearest returns the distance to the closest pixel of the wanted kind, limit if there is none
+func (m *Map) nearest(p sim.Vec, limit float64, wanted func(kind string) bool) float64 {
+	result := limit
+	n := int(math.Ceil(limit / m.mmPerPixel))
+	for dy := -n; dy <= n; dy++ {
+		for dx := -n; dx <= n; dx++ {
+			o := sim.Vec{X: float64(dx) * m.mmPerPixel, Y: float64(dy) * m.mmPerPixel}
+			if o.Len() < result && wanted(m.pixel(p.Add(o))) {
+				result = o.Len()
+			}
+		}
+	}
+	return result
+}
+
+// Floor returns the track as shapes: the paths and the markers, or a line
+// per run of pixels of the same kind for image tracks
+func (m *Map) Floor() []replay.Shape {
+	result := []replay.Shape{}
+	if m.pixels != nil {
+		for y, row := range m.pixels {
+			py := (float64(y) + 0.5) * m.mmPerPixel
+			for x := 0; x < len(row); {
+				end := x + 1
+				for end < len(row) && row[end] == row[x] {
+					end++
+				}
+				if row[x] != Background {
+					result = append(result, replay.Shape{
+						Kind:   replay.Path,
+						Points: []sim.Vec{{X: float64(x) * m.mmPerPixel, Y: py}, {X: float64(end) * m.mmPerPixel, Y: py}},
+						Width:  m.mmPerPixel,
+						Color:  kindColor(row[x]),
+					})
+				}
+				x = end
+			}
+		}
+		return result
+	}
+	for _, line := range m.lines {
+		result = append(result, replay.Shape{Kind: replay.Path, Points: line, Width: m.width, Color: replay.Black})
+	}
+	for _, p := range m.patches {
+		result = append(result, replay.Shape{Kind: replay.Disc, Points: []sim.Vec{p.center}, Radius: p.radius, Color: kindColor(p.color)})
+	}
+	return result
+}
+
+func kindColor(kind string) string {
+	switch kind {
+	case Red:
+		return replay.Red
+	case Green:
+		return replay.Green
+	case Blue:
+		return replay.Blue
+	}
+	return replay.Black
+}
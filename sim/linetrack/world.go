@@ -0,0 +1,182 @@
+package linetrack
+
+import (
+	"go-bots/sim"
+	"go-bots/sim/replay"
+	"math"
+	"math/rand"
+)
+
+// MaxReading is the largest raw reading of a channel
+const MaxReading = 1020
+
+// Sensor is a color sensor looking down
+type Sensor struct {
+	Name string `check:"required"`
+	// X and Y place the sensor in the robot frame (X forward, Y to the left)
+	X float64
+	Y float64
+	// Radius is the size of the spot the sensor sees
+	Radius float64 `check:"min=0"`
+}
+
+// Body describes a line follower
+type Body struct {
+	// WheelBase is the distance between the left and right wheels
+	WheelBase float64 `check:"required,min=1"`
+	// MaxSpeed is the wheel speed (in mm/s) at full duty cycle
+	MaxSpeed float64 `check:"required,min=1"`
+	// MotorMillis is the time constant of the motors
+	MotorMillis float64 `check:"min=0"`
+	// Noise is the standard deviation of each channel of the readings
+	Noise   float64 `check:"min=0"`
+	Sensors []Sensor
+}
+
+// DefaultBody is greyhound: four color sensors in a diamond well ahead of
+// the wheels
+func DefaultBody() Body {
+	return Body{
+		WheelBase:   120,
+		MaxSpeed:    1000,
+		MotorMillis: 50,
+		Noise:       4,
+		Sensors: []Sensor{
+			{Name: "F", X: 82, Y: 0, Radius: 4},
+			{Name: "L", X: 70, Y: 24, Radius: 4},
+			{Name: "R", X: 70, Y: -24, Radius: 4},
+			{Name: "B", X: 58, Y: 0, Radius: 4},
+		},
+	}
+}
+
+// SensorsCenter returns the middle of the sensors, in the robot frame
+func (b *Body) SensorsCenter() sim.Vec {
+	result := sim.Vec{}
+	if len(b.Sensors) == 0 {
+		return result
+	}
+	for _, s := range b.Sensors {
+		result = result.Add(sim.Vec{X: s.X, Y: s.Y})
+	}
+	return result.Scale(1 / float64(len(b.Sensors)))
+}
+
+// World is a robot on a track
+type World struct {
+	Map   *Map
+	Body  Body
+	Pose  sim.Pose
+	Drive sim.Drive
+	// Millis is the simulated time
+	Millis int
+
+	duty [4]int
+	rand *rand.Rand
+	// readings are the last reading of each sensor
+	readings []RGB
+}
+
+// NewWorld places a robot on a track, seed makes the sensor noise repeatable
+func NewWorld(m *Map, body Body, pose sim.Pose, seed int64) *World {
+	return &World{
+		Map:  m,
+		Body: body,
+		Pose: pose,
+		Drive: sim.Drive{
+			WheelBase:   body.WheelBase,
+			MaxSpeed:    body.MaxSpeed,
+			MotorMillis: body.MotorMillis,
+		},
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// SetDuty sets the duty cycle (from -100 to 100, positive forward) of the
+// two left and the two right motors
+func (w *World) SetDuty(left1 int, left2 int, right1 int, right2 int) {
+	w.duty = [4]int{left1, left2, right1, right2}
+}
+
+// Step advances the simulation by millis: the two motors of a side drive
+// the same tread, so the side moves with their average
+func (w *World) Step(millis int) {
+	left := (sim.Clamp(w.duty[0], -100, 100) + sim.Clamp(w.duty[1], -100, 100)) / 2
+	right := (sim.Clamp(w.duty[2], -100, 100) + sim.Clamp(w.duty[3], -100, 100)) / 2
+	speed, rotation := w.Drive.Update(left, right, float64(millis))
+	dt := float64(millis) / 1000
+	// Move along the arc using the middle heading
+	heading := w.Pose.Heading + rotation*dt/2
+	w.Pose.Pos = w.Pose.Pos.Add(sim.FromAngle(heading).Scale(speed * dt))
+	w.Pose.Heading = sim.NormalizeAngle(w.Pose.Heading + rotation*dt)
+	w.Millis += millis
+}
+
+// footprint are the points of the spot of a sensor that are averaged, as
+// fractions of its radius
+var footprint = []sim.Vec{
+	{X: 0, Y: 0},
+	{X: 0.7, Y: 0},
+	{X: 0.35, Y: 0.6},
+	{X: -0.35, Y: 0.6},
+	{X: -0.7, Y: 0},
+	{X: -0.35, Y: -0.6},
+	{X: 0.35, Y: -0.6},
+}
+
+// Read returns the raw reading of sensor i: the average of its spot plus noise
+func (w *World) Read(i int) RGB {
+	s := w.Body.Sensors[i]
+	center := sim.Vec{X: s.X, Y: s.Y}
+	r, g, b := 0.0, 0.0, 0.0
+	for _, f := range footprint {
+		c := w.Map.Color(w.Pose.ToWorld(center.Add(f.Scale(s.Radius))))
+		r, g, b = r+float64(c.R), g+float64(c.G), b+float64(c.B)
+	}
+	n := float64(len(footprint))
+	result := RGB{
+		R: w.channel(r / n),
+		G: w.channel(g / n),
+		B: w.channel(b / n),
+	}
+	if w.readings == nil {
+		w.readings = make([]RGB, len(w.Body.Sensors))
+	}
+	w.readings[i] = result
+	return result
+}
+
+func (w *World) channel(v float64) int {
+	return sim.Clamp(int(math.Round(v+sim.Noise(w.rand, w.Body.Noise))), 0, MaxReading)
+}
+
+// Offset returns how far the middle of the sensors is from the center of
+// the closest line (up to limit)
+func (w *World) Offset(limit float64) float64 {
+	return w.Map.Distance(w.Pose.ToWorld(w.Body.SensorsCenter()), limit)
+}
+
+// Robot returns the robot as it is now, for recordings: the rays are the
+// sensor spots with their last intensity (they do not hit anything, the
+// caller knows which ones see the line)
+func (w *World) Robot(name string) replay.Robot {
+	radius := w.Body.WheelBase / 2
+	rb := replay.Robot{
+		Name:    name,
+		Pos:     w.Pose.Pos,
+		Heading: w.Pose.Heading,
+	}
+	for i, s := range w.Body.Sensors {
+		local := sim.Vec{X: s.X, Y: s.Y}
+		radius = math.Max(radius, local.Len()+s.Radius)
+		pos := w.Pose.ToWorld(local)
+		ray := replay.Ray{Name: s.Name, From: pos, To: pos}
+		if i < len(w.readings) {
+			c := w.readings[i]
+			ray.Value = c.R + c.G + c.B
+		}
+		rb.Rays = append(rb.Rays, ray)
+	}
+	rb.Radius = radius
+	return rb
+}
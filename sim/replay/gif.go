@@ -0,0 +1,223 @@
+package replay
+
+import (
+	"fmt"
+	"go-bots/sim"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"strings"
+)
+
+// GIFFrameMillis is the time between the frames of a GIF animation
+const GIFFrameMillis = 100
+
+// GIFSize is the width of a GIF animation in pixels
+const GIFSize = 480
+
+// backgroundIndex is the palette index of the area around the floor
+const backgroundIndex = 0
+
+// paletteNames are the colors of the GIF palette, after the background
+var paletteNames = []string{Black, White, Grey, Red, Green, Blue, Yellow, Orange}
+
+func gifPalette() color.Palette {
+	p := color.Palette{color.RGBA{0xdd, 0xdd, 0xdd, 0xff}}
+	for _, name := range paletteNames {
+		c := colorCodes[name]
+		p = append(p, color.RGBA{c[0], c[1], c[2], 0xff})
+	}
+	return p
+}
+
+func colorIndex(name string) uint8 {
+	for i, n := range paletteNames {
+		if n == name {
+			return uint8(i + 1)
+		}
+	}
+	return colorIndex(Grey)
+}
+
+// raster draws on a paletted image, mapping the world (Y up) to pixels
+type raster struct {
+	img   *image.Paletted
+	min   sim.Vec
+	top   int
+	scale float64
+}
+
+func (r *raster) pixel(p sim.Vec) (float64, float64) {
+	return (p.X - r.min.X) * r.scale, float64(r.img.Rect.Dy()) - (p.Y-r.min.Y)*r.scale
+}
+
+// disc fills a circle given in pixels
+func (r *raster) disc(cx float64, cy float64, radius float64, c uint8) {
+	radius = math.Max(radius, 0.5)
+	for y := int(cy - radius); y <= int(cy+radius)+1; y++ {
+		for x := int(cx - radius); x <= int(cx+radius)+1; x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dx*dx+dy*dy <= radius*radius && y >= r.top {
+				r.img.SetColorIndex(x, y, c)
+			}
+		}
+	}
+}
+
+// line draws a segment given in pixels, width pixels wide
+func (r *raster) line(x1 float64, y1 float64, x2 float64, y2 float64, width float64, c uint8) {
+	steps := int(math.Ceil(math.Hypot(x2-x1, y2-y1)))
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		r.disc(x1+(x2-x1)*t, y1+(y2-y1)*t, width/2, c)
+	}
+}
+
+func (r *raster) worldDisc(p sim.Vec, radius float64, c uint8) {
+	x, y := r.pixel(p)
+	r.disc(x, y, radius*r.scale, c)
+}
+
+func (r *raster) worldLine(a sim.Vec, b sim.Vec, width float64, c uint8) {
+	x1, y1 := r.pixel(a)
+	x2, y2 := r.pixel(b)
+	r.line(x1, y1, x2, y2, width, c)
+}
+
+func (r *raster) shape(s Shape) {
+	c := colorIndex(s.Color)
+	switch s.Kind {
+	case Disc:
+		if len(s.Points) > 0 {
+			r.worldDisc(s.Points[0], s.Radius, c)
+		}
+	case Path:
+		for i := 1; i < len(s.Points); i++ {
+			r.worldLine(s.Points[i-1], s.Points[i], math.Max(1, s.Width*r.scale), c)
+		}
+	}
+}
+
+// text writes with the 3x5 font, scaled twice
+func (r *raster) text(x int, y int, s string, c uint8) {
+	for _, ch := range strings.ToUpper(s) {
+		glyph := font[ch]
+		for gy, row := range glyph {
+			for gx, bit := range row {
+				if bit != '#' {
+					continue
+				}
+				for k := 0; k < 4; k++ {
+					r.img.SetColorIndex(x+gx*2+k%2, y+gy*2+k/2, c)
+				}
+			}
+		}
+		x += 8
+	}
+}
+
+// WriteGIF writes a run as an animated GIF, one frame every frameMillis, size pixels wide
+func WriteGIF(w io.Writer, r *Run, frameMillis int, size int) error {
+	frames := r.Sample(frameMillis)
+	if len(frames) == 0 {
+		return fmt.Errorf("the run has no frames")
+	}
+	min, max := r.Bounds()
+	extent := max.Sub(min)
+	scale := float64(size) / extent.X
+	lineHeight := 12
+	top := lineHeight*(len(frames[0].Robots)+1) + 4
+	bounds := image.Rect(0, 0, size, top+int(math.Ceil(extent.Y*scale)))
+	palette := gifPalette()
+
+	floor := &raster{img: image.NewPaletted(bounds, palette), min: min, top: top, scale: scale}
+	for _, s := range r.Floor {
+		floor.shape(s)
+	}
+
+	anim := &gif.GIF{}
+	for _, f := range frames {
+		img := image.NewPaletted(bounds, palette)
+		copy(img.Pix, floor.img.Pix)
+		fr := &raster{img: img, min: min, top: top, scale: scale}
+		for i, rb := range f.Robots {
+			c := colorIndex(RobotColors[i%len(RobotColors)])
+			fr.worldDisc(rb.Pos, rb.Radius, c)
+			fr.worldLine(rb.Pos, rb.Pos.Add(sim.FromAngle(rb.Heading).Scale(rb.Radius)), 2, colorIndex(White))
+			for _, ray := range rb.Rays {
+				rc := colorIndex(Grey)
+				if ray.Hit {
+					rc = colorIndex(Red)
+				}
+				if ray.From == ray.To {
+					x, y := fr.pixel(ray.From)
+					fr.disc(x, y, 2.5, rc)
+				} else {
+					fr.worldLine(ray.From, ray.To, 1, rc)
+				}
+			}
+			if rb.Vision != nil && rb.Vision.Intensity > 0 {
+				dir := rb.Heading - sim.Radians(float64(rb.Vision.Angle))
+				fr.worldLine(rb.Pos, rb.Pos.Add(sim.FromAngle(dir).Scale(rb.Radius*3)), 2, colorIndex(Yellow))
+			}
+		}
+		fr.text(4, 4, fmt.Sprintf("%s %d.%03ds", r.Title, f.Millis/1000, f.Millis%1000), colorIndex(Black))
+		for i := range f.Robots {
+			fr.text(4, 4+lineHeight*(i+1), robotText(f, i), colorIndex(RobotColors[i%len(RobotColors)]))
+		}
+		anim.Image = append(anim.Image, img)
+		anim.Delay = append(anim.Delay, frameMillis/10)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// font has 3x5 glyphs for the letters, the digits and a few signs
+var font = map[rune][5]string{
+	'A': {".#.", "#.#", "###", "#.#", "#.#"},
+	'B': {"##.", "#.#", "##.", "#.#", "##."},
+	'C': {".##", "#..", "#..", "#..", ".##"},
+	'D': {"##.", "#.#", "#.#", "#.#", "##."},
+	'E': {"###", "#..", "##.", "#..", "###"},
+	'F': {"###", "#..", "##.", "#..", "#.."},
+	'G': {".##", "#..", "#.#", "#.#", ".##"},
+	'H': {"#.#", "#.#", "###", "#.#", "#.#"},
+	'I': {"###", ".#.", ".#.", ".#.", "###"},
+	'J': {"..#", "..#", "..#", "#.#", ".#."},
+	'K': {"#.#", "#.#", "##.", "#.#", "#.#"},
+	'L': {"#..", "#..", "#..", "#..", "###"},
+	'M': {"#.#", "###", "###", "#.#", "#.#"},
+	'N': {"##.", "#.#", "#.#", "#.#", "#.#"},
+	'O': {".#.", "#.#", "#.#", "#.#", ".#."},
+	'P': {"##.", "#.#", "##.", "#..", "#.."},
+	'Q': {".#.", "#.#", "#.#", "##.", ".##"},
+	'R': {"##.", "#.#", "##.", "#.#", "#.#"},
+	'S': {".##", "#..", ".#.", "..#", "##."},
+	'T': {"###", ".#.", ".#.", ".#.", ".#."},
+	'U': {"#.#", "#.#", "#.#", "#.#", "###"},
+	'V': {"#.#", "#.#", "#.#", "#.#", ".#."},
+	'W': {"#.#", "#.#", "###", "###", "#.#"},
+	'X': {"#.#", "#.#", ".#.", "#.#", "#.#"},
+	'Y': {"#.#", "#.#", ".#.", ".#.", ".#."},
+	'Z': {"###", "..#", ".#.", "#..", "###"},
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"##.", "..#", ".#.", "#..", "###"},
+	'3': {"##.", "..#", ".#.", "..#", "##."},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "##.", "..#", "##."},
+	'6': {".##", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", ".#.", ".#.", ".#."},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "##."},
+	'.': {"...", "...", "...", "...", ".#."},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'-': {"...", "...", "###", "...", "..."},
+	'+': {"...", ".#.", "###", ".#.", "..."},
+	'_': {"...", "...", "...", "...", "###"},
+	'°': {".#.", "#.#", ".#.", "...", "..."},
+}
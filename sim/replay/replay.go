@@ -0,0 +1,185 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"go-bots/sim"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Colors known to the renderers
+const (
+	Black  = "black"
+	White  = "white"
+	Grey   = "grey"
+	Red    = "red"
+	Green  = "green"
+	Blue   = "blue"
+	Yellow = "yellow"
+	Orange = "orange"
+)
+
+// RobotColors are given to the robots in order
+var RobotColors = []string{Blue, Orange, Green, Red}
+
+// Shape kinds
+const (
+	// Disc is a filled circle of Radius around the first point
+	Disc = "disc"
+	// Path is a line of Width through the points
+	Path = "path"
+)
+
+// Shape is something drawn on the floor
+type Shape struct {
+	Kind   string
+	Points []sim.Vec
+	Radius float64 `json:",omitempty"`
+	Width  float64 `json:",omitempty"`
+	Color  string
+}
+
+// Ray is what a sensor sees: a point for the sensors looking down, a
+// segment to what it detects for the distance sensors
+type Ray struct {
+	Name string
+	From sim.Vec
+	To   sim.Vec
+	// Hit tells that the sensor detects something (an edge, the line, an opponent)
+	Hit   bool
+	Value int
+}
+
+// Vision is the estimate of where the opponent is
+type Vision struct {
+	// Angle is in degrees, positive to the right of the robot
+	Angle     int
+	Intensity int
+}
+
+// Robot is a robot in a frame
+type Robot struct {
+	Name    string
+	Pos     sim.Vec
+	Heading float64
+	// Radius is the size of the body
+	Radius float64
+	Rays   []Ray
+	// State is what the logic is doing (the strategy, phase or line status)
+	State  string
+	Vision *Vision `json:",omitempty"`
+}
+
+// Frame is the state of a simulation at a time
+type Frame struct {
+	Millis int
+	Robots []Robot
+}
+
+// Run is a recorded simulation: the floor and the robots at each step
+type Run struct {
+	Title  string
+	Floor  []Shape
+	Frames []Frame
+}
+
+// Add records a frame
+func (r *Run) Add(f Frame) {
+	r.Frames = append(r.Frames, f)
+}
+
+// Sample returns frames at least millis apart (all of them when millis is 0)
+func (r *Run) Sample(millis int) []Frame {
+	result := []Frame{}
+	next := math.MinInt32
+	for _, f := range r.Frames {
+		if f.Millis >= next {
+			result = append(result, f)
+			next = f.Millis + millis
+		}
+	}
+	return result
+}
+
+// Bounds returns the corners of the area covered by the floor and the robots
+func (r *Run) Bounds() (min sim.Vec, max sim.Vec) {
+	min = sim.Vec{X: math.Inf(1), Y: math.Inf(1)}
+	max = sim.Vec{X: math.Inf(-1), Y: math.Inf(-1)}
+	add := func(p sim.Vec, margin float64) {
+		min = sim.Vec{X: math.Min(min.X, p.X-margin), Y: math.Min(min.Y, p.Y-margin)}
+		max = sim.Vec{X: math.Max(max.X, p.X+margin), Y: math.Max(max.Y, p.Y+margin)}
+	}
+	for _, s := range r.Floor {
+		for _, p := range s.Points {
+			add(p, s.Radius+s.Width/2)
+		}
+	}
+	for _, f := range r.Frames {
+		for _, rb := range f.Robots {
+			add(rb.Pos, rb.Radius)
+		}
+	}
+	if math.IsInf(min.X, 0) {
+		return sim.Vec{}, sim.Vec{X: 1, Y: 1}
+	}
+	return min, max
+}
+
+// writers write a run in the format of a file extension
+var writers = map[string]func(w io.Writer, r *Run) error{
+	".json": func(w io.Writer, r *Run) error { return json.NewEncoder(w).Encode(r) },
+	".svg":  func(w io.Writer, r *Run) error { return WriteSVG(w, r, SVGFrameMillis) },
+	".gif":  func(w io.Writer, r *Run) error { return WriteGIF(w, r, GIFFrameMillis, GIFSize) },
+}
+
+// CheckFormat tells if Save knows the format of a file (from its extension)
+func CheckFormat(fileName string) error {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if _, ok := writers[ext]; !ok {
+		return fmt.Errorf("%s: unknown format %q (must be .json, .svg or .gif)", fileName, ext)
+	}
+	return nil
+}
+
+// Save writes a run in the format given by the file extension: .json (to
+// be played back later), .svg (animated) or .gif
+func Save(fileName string, r *Run) error {
+	err := CheckFormat(fileName)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(f)
+	err = writers[strings.ToLower(filepath.Ext(fileName))](buf, r)
+	if err == nil {
+		err = buf.Flush()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Load reads a run saved as JSON
+func Load(fileName string) (*Run, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	r := &Run{}
+	err = json.Unmarshal(b, r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,268 @@
+package replay
+
+import (
+	"fmt"
+	"go-bots/sim"
+	"html"
+	"io"
+	"math"
+	"strings"
+)
+
+// SVGFrameMillis is the time between the frames of an SVG animation
+const SVGFrameMillis = 50
+
+// colorCodes are the colors of the renderers, as red, green, blue
+var colorCodes = map[string][3]uint8{
+	Black:  {0, 0, 0},
+	White:  {255, 255, 255},
+	Grey:   {150, 150, 150},
+	Red:    {220, 30, 30},
+	Green:  {30, 170, 60},
+	Blue:   {40, 80, 220},
+	Yellow: {240, 210, 0},
+	Orange: {240, 130, 0},
+}
+
+func hexColor(name string) string {
+	c, ok := colorCodes[name]
+	if !ok {
+		c = colorCodes[Grey]
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+}
+
+// svgAnimation writes discrete animations that loop over the frames
+type svgAnimation struct {
+	w        io.Writer
+	keyTimes []float64
+	seconds  float64
+}
+
+// animate changes attr to the value of each frame (repeated values are merged)
+func (a *svgAnimation) animate(attr string, values []string) {
+	vs, ks := []string{}, []string{}
+	for i, v := range values {
+		if i > 0 && v == values[i-1] {
+			continue
+		}
+		vs = append(vs, v)
+		ks = append(ks, fmt.Sprintf("%.4f", a.keyTimes[i]))
+	}
+	if len(vs) == 1 {
+		fmt.Fprintf(a.w, `<set attributeName="%s" to="%s"/>`, attr, vs[0])
+		return
+	}
+	fmt.Fprintf(a.w, `<animate attributeName="%s" values="%s" keyTimes="%s" dur="%.2fs" calcMode="discrete" repeatCount="indefinite"/>`,
+		attr, strings.Join(vs, ";"), strings.Join(ks, ";"), a.seconds)
+}
+
+// translate and rotate animate the transform of a group
+func (a *svgAnimation) transform(kind string, values []string) {
+	fmt.Fprintf(a.w, `<animateTransform attributeName="transform" type="%s" values="%s" keyTimes="%s" dur="%.2fs" calcMode="discrete" repeatCount="indefinite"/>`,
+		kind, strings.Join(values, ";"), a.keyTimesString(), a.seconds)
+}
+
+func (a *svgAnimation) keyTimesString() string {
+	ks := make([]string, len(a.keyTimes))
+	for i, k := range a.keyTimes {
+		ks[i] = fmt.Sprintf("%.4f", k)
+	}
+	return strings.Join(ks, ";")
+}
+
+func num(v float64) string {
+	return fmt.Sprintf("%.0f", v)
+}
+
+// WriteSVG writes a run as an animated SVG, one frame every frameMillis
+func WriteSVG(w io.Writer, r *Run, frameMillis int) error {
+	frames := r.Sample(frameMillis)
+	if len(frames) == 0 {
+		return fmt.Errorf("the run has no frames")
+	}
+	min, max := r.Bounds()
+	size := max.Sub(min)
+	textSize := math.Max(size.X, size.Y) / 40
+	dot := textSize / 3
+	// Room for the texts above the floor
+	top := max.Y + textSize*float64(len(frames[0].Robots)+2)
+
+	first, last := frames[0].Millis, frames[len(frames)-1].Millis
+	duration := float64(last-first+frameMillis) / 1000
+	a := &svgAnimation{w: w, seconds: duration}
+	for _, f := range frames {
+		a.keyTimes = append(a.keyTimes, float64(f.Millis-first)/1000/duration)
+	}
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="%s %s %s %s" width="800">`+"\n",
+		num(min.X), num(-top), num(size.X), num(top-min.Y))
+	fmt.Fprintf(w, `<rect x="%s" y="%s" width="%s" height="%s" fill="#dddddd"/>`+"\n", num(min.X), num(-top), num(size.X), num(top-min.Y))
+	// The world has Y going up
+	fmt.Fprintln(w, `<g transform="scale(1,-1)">`)
+	for _, s := range r.Floor {
+		writeShape(w, s)
+	}
+
+	for i := range frames[0].Robots {
+		color := hexColor(RobotColors[i%len(RobotColors)])
+		robot := func(f Frame) Robot {
+			if i < len(f.Robots) {
+				return f.Robots[i]
+			}
+			return Robot{}
+		}
+
+		// Body
+		translate, rotate := []string{}, []string{}
+		for _, f := range frames {
+			rb := robot(f)
+			translate = append(translate, num(rb.Pos.X)+" "+num(rb.Pos.Y))
+			rotate = append(rotate, num(sim.Degrees(rb.Heading)))
+		}
+		radius := frames[0].Robots[i].Radius
+		fmt.Fprint(w, "<g>")
+		a.transform("translate", translate)
+		fmt.Fprint(w, "<g>")
+		a.transform("rotate", rotate)
+		fmt.Fprintf(w, `<circle r="%s" fill="%s" fill-opacity="0.6"/>`, num(radius), color)
+		fmt.Fprintf(w, `<line x1="0" y1="0" x2="%s" y2="0" stroke="white" stroke-width="%s"/>`, num(radius), num(dot))
+		fmt.Fprintln(w, "</g></g>")
+
+		// Sensors
+		for j := range frames[0].Robots[i].Rays {
+			ray := func(f Frame) Ray {
+				rb := robot(f)
+				if j < len(rb.Rays) {
+					return rb.Rays[j]
+				}
+				return Ray{}
+			}
+			isPoint := frames[0].Robots[i].Rays[j].From == frames[0].Robots[i].Rays[j].To
+			x1, y1, x2, y2, colors := []string{}, []string{}, []string{}, []string{}, []string{}
+			for _, f := range frames {
+				ry := ray(f)
+				x1, y1 = append(x1, num(ry.From.X)), append(y1, num(ry.From.Y))
+				x2, y2 = append(x2, num(ry.To.X)), append(y2, num(ry.To.Y))
+				if ry.Hit {
+					colors = append(colors, hexColor(Red))
+				} else {
+					colors = append(colors, hexColor(Grey))
+				}
+			}
+			if isPoint {
+				fmt.Fprintf(w, `<circle r="%s">`, num(dot*1.5))
+				a.animate("cx", x1)
+				a.animate("cy", y1)
+				a.animate("fill", colors)
+				fmt.Fprintln(w, "</circle>")
+			} else {
+				fmt.Fprintf(w, `<line stroke-width="%s">`, num(dot))
+				a.animate("x1", x1)
+				a.animate("y1", y1)
+				a.animate("x2", x2)
+				a.animate("y2", y2)
+				a.animate("stroke", colors)
+				fmt.Fprintln(w, "</line>")
+			}
+		}
+
+		// Vision estimate
+		hasVision := false
+		x1, y1, x2, y2, opacity := []string{}, []string{}, []string{}, []string{}, []string{}
+		for _, f := range frames {
+			rb := robot(f)
+			to, visible := rb.Pos, "0"
+			if rb.Vision != nil {
+				hasVision = true
+				if rb.Vision.Intensity > 0 {
+					dir := rb.Heading - sim.Radians(float64(rb.Vision.Angle))
+					to, visible = rb.Pos.Add(sim.FromAngle(dir).Scale(rb.Radius*3)), "1"
+				}
+			}
+			x1, y1 = append(x1, num(rb.Pos.X)), append(y1, num(rb.Pos.Y))
+			x2, y2 = append(x2, num(to.X)), append(y2, num(to.Y))
+			opacity = append(opacity, visible)
+		}
+		if hasVision {
+			fmt.Fprintf(w, `<line stroke="%s" stroke-width="%s" stroke-dasharray="%s">`, hexColor(Yellow), num(dot), num(dot*3))
+			a.animate("x1", x1)
+			a.animate("y1", y1)
+			a.animate("x2", x2)
+			a.animate("y2", y2)
+			a.animate("opacity", opacity)
+			fmt.Fprintln(w, "</line>")
+		}
+	}
+	fmt.Fprintln(w, "</g>")
+
+	// Texts: the time, then the state of each robot
+	style := fmt.Sprintf(`font-family="monospace" font-size="%s"`, num(textSize))
+	y := -top + textSize*1.2
+	texts := []string{}
+	for _, f := range frames {
+		texts = append(texts, html.EscapeString(fmt.Sprintf("%s %d.%03ds", r.Title, f.Millis/1000, f.Millis%1000)))
+	}
+	writeTexts(w, a, style, min.X+textSize/2, y, "black", texts)
+	for i := range frames[0].Robots {
+		y += textSize * 1.2
+		texts := []string{}
+		for _, f := range frames {
+			texts = append(texts, html.EscapeString(robotText(f, i)))
+		}
+		writeTexts(w, a, style, min.X+textSize/2, y, hexColor(RobotColors[i%len(RobotColors)]), texts)
+	}
+	_, err := fmt.Fprintln(w, "</svg>")
+	return err
+}
+
+// robotText describes robot i in a frame
+func robotText(f Frame, i int) string {
+	if i >= len(f.Robots) {
+		return ""
+	}
+	rb := f.Robots[i]
+	text := rb.Name + " " + rb.State
+	if rb.Vision != nil {
+		text += fmt.Sprintf(" vision %d° %d", rb.Vision.Angle, rb.Vision.Intensity)
+	}
+	return text
+}
+
+// writeTexts writes a text that changes with the frames: one element per
+// run of equal texts, shown only during it
+func writeTexts(w io.Writer, a *svgAnimation, style string, x float64, y float64, color string, texts []string) {
+	for start := 0; start < len(texts); {
+		end := start + 1
+		for end < len(texts) && texts[end] == texts[start] {
+			end++
+		}
+		visibility := make([]string, len(texts))
+		for k := range visibility {
+			visibility[k] = "hidden"
+			if k >= start && k < end {
+				visibility[k] = "visible"
+			}
+		}
+		fmt.Fprintf(w, `<text x="%s" y="%s" fill="%s" %s visibility="hidden">`, num(x), num(y), color, style)
+		a.animate("visibility", visibility)
+		fmt.Fprintf(w, "%s</text>\n", texts[start])
+		start = end
+	}
+}
+
+func writeShape(w io.Writer, s Shape) {
+	switch s.Kind {
+	case Disc:
+		if len(s.Points) > 0 {
+			fmt.Fprintf(w, `<circle cx="%s" cy="%s" r="%s" fill="%s"/>`+"\n", num(s.Points[0].X), num(s.Points[0].Y), num(s.Radius), hexColor(s.Color))
+		}
+	case Path:
+		points := []string{}
+		for _, p := range s.Points {
+			points = append(points, num(p.X)+","+num(p.Y))
+		}
+		fmt.Fprintf(w, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%s" stroke-linejoin="round" stroke-linecap="round"/>`+"\n",
+			strings.Join(points, " "), hexColor(s.Color), num(s.Width))
+	}
+}
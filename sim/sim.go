@@ -0,0 +1,146 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Distances are in millimeters, angles in radians (counterclockwise) and
+// times in milliseconds. In the frame of a robot X points forward and Y to
+// its left.
+
+// Vec is a point or a vector on the floor
+type Vec struct {
+	X float64
+	Y float64
+}
+
+// Add returns v + o
+func (v Vec) Add(o Vec) Vec {
+	return Vec{v.X + o.X, v.Y + o.Y}
+}
+
+// Sub returns v - o
+func (v Vec) Sub(o Vec) Vec {
+	return Vec{v.X - o.X, v.Y - o.Y}
+}
+
+// Scale returns v * k
+func (v Vec) Scale(k float64) Vec {
+	return Vec{v.X * k, v.Y * k}
+}
+
+// Dot returns the dot product of v and o
+func (v Vec) Dot(o Vec) float64 {
+	return v.X*o.X + v.Y*o.Y
+}
+
+// Len returns the length of v
+func (v Vec) Len() float64 {
+	return math.Hypot(v.X, v.Y)
+}
+
+// Rotate returns v rotated by angle
+func (v Vec) Rotate(angle float64) Vec {
+	s, c := math.Sincos(angle)
+	return Vec{v.X*c - v.Y*s, v.X*s + v.Y*c}
+}
+
+// Angle returns the direction of v
+func (v Vec) Angle() float64 {
+	return math.Atan2(v.Y, v.X)
+}
+
+// FromAngle returns the unit vector pointing at angle
+func FromAngle(angle float64) Vec {
+	s, c := math.Sincos(angle)
+	return Vec{c, s}
+}
+
+// Radians converts degrees to radians
+func Radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// Degrees converts radians to degrees
+func Degrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
+
+// NormalizeAngle brings an angle in the range -Pi to Pi
+func NormalizeAngle(a float64) float64 {
+	for a > math.Pi {
+		a -= 2 * math.Pi
+	}
+	for a < -math.Pi {
+		a += 2 * math.Pi
+	}
+	return a
+}
+
+// Pose is the position and heading of a robot
+type Pose struct {
+	Pos     Vec
+	Heading float64
+}
+
+// ToWorld converts a point from the robot frame to the world frame
+func (p Pose) ToWorld(local Vec) Vec {
+	return p.Pos.Add(local.Rotate(p.Heading))
+}
+
+// Drive is a differential drive: the motors on each side follow their duty
+// cycle with a first order lag
+type Drive struct {
+	// WheelBase is the distance between the left and right wheels
+	WheelBase float64
+	// MaxSpeed is the wheel speed (in mm/s) at full duty cycle
+	MaxSpeed float64
+	// MotorMillis is the time constant of the motors
+	MotorMillis float64
+
+	// Left and Right are the current wheel speeds (in mm/s)
+	Left  float64
+	Right float64
+}
+
+// Update moves the wheel speeds towards the ones given by the duty cycles
+// (from -100 to 100) and returns the forward and angular speeds (per second)
+func (d *Drive) Update(leftDuty int, rightDuty int, millis float64) (speed float64, rotation float64) {
+	follow := 1.0
+	if d.MotorMillis > 0 {
+		follow = 1 - math.Exp(-millis/d.MotorMillis)
+	}
+	d.Left += (dutySpeed(leftDuty, d.MaxSpeed) - d.Left) * follow
+	d.Right += (dutySpeed(rightDuty, d.MaxSpeed) - d.Right) * follow
+	return (d.Left + d.Right) / 2, (d.Right - d.Left) / d.WheelBase
+}
+
+func dutySpeed(duty int, maxSpeed float64) float64 {
+	if duty > 100 {
+		duty = 100
+	}
+	if duty < -100 {
+		duty = -100
+	}
+	return float64(duty) * maxSpeed / 100
+}
+
+// Noise returns a normally distributed value with the given standard deviation
+func Noise(r *rand.Rand, deviation float64) float64 {
+	if deviation <= 0 || r == nil {
+		return 0
+	}
+	return r.NormFloat64() * deviation
+}
+
+// Clamp limits v between min and max
+func Clamp(v int, min int, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,87 @@
+package sumo
+
+// Arena describes the dohyo and how the sensors see it
+type Arena struct {
+	// Radius is the radius of the dohyo, including the white edge
+	Radius float64 `check:"required,min=1"`
+	// EdgeWidth is the width of the white edge
+	EdgeWidth float64 `check:"min=0"`
+	// SurfaceReflect, EdgeReflect and OutsideReflect are the reflectance
+	// readings (0 to 100) on the black surface, on the edge and off the dohyo
+	SurfaceReflect int `check:"min=0,max=100"`
+	EdgeReflect    int `check:"min=0,max=100"`
+	OutsideReflect int `check:"min=0,max=100"`
+	// ReflectNoise is the standard deviation of the reflectance readings
+	ReflectNoise float64 `check:"min=0"`
+	// IRRange is the distance at which the IR proximity reading reaches 100
+	IRRange float64 `check:"required,min=1"`
+	// IRHalfAngle is half the opening (in degrees) of the IR sensors cone
+	IRHalfAngle float64 `check:"required,min=1,max=90"`
+	// IRNoise is the standard deviation of the IR readings
+	IRNoise float64 `check:"min=0"`
+	// Gravity (in mm/s²) gives the traction of the wheels
+	Gravity float64 `check:"required,min=1"`
+}
+
+// DefaultArena returns a standard dohyo
+func DefaultArena() Arena {
+	return Arena{
+		Radius:         770,
+		EdgeWidth:      50,
+		SurfaceReflect: 6,
+		EdgeReflect:    70,
+		OutsideReflect: 20,
+		ReflectNoise:   1,
+		IRRange:        700,
+		IRHalfAngle:    20,
+		IRNoise:        1,
+		Gravity:        9810,
+	}
+}
+
+// Sensor kinds
+const (
+	// IR is an infrared proximity sensor, it reads 0 (touching) to 100 (nothing in range)
+	IR = "ir"
+	// Reflect is a color sensor looking down in reflected light mode
+	Reflect = "reflect"
+)
+
+// Sensor is a sensor mounted on a robot
+type Sensor struct {
+	Name string `check:"required"`
+	Kind string `check:"oneof=ir|reflect"`
+	// X and Y place the sensor in the robot frame (X forward, Y to the left)
+	X float64
+	Y float64
+	// Angle is the direction (in degrees, counterclockwise from forward) of an IR sensor
+	Angle float64
+	// OnHead tells that the sensor turns with the robot head
+	OnHead bool
+}
+
+// Body describes a robot
+type Body struct {
+	// Radius is the radius of the (round) body
+	Radius float64 `check:"required,min=1"`
+	// Mass (in kg) decides who wins a pushing match, together with Friction
+	Mass     float64 `check:"required,min=0"`
+	Friction float64 `check:"required,min=0"`
+	// WheelBase is the distance between the left and right wheels
+	WheelBase float64 `check:"required,min=1"`
+	// MaxSpeed is the wheel speed (in mm/s) at full duty cycle
+	MaxSpeed float64 `check:"required,min=1"`
+	// MotorMillis is the time constant of the motors
+	MotorMillis float64 `check:"min=0"`
+	Sensors     []Sensor
+}
+
+// SensorIndex returns the index of the named sensor, -1 if there is none
+func (b *Body) SensorIndex(name string) int {
+	for i, s := range b.Sensors {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,59 @@
+package sumo
+
+import "go-bots/ui"
+
+// KeyMillis is the time between simulated key presses
+const KeyMillis = 100
+
+// Keypad presses keys in the menu of a bot logic, the way a person would
+// before a round
+type Keypad struct {
+	// Keys receives the key events
+	Keys chan<- ui.KeyEvent
+	// InMenu tells if the logic is in its menu
+	InMenu func() bool
+	// Ready tells if the logic has started
+	Ready func() bool
+
+	pending []ui.Key
+	nextKey int
+	entered bool
+}
+
+// Choose goes back to the menu (when needed), presses the keys and then ENTER
+func (k *Keypad) Choose(now int, keys []ui.Key) {
+	k.pending = append([]ui.Key{ui.Back}, keys...)
+	k.pending = append(k.pending, ui.Enter)
+	k.nextKey = now
+	k.entered = false
+}
+
+// Done tells if all the keys have been pressed
+func (k *Keypad) Done() bool {
+	return len(k.pending) == 0
+}
+
+// Step sends the next pending key, one every KeyMillis: Back is only
+// needed outside the menu and ENTER is repeated until the menu is left
+// (a key pressed while the menu is busy can be lost)
+func (k *Keypad) Step(now int) {
+	for len(k.pending) > 0 {
+		key := k.pending[0]
+		menu := k.InMenu()
+		if (key == ui.Back && menu) || (key == ui.Enter && !menu && k.entered) {
+			k.pending = k.pending[1:]
+			continue
+		}
+		if now < k.nextKey || !k.Ready() || (key != ui.Back && !menu) {
+			return
+		}
+		k.Keys <- ui.KeyEvent{Key: key, Millis: now}
+		k.nextKey = now + KeyMillis
+		if key == ui.Enter {
+			k.entered = true
+		} else if key != ui.Back {
+			k.pending = k.pending[1:]
+		}
+		return
+	}
+}
@@ -0,0 +1,186 @@
+package sumo
+
+import (
+	"go-bots/sim"
+	"go-bots/sim/replay"
+	"math"
+	"time"
+)
+
+// Controller drives a robot of the arena
+type Controller interface {
+	// Name identifies the controller
+	Name() string
+	// Body returns the shape and sensors of the robot it drives
+	Body() Body
+	// Start prepares a round that begins at now
+	Start(now int)
+	// Step reads the sensors of r at now and sets its motors
+	Step(now int, r *Robot, w *World)
+	// State describes what the controller is doing
+	State() string
+}
+
+// StallTimeout is how long a controller waits for its logic to take a
+// reading: a logic that stops reading (when a behaviour ends without
+// handing off) leaves its robot stopped, like the motors watchdog does
+const StallTimeout = time.Second
+
+// Idle is an opponent that does not move
+type Idle struct {
+	Shape Body
+}
+
+// Name identifies the controller
+func (c *Idle) Name() string {
+	return "idle"
+}
+
+// Body returns the shape of the robot
+func (c *Idle) Body() Body {
+	return c.Shape
+}
+
+// Start does nothing
+func (c *Idle) Start(now int) {}
+
+// Step stops the motors
+func (c *Idle) Step(now int, r *Robot, w *World) {
+	r.SetDuty(0, 0)
+}
+
+// State describes what the controller is doing
+func (c *Idle) State() string {
+	return "idle"
+}
+
+// DefaultBody is a robot without sensors, used for dummy opponents
+func DefaultBody() Body {
+	return Body{
+		Radius:      100,
+		Mass:        1,
+		Friction:    0.8,
+		WheelBase:   150,
+		MaxSpeed:    500,
+		MotorMillis: 80,
+	}
+}
+
+// Results of a match
+const (
+	// Draw means that no robot was pushed out in time (or both fell together)
+	Draw = -1
+)
+
+// Result is the outcome of a match
+type Result struct {
+	// Winner is the index of the winning robot, or Draw
+	Winner int
+	Millis int
+	Reason string
+}
+
+// Match runs controllers against each other
+type Match struct {
+	World       *World
+	Controllers []Controller
+	// StepMillis is the duration of a simulation step
+	StepMillis int
+	// MaxMillis ends the match with a draw
+	MaxMillis int
+	// Observe is called after every step (when set)
+	Observe func(w *World)
+	// Record receives a frame after every step (when set)
+	Record *replay.Run
+}
+
+// NewMatch places the robots of the controllers facing each other, distance apart
+func NewMatch(arena Arena, seed int64, distance float64, controllers ...Controller) *Match {
+	w := NewWorld(arena, seed)
+	n := len(controllers)
+	for i, c := range controllers {
+		angle := math.Pi + 2*math.Pi*float64(i)/float64(n)
+		pos := sim.FromAngle(angle).Scale(distance / 2)
+		if n == 1 {
+			pos = sim.Vec{}
+		}
+		w.Add(c.Name(), c.Body(), pos, sim.NormalizeAngle(angle+math.Pi))
+	}
+	return &Match{
+		World:       w,
+		Controllers: controllers,
+		StepMillis:  10,
+		MaxMillis:   60000,
+	}
+}
+
+// Jitter randomizes the start of a match
+type Jitter struct {
+	// Distance moves each robot towards or away from the center (up to this far)
+	Distance float64 `check:"min=0"`
+	// Offset moves each robot sideways
+	Offset float64 `check:"min=0"`
+	// Heading turns each robot (up to this many degrees)
+	Heading float64 `check:"min=0,max=180"`
+	// Noise scales the sensor noise of the arena by up to this fraction
+	Noise float64 `check:"min=0,max=1"`
+}
+
+// Randomize moves the robots and changes the sensor noise, within the limits
+// of j, using the random source of the world
+func (m *Match) Randomize(j Jitter) {
+	w := m.World
+	uniform := func(limit float64) float64 {
+		return (w.rand.Float64()*2 - 1) * limit
+	}
+	for _, r := range w.Robots {
+		out := sim.FromAngle(r.Pose.Heading + math.Pi)
+		side := out.Rotate(math.Pi / 2)
+		r.Pose.Pos = r.Pose.Pos.Add(out.Scale(uniform(j.Distance))).Add(side.Scale(uniform(j.Offset)))
+		r.Pose.Heading = sim.NormalizeAngle(r.Pose.Heading + sim.Radians(uniform(j.Heading)))
+	}
+	w.Arena.ReflectNoise *= 1 + uniform(j.Noise)
+	w.Arena.IRNoise *= 1 + uniform(j.Noise)
+}
+
+// Run runs the match until a robot is out or time is up
+func (m *Match) Run() Result {
+	w := m.World
+	for _, c := range m.Controllers {
+		c.Start(w.Millis)
+	}
+	start := w.Millis
+	for {
+		for i, c := range m.Controllers {
+			c.Step(w.Millis, w.Robots[i], w)
+		}
+		w.Step(m.StepMillis)
+		if m.Observe != nil {
+			m.Observe(w)
+		}
+		if m.Record != nil {
+			m.Record.Add(m.Frame())
+		}
+
+		elapsed := w.Millis - start
+		out := []int{}
+		for i, r := range w.Robots {
+			if r.Out {
+				out = append(out, i)
+			}
+		}
+		if len(out) > 0 && len(out) == len(w.Robots)-1 {
+			winner := 0
+			for winner < len(w.Robots) && w.Robots[winner].Out {
+				winner++
+			}
+			return Result{Winner: winner, Millis: elapsed, Reason: "out"}
+		}
+		if len(out) > 0 && len(out) >= len(w.Robots)-1 {
+			return Result{Winner: Draw, Millis: elapsed, Reason: "all out"}
+		}
+		if elapsed >= m.MaxMillis {
+			return Result{Winner: Draw, Millis: elapsed, Reason: "time"}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package sumo
+
+import (
+	"go-bots/sim"
+	"go-bots/sim/replay"
+)
+
+// Insight is what the logic of a controller makes of its sensors
+type Insight struct {
+	// Edges are the reflect sensors that see the edge of the dohyo
+	Edges []string
+	// Vision is where the logic thinks the opponent is (nil when it has no vision)
+	Vision *replay.Vision
+}
+
+// Inspector is a controller that tells its Insight, for recordings
+type Inspector interface {
+	Insight() Insight
+}
+
+// Floor returns the dohyo as shapes: the black surface inside the white edge
+func (a Arena) Floor() []replay.Shape {
+	return []replay.Shape{
+		{Kind: replay.Disc, Points: []sim.Vec{{}}, Radius: a.Radius, Color: replay.White},
+		{Kind: replay.Disc, Points: []sim.Vec{{}}, Radius: a.Radius - a.EdgeWidth, Color: replay.Black},
+	}
+}
+
+// Frame returns the robots of the match as they are now, with the last
+// readings of their sensors
+func (m *Match) Frame() replay.Frame {
+	w := m.World
+	f := replay.Frame{Millis: w.Millis}
+	for i, r := range w.Robots {
+		c := m.Controllers[i]
+		insight := Insight{}
+		if in, ok := c.(Inspector); ok {
+			insight = in.Insight()
+		}
+		rb := replay.Robot{
+			Name:    r.Name,
+			Pos:     r.Pose.Pos,
+			Heading: r.Pose.Heading,
+			Radius:  r.Body.Radius,
+			State:   c.State(),
+			Vision:  insight.Vision,
+		}
+		for _, s := range r.Body.Sensors {
+			value, ok := r.Reading(s.Name)
+			if !ok {
+				continue
+			}
+			pos, dir := w.SensorPose(r, s)
+			ray := replay.Ray{Name: s.Name, From: pos, To: pos, Value: value}
+			if s.Kind == IR {
+				ray.To = pos.Add(sim.FromAngle(dir).Scale(float64(value) * w.Arena.IRRange / 100))
+				ray.Hit = value < 100
+			} else {
+				for _, e := range insight.Edges {
+					ray.Hit = ray.Hit || e == s.Name
+				}
+			}
+			rb.Rays = append(rb.Rays, ray)
+		}
+		f.Robots = append(f.Robots, rb)
+	}
+	return f
+}
@@ -0,0 +1,242 @@
+package sumo
+
+import (
+	"go-bots/sim"
+	"math"
+	"math/rand"
+)
+
+// Robot is a robot in the arena
+type Robot struct {
+	Name string
+	Body Body
+	Pose sim.Pose
+	// Velocity is the speed of the body (in mm/s), it differs from the
+	// one of the wheels when the robot slips or is pushed
+	Velocity sim.Vec
+	Drive    sim.Drive
+	// HeadAngle is the direction of the head (in radians, counterclockwise from forward)
+	HeadAngle float64
+	// Out tells that the robot has left the dohyo
+	Out bool
+
+	leftDuty  int
+	rightDuty int
+	// readings are the last value read from each sensor
+	readings map[string]int
+}
+
+// Reading returns the last value read from the named sensor
+func (r *Robot) Reading(name string) (int, bool) {
+	v, ok := r.readings[name]
+	return v, ok
+}
+
+// SetDuty sets the duty cycle (from -100 to 100) of the left and right motors
+func (r *Robot) SetDuty(left int, right int) {
+	r.leftDuty, r.rightDuty = sim.Clamp(left, -100, 100), sim.Clamp(right, -100, 100)
+}
+
+// Duty returns the duty cycle of the left and right motors
+func (r *Robot) Duty() (left int, right int) {
+	return r.leftDuty, r.rightDuty
+}
+
+// traction is the largest force the wheels can push with (without gravity)
+func (r *Robot) traction() float64 {
+	return r.Body.Mass * r.Body.Friction
+}
+
+// World is the arena with the robots in it
+type World struct {
+	Arena  Arena
+	Robots []*Robot
+	// Millis is the simulated time
+	Millis int
+
+	rand *rand.Rand
+}
+
+// NewWorld returns an empty arena, seed makes the sensor noise repeatable
+func NewWorld(arena Arena, seed int64) *World {
+	return &World{
+		Arena: arena,
+		rand:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Add places a robot in the arena, heading is in radians
+func (w *World) Add(name string, body Body, pos sim.Vec, heading float64) *Robot {
+	r := &Robot{
+		Name: name,
+		Body: body,
+		Pose: sim.Pose{Pos: pos, Heading: heading},
+		Drive: sim.Drive{
+			WheelBase:   body.WheelBase,
+			MaxSpeed:    body.MaxSpeed,
+			MotorMillis: body.MotorMillis,
+		},
+	}
+	w.Robots = append(w.Robots, r)
+	return r
+}
+
+// Step advances the simulation by millis
+func (w *World) Step(millis int) {
+	dt := float64(millis) / 1000
+
+	for _, r := range w.Robots {
+		speed, rotation := r.Drive.Update(r.leftDuty, r.rightDuty, float64(millis))
+		desired := sim.FromAngle(r.Pose.Heading).Scale(speed)
+		// The wheels can change the velocity of the body only as fast as their grip allows
+		change := desired.Sub(r.Velocity)
+		maxChange := r.Body.Friction * w.Arena.Gravity * dt
+		if l := change.Len(); l > maxChange {
+			change = change.Scale(maxChange / l)
+		}
+		r.Velocity = r.Velocity.Add(change)
+		r.Pose.Heading = sim.NormalizeAngle(r.Pose.Heading + rotation*dt)
+	}
+
+	w.push()
+
+	for _, r := range w.Robots {
+		r.Pose.Pos = r.Pose.Pos.Add(r.Velocity.Scale(dt))
+	}
+
+	w.separate()
+
+	for _, r := range w.Robots {
+		if r.Pose.Pos.Len() > w.Arena.Radius {
+			r.Out = true
+		}
+	}
+	w.Millis += millis
+}
+
+// contact returns the normal from a to b and the overlap of their bodies
+// (negative when they do not touch)
+func contact(a *Robot, b *Robot) (normal sim.Vec, overlap float64) {
+	d := b.Pose.Pos.Sub(a.Pose.Pos)
+	dist := d.Len()
+	overlap = a.Body.Radius + b.Body.Radius - dist
+	if dist == 0 {
+		return sim.Vec{X: 1}, overlap
+	}
+	return d.Scale(1 / dist), overlap
+}
+
+// push makes robots in contact move together along the contact normal, the
+// common speed is weighted by their traction: the heavier and grippier
+// robot pushes the other one
+func (w *World) push() {
+	for i, a := range w.Robots {
+		for _, b := range w.Robots[i+1:] {
+			normal, overlap := contact(a, b)
+			if overlap < 0 {
+				continue
+			}
+			va, vb := a.Velocity.Dot(normal), b.Velocity.Dot(normal)
+			if va <= vb {
+				continue
+			}
+			ta, tb := a.traction(), b.traction()
+			common := (va + vb) / 2
+			if ta+tb > 0 {
+				common = (ta*va + tb*vb) / (ta + tb)
+			}
+			a.Velocity = a.Velocity.Add(normal.Scale(common - va))
+			b.Velocity = b.Velocity.Add(normal.Scale(common - vb))
+		}
+	}
+}
+
+// separate moves overlapping robots apart, the lighter one moves more
+func (w *World) separate() {
+	for i, a := range w.Robots {
+		for _, b := range w.Robots[i+1:] {
+			normal, overlap := contact(a, b)
+			if overlap <= 0 {
+				continue
+			}
+			shareA := 0.5
+			if a.Body.Mass+b.Body.Mass > 0 {
+				shareA = b.Body.Mass / (a.Body.Mass + b.Body.Mass)
+			}
+			a.Pose.Pos = a.Pose.Pos.Sub(normal.Scale(overlap * shareA))
+			b.Pose.Pos = b.Pose.Pos.Add(normal.Scale(overlap * (1 - shareA)))
+		}
+	}
+}
+
+// SensorPose returns where a sensor of a robot is and where it looks
+func (w *World) SensorPose(r *Robot, s Sensor) (pos sim.Vec, dir float64) {
+	pos = r.Pose.ToWorld(sim.Vec{X: s.X, Y: s.Y})
+	dir = r.Pose.Heading + sim.Radians(s.Angle)
+	if s.OnHead {
+		dir += r.HeadAngle
+	}
+	return pos, sim.NormalizeAngle(dir)
+}
+
+// Sense returns the reading of the named sensor of a robot, -1 if it has no such sensor
+func (w *World) Sense(r *Robot, name string) int {
+	i := r.Body.SensorIndex(name)
+	if i < 0 {
+		return -1
+	}
+	s := r.Body.Sensors[i]
+	value := 0
+	if s.Kind == IR {
+		value = w.IR(r, s)
+	} else {
+		value = w.Reflect(r, s)
+	}
+	if r.readings == nil {
+		r.readings = map[string]int{}
+	}
+	r.readings[name] = value
+	return value
+}
+
+// Reflect returns the reflectance seen by a color sensor
+func (w *World) Reflect(r *Robot, s Sensor) int {
+	pos, _ := w.SensorPose(r, s)
+	dist := pos.Len()
+	value := w.Arena.SurfaceReflect
+	if dist > w.Arena.Radius {
+		value = w.Arena.OutsideReflect
+	} else if dist > w.Arena.Radius-w.Arena.EdgeWidth {
+		value = w.Arena.EdgeReflect
+	}
+	return sim.Clamp(value+int(math.Round(sim.Noise(w.rand, w.Arena.ReflectNoise))), 0, 100)
+}
+
+// IR returns the proximity reading of an IR sensor: the distance to the
+// closest robot in its cone, scaled so that IRRange reads 100
+func (w *World) IR(r *Robot, s Sensor) int {
+	pos, dir := w.SensorPose(r, s)
+	halfAngle := sim.Radians(w.Arena.IRHalfAngle)
+	closest := w.Arena.IRRange
+	for _, o := range w.Robots {
+		if o == r {
+			continue
+		}
+		d := o.Pose.Pos.Sub(pos)
+		dist := d.Len()
+		if dist <= o.Body.Radius {
+			closest = 0
+			continue
+		}
+		spread := math.Asin(o.Body.Radius / dist)
+		if math.Abs(sim.NormalizeAngle(d.Angle()-dir)) > halfAngle+spread {
+			continue
+		}
+		closest = math.Min(closest, dist-o.Body.Radius)
+	}
+	if closest >= w.Arena.IRRange {
+		return 100
+	}
+	value := closest*100/w.Arena.IRRange + sim.Noise(w.rand, w.Arena.IRNoise)
+	return sim.Clamp(int(math.Round(value)), 0, 100)
+}
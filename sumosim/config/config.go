@@ -0,0 +1,64 @@
+package config
+
+import (
+	"go-bots/botconf"
+	"go-bots/sim/sumo"
+	"io/ioutil"
+)
+
+// Config data
+type Config struct {
+	// Profile is the name of the profile in use (empty for the base section)
+	Profile string `toml:"-"`
+
+	// StepMillis is the duration of a simulation step (the io loop period of the bots)
+	StepMillis int `check:"required,min=1,max=100"`
+	// MaxMillis ends a match with a draw (it includes the pause before the start)
+	MaxMillis int `check:"required,min=1"`
+	// StartDistance is the distance between the centers of the robots at the start
+	StartDistance float64 `check:"min=0"`
+	// Seed makes the sensor noise (and the jitter) repeatable
+	Seed int64
+	// Jitter randomizes the start positions and the sensor noise
+	Jitter sumo.Jitter
+	Arena  sumo.Arena
+	// Bodies replace the simulated body of a bot, by bot name
+	Bodies map[string]sumo.Body
+}
+
+// Default Config data
+func Default() Config {
+	return Config{
+		StepMillis:    10,
+		MaxMillis:     60000,
+		StartDistance: 600,
+		Seed:          1,
+		Arena:         sumo.DefaultArena(),
+		Bodies:        map[string]sumo.Body{},
+	}
+}
+
+// FromString reads Config data from a TOML string applying the named profile
+// and then sets (as Key=Value), values that are not in it keep their defaults
+func FromString(data string, profile string, sets []string) (Config, error) {
+	result := Default()
+	name, err := botconf.DecodeProfileSets(data, profile, sets, &result)
+	if err != nil {
+		return result, err
+	}
+	err = botconf.Validate(&result)
+	if err != nil {
+		return result, err
+	}
+	result.Profile = name
+	return result, nil
+}
+
+// FromFile reads Config data from a TOML file applying the named profile and then sets
+func FromFile(fileName string, profile string, sets []string) (Config, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return Config{}, err
+	}
+	return FromString(string(b), profile, sets)
+}
@@ -0,0 +1,180 @@
+package contest
+
+import (
+	"fmt"
+	"go-bots/border"
+	"go-bots/botconf"
+	"go-bots/clock"
+	"go-bots/cmdline"
+	"go-bots/ev3"
+	scoobaconf "go-bots/scooba/config"
+	scoobasim "go-bots/scooba/simio"
+	seeker2conf "go-bots/seeker2/config"
+	seeker2sim "go-bots/seeker2/simio"
+	"go-bots/sim/sumo"
+	"go-bots/sumosim/config"
+	xl4conf "go-bots/xl4/config"
+	xl4sim "go-bots/xl4/simio"
+	"strings"
+	"time"
+)
+
+// Spec describes a contestant: bot[:strategy[:dir[:profile]]]
+type Spec struct {
+	Bot      string
+	Strategy string
+	Dir      ev3.Direction
+	Profile  string
+}
+
+// ParseSpec reads a contestant given as bot[:strategy[:dir[:profile]]]
+func ParseSpec(s string) (Spec, error) {
+	parts := strings.Split(s, ":")
+	result := Spec{Bot: parts[0], Dir: ev3.Left}
+	if len(parts) > 1 {
+		result.Strategy = parts[1]
+	}
+	if len(parts) > 2 {
+		switch parts[2] {
+		case "left", "":
+		case "right":
+			result.Dir = ev3.Right
+		default:
+			return result, fmt.Errorf("%s: unknown direction %q (must be left or right)", s, parts[2])
+		}
+	}
+	if len(parts) > 3 {
+		result.Profile = parts[3]
+	}
+	if len(parts) > 4 {
+		return result, fmt.Errorf("%s: expected bot[:strategy[:dir[:profile]]]", s)
+	}
+	return result, nil
+}
+
+func defaultStrategy(s string, strategies []string) string {
+	if s == "" {
+		return strategies[0]
+	}
+	return s
+}
+
+// loadBotConfig loads the configuration of a bot with the values of sets on
+// top, keeping the defaults when the file cannot be read. The sets are first
+// checked on defaults (a pointer to the default configuration of the bot), a
+// mistake in them, or a file that cannot be read with them, is an error. load
+// reads the named file
+func loadBotConfig(bot string, load func(fileName string) error, defaults interface{}, sets []string) error {
+	err := botconf.ApplyOverrides(defaults, sets)
+	if err != nil {
+		return fmt.Errorf("%s: %v", bot, err)
+	}
+	err = load(cmdline.FindConfig(bot + ".toml"))
+	if err != nil && len(sets) > 0 {
+		return fmt.Errorf("%s conf with %s: %v", bot, strings.Join(sets, " "), err)
+	}
+	if err != nil {
+		cmdline.Log(cmdline.Error, "Error reading", bot, "conf, using defaults:", err)
+	}
+	return nil
+}
+
+// NewController starts the logic of a bot, with the values of sets on top of
+// its configuration file. bodies replace the simulated body of a bot, by bot
+// name. The logic keeps waiting for readings once the match is over
+func NewController(s Spec, sets []string, bodies map[string]sumo.Body) (sumo.Controller, error) {
+	var c sumo.Controller
+	var err error
+	// The bot's configuration changes are looked for on its simulated time
+	clk := clock.NewManual(time.Time{})
+	switch s.Bot {
+	case "seeker2":
+		defaults := seeker2conf.Default()
+		var botConf *seeker2conf.File
+		err = loadBotConfig(s.Bot, func(fileName string) (err error) {
+			botConf, err = seeker2conf.Load(fileName, s.Profile, sets, clk)
+			return err
+		}, &defaults, sets)
+		var b *seeker2sim.Bot
+		if err == nil {
+			b, err = seeker2sim.New(defaultStrategy(s.Strategy, seeker2sim.Strategies), s.Dir, clk, botConf, &border.Current{})
+		}
+		if err == nil {
+			if body, ok := bodies[s.Bot]; ok {
+				b.SetBody(body)
+			}
+			c = b
+		}
+	case "xl4":
+		defaults := xl4conf.Default()
+		var botConf *xl4conf.File
+		err = loadBotConfig(s.Bot, func(fileName string) (err error) {
+			botConf, err = xl4conf.Load(fileName, s.Profile, sets, clk)
+			return err
+		}, &defaults, sets)
+		var b *xl4sim.Bot
+		if err == nil {
+			b, err = xl4sim.New(defaultStrategy(s.Strategy, xl4sim.Strategies), s.Dir, clk, botConf, &border.Current{})
+		}
+		if err == nil {
+			if body, ok := bodies[s.Bot]; ok {
+				b.SetBody(body)
+			}
+			c = b
+		}
+	case "scooba":
+		defaults := scoobaconf.Default()
+		var botConf *scoobaconf.File
+		err = loadBotConfig(s.Bot, func(fileName string) (err error) {
+			botConf, err = scoobaconf.Load(fileName, s.Profile, sets, clk)
+			return err
+		}, &defaults, sets)
+		var b *scoobasim.Bot
+		if err == nil {
+			b, err = scoobasim.New(defaultStrategy(s.Strategy, scoobasim.Strategies), s.Dir, clk, botConf)
+		}
+		if err == nil {
+			if body, ok := bodies[s.Bot]; ok {
+				b.SetBody(body)
+			}
+			c = b
+		}
+	case "idle":
+		body, ok := bodies[s.Bot]
+		if !ok {
+			body = sumo.DefaultBody()
+		}
+		c = &sumo.Idle{Shape: body}
+	default:
+		err = fmt.Errorf("unknown bot %q (must be seeker2, xl4, scooba or idle)", s.Bot)
+	}
+	return c, err
+}
+
+// NewMatch places the robots of the controllers in the arena of conf, with
+// its seed, timing and start jitter
+func NewMatch(conf config.Config, controllers ...sumo.Controller) *sumo.Match {
+	m := sumo.NewMatch(conf.Arena, conf.Seed, conf.StartDistance, controllers...)
+	m.StepMillis = conf.StepMillis
+	m.MaxMillis = conf.MaxMillis
+	m.Randomize(conf.Jitter)
+	return m
+}
+
+// Play runs a match with conf between the contestants a and b, given as
+// bot[:strategy[:dir[:profile]]] and read from their configuration files
+func Play(conf config.Config, a string, b string) (sumo.Result, error) {
+	controllers := []sumo.Controller{}
+	for _, arg := range []string{a, b} {
+		s, err := ParseSpec(arg)
+		if err != nil {
+			return sumo.Result{}, err
+		}
+		c, err := NewController(s, nil, conf.Bodies)
+		if err != nil {
+			return sumo.Result{}, err
+		}
+		controllers = append(controllers, c)
+	}
+	return NewMatch(conf, controllers...).Run(), nil
+}
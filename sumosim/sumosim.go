@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-bots/botconf"
+	"go-bots/cmdline"
+	"go-bots/ev3"
+	"go-bots/sim"
+	"go-bots/sim/replay"
+	"go-bots/sim/sumo"
+	"go-bots/sumosim/config"
+	"go-bots/sumosim/contest"
+	"os"
+)
+
+var conf = config.Default()
+
+func print(data ...interface{}) {
+	if cmdline.Enabled(cmdline.Info) {
+		fmt.Fprintln(os.Stderr, data...)
+	}
+}
+
+func printError(data ...interface{}) {
+	fmt.Fprintln(os.Stderr, data...)
+}
+
+// observe logs the robots at every step (as "arena" lines, mixed with the
+// trace lines of the bots)
+func observe(m *sumo.Match) func(w *sumo.World) {
+	return func(w *sumo.World) {
+		if !cmdline.Tracing() && !cmdline.Enabled(cmdline.Debug) {
+			return
+		}
+		line := []interface{}{"arena", w.Millis}
+		for i, r := range w.Robots {
+			left, right := r.Duty()
+			line = append(line, r.Name, int(r.Pose.Pos.X), int(r.Pose.Pos.Y), int(sim.Degrees(r.Pose.Heading)), left, right, m.Controllers[i].State())
+		}
+		cmdline.Trace(line...)
+		if cmdline.Enabled(cmdline.Debug) {
+			fmt.Fprintln(os.Stderr, line...)
+		}
+	}
+}
+
+func main() {
+	ev3.HandleSignals()
+	defer ev3.Recover()
+
+	first := flag.String("a", "seeker2", "first contestant, as `bot[:strategy[:dir[:profile]]]` (bots: seeker2, xl4, scooba, idle)")
+	second := flag.String("b", "idle", "second contestant, as `bot[:strategy[:dir[:profile]]]`")
+	seed := flag.Int64("seed", 0, "random `seed` of the noise and jitter (0 keeps the one of the configuration)")
+	record := flag.String("record", "", "record the match to `file` (.json to play it back with replay, .svg or .gif)")
+	var setsA, setsB cmdline.Sets
+	flag.Var(&setsA, "seta", "override a configuration value of the first contestant, as `Key=Value` (repeatable)")
+	flag.Var(&setsB, "setb", "override a configuration value of the second contestant, as `Key=Value` (repeatable)")
+	opts := cmdline.Parse("sumosim.toml")
+
+	c, err := config.FromFile(opts.ConfigFile, opts.Profile, opts.Sets)
+	if err != nil {
+		printError("Error reading conf, using defaults:", err)
+	} else {
+		conf = c
+	}
+	if *seed != 0 {
+		conf.Seed = *seed
+	}
+	print("Configuration loaded:\n" + botconf.String(&conf))
+
+	if *record != "" {
+		err := replay.CheckFormat(*record)
+		if err != nil {
+			ev3.Fatalln("Error:", err)
+		}
+	}
+
+	specs := []contest.Spec{}
+	for _, arg := range []string{*first, *second} {
+		s, err := contest.ParseSpec(arg)
+		if err != nil {
+			ev3.Fatalln("Error:", err)
+		}
+		specs = append(specs, s)
+	}
+
+	controllers := []sumo.Controller{}
+	for i, s := range specs {
+		c, err := contest.NewController(s, [][]string{setsA, setsB}[i], conf.Bodies)
+		if err != nil {
+			ev3.Fatalln("Error:", err)
+		}
+		controllers = append(controllers, c)
+	}
+
+	m := contest.NewMatch(conf, controllers...)
+	m.Observe = observe(m)
+	if *record != "" {
+		m.Record = &replay.Run{Title: *first + " vs " + *second, Floor: conf.Arena.Floor()}
+	}
+	result := m.Run()
+	if m.Record != nil {
+		err := replay.Save(*record, m.Record)
+		if err != nil {
+			printError("Error saving the recording:", err)
+		}
+	}
+
+	// The side (a or b) tells the winner apart when both run the same bot
+	winner, side := "draw", "-"
+	if result.Winner != sumo.Draw {
+		winner = controllers[result.Winner].Name()
+		side = string(rune('a' + result.Winner))
+	}
+	fmt.Println("winner", winner, "side", side, "reason", result.Reason, "millis", result.Millis)
+	ev3.Exit(ev3.ExitOK, "Done")
+}
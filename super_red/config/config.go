@@ -1,67 +1,167 @@
 package config
 
 import (
+	"fmt"
+	"go-bots/botconf"
+	"go-bots/ev3"
 	"io/ioutil"
-
-	"github.com/BurntSushi/toml"
 )
 
 // Config data
 type Config struct {
-	MaxSpeed             int
-	TrackTurnSpeed       int
-	SeekTurnSpeed        int
-	TrackSpeed           int
-	MaxIrFront           int
-	MaxIrSide            int
-	StrategyR1Time       int
-	StrategyS1Time       int
-	StrategyR2Time       int
-	StrategyS2Time       int
-	StrategyStraightTime int
+	// Profile is the name of the profile in use (empty for the base section)
+	Profile string `toml:"-"`
+
+	MaxSpeed       int                 `check:"required,min=1,max=100"`
+	TrackTurnSpeed int                 `check:"min=0,le=MaxSpeed"`
+	SeekTurnSpeed  int                 `check:"min=0,le=MaxSpeed"`
+	TrackSpeed     int                 `check:"min=0,le=MaxSpeed"`
+	MaxIrFront     int                 `check:"min=0,max=100"`
+	MaxIrSide      int                 `check:"min=0,max=100"`
+	Strategies     map[string]Strategy `check:"required"`
+	WatchdogMillis int                 `check:"min=0"`
 }
 
-// Default Config data
-func Default() Config {
+// DeprecatedKeys lists the keys of older super_red.toml files, they are ignored
+func (Config) DeprecatedKeys() map[string]string {
+	moved := "set the Time of the steps in Strategies instead"
+	return map[string]string{
+		"StrategyR1Time":       moved,
+		"StrategyS1Time":       moved,
+		"StrategyR2Time":       moved,
+		"StrategyS2Time":       moved,
+		"StrategyStraightTime": moved,
+	}
+}
+
+// Strategy is an opening sequence of steps, followed by tracking towards Track
+type Strategy struct {
+	Track    string        `check:"oneof=left|right|straight"`
+	TrackDir ev3.Direction `toml:"-"`
+	Steps    []Step        `check:"required"`
+}
+
+// Step moves the bot in a direction for some time (milliseconds in the file, ticks once loaded)
+type Step struct {
+	Direction string        `check:"oneof=left|right|straight"`
+	Dir       ev3.Direction `toml:"-"`
+	Time      int           `check:"required,min=1,max=10000"`
+	// Speed is the outer wheel speed, 0 means MaxSpeed
+	Speed      int `check:"min=0,max=100"`
+	InnerSpeed int `check:"min=-100,max=100"`
+	UseBack    int `check:"min=-1,max=1"`
+	LowerFront bool
+	UseVision  bool
+}
+
+// Direction names used by strategies
+const (
+	DirectionLeft     = "left"
+	DirectionRight    = "right"
+	DirectionStraight = "straight"
+)
+
+// DefaultWatchdogMillis is used when the configuration does not set WatchdogMillis
+const DefaultWatchdogMillis = 1000
+
+func turnStrategy(dir string, opposite string) Strategy {
+	return Strategy{
+		Track: opposite,
+		Steps: []Step{
+			{Direction: dir, Time: 500, InnerSpeed: -10, LowerFront: true},
+			{Direction: DirectionStraight, Time: 100, UseBack: -1, LowerFront: true},
+			{Direction: DirectionStraight, Time: 900, LowerFront: true, UseVision: true},
+			{Direction: opposite, Time: 1000, InnerSpeed: -10, LowerFront: true, UseVision: true},
+			{Direction: DirectionStraight, Time: 900, LowerFront: true, UseVision: true},
+		},
+	}
+}
+
+// defaults are the default values as a file would give them (before fixConfig)
+func defaults() Config {
 	return Config{
-		MaxSpeed:             100,
-		TrackTurnSpeed:       100,
-		SeekTurnSpeed:        100,
-		TrackSpeed:           100,
-		MaxIrFront:           40,
-		MaxIrSide:            30,
-		StrategyR1Time:       500,
-		StrategyS1Time:       900,
-		StrategyR2Time:       1000,
-		StrategyS2Time:       900,
-		StrategyStraightTime: 1200,
+		MaxSpeed:       100,
+		TrackTurnSpeed: 100,
+		SeekTurnSpeed:  100,
+		TrackSpeed:     100,
+		MaxIrFront:     40,
+		MaxIrSide:      30,
+		Strategies: map[string]Strategy{
+			DirectionLeft:  turnStrategy(DirectionLeft, DirectionRight),
+			DirectionRight: turnStrategy(DirectionRight, DirectionLeft),
+			DirectionStraight: {
+				Track: DirectionLeft,
+				Steps: []Step{
+					{Direction: DirectionStraight, Time: 500, LowerFront: true},
+					{Direction: DirectionStraight, Time: 100, UseBack: -1, LowerFront: true},
+					{Direction: DirectionStraight, Time: 1200, LowerFront: true, UseVision: true},
+				},
+			},
+		},
+		WatchdogMillis: DefaultWatchdogMillis,
 	}
 }
 
-// FromString reads Config data from a TOML string
-func FromString(data string) (Config, error) {
-	result := Config{}
-	_, err := toml.Decode(data, &result)
+// Default Config data
+func Default() Config {
+	result := defaults()
+	fixConfig(&result)
+	return result
+}
+
+// FromString reads Config data from a TOML string applying the named profile
+// and then sets (as Key=Value), values that are not in it keep their defaults
+func FromString(data string, profile string, sets []string) (Config, error) {
+	result := defaults()
+	name, err := botconf.DecodeProfileSets(data, profile, sets, &result)
 	if err != nil {
 		return result, err
 	}
+	err = botconf.Validate(&result)
+	if err != nil {
+		return result, err
+	}
+	result.Profile = name
 	fixConfig(&result)
 	return result, nil
 }
 
-// FromFile reads Config data from a TOML file
-func FromFile(fileName string) (Config, error) {
+// FromFile reads Config data from a TOML file applying the named profile and then sets
+func FromFile(fileName string, profile string, sets []string) (Config, error) {
 	b, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return Config{}, err
 	}
-	return FromString(string(b))
+	return FromString(string(b), profile, sets)
 }
 
 func fixConfig(c *Config) {
-	c.StrategyR1Time *= 1000
-	c.StrategyS1Time *= 1000
-	c.StrategyR2Time *= 1000
-	c.StrategyS2Time *= 1000
-	c.StrategyStraightTime *= 1000
+	if c.WatchdogMillis == 0 {
+		c.WatchdogMillis = DefaultWatchdogMillis
+	}
+	for name, strategy := range c.Strategies {
+		strategy.TrackDir, _ = ParseDirection(strategy.Track)
+		for i := range strategy.Steps {
+			step := &strategy.Steps[i]
+			step.Dir, _ = ParseDirection(step.Direction)
+			step.Time *= 1000
+			if step.Speed == 0 {
+				step.Speed = c.MaxSpeed
+			}
+		}
+		c.Strategies[name] = strategy
+	}
+}
+
+// ParseDirection converts a direction name into an ev3.Direction
+func ParseDirection(name string) (ev3.Direction, error) {
+	switch name {
+	case DirectionLeft:
+		return ev3.Left, nil
+	case DirectionRight:
+		return ev3.Right, nil
+	case DirectionStraight:
+		return ev3.NoDirection, nil
+	}
+	return ev3.NoDirection, fmt.Errorf("invalid direction %q", name)
 }
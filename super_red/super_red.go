@@ -3,33 +3,26 @@ package main
 import (
 	"fmt"
 	"go-bots/beep"
+	"go-bots/botconf"
+	"go-bots/clock"
+	"go-bots/cmdline"
 	"go-bots/ev3"
 	"go-bots/super_red/config"
-	"log"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 )
 
-func handleSignals() {
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigs
-		quit("Terminated by signal", sig)
-	}()
-}
-
 var devs *ev3.Devices
+var clk clock.Clock = clock.Real{}
 var initializationTime time.Time
 var motorL, motorR, motorFU, motorFD *ev3.Attribute
 var pmotorFU *ev3.Attribute
 var irL, irFL, irFR, irR *ev3.Attribute
 var irRemote1, irRemote2, irRemote3, irRemote4 *ev3.Attribute
 var buttons *ev3.Buttons
+var watchdog *ev3.Watchdog
 
-var conf config.Config
+var conf = config.Default()
 
 func closeIrProx() {
 	if irL != nil {
@@ -112,14 +105,15 @@ func setIrRemoteMode(remoteChannel int) {
 		irRemote3 = ev3.OpenTextR(devs.In3, ev3.Value3)
 		irRemote4 = ev3.OpenTextR(devs.In4, ev3.Value3)
 	} else {
-		quit("Invalid remote channel number", remoteChannel)
+		ev3.Fatalln("Invalid remote channel number", remoteChannel)
 	}
 }
 
 func initialize() {
-	initializationTime = time.Now()
+	initializationTime = clk.Now()
 
-	buttons = ev3.OpenButtons(false)
+	buttons = ev3.OpenButtons(false, clk)
+	ev3.OnShutdown(beep.CCC)
 
 	devs = ev3.Scan(&ev3.OutPortModes{
 		OutA: ev3.OutPortModeAuto,
@@ -175,29 +169,6 @@ func initialize() {
 	ev3.RunCommand(devs.OutD, ev3.CmdRunDirect)
 }
 
-func close() {
-	beep.CCC()
-
-	// Close buttons
-	buttons.Close()
-
-	// Stop motors
-	ev3.RunCommand(devs.OutA, ev3.CmdReset)
-	ev3.RunCommand(devs.OutB, ev3.CmdReset)
-	ev3.RunCommand(devs.OutC, ev3.CmdReset)
-	ev3.RunCommand(devs.OutD, ev3.CmdReset)
-
-	// Close motors
-	motorL.Close()
-	motorR.Close()
-	motorFU.Close()
-	motorFD.Close()
-
-	// Close sensor values
-	closeIrProx()
-	closeIrRemote()
-}
-
 var lastMoveTicks int
 var lastSpeedLeft int
 var lastSpeedRight int
@@ -213,6 +184,9 @@ func move(left int, right int) {
 }
 
 func moveFull(left int, right int, useBack int, lowerFront bool) {
+	if !watchdog.Feed() {
+		return
+	}
 
 	motorL.Value = -left
 	motorR.Value = -right
@@ -274,19 +248,26 @@ func timespanAsTicks(start time.Time, end time.Time) int {
 	return durationToTicks(end.Sub(start))
 }
 func currentTicks() int {
-	return timespanAsTicks(initializationTime, time.Now())
+	return timespanAsTicks(initializationTime, clk.Now())
 }
 func ticksToMillis(ticks int) int {
 	return ticks / 1000
 }
 
 func print(data ...interface{}) {
-	fmt.Fprintln(os.Stderr, data...)
+	if cmdline.Enabled(cmdline.Info) {
+		fmt.Fprintln(os.Stderr, data...)
+	}
 }
 
-func quit(data ...interface{}) {
-	close()
-	log.Fatalln(data...)
+func debug(data ...interface{}) {
+	if cmdline.Enabled(cmdline.Debug) {
+		fmt.Fprintln(os.Stderr, data...)
+	}
+}
+
+func printError(data ...interface{}) {
+	fmt.Fprintln(os.Stderr, data...)
 }
 
 func checkVision() bool {
@@ -297,19 +278,37 @@ func checkVision() bool {
 	return false
 }
 
+var configFile = ""
+var watcher *botconf.Watcher
+var profile = ""
+
+// sets are the values given with -set, applied on every load
+var sets []string
+
 func loadConfig() {
-	newConf, err := config.FromFile("super_red.toml")
+	watcher.Reset()
+	newConf, err := config.FromFile(configFile, profile, sets)
 	if err != nil {
-		print("Error reading conf:", err)
-		conf = config.Default()
-		print("Using default conf", conf)
+		printError("Error reading conf, keeping the previous one:", err)
 	} else {
 		conf = newConf
-		print("Configuration loaded:", conf)
+		print("Configuration loaded:\n" + botconf.String(conf))
 	}
 }
 
-var strategyDirection = 0
+// selectNextProfile switches to the next profile of the configuration file
+func selectNextProfile() {
+	names, err := botconf.ProfileNamesInFile(configFile)
+	if err != nil {
+		printError("Error reading profiles:", err)
+		return
+	}
+	profile = botconf.NextProfile(names, conf.Profile)
+	print("profile", botconf.ProfileLabel(profile))
+	loadConfig()
+}
+
+var strategyName = config.DirectionStraight
 
 func chooseStrategy(channelNumber int) bool {
 	ev3.WriteStringAttribute(devs.OutB, ev3.Position, "0")
@@ -320,21 +319,31 @@ func chooseStrategy(channelNumber int) bool {
 
 		if remoteValue == 11 {
 			return true
+		} else if remoteValue == 0 && watcher.Changed() {
+			print("Configuration file changed")
+			loadConfig()
 		} else if remoteValue == 3 {
 			loadConfig()
 			ev3.WriteStringAttribute(devs.OutB, ev3.Position, "0")
 			beep.GC()
-			strategyDirection = -1
+			strategyName = config.DirectionLeft
 		} else if remoteValue == 4 {
 			loadConfig()
 			ev3.WriteStringAttribute(devs.OutB, ev3.Position, "0")
 			beep.CG()
-			strategyDirection = 1
+			strategyName = config.DirectionRight
 		} else if remoteValue == 2 {
 			loadConfig()
 			ev3.WriteStringAttribute(devs.OutB, ev3.Position, "0")
 			beep.GG()
-			strategyDirection = 0
+			strategyName = config.DirectionStraight
+		} else if remoteValue == 10 {
+			// Both red buttons: wait for the release and switch profile
+			for readRemote() == 10 {
+				moveStop()
+			}
+			selectNextProfile()
+			beep.CCC()
 		} else if remoteValue == 1 {
 			beep.C()
 			return false
@@ -357,79 +366,42 @@ func waitBegin() {
 
 func strategy() ev3.Direction {
 	setIrProxMode()
-	print("strategy")
-
-	if strategyDirection == -1 {
-		strategyTurn(-1)
-		return 1
-	} else if strategyDirection == 1 {
-		strategyTurn(1)
-		return -1
-	} else {
-		strategyStraight()
-		return -1
+	print("strategy", strategyName, "profile", botconf.ProfileLabel(conf.Profile))
+
+	s, ok := conf.Strategies[strategyName]
+	if !ok {
+		print("Unknown strategy", strategyName)
+		return ev3.Left
 	}
+	for _, step := range s.Steps {
+		if moveStep(step) {
+			break
+		}
+	}
+	return s.TrackDir
 }
 
-func moveStrategyFull(dir ev3.Direction, duration int, useBack int, lowerfront bool, useVision bool) bool {
+func moveStep(step config.Step) bool {
 	start := currentTicks()
 	for {
 		now := currentTicks()
-		if now-start >= duration {
+		if now-start >= step.Time {
 			break
 		}
-		if useVision && checkVision() {
+		if step.UseVision && checkVision() {
 			return true
 		}
-		if dir == ev3.Left {
-			moveFull(-10, conf.MaxSpeed, useBack, lowerfront)
-		} else if dir == ev3.Right {
-			moveFull(conf.MaxSpeed, -10, useBack, lowerfront)
+		if step.Dir == ev3.Left {
+			moveFull(step.InnerSpeed, step.Speed, step.UseBack, step.LowerFront)
+		} else if step.Dir == ev3.Right {
+			moveFull(step.Speed, step.InnerSpeed, step.UseBack, step.LowerFront)
 		} else {
-			moveFull(conf.MaxSpeed, conf.MaxSpeed, useBack, lowerfront)
+			moveFull(step.Speed, step.Speed, step.UseBack, step.LowerFront)
 		}
 	}
 	return false
 }
 
-func moveStrategy(dir ev3.Direction, duration int) bool {
-	return moveStrategyFull(dir, duration, 0, true, true)
-}
-
-func strategyTurn(dir ev3.Direction) {
-
-	if moveStrategyFull(dir, conf.StrategyR1Time, 0, true, false) {
-		return
-	}
-	if moveStrategyFull(0, 100000, -1, true, false) {
-		return
-	}
-
-	if moveStrategy(0, conf.StrategyS1Time) {
-		return
-	}
-	if moveStrategy(-dir, conf.StrategyR2Time) {
-		return
-	}
-	if moveStrategy(0, conf.StrategyS2Time) {
-		return
-	}
-}
-
-func strategyStraight() {
-
-	if moveStrategyFull(0, conf.StrategyR1Time, 0, true, false) {
-		return
-	}
-	if moveStrategyFull(0, 100000, -1, true, false) {
-		return
-	}
-
-	if moveStrategy(0, conf.StrategyStraightTime) {
-		return
-	}
-}
-
 func track(dir ev3.Direction) {
 	print("track", irL.Value, irFL.Value, irFR.Value, irR.Value)
 	for {
@@ -437,50 +409,60 @@ func track(dir ev3.Direction) {
 			return
 		}
 		read()
-		print(irL.Value, irFL.Value, irFR.Value, irR.Value)
+		debug(irL.Value, irFL.Value, irFR.Value, irR.Value)
+		cmdline.Trace(currentTicks(), irL.Value, irFL.Value, irFR.Value, irR.Value)
 
 		if irFL.Value < conf.MaxIrFront {
 			move(conf.TrackSpeed, conf.TrackSpeed)
 			dir = ev3.Left
-			print("FRONT LEFT")
+			debug("FRONT LEFT")
 		} else if irFR.Value < conf.MaxIrFront {
 			move(conf.TrackSpeed, conf.TrackSpeed)
 			dir = ev3.Right
-			print("FRONT RIGHT")
+			debug("FRONT RIGHT")
 		} else if irL.Value < conf.MaxIrSide {
 			move(-conf.TrackTurnSpeed, conf.TrackTurnSpeed)
 			dir = ev3.Left
-			print("LEFT")
+			debug("LEFT")
 		} else if irR.Value < conf.MaxIrSide {
 			move(conf.TrackTurnSpeed, -conf.TrackTurnSpeed)
 			dir = ev3.Right
-			print("RIGHT")
+			debug("RIGHT")
 		} else {
 			if dir == ev3.Right {
 				move(conf.SeekTurnSpeed, -conf.SeekTurnSpeed)
-				print("SEEK RIGHT")
+				debug("SEEK RIGHT")
 			} else if dir == ev3.Left {
 				move(-conf.SeekTurnSpeed, conf.SeekTurnSpeed)
-				print("SEEK LEFT")
+				debug("SEEK LEFT")
 			} else {
-				print("SEEK NONE")
+				debug("SEEK NONE")
 			}
 		}
 	}
 }
 
 func main() {
-	handleSignals()
+	ev3.HandleSignals()
+	defer ev3.Recover()
+
+	opts := cmdline.Parse("super_red.toml")
+	configFile, profile, sets = opts.ConfigFile, opts.Profile, opts.Sets
+	watcher = botconf.NewWatcher(configFile, botconf.WatchInterval, clk)
+
 	initialize()
-	defer close()
 
 	beep.G()
 
+	loadConfig()
+	watchdog = ev3.NewWatchdog(devs, time.Duration(conf.WatchdogMillis)*time.Millisecond, clk)
+	watchdog.Start()
+
 	// conf = config.Default()
 
 	for {
 		if chooseStrategy(2) {
-			return
+			ev3.Exit(ev3.ExitOK, "Done")
 		}
 		waitBegin()
 		trackDir := strategy()
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go-bots/cmdline"
+	"go-bots/sim/sumo"
+	"go-bots/sumosim/config"
+	"go-bots/sumosim/contest"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sides of a match
+const (
+	sideA = "a"
+	sideB = "b"
+	draw  = "draw"
+)
+
+func printError(data ...interface{}) {
+	fmt.Fprintln(os.Stderr, data...)
+}
+
+// match is the outcome of one simulated match
+type match struct {
+	Seed int64 `json:"seed"`
+	// Winner is "a", "b" or "draw" ("" when the match could not run)
+	Winner string `json:"winner"`
+	Reason string `json:"reason"`
+	Millis int    `json:"millis"`
+	Error  string `json:"error,omitempty"`
+}
+
+// pairing collects the matches between two contestants
+type pairing struct {
+	A            string  `json:"a"`
+	B            string  `json:"b"`
+	Matches      int     `json:"matches"`
+	WinsA        int     `json:"winsA"`
+	WinsB        int     `json:"winsB"`
+	Draws        int     `json:"draws"`
+	Errors       int     `json:"errors"`
+	WinRateA     float64 `json:"winRateA"`
+	MeanMillis   int     `json:"meanMillis"`
+	MedianMillis int     `json:"medianMillis"`
+	MinMillis    int     `json:"minMillis"`
+	MaxMillis    int     `json:"maxMillis"`
+	Results      []match `json:"results"`
+}
+
+// runner plays the matches in this process, with the sumosim configuration conf
+type runner struct {
+	conf config.Config
+}
+
+// run plays a match between the contestants a and b with the given seed
+func (r *runner) run(a string, b string, seed int64) match {
+	result := match{Seed: seed}
+	conf := r.conf
+	conf.Seed = seed
+	outcome, err := contest.Play(conf, a, b)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Winner, result.Reason, result.Millis = draw, outcome.Reason, outcome.Millis
+	if outcome.Winner != sumo.Draw {
+		result.Winner = []string{sideA, sideB}[outcome.Winner]
+	}
+	return result
+}
+
+// summarize computes the statistics of a pairing from its results
+func (p *pairing) summarize() {
+	millis := []int{}
+	total := 0
+	for _, m := range p.Results {
+		switch m.Winner {
+		case sideA:
+			p.WinsA++
+		case sideB:
+			p.WinsB++
+		case draw:
+			p.Draws++
+		default:
+			p.Errors++
+			continue
+		}
+		millis = append(millis, m.Millis)
+		total += m.Millis
+	}
+	p.Matches = len(millis)
+	if p.Matches == 0 {
+		return
+	}
+	sort.Ints(millis)
+	p.WinRateA = float64(p.WinsA) / float64(p.Matches)
+	p.MeanMillis = total / p.Matches
+	p.MedianMillis = millis[len(millis)/2]
+	p.MinMillis, p.MaxMillis = millis[0], millis[len(millis)-1]
+}
+
+func splitList(s string) []string {
+	result := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func writeJSON(w io.Writer, pairings []*pairing) error {
+	data, err := json.MarshalIndent(pairings, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// writeCSV writes a row per pairing, or a row per match when each is set
+func writeCSV(w io.Writer, pairings []*pairing, each bool) error {
+	out := csv.NewWriter(w)
+	itoa := strconv.Itoa
+	if each {
+		out.Write([]string{"a", "b", "seed", "winner", "reason", "millis", "error"})
+		for _, p := range pairings {
+			for _, m := range p.Results {
+				out.Write([]string{p.A, p.B, strconv.FormatInt(m.Seed, 10), m.Winner, m.Reason, itoa(m.Millis), m.Error})
+			}
+		}
+	} else {
+		out.Write([]string{"a", "b", "matches", "wins_a", "wins_b", "draws", "errors", "win_rate_a", "mean_millis", "median_millis", "min_millis", "max_millis"})
+		for _, p := range pairings {
+			out.Write([]string{p.A, p.B, itoa(p.Matches), itoa(p.WinsA), itoa(p.WinsB), itoa(p.Draws), itoa(p.Errors),
+				strconv.FormatFloat(p.WinRateA, 'f', 3, 64), itoa(p.MeanMillis), itoa(p.MedianMillis), itoa(p.MinMillis), itoa(p.MaxMillis)})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func main() {
+	first := flag.String("a", "seeker2", "contestants on side a, as a comma separated list of `bot[:strategy[:dir[:profile]]]`")
+	second := flag.String("b", "xl4", "contestants on side b, each one meets every contestant of side a")
+	count := flag.Int("n", 20, "`matches` per pairing")
+	seed := flag.Int64("seed", 1, "`seed` of the first match, the next ones use the following seeds")
+	jobs := flag.Int("j", runtime.NumCPU(), "matches run in `parallel`")
+	format := flag.String("format", "json", "output `format`: json or csv")
+	each := flag.Bool("each", false, "write a csv row per match instead of per pairing")
+	output := flag.String("o", "", "write the statistics to `file` instead of stdout")
+	configFile := flag.String("config", "", "sumosim configuration `file` (default sumosim.toml, searched like sumosim does)")
+	profile := flag.String("profile", "tournament", "sumosim configuration `profile` (it sets the start jitter)")
+	flag.Parse()
+
+	if *format != "json" && *format != "csv" {
+		printError("Unknown format", *format, "(must be json or csv)")
+		os.Exit(2)
+	}
+	if *count < 1 || *jobs < 1 {
+		printError("-n and -j must be at least 1")
+		os.Exit(2)
+	}
+	contestants := append(splitList(*first), splitList(*second)...)
+	for _, c := range contestants {
+		_, err := contest.ParseSpec(c)
+		if err != nil {
+			printError("Error:", err)
+			os.Exit(2)
+		}
+	}
+
+	// The bots only report their errors, like sumosim -log error
+	cmdline.SetLevel(cmdline.Error)
+	if *configFile == "" {
+		*configFile = cmdline.FindConfig("sumosim.toml")
+	}
+	r := &runner{conf: config.Default()}
+	conf, err := config.FromFile(*configFile, *profile, nil)
+	if err != nil {
+		printError("Error reading conf, using defaults:", err)
+	} else {
+		r.conf = conf
+	}
+
+	pairings := []*pairing{}
+	for _, a := range splitList(*first) {
+		for _, b := range splitList(*second) {
+			pairings = append(pairings, &pairing{A: a, B: b, Results: make([]match, *count)})
+		}
+	}
+
+	type job struct {
+		p *pairing
+		i int
+	}
+	queue := make(chan job)
+	var wg sync.WaitGroup
+	var printMutex sync.Mutex
+	for w := 0; w < *jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				m := r.run(j.p.A, j.p.B, *seed+int64(j.i))
+				j.p.Results[j.i] = m
+				printMutex.Lock()
+				if m.Error != "" {
+					printError(j.p.A, "vs", j.p.B, "seed", m.Seed, "error:", m.Error)
+				} else {
+					printError(j.p.A, "vs", j.p.B, "seed", m.Seed, "winner", m.Winner, m.Reason, m.Millis)
+				}
+				printMutex.Unlock()
+			}
+		}()
+	}
+	for _, p := range pairings {
+		for i := range p.Results {
+			queue <- job{p, i}
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, p := range pairings {
+		p.summarize()
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			printError("Error creating", *output+":", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		buf := bufio.NewWriter(f)
+		defer buf.Flush()
+		w = buf
+	}
+	if *format == "json" {
+		err = writeJSON(w, pairings)
+	} else {
+		err = writeCSV(w, pairings, *each)
+	}
+	if err != nil {
+		printError("Error writing the statistics:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// param is a configuration field to tune, between min and max
+type param struct {
+	key string
+	min float64
+	max float64
+	// integer is set when the range is given with integers
+	integer bool
+}
+
+// parseParam reads Key=min:max, a range written with integers tunes an
+// integer field (write 0.0:2.0 for a float field)
+func parseParam(s string) (param, error) {
+	i := strings.Index(s, "=")
+	j := strings.Index(s, ":")
+	if i < 1 || j < i {
+		return param{}, fmt.Errorf("%q: expected Key=min:max", s)
+	}
+	p := param{key: strings.TrimSpace(s[:i])}
+	low, high := strings.TrimSpace(s[i+1:j]), strings.TrimSpace(s[j+1:])
+	var err error
+	if p.min, err = strconv.ParseFloat(low, 64); err != nil {
+		return p, fmt.Errorf("%q: invalid minimum %q", s, low)
+	}
+	if p.max, err = strconv.ParseFloat(high, 64); err != nil {
+		return p, fmt.Errorf("%q: invalid maximum %q", s, high)
+	}
+	if p.max <= p.min {
+		return p, fmt.Errorf("%q: the maximum must be greater than the minimum", s)
+	}
+	_, errLow := strconv.Atoi(low)
+	_, errHigh := strconv.Atoi(high)
+	p.integer = errLow == nil && errHigh == nil
+	return p, nil
+}
+
+// value maps x (from 0 to 1) to the range of the parameter
+func (p param) value(x float64) float64 {
+	v := p.min + (p.max-p.min)*math.Max(0, math.Min(1, x))
+	if p.integer {
+		v = math.Round(v)
+	}
+	return v
+}
+
+// format writes a value the way TOML and -set expect it
+func (p param) format(v float64) string {
+	if p.integer {
+		return strconv.Itoa(int(v))
+	}
+	s := strconv.FormatFloat(v, 'f', 3, 64)
+	s = strings.TrimRight(s, "0")
+	if strings.HasSuffix(s, ".") {
+		s += "0"
+	}
+	return s
+}
+
+// searcher proposes batches of points in [0, 1]^n and learns from their
+// scores (the higher the better)
+type searcher interface {
+	ask() [][]float64
+	tell(xs [][]float64, scores []float64)
+}
+
+// randomSearch samples uniformly
+type randomSearch struct {
+	rand  *rand.Rand
+	dim   int
+	batch int
+}
+
+func (s *randomSearch) ask() [][]float64 {
+	xs := make([][]float64, s.batch)
+	for i := range xs {
+		xs[i] = make([]float64, s.dim)
+		for j := range xs[i] {
+			xs[i][j] = s.rand.Float64()
+		}
+	}
+	return xs
+}
+
+func (s *randomSearch) tell(xs [][]float64, scores []float64) {}
+
+// cmaes is a CMA-ES with a diagonal covariance (sep-CMA-ES): it samples
+// around a mean that moves towards the best points of each generation, the
+// step size and the spread of each parameter adapt to the progress
+type cmaes struct {
+	rand   *rand.Rand
+	dim    int
+	lambda int
+	mu     int
+	// weights of the mu best points (they sum to 1)
+	weights []float64
+	muEff   float64
+
+	cSigma float64
+	dSigma float64
+	cc     float64
+	c1     float64
+	cMu    float64
+	// chiN is the expected length of a N(0, I) vector
+	chiN float64
+
+	mean        []float64
+	sigma       float64
+	variance    []float64
+	pathSigma   []float64
+	pathC       []float64
+	generations int
+}
+
+// newCMAES starts from the middle of the ranges, lambda is the number of
+// points per generation (0 for the usual 4 + 3 ln n)
+func newCMAES(dim int, lambda int, rnd *rand.Rand) *cmaes {
+	n := float64(dim)
+	if lambda < 2 {
+		lambda = 4 + int(3*math.Log(n))
+	}
+	s := &cmaes{rand: rnd, dim: dim, lambda: lambda, mu: lambda / 2, sigma: 0.3}
+	sum := 0.0
+	for i := 0; i < s.mu; i++ {
+		w := math.Log(float64(s.mu)+0.5) - math.Log(float64(i+1))
+		s.weights = append(s.weights, w)
+		sum += w
+	}
+	sumSq := 0.0
+	for i := range s.weights {
+		s.weights[i] /= sum
+		sumSq += s.weights[i] * s.weights[i]
+	}
+	s.muEff = 1 / sumSq
+
+	s.cSigma = (s.muEff + 2) / (n + s.muEff + 5)
+	s.dSigma = 1 + 2*math.Max(0, math.Sqrt((s.muEff-1)/(n+1))-1) + s.cSigma
+	s.cc = (4 + s.muEff/n) / (n + 4 + 2*s.muEff/n)
+	// The learning rates of a diagonal covariance can be (n + 2) / 3 larger
+	s.c1 = 2 / ((n+1.3)*(n+1.3) + s.muEff) * (n + 2) / 3
+	s.cMu = math.Min(1-s.c1, 2*(s.muEff-2+1/s.muEff)/((n+2)*(n+2)+s.muEff)*(n+2)/3)
+	s.chiN = math.Sqrt(n) * (1 - 1/(4*n) + 1/(21*n*n))
+
+	for i := 0; i < dim; i++ {
+		s.mean = append(s.mean, 0.5)
+		s.variance = append(s.variance, 1)
+		s.pathSigma = append(s.pathSigma, 0)
+		s.pathC = append(s.pathC, 0)
+	}
+	return s
+}
+
+func (s *cmaes) ask() [][]float64 {
+	xs := make([][]float64, s.lambda)
+	for i := range xs {
+		xs[i] = make([]float64, s.dim)
+		for j := range xs[i] {
+			x := s.mean[j] + s.sigma*math.Sqrt(s.variance[j])*s.rand.NormFloat64()
+			xs[i][j] = math.Max(0, math.Min(1, x))
+		}
+	}
+	return xs
+}
+
+// tell moves the mean, the paths, the variances and the step size, the
+// points are taken where they were evaluated (inside the ranges)
+func (s *cmaes) tell(xs [][]float64, scores []float64) {
+	if len(xs) < s.lambda {
+		return
+	}
+	order := make([]int, len(xs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+
+	// Steps of the best points, in units of sigma
+	steps := make([][]float64, s.mu)
+	step := make([]float64, s.dim)
+	for k := 0; k < s.mu; k++ {
+		steps[k] = make([]float64, s.dim)
+		for j := range steps[k] {
+			steps[k][j] = (xs[order[k]][j] - s.mean[j]) / s.sigma
+			step[j] += s.weights[k] * steps[k][j]
+		}
+	}
+	for j := range s.mean {
+		s.mean[j] = math.Max(0, math.Min(1, s.mean[j]+s.sigma*step[j]))
+	}
+
+	s.generations++
+	norm := 0.0
+	for j := range s.pathSigma {
+		s.pathSigma[j] = (1-s.cSigma)*s.pathSigma[j] + math.Sqrt(s.cSigma*(2-s.cSigma)*s.muEff)*step[j]/math.Sqrt(s.variance[j])
+		norm += s.pathSigma[j] * s.pathSigma[j]
+	}
+	norm = math.Sqrt(norm)
+	// The covariance path stalls while the step size grows fast
+	hSigma := 0.0
+	if norm/math.Sqrt(1-math.Pow(1-s.cSigma, 2*float64(s.generations))) < (1.4+2/float64(s.dim+1))*s.chiN {
+		hSigma = 1
+	}
+	for j := range s.pathC {
+		s.pathC[j] = (1-s.cc)*s.pathC[j] + hSigma*math.Sqrt(s.cc*(2-s.cc)*s.muEff)*step[j]
+		rankMu := 0.0
+		for k := range steps {
+			rankMu += s.weights[k] * steps[k][j] * steps[k][j]
+		}
+		s.variance[j] = (1-s.c1-s.cMu)*s.variance[j] +
+			s.c1*(s.pathC[j]*s.pathC[j]+(1-hSigma)*s.cc*(2-s.cc)*s.variance[j]) +
+			s.cMu*rankMu
+	}
+	s.sigma *= math.Exp(s.cSigma / s.dSigma * (norm/s.chiN - 1))
+	// Steps larger than the ranges are useless
+	s.sigma = math.Min(s.sigma, 1)
+}
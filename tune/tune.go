@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Objectives
+const (
+	// distance is the distance covered by greyhound in the time of the
+	// track scene: the faster the laps, the longer
+	distance = "distance"
+	// offset is the mean distance of greyhound from the line (smaller is better)
+	offset = "offset"
+	// winrate is the percentage of matches won by the tuned bot (a draw counts half)
+	winrate = "winrate"
+)
+
+// offTrackPenalty is taken from the score of a greyhound run that leaves
+// the track, so that it scores below all the runs that stay on it
+const offTrackPenalty = 1000000
+
+func printError(data ...interface{}) {
+	fmt.Fprintln(os.Stderr, data...)
+}
+
+// runner runs the simulator commands, at most jobs at a time
+type runner struct {
+	slots chan bool
+}
+
+// run executes a command and returns the fields of the last line of its
+// output (Key Value Key Value...), a configuration problem reported on
+// stderr is an error even when the command goes on with the defaults
+func (r *runner) run(command string, args ...string) (map[string]string, error) {
+	r.slots <- true
+	defer func() { <-r.slots }()
+
+	cmd := exec.Command(command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v: %s", command, strings.Join(args, " "), err, errorLine(stderr.String()))
+	}
+	if strings.Contains(stderr.String(), "Error reading") {
+		return nil, fmt.Errorf("%s %s: %s", command, strings.Join(args, " "), errorLine(stderr.String()))
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	values := map[string]string{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		values[fields[i]] = fields[i+1]
+	}
+	return values, nil
+}
+
+// errorLine returns the first error logged (before the exit status)
+func errorLine(stderr string) string {
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		if !strings.Contains(line, "Exit status") {
+			return line
+		}
+	}
+	return ""
+}
+
+// objective scores a configuration, given as overrides of the base one
+type objective interface {
+	score(sets []string) (float64, error)
+	describe() string
+}
+
+// trackObjective runs greyhound on simulated tracks, the score is the mean
+// over the tracks
+type trackObjective struct {
+	runner  *runner
+	command string
+	config  string
+	profile string
+	tracks  []string
+	kind    string
+}
+
+func (o *trackObjective) score(sets []string) (float64, error) {
+	scores := make([]float64, len(o.tracks))
+	errs := make([]error, len(o.tracks))
+	var wg sync.WaitGroup
+	for i, track := range o.tracks {
+		wg.Add(1)
+		go func(i int, track string) {
+			defer wg.Done()
+			args := []string{"-track", track, "-log", "error"}
+			if o.config != "" {
+				args = append(args, "-config", o.config)
+			}
+			if o.profile != "" {
+				args = append(args, "-profile", o.profile)
+			}
+			for _, set := range sets {
+				args = append(args, "-set", set)
+			}
+			values, err := o.runner.run(o.command, args...)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			scores[i], errs[i] = trackScore(values, o.kind)
+		}(i, track)
+	}
+	wg.Wait()
+	return mean(scores), firstError(errs)
+}
+
+// trackScore reads the summary of greyhound:
+// millis <millis> distance <mm> mean-offset <mm> max-offset <mm> off-track <bool>
+func trackScore(values map[string]string, kind string) (float64, error) {
+	d, err1 := strconv.Atoi(values["distance"])
+	off, err2 := strconv.Atoi(values["mean-offset"])
+	lost, err3 := strconv.ParseBool(values["off-track"])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("unexpected greyhound summary %v", values)
+	}
+	score := float64(d)
+	if kind == offset {
+		score = -float64(off)
+	}
+	if lost {
+		score -= offTrackPenalty
+	}
+	return score, nil
+}
+
+func (o *trackObjective) describe() string {
+	return fmt.Sprintf("greyhound %s on %s", o.kind, strings.Join(o.tracks, ", "))
+}
+
+// matchObjective runs sumosim matches of the tuned bot (as contestant a)
+// against each opponent, with the same seeds for every configuration
+type matchObjective struct {
+	runner    *runner
+	command   string
+	config    string
+	profile   string
+	bot       string
+	opponents []string
+	matches   int
+	seed      int64
+}
+
+func (o *matchObjective) score(sets []string) (float64, error) {
+	total := len(o.opponents) * o.matches
+	points := make([]float64, total)
+	errs := make([]error, total)
+	var wg sync.WaitGroup
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			args := []string{"-a", o.bot, "-b", o.opponents[i/o.matches],
+				"-seed", strconv.FormatInt(o.seed+int64(i%o.matches), 10), "-log", "error", "-profile", o.profile}
+			if o.config != "" {
+				args = append(args, "-config", o.config)
+			}
+			for _, set := range sets {
+				args = append(args, "-seta", set)
+			}
+			values, err := o.runner.run(o.command, args...)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			// winner <name> side <a|b|-> reason <reason> millis <millis>
+			switch values["side"] {
+			case "a":
+				points[i] = 100
+			case "-":
+				points[i] = 50
+			case "b":
+			default:
+				errs[i] = fmt.Errorf("unexpected sumosim result %v", values)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return mean(points), firstError(errs)
+}
+
+func (o *matchObjective) describe() string {
+	return fmt.Sprintf("%s win rate against %s (%d matches each)", o.bot, strings.Join(o.opponents, ", "), o.matches)
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', 1, 64)
+}
+
+// findCommand looks for a command next to this one, then in the PATH
+func findCommand(name string) string {
+	exe, err := os.Executable()
+	if err == nil {
+		path := filepath.Join(filepath.Dir(exe), name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return name
+	}
+	return path
+}
+
+func splitList(s string) []string {
+	result := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// sets returns the overrides of a point of the search space
+func sets(params []param, x []float64) []string {
+	result := []string{}
+	for i, p := range params {
+		result = append(result, p.key+"="+p.format(p.value(x[i])))
+	}
+	return result
+}
+
+// writeProfile writes the tuned values as a profile to add to the
+// configuration file of the bot, keys with dots go to sub-tables (note that
+// a map entry set in a profile replaces the whole entry)
+func writeProfile(w io.Writer, name string, comments []string, params []param, x []float64) {
+	for _, c := range comments {
+		fmt.Fprintln(w, "#", c)
+	}
+	tables := []string{""}
+	keys := map[string][]string{}
+	for i, p := range params {
+		table, key := "", p.key
+		if j := strings.LastIndex(p.key, "."); j >= 0 {
+			table, key = p.key[:j], p.key[j+1:]
+		}
+		if _, ok := keys[table]; !ok && table != "" {
+			tables = append(tables, table)
+		}
+		keys[table] = append(keys[table], fmt.Sprintf("%s = %s", key, p.format(p.value(x[i]))))
+	}
+	for _, table := range tables {
+		header := "Profiles." + name
+		if table != "" {
+			header += "." + table
+		}
+		fmt.Fprintf(w, "[%s]\n", header)
+		for _, line := range keys[table] {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+type paramFlags []param
+
+func (p *paramFlags) String() string {
+	return fmt.Sprint(*p)
+}
+
+func (p *paramFlags) Set(value string) error {
+	result, err := parseParam(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, result)
+	return nil
+}
+
+func main() {
+	var params paramFlags
+	flag.Var(&params, "param", "configuration field to tune with its range, as `Key=min:max` (repeatable)")
+	simulator := flag.String("sim", "greyhound", "`simulator`: greyhound (on -track) or sumosim (matches of -bot against -against)")
+	objectiveName := flag.String("objective", "", "`objective`: distance or offset for greyhound (default distance), winrate for sumosim")
+	tracks := flag.String("track", "tracks/oval.toml", "greyhound: comma separated list of track `files`")
+	bot := flag.String("bot", "seeker2", "sumosim: tuned contestant, as `bot[:strategy[:dir[:profile]]]`")
+	against := flag.String("against", "xl4", "sumosim: comma separated list of `opponents`")
+	matches := flag.Int("n", 10, "sumosim: `matches` against each opponent")
+	method := flag.String("method", "cmaes", "search `method`: cmaes or random")
+	budget := flag.Int("evals", 100, "number of configurations to `evaluate`")
+	population := flag.Int("pop", 0, "configurations per generation (default 4 + 3 ln params for cmaes, -j for random)")
+	seed := flag.Int64("seed", 1, "random `seed` of the search and of the first sumosim match")
+	jobs := flag.Int("j", runtime.NumCPU(), "simulations run in `parallel`")
+	command := flag.String("command", "", "simulator `command` (default: next to this one, or in the PATH)")
+	config := flag.String("config", "", "configuration `file` given to the simulator")
+	profile := flag.String("profile", "", "configuration `profile` given to the simulator (sumosim default: tournament)")
+	name := flag.String("name", "tuned", "`name` of the written profile")
+	output := flag.String("o", "", "write the profile to `file` instead of stdout")
+	flag.Parse()
+
+	if len(params) == 0 {
+		printError("Nothing to tune, give at least one -param")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *budget < 1 || *jobs < 1 || *matches < 1 {
+		printError("-evals, -j and -n must be at least 1")
+		os.Exit(2)
+	}
+	if *command == "" {
+		*command = findCommand(*simulator)
+	}
+	r := &runner{slots: make(chan bool, *jobs)}
+
+	var obj objective
+	switch *simulator {
+	case "greyhound":
+		if *objectiveName == "" {
+			*objectiveName = distance
+		}
+		if *objectiveName != distance && *objectiveName != offset {
+			printError("Unknown greyhound objective", *objectiveName, "(must be distance or offset)")
+			os.Exit(2)
+		}
+		obj = &trackObjective{runner: r, command: *command, config: *config, profile: *profile, tracks: splitList(*tracks), kind: *objectiveName}
+	case "sumosim":
+		if *objectiveName == "" {
+			*objectiveName = winrate
+		}
+		if *objectiveName != winrate {
+			printError("Unknown sumosim objective", *objectiveName, "(must be winrate)")
+			os.Exit(2)
+		}
+		if *profile == "" {
+			*profile = "tournament"
+		}
+		opponents := splitList(*against)
+		obj = &matchObjective{runner: r, command: *command, config: *config, profile: *profile, bot: *bot,
+			opponents: opponents, matches: *matches, seed: *seed}
+	default:
+		printError("Unknown simulator", *simulator, "(must be greyhound or sumosim)")
+		os.Exit(2)
+	}
+
+	rnd := rand.New(rand.NewSource(*seed))
+	var s searcher
+	switch *method {
+	case "cmaes":
+		s = newCMAES(len(params), *population, rnd)
+	case "random":
+		batch := *population
+		if batch < 1 {
+			batch = *jobs
+		}
+		s = &randomSearch{rand: rnd, dim: len(params), batch: batch}
+	default:
+		printError("Unknown method", *method, "(must be cmaes or random)")
+		os.Exit(2)
+	}
+
+	printError("Tuning", obj.describe())
+	baseline, err := obj.score(nil)
+	if err != nil {
+		printError("Error:", err)
+		os.Exit(1)
+	}
+	printError("base configuration: score", formatScore(baseline))
+
+	var best []float64
+	bestScore := 0.0
+	for evals, generation := 0, 1; evals < *budget; generation++ {
+		xs := s.ask()
+		if len(xs) > *budget-evals {
+			xs = xs[:*budget-evals]
+		}
+		scores := make([]float64, len(xs))
+		errs := make([]error, len(xs))
+		var wg sync.WaitGroup
+		for i := range xs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				scores[i], errs[i] = obj.score(sets(params, xs[i]))
+			}(i)
+		}
+		wg.Wait()
+		if err := firstError(errs); err != nil {
+			printError("Error:", err)
+			os.Exit(1)
+		}
+		s.tell(xs, scores)
+		evals += len(xs)
+
+		for i, x := range xs {
+			if best == nil || scores[i] > bestScore {
+				best, bestScore = append([]float64{}, x...), scores[i]
+			}
+		}
+		printError("generation", generation, "evaluations", evals, "best score", formatScore(bestScore), strings.Join(sets(params, best), " "))
+	}
+
+	comments := []string{
+		fmt.Sprintf("Tuned for %s (%s, %d evaluations)", obj.describe(), *method, *budget),
+		fmt.Sprintf("score %s, %s with the base configuration", formatScore(bestScore), formatScore(baseline)),
+	}
+	if *output == "" {
+		writeProfile(os.Stdout, *name, comments, params, best)
+		return
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		printError("Error creating", *output+":", err)
+		os.Exit(1)
+	}
+	buf := bufio.NewWriter(f)
+	writeProfile(buf, *name, comments, params, best)
+	err = buf.Flush()
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		printError("Error writing", *output+":", err)
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"go-bots/clock"
 	"go-bots/ev3"
 	"log"
 	"time"
@@ -35,22 +36,30 @@ const (
 	Left
 	// Quit event (CTRL-C on keyboard or quick ENTER-BACK on EV3 keypad)
 	Quit
+	// Profile (p on keyboard) key, switches the configuration profile
+	Profile
+	// Calibrate event (c on keyboard or quick DOWN-DOWN on EV3 keypad)
+	Calibrate
 )
 
 var keys chan<- KeyEvent
 var state *terminal.State
 
 var lastEnterTime time.Time
+var lastDownTime time.Time
+var clk clock.Clock
 var start time.Time
 
-// Init initializes the terminal
-func Init(k chan<- KeyEvent, s time.Time) {
+// Init initializes the terminal, the key events carry the milliseconds
+// elapsed on c since s
+func Init(k chan<- KeyEvent, c clock.Clock, s time.Time) {
 	var err error
 	keys = k
+	clk = c
 	start = s
 	state, err = terminal.GetState(0)
 	if err != nil {
-		log.Fatalln("Error getting terminal state:", err)
+		ev3.Fatalln("Error getting terminal state:", err)
 	}
 }
 
@@ -66,7 +75,7 @@ func Close() {
 }
 
 func keyEvent(k Key) KeyEvent {
-	now := time.Now()
+	now := clk.Now()
 	millis := ev3.TimespanAsMillis(start, now)
 	return KeyEvent{
 		Key:    k,
@@ -76,9 +85,11 @@ func keyEvent(k Key) KeyEvent {
 
 // Loop runs the ui loop, writing events to the channel
 func Loop() {
+	defer ev3.Recover()
+
 	err := t.Init()
 	if err != nil {
-		log.Fatalln("Error setting up ui:", err)
+		ev3.Fatalln("Error setting up ui:", err)
 	}
 	defer t.Close()
 
@@ -94,7 +105,15 @@ func Loop() {
 		keys <- keyEvent(Up)
 	})
 	t.Handle("/sys/kbd/<down>", func(t.Event) {
-		keys <- keyEvent(Down)
+		downTime := clk.Now()
+		interval := downTime.Sub(lastDownTime)
+		lastDownTime = downTime
+		if interval < time.Millisecond*400 {
+			lastDownTime = time.Time{}
+			keys <- keyEvent(Calibrate)
+		} else {
+			keys <- keyEvent(Down)
+		}
 	})
 	t.Handle("/sys/kbd/<right>", func(t.Event) {
 		keys <- keyEvent(Right)
@@ -102,12 +121,18 @@ func Loop() {
 	t.Handle("/sys/kbd/<left>", func(t.Event) {
 		keys <- keyEvent(Left)
 	})
+	t.Handle("/sys/kbd/p", func(t.Event) {
+		keys <- keyEvent(Profile)
+	})
+	t.Handle("/sys/kbd/c", func(t.Event) {
+		keys <- keyEvent(Calibrate)
+	})
 	t.Handle("/sys/kbd/<enter>", func(t.Event) {
-		lastEnterTime = time.Now()
+		lastEnterTime = clk.Now()
 		keys <- keyEvent(Enter)
 	})
 	t.Handle("/sys/kbd/C-8", func(t.Event) {
-		backTime := time.Now()
+		backTime := clk.Now()
 		interval := backTime.Sub(lastEnterTime)
 		if interval < time.Millisecond*400 {
 			keys <- keyEvent(Quit)
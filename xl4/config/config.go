@@ -1,77 +1,226 @@
 package config
 
-const ColorIsOut = 101
-
-const ForwardAcceleration = 10000 / 600
-const ReverseAcceleration = 10000 / 1
-
-//const MaxIrValue = 100
-
-const MaxIrDistance = 80
-
-const IgnoreBorderIrDistance = 40
-
-// VisionIntensityMax is the maximum vision intensity
-//const VisionIntensityMax = 100
-
-// VisionAngleMax is the maximum vision angle (positive on the right)
-//const VisionAngleMax = 100
-
-const MaxSpeed = 10000
-
-const StartTime = 5000
-
-const SeekMoveSpeed = 3500
-const SeekMoveMillis = 860
-const SeekTurnSpeed = 3700
-const SeekTurnMillis = 1200
-
-const BackTurn1SpeedOuter = MaxSpeed
-const BackTurn1SpeedInner = MaxSpeed / 2
-const BackTurn1Millis = 80
-const BackTurn2Speed = 3700
-const BackTurn2Millis = 500
-const BackMoveSpeed = MaxSpeed
-const BackMoveMillis = 5
-const BackTurn3Speed = 3700
-const BackTurn3Millis = 1600
-
-const CircleFindBorderMillis = 80
-const CircleFindBorderOuterSpeed = MaxSpeed * 80 / 100
-const CircleFindBorderInnerSpeed = MaxSpeed * 40 / 100
-const CircleFindBorderOuterSpeedSlowLeft = MaxSpeed * 28 / 100
-const CircleFindBorderInnerSpeedSlowLeft = MaxSpeed * 18 / 100
-const CircleFindBorderOuterSpeedSlowRight = MaxSpeed * 28 / 100
-const CircleFindBorderInnerSpeedSlowRight = MaxSpeed * 18 / 100
-const CircleMillis = 2500
-const CircleOuterSpeed = MaxSpeed
-const CircleInnerSpeedLeft = 2800
-const CircleInnerSpeedRight = 3000
-const CircleAdjustInnerMax = 600
-const CircleSpiralMillis = 450
-const CircleSpiralOuterSpeed = MaxSpeed
-const CircleSpiralInnerSpeed = 1500
-
-const GoForwardMillis = 30000
-const GoForwardSpeed = MaxSpeed
-
-// const GoForwardTurnMillis = 600
-const GoForwardTurnMillis = 0
-const GoForwardTurnOuterSpeed = MaxSpeed
-const GoForwardTurnInnerSpeed = 1000
-const GoForwardAdjustmentStep = 1500
-const GoForwardAdjustmentSteps = 5
-
-const TurnBackPreMoveMillis = 400
-const TurnBackPreMoveSpeed = MaxSpeed
-const TurnBackMillis = 120
-const TurnBackOuterSpeed = MaxSpeed
-const TurnBackInnerSpeed = -MaxSpeed
-const TurnBackMoveMillis = 800
-const TurnBackMoveSpeed = MaxSpeed
-
-const TrackOnly1SensorOuterSpeed = 8000
-const TrackOnly1SensorInnerSpeed = 6000
-const TrackSpeed = MaxSpeed
-const TrackCenterZone = 20
-const TrackDifferenceCoefficent = 50
+import (
+	"go-bots/botconf"
+	"go-bots/clock"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Config data
+type Config struct {
+	// Profile is the name of the profile in use (empty for the base section)
+	Profile string `toml:"-"`
+
+	// ColorIsOut is the border threshold used until the corner sensors are calibrated
+	ColorIsOut int `check:"min=0,max=101"`
+	// BorderMarginPC places the calibrated thresholds between surface (0) and border (100)
+	BorderMarginPC int `check:"min=1,max=99"`
+	// BorderSampleMillis is how long the surface and the border are sampled
+	BorderSampleMillis int `check:"required,min=1"`
+
+	ForwardAcceleration int `check:"required,min=1"`
+	ReverseAcceleration int `check:"required,min=1"`
+
+	// MaxIrValue is the value of an ir sensor that sees nothing
+	MaxIrValue int `check:"required,min=1"`
+
+	MaxIrDistance int `check:"min=0,le=MaxIrValue"`
+
+	IgnoreBorderIrDistance int `check:"min=0,le=MaxIrValue"`
+
+	// VisionIntensityMax is the maximum vision intensity
+	VisionIntensityMax int `check:"required,min=1"`
+
+	// VisionAngleMax is the maximum vision angle (positive on the right)
+	VisionAngleMax int
+
+	MaxSpeed int `check:"required,min=1,max=10000"`
+
+	StartTime int `check:"min=0"`
+
+	// WatchdogMillis is the time without motor commands after which the motors are stopped
+	WatchdogMillis int `check:"required,min=1"`
+
+	SeekMoveSpeed  int `check:"le=MaxSpeed"`
+	SeekMoveMillis int `check:"min=0"`
+	SeekTurnSpeed  int `check:"le=MaxSpeed"`
+	SeekTurnMillis int `check:"min=0"`
+
+	BackTurn1SpeedOuter int `check:"le=MaxSpeed"`
+	BackTurn1SpeedInner int `check:"le=MaxSpeed"`
+	BackTurn1Millis     int
+	BackTurn2Speed      int `check:"le=MaxSpeed"`
+	BackTurn2Millis     int
+	BackMoveSpeed       int `check:"le=MaxSpeed"`
+	BackMoveMillis      int
+	BackTurn3Speed      int `check:"le=MaxSpeed"`
+	BackTurn3Millis     int
+
+	CircleFindBorderMillis              int
+	CircleFindBorderOuterSpeed          int `check:"le=MaxSpeed"`
+	CircleFindBorderInnerSpeed          int `check:"le=MaxSpeed"`
+	CircleFindBorderOuterSpeedSlowLeft  int `check:"le=MaxSpeed"`
+	CircleFindBorderInnerSpeedSlowLeft  int `check:"le=MaxSpeed"`
+	CircleFindBorderOuterSpeedSlowRight int `check:"le=MaxSpeed"`
+	CircleFindBorderInnerSpeedSlowRight int `check:"le=MaxSpeed"`
+	CircleMillis                        int
+	CircleOuterSpeed                    int `check:"le=MaxSpeed"`
+	CircleInnerSpeedLeft                int `check:"le=MaxSpeed"`
+	CircleInnerSpeedRight               int `check:"le=MaxSpeed"`
+	CircleAdjustInnerMax                int
+	CircleSpiralMillis                  int
+	CircleSpiralOuterSpeed              int `check:"le=MaxSpeed"`
+	CircleSpiralInnerSpeed              int `check:"le=MaxSpeed"`
+
+	GoForwardMillis int `check:"min=0"`
+	GoForwardSpeed  int `check:"le=MaxSpeed"`
+
+	GoForwardTurnMillis      int
+	GoForwardTurnOuterSpeed  int `check:"le=MaxSpeed"`
+	GoForwardTurnInnerSpeed  int `check:"le=MaxSpeed"`
+	GoForwardAdjustmentStep  int
+	GoForwardAdjustmentSteps int `check:"required,min=1"`
+
+	TurnBackPreMoveMillis int `check:"min=0"`
+	TurnBackPreMoveSpeed  int `check:"le=MaxSpeed"`
+	TurnBackMillis        int
+	TurnBackOuterSpeed    int `check:"le=MaxSpeed"`
+	TurnBackInnerSpeed    int `check:"le=MaxSpeed"`
+	TurnBackMoveMillis    int
+	TurnBackMoveSpeed     int `check:"le=MaxSpeed"`
+
+	TrackOnly1SensorOuterSpeed int `check:"le=MaxSpeed"`
+	TrackOnly1SensorInnerSpeed int `check:"le=MaxSpeed"`
+	TrackSpeed                 int `check:"le=MaxSpeed"`
+	TrackCenterZone            int `check:"min=0"`
+	TrackDifferenceCoefficent  int
+}
+
+// CompleteConfig fills in computed configuration fields
+func CompleteConfig(c *Config) {
+}
+
+// Default Config data (the values the bot was tuned with)
+func Default() Config {
+	const maxSpeed = 10000
+	result := Config{
+		BorderMarginPC:                      50,
+		BorderSampleMillis:                  1000,
+		ColorIsOut:                          101,
+		ForwardAcceleration:                 10000 / 600,
+		ReverseAcceleration:                 10000 / 1,
+		MaxIrValue:                          100,
+		MaxIrDistance:                       80,
+		IgnoreBorderIrDistance:              40,
+		VisionIntensityMax:                  100,
+		VisionAngleMax:                      100,
+		MaxSpeed:                            maxSpeed,
+		StartTime:                           5000,
+		WatchdogMillis:                      500,
+		SeekMoveSpeed:                       3500,
+		SeekMoveMillis:                      860,
+		SeekTurnSpeed:                       3700,
+		SeekTurnMillis:                      1200,
+		BackTurn1SpeedOuter:                 maxSpeed,
+		BackTurn1SpeedInner:                 maxSpeed / 2,
+		BackTurn1Millis:                     80,
+		BackTurn2Speed:                      3700,
+		BackTurn2Millis:                     500,
+		BackMoveSpeed:                       maxSpeed,
+		BackMoveMillis:                      5,
+		BackTurn3Speed:                      3700,
+		BackTurn3Millis:                     1600,
+		CircleFindBorderMillis:              80,
+		CircleFindBorderOuterSpeed:          maxSpeed * 80 / 100,
+		CircleFindBorderInnerSpeed:          maxSpeed * 40 / 100,
+		CircleFindBorderOuterSpeedSlowLeft:  maxSpeed * 28 / 100,
+		CircleFindBorderInnerSpeedSlowLeft:  maxSpeed * 18 / 100,
+		CircleFindBorderOuterSpeedSlowRight: maxSpeed * 28 / 100,
+		CircleFindBorderInnerSpeedSlowRight: maxSpeed * 18 / 100,
+		CircleMillis:                        2500,
+		CircleOuterSpeed:                    maxSpeed,
+		CircleInnerSpeedLeft:                2800,
+		CircleInnerSpeedRight:               3000,
+		CircleAdjustInnerMax:                600,
+		CircleSpiralMillis:                  450,
+		CircleSpiralOuterSpeed:              maxSpeed,
+		CircleSpiralInnerSpeed:              1500,
+		GoForwardMillis:                     30000,
+		GoForwardSpeed:                      maxSpeed,
+		GoForwardTurnMillis:                 0,
+		GoForwardTurnOuterSpeed:             maxSpeed,
+		GoForwardTurnInnerSpeed:             1000,
+		GoForwardAdjustmentStep:             1500,
+		GoForwardAdjustmentSteps:            5,
+		TurnBackPreMoveMillis:               400,
+		TurnBackPreMoveSpeed:                maxSpeed,
+		TurnBackMillis:                      120,
+		TurnBackOuterSpeed:                  maxSpeed,
+		TurnBackInnerSpeed:                  -maxSpeed,
+		TurnBackMoveMillis:                  800,
+		TurnBackMoveSpeed:                   maxSpeed,
+		TrackOnly1SensorOuterSpeed:          8000,
+		TrackOnly1SensorInnerSpeed:          6000,
+		TrackSpeed:                          maxSpeed,
+		TrackCenterZone:                     20,
+		TrackDifferenceCoefficent:           50,
+	}
+	CompleteConfig(&result)
+	return result
+}
+
+// FromString reads Config data from a TOML string applying the named profile
+// and then sets (as Key=Value), values that are not in it keep their defaults
+func FromString(data string, profile string, sets []string) (Config, error) {
+	result := Default()
+	name, err := botconf.DecodeProfileSets(data, profile, sets, &result)
+	if err != nil {
+		return result, err
+	}
+	err = botconf.Validate(&result)
+	if err != nil {
+		return result, err
+	}
+	result.Profile = name
+	CompleteConfig(&result)
+	return result, nil
+}
+
+// FromFile reads Config data from a TOML file applying the named profile and then sets
+func FromFile(fileName string, profile string, sets []string) (Config, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return Config{}, err
+	}
+	return FromString(string(b), profile, sets)
+}
+
+// File is the configuration file of xl4, see botconf.File
+type File struct {
+	*botconf.File
+}
+
+// Load reads a configuration file with the named profile and sets (as
+// Key=Value, they are applied again on every reload), changes to the file are
+// looked for on the clock c. On error the file uses the defaults
+func Load(fileName string, profileName string, sets []string, c clock.Clock) (*File, error) {
+	defaults := Default()
+	f, err := botconf.LoadFile(fileName, profileName, sets, &defaults, decode, c)
+	return &File{f}, err
+}
+
+func decode(fileName string, profile string, sets []string) (interface{}, string, error) {
+	c, err := FromFile(fileName, profile, sets)
+	return &c, c.Profile, err
+}
+
+// Get returns the configuration in use, callers must not modify it
+func (f *File) Get() *Config {
+	return f.Current().(*Config)
+}
+
+// BorderFile is where the border calibration is kept, next to the file
+func (f *File) BorderFile() string {
+	return filepath.Join(filepath.Dir(f.Name()), "xl4-border.toml")
+}
@@ -0,0 +1,101 @@
+package io
+
+import (
+	"go-bots/border"
+	"go-bots/xl4/config"
+	"go-bots/xl4/logic"
+	"sync"
+	"time"
+)
+
+// Driver is the part of the io module that does not touch the devices: it
+// turns the commands of the logic into motor and led values and the sensor
+// readings into the data of the logic. IO runs it on the robot and the
+// simulator on a simulated one
+type Driver struct {
+	start       time.Time
+	conf        *config.File
+	calibration *border.Current
+
+	mutex                 sync.Mutex
+	speedRight, speedLeft int
+	lastMillis            int
+}
+
+// Outputs are the values a command sets on the devices
+type Outputs struct {
+	// MotorLeft and MotorRight are the duty cycles of the wheels (positive forward)
+	MotorLeft  int
+	MotorRight int
+
+	LedLeftGreen  int
+	LedLeftRed    int
+	LedRightGreen int
+	LedRightRed   int
+}
+
+// Sensors are the values read from the devices
+type Sensors struct {
+	CornerLeft  int
+	CornerRight int
+}
+
+// NewDriver creates a driver giving times relative to s, with the
+// configuration and the corner sensor calibration of the bot
+func NewDriver(s time.Time, conf *config.File, calibration *border.Current) *Driver {
+	return &Driver{start: s, conf: conf, calibration: calibration}
+}
+
+func computeSpeed(conf *config.Config, currentSpeed int, targetSpeed int, millis int) int {
+	if currentSpeed < targetSpeed {
+		speedDelta := currentSpeed + conf.MaxSpeed
+		forwardAcceleration := conf.ForwardAcceleration + (speedDelta / (conf.MaxSpeed * 2))
+		currentSpeed += (forwardAcceleration * millis)
+		if currentSpeed > targetSpeed {
+			currentSpeed = targetSpeed
+		}
+	}
+	if currentSpeed > targetSpeed {
+		currentSpeed -= (conf.ReverseAcceleration * millis)
+		if currentSpeed < targetSpeed {
+			currentSpeed = targetSpeed
+		}
+	}
+	return currentSpeed
+}
+
+// Command accelerates the wheels towards the speeds of c
+func (d *Driver) Command(c *logic.Commands) Outputs {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	conf := d.conf.Get()
+	millis := c.Millis - d.lastMillis
+	d.speedRight = computeSpeed(conf, d.speedRight, c.SpeedRight, millis)
+	d.speedLeft = computeSpeed(conf, d.speedLeft, c.SpeedLeft, millis)
+	d.lastMillis = c.Millis
+
+	return Outputs{
+		MotorLeft:     d.speedLeft / 100,
+		MotorRight:    d.speedRight / 100,
+		LedLeftGreen:  c.LedLeftGreen,
+		LedLeftRed:    c.LedLeftRed,
+		LedRightGreen: c.LedRightGreen,
+		LedRightRed:   c.LedRightRed,
+	}
+}
+
+// Read turns sensor values read at millis into the data for the logic
+func (d *Driver) Read(millis int, s Sensors) logic.Data {
+	leftThreshold, rightThreshold := d.calibration.Thresholds(d.conf.Get().ColorIsOut)
+	return logic.Data{
+		Start:            d.start,
+		Millis:           millis,
+		CornerRightIsOut: s.CornerRight > rightThreshold,
+		CornerLeftIsOut:  s.CornerLeft > leftThreshold,
+		CornerRight:      s.CornerRight,
+		CornerLeft:       s.CornerLeft,
+		IrLeftValue:      100,
+		IrRightValue:     100,
+	}
+}
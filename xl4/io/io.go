@@ -1,199 +1,184 @@
 package io
 
 import (
+	"go-bots/border"
+	"go-bots/clock"
 	"go-bots/ev3"
 	"go-bots/xl4/config"
 	"go-bots/xl4/logic"
 	"time"
 )
 
-func colorIsOut(v int) bool {
-	return v > config.ColorIsOut
-}
+// IO connects the logic to the devices of the robot
+type IO struct {
+	devs *ev3.Devices
+	data chan<- logic.Data
+
+	mr1, mr2, ml1, ml2   *ev3.Attribute
+	colR, colL, irR, irL *ev3.Attribute
 
-var devs *ev3.Devices
-var data chan<- logic.Data
-var commands <-chan logic.Commands
+	ledRR, ledRG, ledLR, ledLG *ev3.Attribute
 
-var mr1, mr2, ml1, ml2 *ev3.Attribute
-var colR, colL, irR, irL *ev3.Attribute
+	watchdog *ev3.Watchdog
+	driver   *Driver
 
-var ledRR, ledRG, ledLR, ledLG *ev3.Attribute
+	clock clock.Clock
+	start time.Time
 
-var start time.Time
+	conf *config.File
+}
 
 // StartTime gets the time when the bot started
-func StartTime() time.Time {
-	return start
+func (io *IO) StartTime() time.Time {
+	return io.start
 }
 
-// Init initializes the io module
-func Init(d chan<- logic.Data, s time.Time) {
-	devs = ev3.Scan(&ev3.OutPortModes{
+// New opens and initializes the devices, the readings are sent to d with
+// the times of c relative to s, the configuration and the corner sensor
+// calibration are the ones of the bot
+func New(d chan<- logic.Data, c clock.Clock, s time.Time, conf *config.File, calibration *border.Current) *IO {
+	io := &IO{data: d, clock: c, start: s, conf: conf, driver: NewDriver(s, conf, calibration)}
+	io.devs = ev3.Scan(&ev3.OutPortModes{
 		OutA: ev3.OutPortModeDcMotor,
 		OutB: ev3.OutPortModeDcMotor,
 		OutC: ev3.OutPortModeDcMotor,
 		OutD: ev3.OutPortModeDcMotor,
 	})
-	data = d
-	start = s
 
 	// IR left
-	// ev3.CheckDriver(devs.In1, ev3.DriverIr, ev3.In1)
+	// ev3.CheckDriver(io.devs.In1, ev3.DriverIr, ev3.In1)
 	// Color left
-	ev3.CheckDriver(devs.In2, ev3.DriverColor, ev3.In2)
+	ev3.CheckDriver(io.devs.In2, ev3.DriverColor, ev3.In2)
 	// IR right
-	// ev3.CheckDriver(devs.In3, ev3.DriverIr, ev3.In3)
+	// ev3.CheckDriver(io.devs.In3, ev3.DriverIr, ev3.In3)
 	// Color right
-	ev3.CheckDriver(devs.In4, ev3.DriverColor, ev3.In4)
+	ev3.CheckDriver(io.devs.In4, ev3.DriverColor, ev3.In4)
 
 	// Right back inverted
-	ev3.CheckDriver(devs.OutA, ev3.DriverRcxMotor, ev3.OutA)
+	ev3.CheckDriver(io.devs.OutA, ev3.DriverRcxMotor, ev3.OutA)
 	// Right front direct
-	ev3.CheckDriver(devs.OutB, ev3.DriverRcxMotor, ev3.OutB)
+	ev3.CheckDriver(io.devs.OutB, ev3.DriverRcxMotor, ev3.OutB)
 	// Left front direct
-	ev3.CheckDriver(devs.OutC, ev3.DriverRcxMotor, ev3.OutC)
+	ev3.CheckDriver(io.devs.OutC, ev3.DriverRcxMotor, ev3.OutC)
 	// Left back direct
-	ev3.CheckDriver(devs.OutD, ev3.DriverRcxMotor, ev3.OutD)
-
-	// ev3.SetMode(devs.In1, ev3.IrModeProx)
-	ev3.SetMode(devs.In2, ev3.ColorModeReflect)
-	// ev3.SetMode(devs.In3, ev3.IrModeProx)
-	ev3.SetMode(devs.In4, ev3.ColorModeReflect)
-
-	ev3.RunCommand(devs.OutA, ev3.CmdStop)
-	ev3.RunCommand(devs.OutB, ev3.CmdStop)
-	ev3.RunCommand(devs.OutC, ev3.CmdStop)
-	ev3.RunCommand(devs.OutD, ev3.CmdStop)
-
-	// irR = ev3.OpenByteR(devs.In3, ev3.BinData)
-	// irL = ev3.OpenByteR(devs.In1, ev3.BinData)
-	colR = ev3.OpenByteR(devs.In4, ev3.BinData)
-	colL = ev3.OpenByteR(devs.In2, ev3.BinData)
-	mr2 = ev3.OpenTextW(devs.OutA, ev3.DutyCycleSp)
-	mr1 = ev3.OpenTextW(devs.OutB, ev3.DutyCycleSp)
-	ml1 = ev3.OpenTextW(devs.OutC, ev3.DutyCycleSp)
-	ml2 = ev3.OpenTextW(devs.OutD, ev3.DutyCycleSp)
-
-	ledLG = ev3.OpenTextW(devs.LedLeftGreen, ev3.Brightness)
-	ledLR = ev3.OpenTextW(devs.LedLeftRed, ev3.Brightness)
-	ledRG = ev3.OpenTextW(devs.LedRightGreen, ev3.Brightness)
-	ledRR = ev3.OpenTextW(devs.LedRightRed, ev3.Brightness)
-	ledLG.Value = 0
-	ledLR.Value = 0
-	ledRG.Value = 0
-	ledRR.Value = 0
-	ledLG.Sync()
-	ledLR.Sync()
-	ledRG.Sync()
-	ledRR.Sync()
-
-	mr1.Value = 0
-	mr2.Value = 0
-	ml1.Value = 0
-	ml2.Value = 0
-
-	mr1.Sync()
-	mr2.Sync()
-	ml1.Sync()
-	ml2.Sync()
-
-	ev3.RunCommand(devs.OutA, ev3.CmdRunDirect)
-	ev3.RunCommand(devs.OutB, ev3.CmdRunDirect)
-	ev3.RunCommand(devs.OutC, ev3.CmdRunDirect)
-	ev3.RunCommand(devs.OutD, ev3.CmdRunDirect)
+	ev3.CheckDriver(io.devs.OutD, ev3.DriverRcxMotor, ev3.OutD)
+
+	// ev3.SetMode(io.devs.In1, ev3.IrModeProx)
+	ev3.SetMode(io.devs.In2, ev3.ColorModeReflect)
+	// ev3.SetMode(io.devs.In3, ev3.IrModeProx)
+	ev3.SetMode(io.devs.In4, ev3.ColorModeReflect)
+
+	ev3.RunCommand(io.devs.OutA, ev3.CmdStop)
+	ev3.RunCommand(io.devs.OutB, ev3.CmdStop)
+	ev3.RunCommand(io.devs.OutC, ev3.CmdStop)
+	ev3.RunCommand(io.devs.OutD, ev3.CmdStop)
+
+	// io.irR = ev3.OpenByteR(io.devs.In3, ev3.BinData)
+	// io.irL = ev3.OpenByteR(io.devs.In1, ev3.BinData)
+	io.colR = ev3.OpenByteR(io.devs.In4, ev3.BinData)
+	io.colL = ev3.OpenByteR(io.devs.In2, ev3.BinData)
+	io.mr2 = ev3.OpenTextW(io.devs.OutA, ev3.DutyCycleSp)
+	io.mr1 = ev3.OpenTextW(io.devs.OutB, ev3.DutyCycleSp)
+	io.ml1 = ev3.OpenTextW(io.devs.OutC, ev3.DutyCycleSp)
+	io.ml2 = ev3.OpenTextW(io.devs.OutD, ev3.DutyCycleSp)
+
+	io.ledLG = ev3.OpenTextW(io.devs.LedLeftGreen, ev3.Brightness)
+	io.ledLR = ev3.OpenTextW(io.devs.LedLeftRed, ev3.Brightness)
+	io.ledRG = ev3.OpenTextW(io.devs.LedRightGreen, ev3.Brightness)
+	io.ledRR = ev3.OpenTextW(io.devs.LedRightRed, ev3.Brightness)
+	io.ledLG.Value = 0
+	io.ledLR.Value = 0
+	io.ledRG.Value = 0
+	io.ledRR.Value = 0
+	io.ledLG.Sync()
+	io.ledLR.Sync()
+	io.ledRG.Sync()
+	io.ledRR.Sync()
+
+	io.mr1.Value = 0
+	io.mr2.Value = 0
+	io.ml1.Value = 0
+	io.ml2.Value = 0
+
+	io.mr1.Sync()
+	io.mr2.Sync()
+	io.ml1.Sync()
+	io.ml2.Sync()
+
+	ev3.RunCommand(io.devs.OutA, ev3.CmdRunDirect)
+	ev3.RunCommand(io.devs.OutB, ev3.CmdRunDirect)
+	ev3.RunCommand(io.devs.OutC, ev3.CmdRunDirect)
+	ev3.RunCommand(io.devs.OutD, ev3.CmdRunDirect)
+
+	io.watchdog = ev3.NewWatchdog(io.devs, time.Duration(io.conf.Get().WatchdogMillis)*time.Millisecond, io.clock)
+	io.watchdog.Start()
+	return io
 }
 
-func computeSpeed(currentSpeed int, targetSpeed int, millis int) int {
-	if currentSpeed < targetSpeed {
-		speedDelta := currentSpeed + config.MaxSpeed
-		forwardAcceleration := config.ForwardAcceleration + (speedDelta / (config.MaxSpeed * 2))
-		currentSpeed += (forwardAcceleration * millis)
-		if currentSpeed > targetSpeed {
-			currentSpeed = targetSpeed
-		}
-	}
-	if currentSpeed > targetSpeed {
-		currentSpeed -= (config.ReverseAcceleration * millis)
-		if currentSpeed < targetSpeed {
-			currentSpeed = targetSpeed
-		}
+// ProcessCommand applies a command of the logic to the devices
+func (io *IO) ProcessCommand(c *logic.Commands) {
+	if !io.watchdog.Feed() {
+		return
 	}
-	return currentSpeed
-}
 
-var speedRight, speedLeft int
-var lastMillis, currentMillis int
-
-func ProcessCommand(c *logic.Commands) {
-	currentMillis = c.Millis
-	millis := currentMillis - lastMillis
-	speedRight = computeSpeed(speedRight, c.SpeedRight, millis)
-	speedLeft = computeSpeed(speedLeft, c.SpeedLeft, millis)
-	lastMillis = currentMillis
-
-	mr1.Value = speedRight / 100
-	mr2.Value = -speedRight / 100
-	ml1.Value = speedLeft / 100
-	ml2.Value = speedLeft / 100
-	mr1.Sync()
-	mr2.Sync()
-	ml1.Sync()
-	ml2.Sync()
-
-	ledLG.Value = c.LedLeftGreen
-	ledLR.Value = c.LedLeftRed
-	ledRG.Value = c.LedRightGreen
-	ledRR.Value = c.LedRightRed
-	ledLG.Sync()
-	ledLR.Sync()
-	ledRG.Sync()
-	ledRR.Sync()
+	out := io.driver.Command(c)
+	io.mr1.Value = out.MotorRight
+	io.mr2.Value = -out.MotorRight
+	io.ml1.Value = out.MotorLeft
+	io.ml2.Value = out.MotorLeft
+	io.mr1.Sync()
+	io.mr2.Sync()
+	io.ml1.Sync()
+	io.ml2.Sync()
+
+	io.ledLG.Value = out.LedLeftGreen
+	io.ledLR.Value = out.LedLeftRed
+	io.ledRG.Value = out.LedRightGreen
+	io.ledRR.Value = out.LedRightRed
+	io.ledLG.Sync()
+	io.ledLR.Sync()
+	io.ledRG.Sync()
+	io.ledRR.Sync()
 }
 
 // Loop contains the io loop
-func Loop() {
+func (io *IO) Loop() {
+	defer ev3.Recover()
+
 	for {
-		now := time.Now()
-		millis := ev3.TimespanAsMillis(start, now)
-
-		colR.Sync()
-		colL.Sync()
-		// irR.Sync()
-		// irL.Sync()
-		// fmt.Fprintln(os.Stderr, "DATA", irL.Value, irR.Value)
-		// intensity, angle := vision.Process(millis, irL.Value, irR.Value)
-
-		data <- logic.Data{
-			Start:            start,
-			Millis:           millis,
-			CornerRightIsOut: colorIsOut(colR.Value),
-			CornerLeftIsOut:  colorIsOut(colL.Value),
-			CornerRight:      colR.Value,
-			CornerLeft:       colL.Value,
-			IrLeftValue:      100,
-			IrRightValue:     100,
-		}
+		now := io.clock.Now()
+		millis := ev3.TimespanAsMillis(io.start, now)
+
+		io.colR.Sync()
+		io.colL.Sync()
+		// io.irR.Sync()
+		// io.irL.Sync()
+		// fmt.Fprintln(os.Stderr, "DATA", io.irL.Value, io.irR.Value)
+		// intensity, angle := vision.Process(millis, io.irL.Value, io.irR.Value)
+
+		io.data <- io.driver.Read(millis, Sensors{CornerLeft: io.colL.Value, CornerRight: io.colR.Value})
 	}
 }
 
 // Close terminates and cleans up the io module
-func Close() {
-	defer ev3.RunCommand(devs.OutA, ev3.CmdStop)
-	defer ev3.RunCommand(devs.OutB, ev3.CmdStop)
-	defer ev3.RunCommand(devs.OutC, ev3.CmdStop)
-	defer ev3.RunCommand(devs.OutD, ev3.CmdStop)
-
-	ledLG.Value = 0
-	ledLR.Value = 0
-	ledRG.Value = 0
-	ledRR.Value = 0
-	ledLG.Sync()
-	ledLR.Sync()
-	ledRG.Sync()
-	ledRR.Sync()
+func (io *IO) Close() {
+	io.watchdog.Stop()
+
+	defer ev3.RunCommand(io.devs.OutA, ev3.CmdStop)
+	defer ev3.RunCommand(io.devs.OutB, ev3.CmdStop)
+	defer ev3.RunCommand(io.devs.OutC, ev3.CmdStop)
+	defer ev3.RunCommand(io.devs.OutD, ev3.CmdStop)
+
+	io.ledLG.Value = 0
+	io.ledLR.Value = 0
+	io.ledRG.Value = 0
+	io.ledRR.Value = 0
+	io.ledLG.Sync()
+	io.ledLR.Sync()
+	io.ledRG.Sync()
+	io.ledRR.Sync()
 
 	// TODO: close all files
 	// pf, mf, ml, mc, mr
-	// colR, colL, irR, irL
+	// io.colR, io.colL, io.irR, io.irL
 }
@@ -0,0 +1,18 @@
+package logic
+
+import (
+	"context"
+	"go-bots/border"
+	"go-bots/ev3"
+)
+
+// calibrateBorder calibrates the corner sensors, then uses and saves the thresholds
+func (l *Logic) calibrateBorder(ctx context.Context, start int, dir ev3.Direction) {
+	now, ok := l.runner.CalibrateBorder(ctx, machineBot{l}, start, dir, l.conf.BorderSampleMillis, l.conf.BorderMarginPC, func(c border.Calibration) error {
+		l.calibration.Set(c)
+		return border.Save(c, l.file.BorderFile())
+	})
+	if ok {
+		l.runner.HandOff(ctx, l.chooseStrategy, now, dir)
+	}
+}
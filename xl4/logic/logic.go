@@ -1,7 +1,11 @@
 package logic
 
 import (
+	"go-bots/behaviour"
+	"go-bots/border"
+	"go-bots/ev3"
 	"go-bots/ui"
+	"go-bots/xl4/config"
 	"time"
 )
 
@@ -28,22 +32,52 @@ type Commands struct {
 	LedLeftGreen  int
 }
 
-var data <-chan Data
-var commandProcessor func(*Commands)
-var keys <-chan ui.KeyEvent
-var quit chan<- bool
-
-// Init initializes the logic module
-func Init(d <-chan Data, c func(*Commands), k <-chan ui.KeyEvent, q chan<- bool) {
-	data = d
-	commandProcessor = c
-	keys = k
-	quit = q
+// Logic drives a bot from its readings, each instance runs independently
+type Logic struct {
+	runner *behaviour.Runner
+
+	data             <-chan Data
+	commandProcessor func(*Commands)
+	keys             <-chan ui.KeyEvent
+	quit             chan<- bool
+
+	c Commands
+	// d is the last reading taken by a machine
+	d Data
+	// conf is the configuration of the current round, read from file
+	conf *config.Config
+	file *config.File
+	// calibration is the border calibration of the corner sensors
+	calibration *border.Current
+	// adjustForward is the step of the goForward adjustment chosen in the menu
+	adjustForward int
 }
 
-var c = Commands{}
+// New creates the logic of a bot: it reads d, gives its commands to c,
+// reacts to the keys k and signals q when the user quits from the menu.
+// Its configuration is read from conf, calibrating the border sets calibration
+func New(d <-chan Data, c func(*Commands), k <-chan ui.KeyEvent, q chan<- bool, conf *config.File, calibration *border.Current) *Logic {
+	l := &Logic{
+		data:             d,
+		commandProcessor: c,
+		keys:             k,
+		quit:             q,
+		runner:           behaviour.NewRunner(),
+		conf:             conf.Get(),
+		file:             conf,
+		calibration:      calibration,
+	}
+	return l
+}
+
+// Run starts the logic
+func (l *Logic) Run() {
+	go l.runner.DispatchKeys(l.keys, l.quit, l.chooseStrategy)
+	go l.runner.Run()
+	l.runner.Interrupt(l.chooseStrategy, 0, ev3.NoDirection)
+}
 
-// Run starts the logic module
-func Run() {
-	go chooseStrategy(0)
+// History returns the most recent transitions of the logic, oldest first
+func (l *Logic) History() []behaviour.Transition {
+	return l.runner.History()
 }
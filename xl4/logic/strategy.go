@@ -1,371 +1,245 @@
 package logic
 
 import (
-	"fmt"
+	"context"
+	"go-bots/behaviour"
+	"go-bots/botconf"
+	"go-bots/cmdline"
 	"go-bots/ev3"
 	"go-bots/ui"
-	"go-bots/xl4/config"
-	"os"
 )
 
-func pauseBeforeBegin(start int, strategy func(int, ev3.Direction), dir ev3.Direction) {
-	for {
-		select {
-		case d := <-data:
-			now, elapsed := handleTime(d, start)
-			if elapsed >= config.StartTime {
-				go strategy(now, dir)
-				return
-			}
-			speed(0, 0)
-			intensity := ((elapsed % 1000) * 255) / (config.StartTime / 5)
-			if elapsed > (config.StartTime * 4 / 5) {
-				leds(intensity, intensity, intensity, intensity)
-			} else {
-				leds(0, 0, intensity, intensity)
-			}
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
+// pauseBeforeBegin waits for the start time and then hands off to strategy
+func (l *Logic) pauseBeforeBegin(strategy behaviour.Func) behaviour.Func {
+	return func(ctx context.Context, start int, dir ev3.Direction) {
+		l.runner.EnterState(start, "pauseBeforeBegin", "", dir)
+		behaviour.Log(start, dir, "profile "+botconf.ProfileLabel(l.conf.Profile))
+		for {
+			select {
+			case d := <-l.data:
+				now, elapsed := l.handleTime(d, start)
+				if elapsed >= l.conf.StartTime {
+					l.runner.HandOff(ctx, strategy, now, dir)
+					return
+				}
+				l.speed(0, 0)
+				intensity := ((elapsed % 1000) * 255) / (l.conf.StartTime / 5)
+				if elapsed > (l.conf.StartTime * 4 / 5) {
+					l.leds(intensity, intensity, intensity, intensity)
+				} else {
+					l.leds(0, 0, intensity, intensity)
+				}
+				l.cmd()
+			case <-ctx.Done():
 				return
 			}
 		}
 	}
 }
 
-var adjustForward int
+func (l *Logic) chooseStrategy(ctx context.Context, start int, dir ev3.Direction) {
+	l.runner.SetInMenu(true)
+	defer l.runner.SetInMenu(false)
+	l.reloadConfig()
 
-func chooseStrategy(start int) {
-	strategy := seekStrategy
-	adjustForward = 0
+	strategy := l.seekStrategy
+	l.adjustForward = 0
 	strategyIsGoForward := false
-	var dir ev3.Direction = ev3.Left
-	leds(0, 0, 0, 0)
-	speed(0, 0)
-	cmd()
+	dir = ev3.Left
+	l.runner.EnterState(start, "chooseStrategy", "", dir)
+	l.leds(0, 0, 0, 0)
+	l.speed(0, 0)
+	l.cmd()
 
 	for {
 		select {
-		case d := <-data:
-			handleTime(d, start)
-			speed(0, 0)
-			cmd()
-		case k := <-keys:
-			checkQuit(k)
+		case d := <-l.data:
+			l.handleTime(d, start)
+			l.refreshConfig()
+			l.speed(0, 0)
+			l.cmd()
+		case k := <-l.runner.MenuKeys():
 			if k.Key == ui.Enter {
-				go pauseBeforeBegin(k.Millis, strategy, dir)
+				l.runner.HandOff(ctx, l.pauseBeforeBegin(strategy), k.Millis, dir)
+				return
+			} else if k.Key == ui.Profile {
+				l.selectNextProfile()
+			} else if k.Key == ui.Calibrate {
+				l.runner.HandOff(ctx, l.calibrateBorder, k.Millis, dir)
 				return
 			} else if k.Key == ui.Left {
 				dir = ev3.Left
-				strategy = goForward
+				strategy = l.goForward
 				strategyIsGoForward = true
-				adjustForward++
-				if adjustForward > config.GoForwardAdjustmentSteps {
-					adjustForward = 0
+				l.adjustForward++
+				if l.adjustForward > l.conf.GoForwardAdjustmentSteps {
+					l.adjustForward = 0
 				}
-				leds(255, adjustForward*255/config.GoForwardAdjustmentSteps, 0, 0)
-				fmt.Fprintln(os.Stderr, "chooseStrategy forward adjusted left", adjustForward)
+				l.leds(255, l.adjustForward*255/l.conf.GoForwardAdjustmentSteps, 0, 0)
+				cmdline.Log(cmdline.Info, "chooseStrategy forward adjusted left", l.adjustForward)
 			} else if k.Key == ui.Right {
 				dir = ev3.Right
-				strategy = goForward
+				strategy = l.goForward
 				strategyIsGoForward = true
-				adjustForward++
-				if adjustForward > config.GoForwardAdjustmentSteps {
-					adjustForward = 0
+				l.adjustForward++
+				if l.adjustForward > l.conf.GoForwardAdjustmentSteps {
+					l.adjustForward = 0
 				}
-				leds(adjustForward*255/config.GoForwardAdjustmentSteps, 255, 0, 0)
-				fmt.Fprintln(os.Stderr, "chooseStrategy forward adjusted right", adjustForward)
+				l.leds(l.adjustForward*255/l.conf.GoForwardAdjustmentSteps, 255, 0, 0)
+				cmdline.Log(cmdline.Info, "chooseStrategy forward adjusted right", l.adjustForward)
 			} else if k.Key == ui.Up {
 				if strategyIsGoForward {
-					strategy = seekStrategy
+					strategy = l.seekStrategy
 					strategyIsGoForward = false
-					leds(0, 0, 0, 0)
-					fmt.Fprintln(os.Stderr, "chooseStrategy seek")
+					l.leds(0, 0, 0, 0)
+					cmdline.Log(cmdline.Info, "chooseStrategy seek")
 				} else {
-					strategy = goForward
+					strategy = l.goForward
 					strategyIsGoForward = true
-					adjustForward = 0
+					l.adjustForward = 0
 					if dir == ev3.Left {
-						leds(255, 0, 0, 0)
-						fmt.Fprintln(os.Stderr, "chooseStrategy forward left")
+						l.leds(255, 0, 0, 0)
+						cmdline.Log(cmdline.Info, "chooseStrategy forward left")
 					} else {
-						leds(0, 255, 0, 0)
-						fmt.Fprintln(os.Stderr, "chooseStrategy forward right")
+						l.leds(0, 255, 0, 0)
+						cmdline.Log(cmdline.Info, "chooseStrategy forward right")
 					}
 				}
 			} else if k.Key == ui.Down {
-				strategy = turnBack
+				strategy = l.turnBack
 				strategyIsGoForward = false
 				if dir == ev3.Left {
-					leds(0, 0, 255, 0)
-					fmt.Fprintln(os.Stderr, "chooseStrategy back left")
+					l.leds(0, 0, 255, 0)
+					cmdline.Log(cmdline.Info, "chooseStrategy back left")
 				} else {
-					leds(0, 0, 0, 255)
-					fmt.Fprintln(os.Stderr, "chooseStrategy back right")
+					l.leds(0, 0, 0, 255)
+					cmdline.Log(cmdline.Info, "chooseStrategy back right")
 				}
 			}
-			speed(0, 0)
-			cmd()
+			l.speed(0, 0)
+			l.cmd()
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func circle(start int, dir ev3.Direction) {
-	now, elapsed := start, 0
-
-	log(now, dir, "CIRCLE find border")
-findBorder:
-	for {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-			if dir == ev3.Right {
-				if d.CornerRightIsOut {
-					break findBorder
-				}
-				if elapsed < config.CircleFindBorderMillis {
-					speed(config.CircleFindBorderOuterSpeed, -config.CircleFindBorderInnerSpeed)
-				} else {
-					speed(config.CircleFindBorderOuterSpeedSlowRight, -config.CircleFindBorderInnerSpeedSlowRight)
-				}
-			} else {
-				if d.CornerLeftIsOut {
-					break findBorder
-				}
-				if elapsed < config.CircleFindBorderMillis {
-					speed(-config.CircleFindBorderInnerSpeed, config.CircleFindBorderOuterSpeed)
-				} else {
-					speed(-config.CircleFindBorderInnerSpeedSlowLeft, config.CircleFindBorderOuterSpeedSlowLeft)
-				}
-			}
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	log(now, dir, "CIRCLE start")
-	dir = ev3.ChangeDirection(dir)
-	borderFoundTime := elapsed
-	for elapsed-borderFoundTime < config.CircleMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				cornerValue := d.CornerLeft
-				adjustInner := cornerValue * config.CircleAdjustInnerMax / 100
-				inner := config.CircleInnerSpeedRight - adjustInner
-				speed(config.CircleOuterSpeed, inner)
-			} else {
-				cornerValue := d.CornerRight
-				adjustInner := cornerValue * config.CircleAdjustInnerMax / 100
-				inner := config.CircleInnerSpeedLeft - adjustInner
-				speed(inner, config.CircleOuterSpeed)
-			}
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	log(now, dir, "CIRCLE spiral")
-	circleDoneTime := elapsed
-	for elapsed-circleDoneTime < config.CircleSpiralMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				speed(config.CircleSpiralOuterSpeed, config.CircleSpiralInnerSpeed)
-			} else {
-				speed(config.CircleSpiralInnerSpeed, config.CircleSpiralOuterSpeed)
-			}
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) circleMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "CIRCLE",
+		Phases: []behaviour.Phase{
+			{
+				Name: "CIRCLE find border",
+				Steer: func(r *behaviour.Run) (int, int) {
+					if r.Elapsed < l.conf.CircleFindBorderMillis {
+						return behaviour.Sides(r.Dir, l.conf.CircleFindBorderOuterSpeed, -l.conf.CircleFindBorderInnerSpeed)
+					}
+					if r.Dir == ev3.Right {
+						return l.conf.CircleFindBorderOuterSpeedSlowRight, -l.conf.CircleFindBorderInnerSpeedSlowRight
+					}
+					return -l.conf.CircleFindBorderInnerSpeedSlowLeft, l.conf.CircleFindBorderOuterSpeedSlowLeft
+				},
+				Until: func(r *behaviour.Run) bool {
+					if r.Dir == ev3.Right {
+						return l.d.CornerRightIsOut
+					}
+					return l.d.CornerLeftIsOut
+				},
+				FlipDir: true,
+			},
+			{
+				Name:   "CIRCLE start",
+				Millis: l.conf.CircleMillis,
+				Steer: func(r *behaviour.Run) (int, int) {
+					if r.Dir == ev3.Right {
+						adjustInner := l.d.CornerLeft * l.conf.CircleAdjustInnerMax / 100
+						return l.conf.CircleOuterSpeed, l.conf.CircleInnerSpeedRight - adjustInner
+					}
+					adjustInner := l.d.CornerRight * l.conf.CircleAdjustInnerMax / 100
+					return l.conf.CircleInnerSpeedLeft - adjustInner, l.conf.CircleOuterSpeed
+				},
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+			{
+				Name:       "CIRCLE spiral",
+				Millis:     l.conf.CircleSpiralMillis,
+				Outer:      l.conf.CircleSpiralOuterSpeed,
+				Inner:      l.conf.CircleSpiralInnerSpeed,
+				Interrupts: []behaviour.Check{l.checkVision},
+			},
+		},
+		Next:     l.seekStrategy,
+		FlipNext: true,
 	}
-
-	log(now, dir, "CIRCLE done")
-	go seek(now, ev3.ChangeDirection(dir), false)
 }
 
-func goForward(start int, dir ev3.Direction) {
-	now, elapsed := start, 0
-
-	fmt.Fprintln(os.Stderr, "goForward", now, dir)
-
-	for elapsed < config.GoForwardMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			if dir == ev3.Left {
-				speed(config.GoForwardSpeed-(adjustForward*config.GoForwardAdjustmentStep), config.GoForwardSpeed)
-			} else if dir == ev3.Right {
-				speed(config.GoForwardSpeed, config.GoForwardSpeed-(adjustForward*config.GoForwardAdjustmentStep))
-			} else {
-				speed(config.GoForwardSpeed, config.GoForwardSpeed)
-			}
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	fmt.Fprintln(os.Stderr, "goForward turn", now, dir)
-
-	forwardDone := elapsed
-	for elapsed-forwardDone < config.GoForwardTurnMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				speed(config.GoForwardTurnOuterSpeed, config.GoForwardTurnInnerSpeed)
-			} else {
-				speed(config.GoForwardTurnInnerSpeed, config.GoForwardTurnOuterSpeed)
-			}
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	fmt.Fprintln(os.Stderr, "goForward done", now, dir)
-	go seek(now, ev3.ChangeDirection(dir), true)
+func (l *Logic) circle(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.circleMachine(), start, dir, 0)
 }
 
-func turnBack(start int, dir ev3.Direction) {
-	now, elapsed := start, 0
-
-	fmt.Fprintln(os.Stderr, "turnBack", now, dir)
-
-	for elapsed < config.TurnBackPreMoveMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			speed(config.TurnBackPreMoveSpeed, config.TurnBackPreMoveSpeed)
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
-	}
-
-	fmt.Fprintln(os.Stderr, "turnBack turn", now, dir)
-	turnBackPreMoveDone := elapsed
-	for elapsed-turnBackPreMoveDone < config.TurnBackMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			if dir == ev3.Right {
-				speed(config.TurnBackOuterSpeed, config.TurnBackInnerSpeed)
-			} else {
-				speed(config.TurnBackInnerSpeed, config.TurnBackOuterSpeed)
-			}
-
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) goForwardMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "goForward",
+		Phases: []behaviour.Phase{
+			{
+				Name:   "goForward move",
+				Millis: l.conf.GoForwardMillis,
+				Steer: func(r *behaviour.Run) (int, int) {
+					if r.Dir == ev3.NoDirection {
+						return l.conf.GoForwardSpeed, l.conf.GoForwardSpeed
+					}
+					return behaviour.Sides(r.Dir, l.conf.GoForwardSpeed, l.conf.GoForwardSpeed-(l.adjustForward*l.conf.GoForwardAdjustmentStep))
+				},
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+			{
+				Name:       "goForward turn",
+				Millis:     l.conf.GoForwardTurnMillis,
+				Outer:      l.conf.GoForwardTurnOuterSpeed,
+				Inner:      l.conf.GoForwardTurnInnerSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+		},
+		Next:     l.seekTurning,
+		FlipNext: true,
 	}
+}
 
-	fmt.Fprintln(os.Stderr, "turnBack move", now, dir)
-
-	turnBackMoveDone := elapsed
-	for elapsed-turnBackMoveDone < config.TurnBackMoveMillis {
-		select {
-		case d := <-data:
-			now, elapsed = handleTime(d, start)
-
-			if checkVision(d, now) {
-				return
-			}
-			if checkBorder(d, now) {
-				return
-			}
-
-			// speed(config.TurnBackMoveSpeed, config.TurnBackMoveSpeed)
-			if dir == ev3.Right {
-				speed(config.TurnBackMoveSpeed*20/100, config.TurnBackMoveSpeed)
-			} else {
-				speed(config.TurnBackMoveSpeed, config.TurnBackMoveSpeed*20/100)
-			}
+func (l *Logic) goForward(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.goForwardMachine(), start, dir, 0)
+}
 
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) turnBackMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "turnBack",
+		Phases: []behaviour.Phase{
+			{
+				Name:       "turnBack pre move",
+				Millis:     l.conf.TurnBackPreMoveMillis,
+				Outer:      l.conf.TurnBackPreMoveSpeed,
+				Inner:      l.conf.TurnBackPreMoveSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+			{
+				Name:       "turnBack turn",
+				Millis:     l.conf.TurnBackMillis,
+				Outer:      l.conf.TurnBackOuterSpeed,
+				Inner:      l.conf.TurnBackInnerSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+			{
+				Name:       "turnBack move",
+				Millis:     l.conf.TurnBackMoveMillis,
+				Outer:      l.conf.TurnBackMoveSpeed * 20 / 100,
+				Inner:      l.conf.TurnBackMoveSpeed,
+				Interrupts: []behaviour.Check{l.checkVision, l.checkBorder},
+			},
+		},
+		Next:     l.seekTurning,
+		FlipNext: true,
 	}
+}
 
-	fmt.Fprintln(os.Stderr, "turnBack done", now, dir)
-	go seek(now, ev3.ChangeDirection(dir), true)
+func (l *Logic) turnBack(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.turnBackMachine(), start, dir, 0)
 }
@@ -1,64 +1,57 @@
 package logic
 
 import (
+	"context"
+	"go-bots/behaviour"
 	"go-bots/ev3"
-	"go-bots/xl4/config"
 )
 
-func checkVision(d Data, now int) bool {
-	result := d.IrLeftValue < config.MaxIrDistance || d.IrRightValue < config.MaxIrDistance
+func (l *Logic) checkVision(ctx context.Context, r *behaviour.Run) bool {
+	result := l.d.IrLeftValue < l.conf.MaxIrDistance || l.d.IrRightValue < l.conf.MaxIrDistance
 	if result {
-		go track(now)
+		l.runner.HandOff(ctx, l.track, r.Now, ev3.NoDirection)
 	}
 	return result
 }
 
-func track(start int) {
-	now, _ := start, 0
-	var dir ev3.Direction = ev3.Right
-
-	log(now, ev3.NoDirection, "TRACK")
-
-	for {
-		select {
-		case d := <-data:
-			now, _ = handleTime(d, start)
-
-			if d.IrLeftValue >= config.MaxIrDistance && d.IrRightValue >= config.MaxIrDistance {
-				go seek(now, dir, true)
-				return
-			}
-			if (d.IrLeftValue >= config.IgnoreBorderIrDistance || d.IrRightValue >= config.IgnoreBorderIrDistance) && checkBorder(d, now) {
-				return
-			}
-
-			if d.IrLeftValue >= config.MaxIrDistance {
-				speed(config.TrackOnly1SensorOuterSpeed, config.TrackOnly1SensorInnerSpeed)
-				dir = ev3.Right
-			} else if d.IrRightValue >= config.MaxIrDistance {
-				speed(config.TrackOnly1SensorInnerSpeed, config.TrackOnly1SensorOuterSpeed)
-				dir = ev3.Left
-			} else {
-				difference := d.IrLeftValue - d.IrRightValue
-
-				if difference > config.TrackCenterZone {
-					speed(config.TrackSpeed, config.TrackSpeed-(difference*config.TrackDifferenceCoefficent))
-					dir = ev3.Right
-				} else if difference < -config.TrackCenterZone {
-					speed(config.TrackSpeed+(difference*config.TrackDifferenceCoefficent), config.TrackSpeed)
-					dir = ev3.Left
-				} else {
-					speed(config.TrackSpeed, config.TrackSpeed)
-				}
-
-			}
-			// fmt.Fprintln(os.Stderr, "TRACK time ", now, ", speed", c.SpeedLeft, c.SpeedRight, ", IRsensors", d.IrLeftValue, d.IrRightValue)
-			ledsFromData(d)
-			cmd()
-		case k := <-keys:
-			if checkDone(k) {
-				return
-			}
-		}
+func (l *Logic) trackMachine() *behaviour.Machine {
+	return &behaviour.Machine{
+		Name: "TRACK",
+		Phases: []behaviour.Phase{
+			{
+				Name: "TRACK follow",
+				Steer: func(r *behaviour.Run) (int, int) {
+					if l.d.IrLeftValue >= l.conf.MaxIrDistance {
+						r.Dir = ev3.Right
+						return l.conf.TrackOnly1SensorOuterSpeed, l.conf.TrackOnly1SensorInnerSpeed
+					} else if l.d.IrRightValue >= l.conf.MaxIrDistance {
+						r.Dir = ev3.Left
+						return l.conf.TrackOnly1SensorInnerSpeed, l.conf.TrackOnly1SensorOuterSpeed
+					}
+					difference := l.d.IrLeftValue - l.d.IrRightValue
+					if difference > l.conf.TrackCenterZone {
+						r.Dir = ev3.Right
+						return l.conf.TrackSpeed, l.conf.TrackSpeed - (difference * l.conf.TrackDifferenceCoefficent)
+					} else if difference < -l.conf.TrackCenterZone {
+						r.Dir = ev3.Left
+						return l.conf.TrackSpeed + (difference * l.conf.TrackDifferenceCoefficent), l.conf.TrackSpeed
+					}
+					return l.conf.TrackSpeed, l.conf.TrackSpeed
+				},
+				Until: func(r *behaviour.Run) bool {
+					return l.d.IrLeftValue >= l.conf.MaxIrDistance && l.d.IrRightValue >= l.conf.MaxIrDistance
+				},
+				Interrupts: []behaviour.Check{
+					func(ctx context.Context, r *behaviour.Run) bool {
+						return (l.d.IrLeftValue >= l.conf.IgnoreBorderIrDistance || l.d.IrRightValue >= l.conf.IgnoreBorderIrDistance) && l.checkBorder(ctx, r)
+					},
+				},
+			},
+		},
+		Next: l.seekTurning,
 	}
 }
+
+func (l *Logic) track(ctx context.Context, start int, dir ev3.Direction) {
+	l.runMachine(ctx, l.trackMachine(), start, ev3.Right, 0)
+}
@@ -0,0 +1,249 @@
+package simio
+
+import (
+	"fmt"
+	"go-bots/behaviour"
+	"go-bots/border"
+	"go-bots/clock"
+	"go-bots/ev3"
+	"go-bots/sim/sumo"
+	"go-bots/ui"
+	"go-bots/xl4/config"
+	"go-bots/xl4/io"
+	"go-bots/xl4/logic"
+	"sync"
+	"time"
+)
+
+// Sensor names of the simulated body
+const (
+	CornerLeft  = "corner-left"
+	CornerRight = "corner-right"
+)
+
+// Body returns the shape and sensors of xl4
+func Body() sumo.Body {
+	return sumo.Body{
+		Radius:      110,
+		Mass:        1.4,
+		Friction:    1,
+		WheelBase:   160,
+		MaxSpeed:    400,
+		MotorMillis: 100,
+		Sensors: []sumo.Sensor{
+			{Name: CornerLeft, Kind: sumo.Reflect, X: 100, Y: 80},
+			{Name: CornerRight, Kind: sumo.Reflect, X: 100, Y: -80},
+		},
+	}
+}
+
+// Strategies lists the strategies that can be chosen from the menu
+var Strategies = []string{"seek", "goForward", "turnBack"}
+
+// menuKeys returns the keys that choose a strategy in the menu
+func menuKeys(strategy string, dir ev3.Direction) ([]ui.Key, error) {
+	right := dir == ev3.Right
+	switch strategy {
+	case "seek":
+		if right {
+			return []ui.Key{ui.Right, ui.Up}, nil
+		}
+		return []ui.Key{}, nil
+	case "goForward":
+		if right {
+			return []ui.Key{ui.Right, ui.Up, ui.Up}, nil
+		}
+		return []ui.Key{ui.Up}, nil
+	case "turnBack":
+		if right {
+			return []ui.Key{ui.Right, ui.Down}, nil
+		}
+		return []ui.Key{ui.Down}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q (must be one of %v)", strategy, Strategies)
+}
+
+// Bot runs the xl4 logic against a robot of the simulated arena, in place
+// of the io module
+type Bot struct {
+	body     sumo.Body
+	strategy []ui.Key
+	data     chan logic.Data
+	keys     chan ui.KeyEvent
+	quit     chan bool
+	clock    *clock.Manual
+	// stallClock times the wait for the logic to take a reading, the
+	// simulation is stopped meanwhile so it cannot be its own clock
+	stallClock  clock.Clock
+	conf        *config.File
+	calibration *border.Current
+	start       time.Time
+	keypad      *sumo.Keypad
+	logic       *logic.Logic
+	driver      *io.Driver
+
+	mutex    sync.Mutex
+	commands []logic.Commands
+	stalled  bool
+	// taken is the time of the last reading taken by the logic
+	taken   int
+	insight sumo.Insight
+}
+
+// New starts the xl4 logic with the given strategy and direction,
+// its time is kept on c, its configuration and corner sensor calibration are
+// conf and calibration
+func New(strategy string, dir ev3.Direction, c *clock.Manual, conf *config.File, calibration *border.Current) (*Bot, error) {
+	keys, err := menuKeys(strategy, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bot{
+		body:        Body(),
+		strategy:    keys,
+		data:        make(chan logic.Data),
+		keys:        make(chan ui.KeyEvent),
+		quit:        make(chan bool, 1),
+		clock:       c,
+		stallClock:  clock.Real{},
+		conf:        conf,
+		calibration: calibration,
+	}
+	b.start = b.clock.Now()
+	b.logic = logic.New(b.data, b.processCommand, b.keys, b.quit, conf, calibration)
+	b.driver = io.NewDriver(b.start, conf, calibration)
+	b.keypad = &sumo.Keypad{
+		Keys:   b.keys,
+		InMenu: func() bool { return b.state().Machine == "chooseStrategy" },
+		Ready:  func() bool { return b.state().Machine != "" },
+	}
+	go b.logic.Run()
+	return b, nil
+}
+
+// SetBody replaces the default body
+func (b *Bot) SetBody(body sumo.Body) {
+	b.body = body
+}
+
+// SetStallClock replaces the clock timing the wait for the logic (the system
+// clock by default)
+func (b *Bot) SetStallClock(c clock.Clock) {
+	b.stallClock = c
+}
+
+// Name identifies the controller
+func (b *Bot) Name() string {
+	return "xl4"
+}
+
+// Body returns the shape and sensors of the robot
+func (b *Bot) Body() sumo.Body {
+	return b.body
+}
+
+// Start goes back to the menu (when needed), chooses the strategy and presses ENTER
+func (b *Bot) Start(now int) {
+	b.keypad.Choose(now, b.strategy)
+}
+
+// State describes what the logic is doing
+func (b *Bot) State() string {
+	s := b.state()
+	if s.Phase == "" {
+		return s.Machine
+	}
+	return s.Phase
+}
+
+// state returns the last state entered for a reading before the last one
+// taken, like the commands
+func (b *Bot) state() behaviour.Transition {
+	result := behaviour.Transition{}
+	for _, t := range b.logic.History() {
+		if t.Millis < b.taken {
+			result = t
+		}
+	}
+	return result
+}
+
+// Insight tells which corners see the edge
+func (b *Bot) Insight() sumo.Insight {
+	return b.insight
+}
+
+// processCommand records the commands, they are applied at the next step
+func (b *Bot) processCommand(c *logic.Commands) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.commands = append(b.commands, *c)
+}
+
+// command returns the last command given for a reading before the last
+// one taken (see the seeker2 simio module)
+func (b *Bot) command() (logic.Commands, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	found := -1
+	for i, c := range b.commands {
+		if c.Millis < b.taken {
+			found = i
+		}
+	}
+	if found < 0 {
+		return logic.Commands{}, false
+	}
+	result := b.commands[found]
+	b.commands = b.commands[found+1:]
+	return result, true
+}
+
+// Step applies the last command to the robot (the leds are not simulated),
+// reads its sensors and hands them to the logic
+func (b *Bot) Step(now int, r *sumo.Robot, w *sumo.World) {
+	b.clock.Set(b.start.Add(time.Duration(now) * time.Millisecond))
+
+	if c, ok := b.command(); ok {
+		out := b.driver.Command(&c)
+		r.SetDuty(out.MotorLeft, out.MotorRight)
+	}
+
+	d := b.driver.Read(ev3.TimespanAsMillis(b.start, b.clock.Now()), io.Sensors{
+		CornerLeft:  w.Sense(r, CornerLeft),
+		CornerRight: w.Sense(r, CornerRight),
+	})
+	b.insight = sumo.Insight{}
+	if d.CornerLeftIsOut {
+		b.insight.Edges = append(b.insight.Edges, CornerLeft)
+	}
+	if d.CornerRightIsOut {
+		b.insight.Edges = append(b.insight.Edges, CornerRight)
+	}
+	b.send(r, d)
+	// The keys follow the reading, once the logic has taken it
+	b.keypad.Step(now)
+}
+
+// send hands a reading to the logic, when the logic does not take it the
+// robot stops until the logic reads again
+func (b *Bot) send(r *sumo.Robot, d logic.Data) {
+	if b.stalled {
+		select {
+		case b.data <- d:
+			b.stalled = false
+			b.taken = d.Millis
+		default:
+			r.SetDuty(0, 0)
+		}
+		return
+	}
+	select {
+	case b.data <- d:
+		b.taken = d.Millis
+	case <-b.stallClock.After(sumo.StallTimeout):
+		b.stalled = true
+		r.SetDuty(0, 0)
+	}
+}
@@ -2,8 +2,18 @@ package vision
 
 import "go-bots/xl4/config"
 
-var lastSecond = 0
-var lastSecondChanged = false
+// Vision turns the readings of the IR sensors into where the opponent is
+type Vision struct {
+	lastSecond        int
+	lastSecondChanged bool
+
+	conf *config.File
+}
+
+// New creates a vision using the configuration of conf
+func New(conf *config.File) *Vision {
+	return &Vision{conf: conf}
+}
 
 func detectLastSecond(now int, lastSecond int) (int, bool) {
 	lastSecondChanged := false
@@ -15,40 +25,41 @@ func detectLastSecond(now int, lastSecond int) (int, bool) {
 }
 
 // Process processes IR input values into vision data
-func Process(millis int, leftValue int, rightValue int) (intensity int, angle int) {
+func (v *Vision) Process(millis int, leftValue int, rightValue int) (intensity int, angle int) {
+	conf := v.conf.Get()
 	intensity = 0
 	angle = 0
-	if leftValue >= config.MaxIrDistance && rightValue >= config.MaxIrDistance {
+	if leftValue >= conf.MaxIrDistance && rightValue >= conf.MaxIrDistance {
 
-		lastSecond, lastSecondChanged = detectLastSecond(millis, lastSecond)
-		if lastSecondChanged {
+		v.lastSecond, v.lastSecondChanged = detectLastSecond(millis, v.lastSecond)
+		if v.lastSecondChanged {
 			// fmt.Fprintln(os.Stderr, "DATA EMPTY")
 		}
 
 		return
 	}
-	intensityLeft := (config.MaxIrValue - leftValue) * config.VisionIntensityMax / config.MaxIrValue
-	intensityRight := (config.MaxIrValue - rightValue) * config.VisionIntensityMax / config.MaxIrValue
+	intensityLeft := (conf.MaxIrValue - leftValue) * conf.VisionIntensityMax / conf.MaxIrValue
+	intensityRight := (conf.MaxIrValue - rightValue) * conf.VisionIntensityMax / conf.MaxIrValue
 	if intensityRight > intensityLeft {
 		intensity = intensityRight
 	} else {
 		intensity = intensityLeft
 	}
 	if intensityLeft == 0 {
-		angle = (config.VisionIntensityMax - intensityRight) * config.VisionAngleMax / config.VisionIntensityMax
+		angle = (conf.VisionIntensityMax - intensityRight) * conf.VisionAngleMax / conf.VisionIntensityMax
 		angle /= 2
-		angle += config.VisionAngleMax / 2
+		angle += conf.VisionAngleMax / 2
 	} else if intensityRight == 0 {
-		angle = (config.VisionIntensityMax - intensityLeft) * config.VisionAngleMax / config.VisionIntensityMax
+		angle = (conf.VisionIntensityMax - intensityLeft) * conf.VisionAngleMax / conf.VisionIntensityMax
 		angle /= 2
-		angle += config.VisionAngleMax / 2
+		angle += conf.VisionAngleMax / 2
 		angle = -angle
 	} else {
 		angle = (intensityRight - intensityLeft) / 2
 	}
 
-	lastSecond, lastSecondChanged = detectLastSecond(millis, lastSecond)
-	if lastSecondChanged {
+	v.lastSecond, v.lastSecondChanged = detectLastSecond(millis, v.lastSecond)
+	if v.lastSecondChanged {
 		// fmt.Fprintln(os.Stderr, "DATA", leftValue, rightValue, intensity, angle)
 	}
 
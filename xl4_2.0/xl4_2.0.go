@@ -3,34 +3,27 @@ package main
 import (
 	"fmt"
 	"go-bots/ev3"
-	"log"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"go-bots/beep"
+	"go-bots/botconf"
+	"go-bots/clock"
+	"go-bots/cmdline"
 
 	"go-bots/xl4_2.0/config"
 )
 
-func handleSignals() {
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigs
-		quit("Terminated by signal", sig)
-	}()
-}
-
 var devs *ev3.Devices
+var clk clock.Clock = clock.Real{}
 var initializationTime time.Time
 var motorL1, motorL2, motorR1, motorR2 *ev3.Attribute
 var irL, irFL, irFR, irR *ev3.Attribute
 var irRemote1, irRemote2, irRemote3, irRemote4 *ev3.Attribute
 var buttons *ev3.Buttons
+var watchdog *ev3.Watchdog
 
-var conf config.Config
+var conf = config.Default()
 
 func closeIrProx() {
 	if irL != nil {
@@ -113,14 +106,15 @@ func setIrRemoteMode(remoteChannel int) {
 		irRemote3 = ev3.OpenTextR(devs.In3, ev3.Value3)
 		irRemote4 = ev3.OpenTextR(devs.In4, ev3.Value3)
 	} else {
-		quit("Invalid remote channel number", remoteChannel)
+		ev3.Fatalln("Invalid remote channel number", remoteChannel)
 	}
 }
 
 func initialize() {
-	initializationTime = time.Now()
+	initializationTime = clk.Now()
 
-	buttons = ev3.OpenButtons(false)
+	buttons = ev3.OpenButtons(false, clk)
+	ev3.OnShutdown(beep.CCC)
 
 	devs = ev3.Scan(&ev3.OutPortModes{
 		OutA: ev3.OutPortModeDcMotor,
@@ -174,29 +168,6 @@ func initialize() {
 	ev3.RunCommand(devs.OutD, ev3.CmdRunDirect)
 }
 
-func close() {
-	beep.CCC()
-
-	// Close buttons
-	buttons.Close()
-
-	// Stop motors
-	ev3.RunCommand(devs.OutA, ev3.CmdStop)
-	ev3.RunCommand(devs.OutB, ev3.CmdStop)
-	ev3.RunCommand(devs.OutC, ev3.CmdStop)
-	ev3.RunCommand(devs.OutD, ev3.CmdStop)
-
-	// Close motors
-	motorL1.Close()
-	motorL2.Close()
-	motorR1.Close()
-	motorR2.Close()
-
-	// Close sensor values
-	closeIrProx()
-	closeIrRemote()
-}
-
 var lastMoveTicks int
 var lastSpeedLeft int
 var lastSpeedRight int
@@ -204,6 +175,9 @@ var lastSpeedRight int
 const accelSpeedFactor int = 10000
 
 func move(left int, right int, now int) {
+	if !watchdog.Feed() {
+		return
+	}
 	ticks := now - lastMoveTicks
 	lastMoveTicks = now
 	right *= accelSpeedFactor
@@ -288,19 +262,26 @@ func timespanAsTicks(start time.Time, end time.Time) int {
 	return durationToTicks(end.Sub(start))
 }
 func currentTicks() int {
-	return timespanAsTicks(initializationTime, time.Now())
+	return timespanAsTicks(initializationTime, clk.Now())
 }
 func ticksToMillis(ticks int) int {
 	return ticks / 1000
 }
 
 func print(data ...interface{}) {
-	fmt.Fprintln(os.Stderr, data...)
+	if cmdline.Enabled(cmdline.Info) {
+		fmt.Fprintln(os.Stderr, data...)
+	}
 }
 
-func quit(data ...interface{}) {
-	close()
-	log.Fatalln(data...)
+func debug(data ...interface{}) {
+	if cmdline.Enabled(cmdline.Debug) {
+		fmt.Fprintln(os.Stderr, data...)
+	}
+}
+
+func printError(data ...interface{}) {
+	fmt.Fprintln(os.Stderr, data...)
 }
 
 func checkVision() bool {
@@ -312,19 +293,37 @@ func checkVision() bool {
 	return false
 }
 
+var configFile = ""
+var watcher *botconf.Watcher
+var profile = ""
+
+// sets are the values given with -set, applied on every load
+var sets []string
+
 func loadConfig() {
-	newConf, err := config.FromFile("xl4_2.0.toml")
+	watcher.Reset()
+	newConf, err := config.FromFile(configFile, profile, sets)
 	if err != nil {
-		print("Error reading conf:", err)
-		conf = config.Default()
-		print("Using default conf", conf)
+		printError("Error reading conf, keeping the previous one:", err)
 	} else {
 		conf = newConf
-		print("Configuration loaded:", conf)
+		print("Configuration loaded:\n" + botconf.String(conf))
+	}
+}
+
+// selectNextProfile switches to the next profile of the configuration file
+func selectNextProfile() {
+	names, err := botconf.ProfileNamesInFile(configFile)
+	if err != nil {
+		printError("Error reading profiles:", err)
+		return
 	}
+	profile = botconf.NextProfile(names, conf.Profile)
+	print("profile", botconf.ProfileLabel(profile))
+	loadConfig()
 }
 
-var strategyDirection = 0
+var strategyName = config.DirectionStraight
 
 func chooseStrategy(channelNumber int) bool {
 	setIrRemoteMode(channelNumber)
@@ -335,23 +334,34 @@ func chooseStrategy(channelNumber int) bool {
 
 		if remoteValue == 11 {
 			return true
+		} else if remoteValue == 0 && watcher.Changed() {
+			print("Configuration file changed")
+			loadConfig()
 		} else if remoteValue == 3 {
 			loadConfig()
 			beep.GC()
-			strategyDirection = -1
+			strategyName = config.DirectionLeft
 		} else if remoteValue == 4 {
 			loadConfig()
 			beep.CG()
-			strategyDirection = 1
+			strategyName = config.DirectionRight
 		} else if remoteValue == 2 {
 			loadConfig()
 			beep.GG()
-			strategyDirection = 0
+			strategyName = config.DirectionStraight
+		} else if remoteValue == 10 {
+			// Both red buttons: wait for the release and switch profile
+			for readRemote() == 10 {
+				now := currentTicks()
+				move(0, 0, now)
+			}
+			selectNextProfile()
+			beep.CCC()
 		} else if remoteValue == 1 {
 			beep.C()
 			return false
 		}
-		// print(strategyDirection)
+		// print(strategyName)
 	}
 }
 
@@ -370,136 +380,41 @@ func waitBegin() {
 
 func strategy() ev3.Direction {
 	setIrProxMode()
-	print("strategy")
-	for {
-		now := currentTicks()
-		move(0, 0, now)
-
-		if strategyDirection == -1 {
-			return strategyLeft()
-		} else if strategyDirection == 0 {
-			return strategyStraight()
-		} else if strategyDirection == 1 {
-			return strategyRight()
-		}
-	}
-}
+	print("strategy", strategyName, "profile", botconf.ProfileLabel(conf.Profile))
 
-func strategyLeft() ev3.Direction {
-	print("strategy left")
-	startR1 := currentTicks()
-	for {
-		now := currentTicks()
-		if now-startR1 >= conf.StrategyR1Time {
-			break
-		}
-		if checkVision() {
-			return ev3.Right
-		}
-		move(-20, conf.MaxSpeed, now)
+	s, ok := conf.Strategies[strategyName]
+	if !ok {
+		print("Unknown strategy", strategyName)
+		return ev3.Left
 	}
-	startS1 := currentTicks()
-	for {
-		now := currentTicks()
-		if now-startS1 >= conf.StrategyS1Time {
+	for _, step := range s.Steps {
+		if moveStep(step) {
 			break
 		}
-		if checkVision() {
-			return ev3.Right
-		}
-		move(conf.MaxSpeed, conf.MaxSpeed, now)
 	}
-	startR2 := currentTicks()
-	for {
-		now := currentTicks()
-		if now-startR2 >= conf.StrategyR2Time {
-			break
-		}
-		if checkVision() {
-			return ev3.Right
-		}
-		move(conf.MaxSpeed, -20, now)
-	}
-	startS2 := currentTicks()
-	for {
-		now := currentTicks()
-		if now-startS2 >= conf.StrategyS2Time {
-			break
-		}
-		if checkVision() {
-			return ev3.Right
-		}
-		move(conf.MaxSpeed, conf.MaxSpeed, now)
-	}
-	print("ho finito strategy left, seeeee!!!")
-	return ev3.Right
+	print("ho finito strategy", strategyName+", seeeee!!!")
+	return s.TrackDir
 }
 
-func strategyStraight() ev3.Direction {
-	print("strategy straight")
+func moveStep(step config.Step) bool {
 	start := currentTicks()
 	for {
 		now := currentTicks()
-		if now-start >= conf.StrategyStraightTime {
-			break
-		}
-		if checkVision() {
-			return ev3.Left
-		}
-		move(conf.MaxSpeed, conf.MaxSpeed, now)
-	}
-	print("ho finito strategy straight, seeeee!!!")
-	return ev3.Left
-}
-
-func strategyRight() ev3.Direction {
-	print("strategy right")
-	startR1 := currentTicks()
-	for {
-		now := currentTicks()
-		if now-startR1 >= conf.StrategyR1Time {
-			break
-		}
-		if checkVision() {
-			return ev3.Left
-		}
-		move(conf.MaxSpeed, -20, now)
-	}
-	startS1 := currentTicks()
-	for {
-		now := currentTicks()
-		if now-startS1 >= conf.StrategyS1Time {
+		if now-start >= step.Time {
 			break
 		}
-		if checkVision() {
-			return ev3.Left
-		}
-		move(conf.MaxSpeed, conf.MaxSpeed, now)
-	}
-	startR2 := currentTicks()
-	for {
-		now := currentTicks()
-		if now-startR2 >= conf.StrategyR2Time {
-			break
-		}
-		if checkVision() {
-			return ev3.Left
-		}
-		move(-20, conf.MaxSpeed, now)
-	}
-	startS2 := currentTicks()
-	for {
-		now := currentTicks()
-		if now-startS2 >= conf.StrategyS2Time {
-			break
+		if step.UseVision && checkVision() {
+			return true
 		}
-		if checkVision() {
-			return ev3.Left
+		if step.Dir == ev3.Left {
+			move(step.InnerSpeed, step.Speed, now)
+		} else if step.Dir == ev3.Right {
+			move(step.Speed, step.InnerSpeed, now)
+		} else {
+			move(step.Speed, step.Speed, now)
 		}
-		move(conf.MaxSpeed, conf.MaxSpeed, now)
 	}
-	print("ho finito strategy right, seeeee!!!")
-	return ev3.Left
+	return false
 }
 
 func track(dir ev3.Direction) {
@@ -510,50 +425,60 @@ func track(dir ev3.Direction) {
 		}
 		now := currentTicks()
 		read()
-		// print(irL.Value, irFL.Value, irFR.Value, irR.Value)
+		debug(irL.Value, irFL.Value, irFR.Value, irR.Value)
+		cmdline.Trace(now, irL.Value, irFL.Value, irFR.Value, irR.Value)
 
 		if irFL.Value < conf.MaxIrFront {
 			move(conf.TrackSpeed, conf.TrackSpeed, now)
 			dir = ev3.Left
-			print("FRONT LEFT")
+			debug("FRONT LEFT")
 		} else if irFR.Value < conf.MaxIrFront {
 			move(conf.TrackSpeed, conf.TrackSpeed, now)
 			dir = ev3.Right
-			print("FRONT RIGHT")
+			debug("FRONT RIGHT")
 		} else if irL.Value < conf.MaxIrSide {
 			move(-conf.TrackTurnSpeed, conf.TrackTurnSpeed, now)
 			dir = ev3.Left
-			print("LEFT")
+			debug("LEFT")
 		} else if irR.Value < conf.MaxIrSide {
 			move(conf.TrackTurnSpeed, -conf.TrackTurnSpeed, now)
 			dir = ev3.Right
-			print("RIGHT")
+			debug("RIGHT")
 		} else {
 			if dir == ev3.Right {
 				move(conf.SeekTurnSpeed, -conf.SeekTurnSpeed, now)
-				print("SEEK RIGHT")
+				debug("SEEK RIGHT")
 			} else if dir == ev3.Left {
 				move(-conf.SeekTurnSpeed, conf.SeekTurnSpeed, now)
-				print("SEEK LEFT")
+				debug("SEEK LEFT")
 			} else {
-				print("SEEK NONE")
+				debug("SEEK NONE")
 			}
 		}
 	}
 }
 
 func main() {
-	handleSignals()
+	ev3.HandleSignals()
+	defer ev3.Recover()
+
+	opts := cmdline.Parse("xl4_2.0.toml")
+	configFile, profile, sets = opts.ConfigFile, opts.Profile, opts.Sets
+	watcher = botconf.NewWatcher(configFile, botconf.WatchInterval, clk)
+
 	initialize()
-	defer close()
 
 	beep.G()
 
+	loadConfig()
+	watchdog = ev3.NewWatchdog(devs, time.Duration(conf.WatchdogMillis)*time.Millisecond, clk)
+	watchdog.Start()
+
 	// conf = config.Default()
 
 	for {
 		if chooseStrategy(1) {
-			return
+			ev3.Exit(ev3.ExitOK, "Done")
 		}
 		waitBegin()
 		trackDir := strategy()